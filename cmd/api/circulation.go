@@ -0,0 +1,703 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/circulation"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLoanPeriod  = 14 * 24 * time.Hour
+	defaultMaxRenewals = 2
+	defaultHoldPickup  = 3 * 24 * time.Hour
+
+	maxBarcodeLength          = 64
+	maxCirculationFieldLength = 255
+	defaultCopyCondition      = "good"
+)
+
+// copyConditions are conditions a copy can be in, from the best one.
+var copyConditions = map[string]bool{
+	"new":     true,
+	"good":    true,
+	"fair":    true,
+	"poor":    true,
+	"damaged": true,
+}
+
+// loanPolicy sets due dates of loans, every renewal extends the loan by
+// period as well. Copies set aside for holds wait holdPickup for the member.
+type loanPolicy struct {
+	period      time.Duration
+	maxRenewals int
+	holdPickup  time.Duration
+}
+
+type copyRequest struct {
+	BookID    string `json:"book_id"`
+	Barcode   string `json:"barcode"`
+	Condition string `json:"condition"`
+	Location  string `json:"location"`
+}
+
+type memberRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type checkoutRequest struct {
+	CopyID   string `json:"copy_id"`
+	MemberID string `json:"member_id"`
+}
+
+type holdRequest struct {
+	BookID   string `json:"book_id"`
+	MemberID string `json:"member_id"`
+}
+
+func prepareCirculationRepo(dbType, connString string) (repository.CirculationRepo, error) {
+	switch dbType {
+	case "postgresql":
+		db, err := database.OpenPostgreSQL(connString)
+		if err != nil {
+			return nil, err
+		}
+		return circulation.NewPostgreSQLRepo(db), nil
+	case "mongodb":
+		db, err := database.OpenMongoDB(connString)
+		if err != nil {
+			return nil, err
+		}
+		repo := circulation.NewMongoDBRepo(db)
+		return repo, repo.CreateIndexes()
+	}
+
+	return nil, fmt.Errorf("unsupported database type: %q", dbType)
+}
+
+// circulationRoutes mounts copies, members, loans and holds. Readers may look up
+// copies, everything else is left to editors.
+func (s *Server) circulationRoutes(r chi.Router, limits map[string]func(http.Handler) http.Handler) {
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleReader))
+		r.Use(limits[readRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Get("/copy", s.handleGetCopies)
+		r.Get("/copy/{id}", s.handleGetCopy)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleEditor))
+		r.Use(limits[writeRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Post("/copy", s.handleAddCopy)
+		r.Put("/copy/{id}", s.handleUpdateCopy)
+		r.Delete("/copy/{id}", s.handleDeleteCopy)
+
+		r.Get("/member", s.handleGetMembers)
+		r.Post("/member", s.handleAddMember)
+		r.Get("/member/{id}", s.handleGetMember)
+		r.Delete("/member/{id}", s.handleDeleteMember)
+		r.Get("/member/{id}/holds", s.handleGetMemberHolds)
+
+		r.Get("/loan", s.handleGetLoans)
+		r.Post("/loan", s.handleCheckout)
+		r.Get("/loan/{id}", s.handleGetLoan)
+		r.Post("/loan/{id}/return", s.handleReturn)
+		r.Post("/loan/{id}/renew", s.handleRenew)
+
+		r.Get("/hold", s.handleGetHolds)
+		r.Post("/hold", s.handlePlaceHold)
+		r.Get("/hold/{id}", s.handleGetHold)
+		r.Post("/hold/{id}/cancel", s.handleCancelHold)
+
+		if s.notifications != nil {
+			s.notificationRoutes(r)
+		}
+	})
+}
+
+// handleGetCopies lists copies of the book named by book_id query
+// parameter.
+func (s *Server) handleGetCopies(w http.ResponseWriter, r *http.Request) {
+	bookID := r.URL.Query().Get("book_id")
+	if bookID == "" {
+		v := &validationError{}
+		v.add("book_id", "is required")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	copies, err := s.circulation.GetCopies(requestTenant(r.Context()), bookID)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, copies, http.StatusOK)
+}
+
+func (s *Server) handleGetCopy(w http.ResponseWriter, r *http.Request) {
+	c, err := s.circulation.GetCopy(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, c, http.StatusOK)
+}
+
+func (s *Server) handleAddCopy(w http.ResponseWriter, r *http.Request) {
+	c, ok := readCopy(w, r)
+	if !ok {
+		return
+	}
+
+	if c.BookID == "" {
+		v := &validationError{}
+		v.add("book_id", "is required")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = repo.GetBook(c.BookID); err != nil {
+		handleReferenceError(w, r, err)
+		return
+	}
+
+	c.Tenant = requestTenant(r.Context())
+	c, err = s.circulation.AddCopy(c)
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Location": []string{"/copy/" + c.ID}}
+	_ = writeResource(w, c, http.StatusCreated, headers)
+}
+
+// handleUpdateCopy replaces barcode, condition and location of the copy,
+// it stays a copy of the same book.
+func (s *Server) handleUpdateCopy(w http.ResponseWriter, r *http.Request) {
+	existing, err := s.circulation.GetCopy(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	c, ok := readCopy(w, r)
+	if !ok {
+		return
+	}
+	if c.BookID != "" && c.BookID != existing.BookID {
+		v := &validationError{}
+		v.add("book_id", "cannot be changed")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+	c.ID = existing.ID
+	c.Tenant = existing.Tenant
+	c.BookID = existing.BookID
+	c.CreatedAt = existing.CreatedAt
+
+	if err = s.circulation.UpdateCopy(c); err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, c, http.StatusOK)
+}
+
+func (s *Server) handleDeleteCopy(w http.ResponseWriter, r *http.Request) {
+	if err := s.circulation.DeleteCopy(requestTenant(r.Context()), chi.URLParam(r, "id")); err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := s.circulation.GetMembers(requestTenant(r.Context()))
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, members, http.StatusOK)
+}
+
+func (s *Server) handleGetMember(w http.ResponseWriter, r *http.Request) {
+	m, err := s.circulation.GetMember(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, m, http.StatusOK)
+}
+
+func (s *Server) handleAddMember(w http.ResponseWriter, r *http.Request) {
+	var req *memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	if err := validateMember(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	m, err := s.circulation.AddMember(&models.Member{Tenant: requestTenant(r.Context()), Name: req.Name, Email: req.Email})
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	headers := http.Header{"Location": []string{"/member/" + m.ID}}
+	_ = writeResource(w, m, http.StatusCreated, headers)
+}
+
+func (s *Server) handleDeleteMember(w http.ResponseWriter, r *http.Request) {
+	if err := s.circulation.DeleteMember(requestTenant(r.Context()), chi.URLParam(r, "id")); err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetLoans lists loans, newest first, optionally only those of
+// member_id or copy_id and only active ones with active=true.
+func (s *Server) handleGetLoans(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := repository.LoanFilter{MemberID: query.Get("member_id"), CopyID: query.Get("copy_id")}
+	var err error
+	if filter.ActiveOnly, err = activeOnly(query); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	loans, err := s.circulation.GetLoans(requestTenant(r.Context()), filter)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, loans, http.StatusOK)
+}
+
+func (s *Server) handleGetLoan(w http.ResponseWriter, r *http.Request) {
+	l, err := s.circulation.GetLoan(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, l, http.StatusOK)
+}
+
+// handleCheckout lends the copy to the member until the end of the loan
+// period, it responds with 409 when the copy is already on loan or set
+// aside for another member. A hold of the member for the book is
+// collected, so a copy set aside for it goes to the next waiting hold.
+func (s *Server) handleCheckout(w http.ResponseWriter, r *http.Request) {
+	var req *checkoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	if req == nil {
+		_ = handleErrorJSON(w, r, newPublicError("request body must be a checkout"), http.StatusBadRequest)
+		return
+	}
+	v := &validationError{}
+	if req.CopyID == "" {
+		v.add("copy_id", "is required")
+	}
+	if req.MemberID == "" {
+		v.add("member_id", "is required")
+	}
+	if err := v.errOrNil(); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	l, err := s.circulation.Checkout(&models.Loan{
+		Tenant:       requestTenant(r.Context()),
+		CopyID:       req.CopyID,
+		MemberID:     req.MemberID,
+		CheckedOutAt: now,
+		DueAt:        now.Add(s.loanPolicy.period),
+	})
+	if errors.Is(err, repository.ErrCopyNotFound) || errors.Is(err, repository.ErrMemberNotFound) {
+		handleReferenceError(w, r, err)
+		return
+	}
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	s.promoteHolds(l.Tenant, l.BookID)
+
+	headers := http.Header{"Location": []string{"/loan/" + l.ID}}
+	_ = writeResource(w, l, http.StatusCreated, headers)
+}
+
+// handleReturn closes the loan, the returned copy is set aside for the
+// oldest waiting hold of the book.
+func (s *Server) handleReturn(w http.ResponseWriter, r *http.Request) {
+	l, err := s.circulation.Return(requestTenant(r.Context()), chi.URLParam(r, "id"), time.Now().UTC())
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	s.promoteHolds(l.Tenant, l.BookID)
+
+	_ = writeResource(w, l, http.StatusOK)
+}
+
+// handleRenew extends the loan by the loan period, counted from now when
+// the loan is already overdue.
+func (s *Server) handleRenew(w http.ResponseWriter, r *http.Request) {
+	tenant, id := requestTenant(r.Context()), chi.URLParam(r, "id")
+	existing, err := s.circulation.GetLoan(tenant, id)
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	from := existing.DueAt
+	if now := time.Now().UTC(); now.After(from) {
+		from = now
+	}
+
+	l, err := s.circulation.Renew(tenant, id, from.Add(s.loanPolicy.period), s.loanPolicy.maxRenewals)
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, l, http.StatusOK)
+}
+
+// handleGetHolds lists holds, oldest first, optionally only those of
+// book_id or member_id and only active ones with active=true.
+func (s *Server) handleGetHolds(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := repository.HoldFilter{BookID: query.Get("book_id"), MemberID: query.Get("member_id")}
+	var err error
+	if filter.ActiveOnly, err = activeOnly(query); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	holds, err := s.circulation.GetHolds(requestTenant(r.Context()), filter)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, holds, http.StatusOK)
+}
+
+// handleGetMemberHolds lists holds of the member, only active ones with
+// active=true.
+func (s *Server) handleGetMemberHolds(w http.ResponseWriter, r *http.Request) {
+	tenant, id := requestTenant(r.Context()), chi.URLParam(r, "id")
+	filter := repository.HoldFilter{MemberID: id}
+	var err error
+	if filter.ActiveOnly, err = activeOnly(r.URL.Query()); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if _, err = s.circulation.GetMember(tenant, id); err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	holds, err := s.circulation.GetHolds(tenant, filter)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, holds, http.StatusOK)
+}
+
+func (s *Server) handleGetHold(w http.ResponseWriter, r *http.Request) {
+	h, err := s.circulation.GetHold(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, h, http.StatusOK)
+}
+
+// handlePlaceHold queues the member for the book. The hold is ready at
+// once when a copy of the book is free, otherwise it tells the position
+// in the queue.
+func (s *Server) handlePlaceHold(w http.ResponseWriter, r *http.Request) {
+	var req *holdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	if req == nil {
+		_ = handleErrorJSON(w, r, newPublicError("request body must be a hold"), http.StatusBadRequest)
+		return
+	}
+	v := &validationError{}
+	if req.BookID == "" {
+		v.add("book_id", "is required")
+	}
+	if req.MemberID == "" {
+		v.add("member_id", "is required")
+	}
+	if err := v.errOrNil(); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = repo.GetBook(req.BookID); err != nil {
+		handleReferenceError(w, r, err)
+		return
+	}
+
+	tenant := requestTenant(r.Context())
+	h, err := s.circulation.PlaceHold(&models.Hold{
+		Tenant:   tenant,
+		BookID:   req.BookID,
+		MemberID: req.MemberID,
+		PlacedAt: time.Now().UTC(),
+	})
+	if errors.Is(err, repository.ErrMemberNotFound) {
+		handleReferenceError(w, r, err)
+		return
+	}
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	s.promoteHolds(tenant, h.BookID)
+	if placed, err := s.circulation.GetHold(tenant, h.ID); err == nil {
+		h = placed
+	}
+
+	headers := http.Header{"Location": []string{"/hold/" + h.ID}}
+	_ = writeResource(w, h, http.StatusCreated, headers)
+}
+
+// handleCancelHold closes the active hold, a copy set aside for it goes to
+// the next waiting hold.
+func (s *Server) handleCancelHold(w http.ResponseWriter, r *http.Request) {
+	h, err := s.circulation.CancelHold(requestTenant(r.Context()), chi.URLParam(r, "id"), time.Now().UTC())
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	s.promoteHolds(h.Tenant, h.BookID)
+
+	_ = writeResource(w, h, http.StatusOK)
+}
+
+// promoteHolds sets free copies of the book aside for waiting holds. It
+// follows changes which already succeeded, so failures are only logged and
+// the copies wait for the next promotion.
+func (s *Server) promoteHolds(tenant, bookID string) {
+	_, err := s.circulation.PromoteHolds(tenant, bookID, time.Now().UTC(), s.loanPolicy.holdPickup)
+	if err != nil {
+		log.Printf("circulation: promoting holds of book %s failed: %v\n", bookID, err)
+	}
+}
+
+// expireHolds expires holds not collected in time every interval until
+// stop is closed.
+func (s *Server) expireHolds(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.expireReadyHolds(time.Now().UTC())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// expireReadyHolds expires ready holds not collected before now and
+// promotes waiting holds to their copies.
+func (s *Server) expireReadyHolds(now time.Time) {
+	expired, err := s.circulation.ExpireHolds(now)
+	if err != nil {
+		log.Println("circulation: expiring holds failed:", err)
+		return
+	}
+
+	promoted := map[string]bool{}
+	for _, h := range expired {
+		key := h.Tenant + "/" + h.BookID
+		if !promoted[key] {
+			promoted[key] = true
+			s.promoteHolds(h.Tenant, h.BookID)
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("circulation: expired %d holds\n", len(expired))
+	}
+}
+
+// handleCirculationError responds with 404 for missing records, 409 for
+// changes conflicting with loans or holds and hides every other error
+// behind 500.
+func handleCirculationError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrCopyNotFound),
+		errors.Is(err, repository.ErrMemberNotFound),
+		errors.Is(err, repository.ErrLoanNotFound),
+		errors.Is(err, repository.ErrHoldNotFound):
+		_ = handleErrorJSON(w, r, expose(err), http.StatusNotFound)
+	case errors.Is(err, repository.ErrBarcodeTaken),
+		errors.Is(err, repository.ErrCopyOnLoan),
+		errors.Is(err, repository.ErrCopyOnHold),
+		errors.Is(err, repository.ErrMemberHasLoans),
+		errors.Is(err, repository.ErrLoanReturned),
+		errors.Is(err, repository.ErrRenewalsReached),
+		errors.Is(err, repository.ErrHoldExists),
+		errors.Is(err, repository.ErrHoldClosed):
+		_ = handleErrorJSON(w, r, expose(err), http.StatusConflict)
+	default:
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// handleReferenceError responds with 422 when a record named in request
+// body does not exist.
+func handleReferenceError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrBookNotFound) ||
+		errors.Is(err, repository.ErrCopyNotFound) ||
+		errors.Is(err, repository.ErrMemberNotFound) {
+		_ = handleErrorJSON(w, r, expose(err), http.StatusUnprocessableEntity)
+		return
+	}
+	_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+}
+
+// activeOnly parses active query parameter, which is false when missing.
+func activeOnly(query url.Values) (bool, error) {
+	active := query.Get("active")
+	if active == "" {
+		return false, nil
+	}
+
+	only, err := strconv.ParseBool(active)
+	if err != nil {
+		v := &validationError{}
+		v.add("active", "must be true or false")
+		return false, v.errOrNil()
+	}
+	return only, nil
+}
+
+// readCopy decodes and validates the copy sent in request body,
+// responding with an error when it is not valid.
+func readCopy(w http.ResponseWriter, r *http.Request) (*models.Copy, bool) {
+	var req *copyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return nil, false
+	}
+
+	if err := validateCopy(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	c := &models.Copy{BookID: req.BookID, Barcode: req.Barcode, Condition: req.Condition, Location: req.Location}
+	if c.Condition == "" {
+		c.Condition = defaultCopyCondition
+	}
+	return c, true
+}
+
+func validateCopy(req *copyRequest) error {
+	if req == nil {
+		return newPublicError("request body must be a copy")
+	}
+
+	v := &validationError{}
+	switch {
+	case req.Barcode == "":
+		v.add("barcode", "is required")
+	case len(req.Barcode) > maxBarcodeLength:
+		v.add("barcode", fmt.Sprintf("must have at most %d characters", maxBarcodeLength))
+	}
+	if req.Condition != "" && !copyConditions[req.Condition] {
+		v.add("condition", "must be one of: new, good, fair, poor, damaged")
+	}
+	if len([]rune(req.Location)) > maxCirculationFieldLength {
+		v.add("location", fmt.Sprintf("must have at most %d characters", maxCirculationFieldLength))
+	}
+	return v.errOrNil()
+}
+
+func validateMember(req *memberRequest) error {
+	if req == nil {
+		return newPublicError("request body must be a member")
+	}
+
+	v := &validationError{}
+	switch {
+	case req.Name == "":
+		v.add("name", "is required")
+	case len([]rune(req.Name)) > maxCirculationFieldLength:
+		v.add("name", fmt.Sprintf("must have at most %d characters", maxCirculationFieldLength))
+	}
+
+	addr, err := mail.ParseAddress(req.Email)
+	switch {
+	case req.Email == "":
+		v.add("email", "is required")
+	case len(req.Email) > maxCirculationFieldLength:
+		v.add("email", fmt.Sprintf("must have at most %d characters", maxCirculationFieldLength))
+	case err != nil || addr.Address != req.Email:
+		v.add("email", "must be an email address")
+	}
+	return v.errOrNil()
+}
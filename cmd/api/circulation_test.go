@@ -0,0 +1,632 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Server_Circulation_ShouldLendCopyOnce(t *testing.T) {
+	// setup
+	srv := prepareCirculationServer(t)
+
+	// given
+	var member models.Member
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member1","email":"member1@example.com"}`, &member)
+	var c models.Copy
+	res := doJSON(t, http.MethodPost, srv.URL+"/copy", `{"book_id":"1","barcode":"B-1","location":"A1"}`, &c)
+	if res.StatusCode != http.StatusCreated || c.Condition != defaultCopyCondition {
+		t.Fatalf("Expected created copy in default condition, received %d: %+v\n", res.StatusCode, c)
+	}
+	checkout := fmt.Sprintf(`{"copy_id":%q,"member_id":%q}`, c.ID, member.ID)
+
+	// when
+	var loan models.Loan
+	res = doJSON(t, http.MethodPost, srv.URL+"/loan", checkout, &loan)
+	again := doJSON(t, http.MethodPost, srv.URL+"/loan", checkout, nil)
+	var book bookDetails
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books/1", "", &book)
+
+	// then
+	if res.StatusCode != http.StatusCreated || loan.BookID != "1" || !loan.DueAt.After(loan.CheckedOutAt) {
+		t.Fatalf("Expected created loan of book 1, received %d: %+v\n", res.StatusCode, loan)
+	}
+	if again.StatusCode != http.StatusConflict {
+		t.Fatalf("Second checkout should conflict, received %d\n", again.StatusCode)
+	}
+	if book.Book == nil || book.Name != "Name1" || book.Availability == nil || *book.Availability != (models.Availability{Copies: 1, Available: 0}) {
+		t.Fatalf("Expected book with no available copies, has: %+v %+v\n", book.Book, book.Availability)
+	}
+}
+
+func Test_Server_Circulation_ShouldRenewAndReturnLoan(t *testing.T) {
+	// setup
+	srv := prepareCirculationServer(t)
+
+	var member models.Member
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member1","email":"member1@example.com"}`, &member)
+	var c models.Copy
+	doJSON(t, http.MethodPost, srv.URL+"/copy", `{"book_id":"1","barcode":"B-1"}`, &c)
+
+	// given
+	var loan models.Loan
+	doJSON(t, http.MethodPost, srv.URL+"/loan", fmt.Sprintf(`{"copy_id":%q,"member_id":%q}`, c.ID, member.ID), &loan)
+
+	// when
+	var renewed models.Loan
+	doJSON(t, http.MethodPost, srv.URL+"/loan/"+loan.ID+"/renew", "", &renewed)
+	overLimit := doJSON(t, http.MethodPost, srv.URL+"/loan/"+loan.ID+"/renew", "", nil)
+	var returned models.Loan
+	doJSON(t, http.MethodPost, srv.URL+"/loan/"+loan.ID+"/return", "", &returned)
+	returnedAgain := doJSON(t, http.MethodPost, srv.URL+"/loan/"+loan.ID+"/return", "", nil)
+	var book bookDetails
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books/1", "", &book)
+
+	// then
+	if renewed.Renewals != 1 || !renewed.DueAt.Equal(loan.DueAt.Add(defaultLoanPeriod)) {
+		t.Fatalf("Renewal should extend the loan by loan period, has: %+v\n", renewed)
+	}
+	if overLimit.StatusCode != http.StatusConflict || returnedAgain.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected conflicts, received %d and %d\n", overLimit.StatusCode, returnedAgain.StatusCode)
+	}
+	if returned.ReturnedAt == nil {
+		t.Fatalf("Returned loan should have return time: %+v\n", returned)
+	}
+	if book.Availability == nil || book.Availability.Available != 1 {
+		t.Fatalf("Returned copy should be available, has: %+v\n", book.Availability)
+	}
+}
+
+func Test_Server_Circulation_ShouldPromoteHoldsInOrder(t *testing.T) {
+	// setup
+	srv := prepareCirculationServer(t)
+
+	var first, second, third models.Member
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member1","email":"member1@example.com"}`, &first)
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member2","email":"member2@example.com"}`, &second)
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member3","email":"member3@example.com"}`, &third)
+	var c models.Copy
+	doJSON(t, http.MethodPost, srv.URL+"/copy", `{"book_id":"1","barcode":"B-1"}`, &c)
+	var loan models.Loan
+	doJSON(t, http.MethodPost, srv.URL+"/loan", fmt.Sprintf(`{"copy_id":%q,"member_id":%q}`, c.ID, first.ID), &loan)
+
+	// given
+	var secondHold, thirdHold models.Hold
+	res := doJSON(t, http.MethodPost, srv.URL+"/hold", fmt.Sprintf(`{"book_id":"1","member_id":%q}`, second.ID), &secondHold)
+	doJSON(t, http.MethodPost, srv.URL+"/hold", fmt.Sprintf(`{"book_id":"1","member_id":%q}`, third.ID), &thirdHold)
+	again := doJSON(t, http.MethodPost, srv.URL+"/hold", fmt.Sprintf(`{"book_id":"1","member_id":%q}`, third.ID), nil)
+
+	// when
+	doJSON(t, http.MethodPost, srv.URL+"/loan/"+loan.ID+"/return", "", nil)
+	var ready, waiting models.Hold
+	doJSON(t, http.MethodGet, srv.URL+"/hold/"+secondHold.ID, "", &ready)
+	doJSON(t, http.MethodGet, srv.URL+"/hold/"+thirdHold.ID, "", &waiting)
+	taken := doJSON(t, http.MethodPost, srv.URL+"/loan", fmt.Sprintf(`{"copy_id":%q,"member_id":%q}`, c.ID, third.ID), nil)
+	collected := doJSON(t, http.MethodPost, srv.URL+"/loan", fmt.Sprintf(`{"copy_id":%q,"member_id":%q}`, c.ID, second.ID), nil)
+	var holds []*models.Hold
+	doJSON(t, http.MethodGet, srv.URL+"/member/"+second.ID+"/holds", "", &holds)
+
+	// then
+	if res.StatusCode != http.StatusCreated || secondHold.Status != models.HoldWaiting || secondHold.Position != 1 || thirdHold.Position != 2 {
+		t.Fatalf("Expected queued holds, received %d: %+v %+v\n", res.StatusCode, secondHold, thirdHold)
+	}
+	if again.StatusCode != http.StatusConflict {
+		t.Fatalf("Second hold of the member should conflict, received %d\n", again.StatusCode)
+	}
+	if ready.Status != models.HoldReady || ready.CopyID != c.ID || ready.ExpiresAt == nil || waiting.Position != 1 {
+		t.Fatalf("Returned copy should be set aside for the first hold, has: %+v %+v\n", ready, waiting)
+	}
+	if taken.StatusCode != http.StatusConflict || collected.StatusCode != http.StatusCreated {
+		t.Fatalf("Only the holder should check out the copy, received %d and %d\n", taken.StatusCode, collected.StatusCode)
+	}
+	if len(holds) != 1 || holds[0].Status != models.HoldCollected {
+		t.Fatalf("Hold of the member should be collected, has: %+v\n", holds)
+	}
+}
+
+func Test_Server_Circulation_ShouldPassExpiredHoldToNextMember(t *testing.T) {
+	// setup
+	ts := NewServer("", prepareDbRepo(3))
+	ts.circulation = newCirculationRepoStub()
+	srv := httptest.NewServer(ts.routes())
+	defer srv.Close()
+
+	var first, second models.Member
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member1","email":"member1@example.com"}`, &first)
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member2","email":"member2@example.com"}`, &second)
+	var c models.Copy
+	doJSON(t, http.MethodPost, srv.URL+"/copy", `{"book_id":"1","barcode":"B-1"}`, &c)
+
+	// given
+	var firstHold, secondHold models.Hold
+	doJSON(t, http.MethodPost, srv.URL+"/hold", fmt.Sprintf(`{"book_id":"1","member_id":%q}`, first.ID), &firstHold)
+	doJSON(t, http.MethodPost, srv.URL+"/hold", fmt.Sprintf(`{"book_id":"1","member_id":%q}`, second.ID), &secondHold)
+
+	// when
+	ts.expireReadyHolds(time.Now().UTC().Add(defaultHoldPickup + time.Minute))
+	var expired, ready models.Hold
+	doJSON(t, http.MethodGet, srv.URL+"/hold/"+firstHold.ID, "", &expired)
+	doJSON(t, http.MethodGet, srv.URL+"/hold/"+secondHold.ID, "", &ready)
+	cancelled := doJSON(t, http.MethodPost, srv.URL+"/hold/"+firstHold.ID+"/cancel", "", nil)
+
+	// then
+	if firstHold.Status != models.HoldReady || secondHold.Position != 1 {
+		t.Fatalf("Hold of a free copy should be ready at once, has: %+v %+v\n", firstHold, secondHold)
+	}
+	if expired.Status != models.HoldExpired || ready.Status != models.HoldReady || ready.CopyID != c.ID {
+		t.Fatalf("Copy of expired hold should go to the next hold, has: %+v %+v\n", expired, ready)
+	}
+	if cancelled.StatusCode != http.StatusConflict {
+		t.Fatalf("Expired hold should not be cancelled, received %d\n", cancelled.StatusCode)
+	}
+}
+
+func Test_Server_Circulation_ShouldRejectInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		method, path   string
+		body           string
+		expectedStatus int
+	}{
+		{"Copy of missing book", http.MethodPost, "/copy", `{"book_id":"99","barcode":"B-1"}`, http.StatusUnprocessableEntity},
+		{"Copy without barcode", http.MethodPost, "/copy", `{"book_id":"1"}`, http.StatusBadRequest},
+		{"Copy in unknown condition", http.MethodPost, "/copy", `{"book_id":"1","barcode":"B-1","condition":"lost"}`, http.StatusBadRequest},
+		{"Member with invalid email", http.MethodPost, "/member", `{"name":"Member1","email":"Member1 <member1>"}`, http.StatusBadRequest},
+		{"Checkout of missing copy", http.MethodPost, "/loan", `{"copy_id":"99","member_id":"1"}`, http.StatusUnprocessableEntity},
+		{"Copies without book", http.MethodGet, "/copy", "", http.StatusBadRequest},
+		{"Missing loan", http.MethodGet, "/loan/99", "", http.StatusNotFound},
+		{"Hold of missing book", http.MethodPost, "/hold", `{"book_id":"99","member_id":"1"}`, http.StatusUnprocessableEntity},
+		{"Hold of missing member", http.MethodPost, "/hold", `{"book_id":"1","member_id":"99"}`, http.StatusUnprocessableEntity},
+		{"Hold without member", http.MethodPost, "/hold", `{"book_id":"1"}`, http.StatusBadRequest},
+		{"Holds of missing member", http.MethodGet, "/member/99/holds", "", http.StatusNotFound},
+		{"Holds with invalid active", http.MethodGet, "/hold?active=maybe", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			srv := prepareCirculationServer(t)
+
+			// when
+			res := doJSON(t, tt.method, srv.URL+tt.path, tt.body, nil)
+
+			// then
+			if res.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected %d, received %d\n", tt.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+}
+
+// utils
+
+func prepareCirculationServer(t *testing.T) *httptest.Server {
+	ts := NewServer("", prepareDbRepo(3))
+	ts.circulation = newCirculationRepoStub()
+	ts.loanPolicy.maxRenewals = 1
+
+	srv := httptest.NewServer(ts.routes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// circulationRepoStub keeps records of a single tenant, IDs are shared by
+// all kinds of records.
+type circulationRepoStub struct {
+	mu      sync.Mutex
+	lastID  int
+	copies  map[string]*models.Copy
+	members map[string]*models.Member
+	loans   map[string]*models.Loan
+	holds   map[string]*models.Hold
+}
+
+func newCirculationRepoStub() *circulationRepoStub {
+	return &circulationRepoStub{
+		copies:  map[string]*models.Copy{},
+		members: map[string]*models.Member{},
+		loans:   map[string]*models.Loan{},
+		holds:   map[string]*models.Hold{},
+	}
+}
+
+func (r *circulationRepoStub) nextID() string {
+	r.lastID++
+	return fmt.Sprint(r.lastID)
+}
+
+func (r *circulationRepoStub) GetCopies(tenant, bookID string) ([]*models.Copy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copies := []*models.Copy{}
+	for _, c := range r.copies {
+		if c.BookID == bookID {
+			copies = append(copies, c)
+		}
+	}
+	return copies, nil
+}
+
+func (r *circulationRepoStub) GetCopy(tenant, id string) (*models.Copy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.copies[id]
+	if !ok {
+		return nil, repository.ErrCopyNotFound
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (r *circulationRepoStub) AddCopy(c *models.Copy) (*models.Copy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	created := *c
+	created.ID = r.nextID()
+	r.copies[created.ID] = &created
+	return &created, nil
+}
+
+func (r *circulationRepoStub) UpdateCopy(c *models.Copy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.copies[c.ID]; !ok {
+		return repository.ErrCopyNotFound
+	}
+	updated := *c
+	r.copies[c.ID] = &updated
+	return nil
+}
+
+func (r *circulationRepoStub) DeleteCopy(tenant, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.copies[id]; !ok {
+		return repository.ErrCopyNotFound
+	}
+	delete(r.copies, id)
+	return nil
+}
+
+func (r *circulationRepoStub) GetAvailability(tenant, bookID string) (*models.Availability, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a := &models.Availability{}
+	for _, c := range r.copies {
+		if c.BookID == bookID {
+			a.Copies++
+			if r.activeLoan(c.ID) == nil && r.readyHold(c.ID) == nil {
+				a.Available++
+			}
+		}
+	}
+	for _, h := range r.holds {
+		if h.BookID == bookID && h.Status == models.HoldWaiting {
+			a.Holds++
+		}
+	}
+	return a, nil
+}
+
+func (r *circulationRepoStub) GetMembers(tenant string) ([]*models.Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members := []*models.Member{}
+	for _, m := range r.members {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (r *circulationRepoStub) GetMember(tenant, id string) (*models.Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.members[id]
+	if !ok {
+		return nil, repository.ErrMemberNotFound
+	}
+	return m, nil
+}
+
+func (r *circulationRepoStub) AddMember(m *models.Member) (*models.Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	created := *m
+	created.ID = r.nextID()
+	r.members[created.ID] = &created
+	return &created, nil
+}
+
+func (r *circulationRepoStub) DeleteMember(tenant, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.members[id]; !ok {
+		return repository.ErrMemberNotFound
+	}
+	delete(r.members, id)
+	return nil
+}
+
+func (r *circulationRepoStub) GetLoans(tenant string, filter repository.LoanFilter) ([]*models.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loans := []*models.Loan{}
+	for _, l := range r.loans {
+		if (filter.MemberID == "" || l.MemberID == filter.MemberID) &&
+			(filter.CopyID == "" || l.CopyID == filter.CopyID) &&
+			(!filter.ActiveOnly || l.Active()) {
+			loans = append(loans, l)
+		}
+	}
+	return loans, nil
+}
+
+func (r *circulationRepoStub) GetLoan(tenant, id string) (*models.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.loans[id]
+	if !ok {
+		return nil, repository.ErrLoanNotFound
+	}
+	copied := *l
+	return &copied, nil
+}
+
+func (r *circulationRepoStub) GetDueLoans(before time.Time) ([]*models.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loans := []*models.Loan{}
+	for _, l := range r.loans {
+		if l.Active() && l.DueAt.Before(before) {
+			copied := *l
+			loans = append(loans, &copied)
+		}
+	}
+	sort.Slice(loans, func(i, j int) bool { return loans[i].DueAt.Before(loans[j].DueAt) })
+	return loans, nil
+}
+
+func (r *circulationRepoStub) Checkout(l *models.Loan) (*models.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.copies[l.CopyID]
+	if !ok {
+		return nil, repository.ErrCopyNotFound
+	}
+	if _, ok = r.members[l.MemberID]; !ok {
+		return nil, repository.ErrMemberNotFound
+	}
+	if r.activeLoan(l.CopyID) != nil {
+		return nil, repository.ErrCopyOnLoan
+	}
+	if h := r.readyHold(l.CopyID); h != nil && h.MemberID != l.MemberID {
+		return nil, repository.ErrCopyOnHold
+	}
+
+	created := *l
+	created.ID = r.nextID()
+	created.BookID = c.BookID
+	r.loans[created.ID] = &created
+	for _, h := range r.holds {
+		if h.BookID == c.BookID && h.MemberID == l.MemberID && h.Active() {
+			h.Status = models.HoldCollected
+			h.ClosedAt = &l.CheckedOutAt
+		}
+	}
+	copied := created
+	return &copied, nil
+}
+
+func (r *circulationRepoStub) Return(tenant, id string, returnedAt time.Time) (*models.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.loans[id]
+	if !ok {
+		return nil, repository.ErrLoanNotFound
+	}
+	if !l.Active() {
+		return nil, repository.ErrLoanReturned
+	}
+	l.ReturnedAt = &returnedAt
+	copied := *l
+	return &copied, nil
+}
+
+func (r *circulationRepoStub) Renew(tenant, id string, dueAt time.Time, maxRenewals int) (*models.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.loans[id]
+	if !ok {
+		return nil, repository.ErrLoanNotFound
+	}
+	if !l.Active() {
+		return nil, repository.ErrLoanReturned
+	}
+	if l.Renewals >= maxRenewals {
+		return nil, repository.ErrRenewalsReached
+	}
+	l.DueAt = dueAt
+	l.Renewals++
+	copied := *l
+	return &copied, nil
+}
+
+func (r *circulationRepoStub) PlaceHold(h *models.Hold) (*models.Hold, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.members[h.MemberID]; !ok {
+		return nil, repository.ErrMemberNotFound
+	}
+	for _, existing := range r.holds {
+		if existing.BookID == h.BookID && existing.MemberID == h.MemberID && existing.Active() {
+			return nil, repository.ErrHoldExists
+		}
+	}
+
+	created := *h
+	created.ID = r.nextID()
+	created.Status = models.HoldWaiting
+	r.holds[created.ID] = &created
+	copied := created
+	return &copied, nil
+}
+
+func (r *circulationRepoStub) GetHolds(tenant string, filter repository.HoldFilter) ([]*models.Hold, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	holds := []*models.Hold{}
+	for _, h := range r.sortedHolds() {
+		if (filter.BookID == "" || h.BookID == filter.BookID) &&
+			(filter.MemberID == "" || h.MemberID == filter.MemberID) &&
+			(!filter.ActiveOnly || h.Active()) {
+			holds = append(holds, r.withPosition(h))
+		}
+	}
+	return holds, nil
+}
+
+func (r *circulationRepoStub) GetHold(tenant, id string) (*models.Hold, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.holds[id]
+	if !ok {
+		return nil, repository.ErrHoldNotFound
+	}
+	return r.withPosition(h), nil
+}
+
+func (r *circulationRepoStub) CancelHold(tenant, id string, cancelledAt time.Time) (*models.Hold, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.holds[id]
+	if !ok {
+		return nil, repository.ErrHoldNotFound
+	}
+	if !h.Active() {
+		return nil, repository.ErrHoldClosed
+	}
+	h.Status = models.HoldCancelled
+	h.ClosedAt = &cancelledAt
+	copied := *h
+	return &copied, nil
+}
+
+func (r *circulationRepoStub) PromoteHolds(tenant, bookID string, now time.Time, pickup time.Duration) ([]*models.Hold, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	free := []string{}
+	for _, c := range r.copies {
+		if c.BookID == bookID && r.activeLoan(c.ID) == nil && r.readyHold(c.ID) == nil {
+			free = append(free, c.ID)
+		}
+	}
+
+	promoted := []*models.Hold{}
+	for _, h := range r.sortedHolds() {
+		if len(free) == 0 {
+			break
+		}
+		if h.BookID != bookID || h.Status != models.HoldWaiting {
+			continue
+		}
+		expiresAt := now.Add(pickup)
+		h.Status, h.CopyID, h.ReadyAt, h.ExpiresAt = models.HoldReady, free[0], &now, &expiresAt
+		free = free[1:]
+		copied := *h
+		promoted = append(promoted, &copied)
+	}
+	return promoted, nil
+}
+
+func (r *circulationRepoStub) ExpireHolds(now time.Time) ([]*models.Hold, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expired := []*models.Hold{}
+	for _, h := range r.holds {
+		if h.Status == models.HoldReady && h.ExpiresAt.Before(now) {
+			h.Status = models.HoldExpired
+			h.ClosedAt = &now
+			copied := *h
+			expired = append(expired, &copied)
+		}
+	}
+	return expired, nil
+}
+
+// sortedHolds returns holds in the order they were placed.
+func (r *circulationRepoStub) sortedHolds() []*models.Hold {
+	holds := make([]*models.Hold, 0, len(r.holds))
+	for _, h := range r.holds {
+		holds = append(holds, h)
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		a, _ := strconv.Atoi(holds[i].ID)
+		b, _ := strconv.Atoi(holds[j].ID)
+		return a < b
+	})
+	return holds
+}
+
+func (r *circulationRepoStub) withPosition(h *models.Hold) *models.Hold {
+	copied := *h
+	if h.Status != models.HoldWaiting {
+		return &copied
+	}
+	for _, other := range r.sortedHolds() {
+		if other.BookID == h.BookID && other.Status == models.HoldWaiting {
+			copied.Position++
+		}
+		if other.ID == h.ID {
+			break
+		}
+	}
+	return &copied
+}
+
+func (r *circulationRepoStub) readyHold(copyID string) *models.Hold {
+	for _, h := range r.holds {
+		if h.CopyID == copyID && h.Status == models.HoldReady {
+			return h
+		}
+	}
+	return nil
+}
+
+func (r *circulationRepoStub) activeLoan(copyID string) *models.Loan {
+	for _, l := range r.loans {
+		if l.CopyID == copyID && l.Active() {
+			return l
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"github.com/auwendil/crud-app/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_CLI_ReadBooks(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		format        string
+		expectedCount int
+		expectedError bool
+	}{
+		{"JSON array", `[{"id":"7","name":"Book1","author":"Author1"},{"name":"Book2","author":"Author2"}]`, "json", 2, false},
+		{"JSON lines", "{\"name\":\"Book1\",\"author\":\"Author1\"}\n{\"name\":\"Book2\",\"author\":\"Author2\"}\n", "jsonl", 2, false},
+		{"Invalid book", `[{"name":"Book1"}]`, "json", 0, true},
+		{"Malformed line", "{\"name\":\"Book1\",\"author\":\"Author1\"}\n{\"name\":", "jsonl", 0, true},
+		{"Unknown format", `[]`, "csv", 0, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			books, err := readBooks(strings.NewReader(tt.input), tt.format)
+
+			// then
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("Expected error %t, has: %v\n", tt.expectedError, err)
+			}
+			if len(books) != tt.expectedCount {
+				t.Fatalf("Read %d books, should be %d\n", len(books), tt.expectedCount)
+			}
+		})
+	}
+}
+
+func Test_CLI_WriteBooks_ShouldAlignTable(t *testing.T) {
+	// given
+	books := []*models.Book{
+		{ID: "1", Name: "Solaris", Author: "Stanislaw Lem"},
+		{ID: "12", Name: "The Trial", Author: "Franz Kafka"},
+	}
+	var out bytes.Buffer
+
+	// when
+	err := writeBooks(&out, "table", books)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ID  NAME       AUTHOR\n" +
+		"1   Solaris    Stanislaw Lem\n" +
+		"12  The Trial  Franz Kafka\n"
+	if out.String() != expected {
+		t.Fatalf("Table is:\n%s\nshould be:\n%s\n", out.String(), expected)
+	}
+}
+
+func Test_CLI_ExportAndImport_ShouldCopyBooks(t *testing.T) {
+	// setup
+	source := "--event_store=file=" + filepath.Join(t.TempDir(), "source")
+	destination := "--event_store=file=" + filepath.Join(t.TempDir(), "destination")
+	file := filepath.Join(t.TempDir(), "books.jsonl")
+
+	// given
+	if err := runSeed([]string{source, "--count=12"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	if err := runExport([]string{source, "--file=" + file, "--format=jsonl", "--batch_size=5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runImport([]string{destination, "--file=" + file, "--format=jsonl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	exported, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(exported), "\n"); lines != 12 {
+		t.Fatalf("Exported %d lines, should be 12\n", lines)
+	}
+
+	repo, err := (&repoFlags{
+		dbType:     ptr("postgresql"),
+		connString: ptr(""),
+		eventStore: ptr(strings.TrimPrefix(destination, "--event_store=")),
+		tenancy:    ptr("shared"),
+		tenant:     ptr(""),
+	}).open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	books, _ := repo.GetAllBooks()
+	if len(books) != 12 || books[11].Name != "Pride and Prejudice #2" {
+		t.Fatalf("Imported books are different than expected: %v\n", books)
+	}
+}
+
+func Test_CLI_Purge_ShouldRequireConfirmation(t *testing.T) {
+	// when
+	err := runPurge([]string{"--event_store=file=" + t.TempDir()})
+
+	// then
+	if err == nil {
+		t.Fatal("Expected to return error but returned nil instead")
+	}
+}
+
+// utils
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/repository/eventsourced"
+	"github.com/auwendil/crud-app/internal/repository/publish"
+	"github.com/auwendil/crud-app/pkg/client"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Client_ShouldManageBooksThroughRouter(t *testing.T) {
+	// setup
+	c := prepareClientServer(t)
+	ctx := context.Background()
+
+	// when
+	created, err := c.AddBook(ctx, &client.Book{Name: "Book1", Author: "Author1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := c.UpdateBook(ctx, created.ID, &client.Book{Name: "Book2", Author: "Author2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, err := c.GetBook(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteBook(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, missingErr := c.GetBook(ctx, created.ID)
+
+	// then
+	if *updated != *fetched || fetched.Name != "Book2" {
+		t.Fatalf("Fetched book %+v differs from updated %+v\n", fetched, updated)
+	}
+	if !errors.Is(missingErr, client.ErrNotFound) {
+		t.Fatalf("Deleted book should not be found, has: %v\n", missingErr)
+	}
+}
+
+func Test_Client_ShouldReturnValidationErrors(t *testing.T) {
+	// setup
+	c := prepareClientServer(t)
+
+	// when
+	_, err := c.AddBook(context.Background(), &client.Book{Name: "Book1"})
+
+	// then
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrBadRequest) {
+		t.Fatalf("Expected bad request *client.Error, has: %v\n", err)
+	}
+	if len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "author" || apiErr.RequestID == "" {
+		t.Fatalf("Problem details were not decoded: %+v\n", apiErr)
+	}
+}
+
+func Test_Client_BooksIterator_ShouldWalkAllPages(t *testing.T) {
+	// setup
+	c := prepareClientServer(t)
+	ctx := context.Background()
+
+	// given
+	for i := 0; i < 7; i++ {
+		if _, err := c.AddBook(ctx, &client.Book{Name: "Book", Author: "Author"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// when
+	it := c.Books(ctx, 3)
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.Book().ID)
+	}
+
+	// then
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 7 || ids[0] != "1" || ids[6] != "7" {
+		t.Fatalf("Iterated books are different than expected: %v\n", ids)
+	}
+}
+
+func Test_Client_Subscribe_ShouldReceiveChanges(t *testing.T) {
+	// setup
+	c := prepareClientServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// given
+	stream, err := c.Subscribe(ctx, client.EventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// when
+	created, err := c.AddBook(ctx, &client.Book{Name: "Book1", Author: "Author1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := stream.Next()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != client.BookCreated || e.BookID != created.ID || e.Book.Name != "Book1" {
+		t.Fatalf("Received event is different than expected: %+v\n", e)
+	}
+	if stream.LastEventID() != e.ID {
+		t.Fatalf("Last event ID is %d, should be %d\n", stream.LastEventID(), e.ID)
+	}
+}
+
+func Test_Client_ShouldManageWebhooksThroughRouter(t *testing.T) {
+	// setup
+	srv, _ := prepareWebhooksServer(t)
+	c, err := client.New(srv.URL, client.DefaultConfig())
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while creating client: %s\n", err)
+	}
+	ctx := context.Background()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	// when
+	created, err := c.AddWebhook(ctx, &client.Webhook{URL: "https://example.com/hook", Events: []string{client.BookCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := c.UpdateWebhook(ctx, created.ID, &client.Webhook{URL: receiver.URL, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := c.TestWebhook(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := c.WebhookDeliveries(ctx, created.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters, err := c.DeadLetters(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, err := c.ListWebhooks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteWebhook(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, missingErr := c.GetWebhook(ctx, created.ID)
+
+	// then
+	if created.Secret == "" || created.Active || !updated.Active || updated.URL != receiver.URL {
+		t.Fatalf("Webhooks are different than expected, created: %+v, updated: %+v\n", created, updated)
+	}
+	if delivery.Succeeded || delivery.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Test delivery should fail with the receiver status, has: %+v\n", delivery)
+	}
+	if len(deliveries) != 1 || deliveries[0].WebhookID != created.ID {
+		t.Fatalf("Deliveries are different than expected: %+v\n", deliveries)
+	}
+	if len(deadLetters) != 0 {
+		t.Fatalf("Test deliveries should not be dead letters, has: %+v\n", deadLetters)
+	}
+	if len(webhooks) != 1 || webhooks[0].Secret != "" {
+		t.Fatalf("Listed webhooks are different than expected: %+v\n", webhooks)
+	}
+	if !errors.Is(missingErr, client.ErrNotFound) {
+		t.Fatalf("Deleted webhook should not be found, has: %v\n", missingErr)
+	}
+}
+
+// utils
+
+func prepareClientServer(t *testing.T) *client.Client {
+	store, err := eventsourced.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while opening event store: %s\n", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	repo, err := eventsourced.New(store, 0)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing repository: %s\n", err)
+	}
+
+	bus := events.NewBus(defaultEventsHistory, defaultEventsBuffer, 0)
+	s := NewServer("", publish.New(repo, bus))
+	s.events = bus
+
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(ts.Close)
+
+	c, err := client.New(ts.URL, client.DefaultConfig())
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while creating client: %s\n", err)
+	}
+	return c
+}
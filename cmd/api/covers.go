@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/blob"
+	"github.com/auwendil/crud-app/internal/imaging"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/cover"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxCoverBytes = 5 << 20
+	maxCoverPixels       = 40_000_000
+	coverOriginalName    = "original"
+	coverStoreTimeout    = 30 * time.Second
+
+	// images are never replaced under the same key, so they are cached
+	// for a year
+	coverCacheMaxAge = "max-age=31536000, immutable"
+)
+
+// coverSize is a thumbnail generated for every cover, fitting in a square
+// of maxSide pixels.
+type coverSize struct {
+	name    string
+	maxSide int
+}
+
+var coverSizes = []coverSize{{name: "small", maxSide: 96}, {name: "medium", maxSide: 256}, {name: "large", maxSide: 512}}
+
+var (
+	errCoverTypeMismatch = newPublicError("Content-Type does not match the image")
+	errCoverUnreadable   = newPublicError("request body cannot be read")
+)
+
+// coverLinks is the cover of a book as sent to clients, with URLs of the
+// original image and of its thumbnails by their names.
+type coverLinks struct {
+	URL         string            `json:"url"`
+	ContentType string            `json:"content_type"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	Thumbnails  map[string]string `json:"thumbnails"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+func prepareCoverRepo(dbType, connString string) (repository.CoverRepo, error) {
+	switch dbType {
+	case "postgresql":
+		db, err := database.OpenPostgreSQL(connString)
+		if err != nil {
+			return nil, err
+		}
+		return cover.NewPostgreSQLRepo(db), nil
+	case "mongodb":
+		db, err := database.OpenMongoDB(connString)
+		if err != nil {
+			return nil, err
+		}
+		repo := cover.NewMongoDBRepo(db)
+		return repo, repo.CreateIndexes()
+	}
+
+	return nil, fmt.Errorf("unsupported database type: %q", dbType)
+}
+
+// coverStoreFlags select the blob store keeping cover images, the server
+// and subcommands deleting books have to use the same store.
+type coverStoreFlags struct {
+	store *string
+	dir   *string
+	s3    blob.S3Config
+}
+
+func addCoverStoreFlags(fs *flag.FlagSet) *coverStoreFlags {
+	f := &coverStoreFlags{
+		store: fs.String("cover_store", "file", "Blob store keeping cover images, available: [file, s3]"),
+		dir:   fs.String("cover_dir", "covers", "Directory of file cover store"),
+	}
+	fs.StringVar(&f.s3.Endpoint, "cover_s3_endpoint", "", "Endpoint of S3 compatible cover store, e.g. https://s3.eu-central-1.amazonaws.com")
+	fs.StringVar(&f.s3.Bucket, "cover_s3_bucket", "", "Bucket of S3 cover store")
+	fs.StringVar(&f.s3.Region, "cover_s3_region", "us-east-1", "Region of S3 cover store")
+	fs.StringVar(&f.s3.AccessKey, "cover_s3_access_key", "", "Access key ID signing requests to S3 cover store")
+	fs.StringVar(&f.s3.SecretKey, "cover_s3_secret_key", "", "Secret access key signing requests to S3 cover store")
+	return f
+}
+
+func (f *coverStoreFlags) open() (blob.Store, error) {
+	return prepareCoverStore(*f.store, *f.dir, f.s3)
+}
+
+func prepareCoverStore(storeType, dir string, s3 blob.S3Config) (blob.Store, error) {
+	switch storeType {
+	case "file":
+		return blob.NewFileStore(dir)
+	case "s3":
+		if s3.Endpoint == "" || s3.Bucket == "" {
+			return nil, fmt.Errorf("s3 cover store requires --cover_s3_endpoint and --cover_s3_bucket")
+		}
+		s3.CacheControl = "public, " + coverCacheMaxAge
+		return blob.NewS3Store(s3, &http.Client{Timeout: coverStoreTimeout}), nil
+	}
+
+	return nil, fmt.Errorf("unsupported cover store: %q", storeType)
+}
+
+// coverRoutes mounts uploads of covers and their images. Images are read
+// with the rest of the catalog, uploads count against upload limits, so
+// their bodies can be larger than of other writes.
+func (s *Server) coverRoutes(r chi.Router, limits map[string]func(http.Handler) http.Handler) {
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleReader))
+		r.Use(limits[readRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Get("/covers/{tenant}/{bookID}/{file}", s.handleGetCoverImage)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleEditor))
+		r.Use(limits[uploadRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Put("/book/{id}/cover", s.handleSetCover)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleEditor))
+		r.Use(limits[writeRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Delete("/book/{id}/cover", s.handleDeleteCover)
+	})
+}
+
+// handleSetCover replaces the cover of the book with the image sent as
+// request body. The type of the image is detected from its content, a
+// declared Content-Type other than application/octet-stream must match it.
+func (s *Server) handleSetCover(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !s.bookExists(w, r, id) {
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			_ = handleErrorJSON(w, r, errRequestTooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		_ = handleErrorJSON(w, r, errCoverUnreadable, http.StatusBadRequest)
+		return
+	}
+
+	info, err := imaging.Inspect(data, maxCoverPixels)
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedType):
+		_ = handleErrorJSON(w, r, expose(imaging.ErrUnsupportedType), http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		_ = handleErrorJSON(w, r, expose(err), http.StatusUnprocessableEntity)
+		return
+	}
+	if declared := declaredCoverType(r); declared != "" && declared != info.ContentType {
+		_ = handleErrorJSON(w, r, errCoverTypeMismatch, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	img, err := imaging.Decode(data)
+	if err != nil {
+		_ = handleErrorJSON(w, r, expose(err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	hash := sha256.Sum256(data)
+	c := &models.Cover{
+		Tenant:      requestTenant(r.Context()),
+		BookID:      id,
+		Version:     hex.EncodeToString(hash[:8]),
+		ContentType: info.ContentType,
+		Width:       info.Width,
+		Height:      info.Height,
+		Size:        int64(len(data)),
+		UpdatedAt:   time.Now().UTC(),
+	}
+
+	// images stored before a failure are left behind, they may belong to
+	// the current cover when the same image is uploaded again
+	ctx, cancel := context.WithTimeout(r.Context(), coverStoreTimeout)
+	defer cancel()
+
+	if err = s.coverStore.Put(ctx, coverKey(c, coverOriginalName, c.ContentType), data, c.ContentType); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	thumbnailType := imaging.ThumbnailType(c.ContentType)
+	for _, size := range coverSizes {
+		thumbnail := imaging.Thumbnail(img, size.maxSide)
+		out, err := imaging.Encode(thumbnail, thumbnailType)
+		if err == nil {
+			err = s.coverStore.Put(ctx, coverKey(c, size.name, thumbnailType), out, thumbnailType)
+		}
+		if err != nil {
+			_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		bounds := thumbnail.Bounds()
+		c.Thumbnails = append(c.Thumbnails, &models.CoverThumbnail{
+			Name:        size.name,
+			ContentType: thumbnailType,
+			Width:       bounds.Dx(),
+			Height:      bounds.Dy(),
+		})
+	}
+
+	previous, err := s.covers.SetCover(c)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	// the same image uploaded again is stored under the same keys
+	if previous != nil && previous.Version != c.Version {
+		s.deleteCoverImages(previous)
+	}
+
+	_ = writeResource(w, s.coverLinks(c), http.StatusOK)
+}
+
+func (s *Server) handleDeleteCover(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	deleted, err := s.covers.DeleteCovers(requestTenant(r.Context()), id)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if len(deleted) == 0 {
+		_ = handleErrorJSON(w, r, expose(fmt.Errorf("%w (id=%s)", repository.ErrCoverNotFound, id)), http.StatusNotFound)
+		return
+	}
+
+	s.deleteCoverImages(deleted...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetCoverImage serves an image of the cover store. Images never
+// change under their keys, so they are cached for a year and revalidated
+// by their keys. Responses are private when authentication is enabled.
+func (s *Server) handleGetCoverImage(w http.ResponseWriter, r *http.Request) {
+	tenant := chi.URLParam(r, "tenant")
+	key := tenant + "/" + chi.URLParam(r, "bookID") + "/" + chi.URLParam(r, "file")
+	if tenant != requestTenant(r.Context()) || !blob.ValidKey(key) {
+		_ = handleErrorJSON(w, r, expose(blob.ErrNotFound), http.StatusNotFound)
+		return
+	}
+
+	etag := strconv.Quote(chi.URLParam(r, "file"))
+	if r.Header.Get("If-None-Match") == etag {
+		s.setCoverCacheHeaders(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	obj, err := s.coverStore.Get(r.Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		_ = handleErrorJSON(w, r, expose(blob.ErrNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer obj.Body.Close()
+
+	s.setCoverCacheHeaders(w, etag)
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if obj.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, obj.Body)
+}
+
+func (s *Server) setCoverCacheHeaders(w http.ResponseWriter, etag string) {
+	visibility := "public, "
+	if s.authenticator != nil {
+		visibility = "private, "
+	}
+	w.Header().Set("Cache-Control", visibility+coverCacheMaxAge)
+	w.Header().Set("ETag", etag)
+}
+
+// withCovers adds covers to books when covers are enabled.
+func (s *Server) withCovers(tenant string, items []*bookDetails) error {
+	if s.covers == nil || len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+
+	covers, err := s.covers.GetCovers(tenant, ids)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if c, ok := covers[item.ID]; ok {
+			item.Cover = s.coverLinks(c)
+		}
+	}
+	return nil
+}
+
+func (s *Server) coverLinks(c *models.Cover) *coverLinks {
+	links := &coverLinks{
+		URL:         s.coverURL(coverKey(c, coverOriginalName, c.ContentType)),
+		ContentType: c.ContentType,
+		Width:       c.Width,
+		Height:      c.Height,
+		Thumbnails:  make(map[string]string, len(c.Thumbnails)),
+		UpdatedAt:   c.UpdatedAt,
+	}
+	for _, t := range c.Thumbnails {
+		links.Thumbnails[t.Name] = s.coverURL(coverKey(c, t.Name, t.ContentType))
+	}
+	return links
+}
+
+func (s *Server) coverURL(key string) string {
+	return strings.TrimRight(s.coverBaseURL, "/") + "/" + key
+}
+
+// deleteCoverImages removes the original and thumbnails of covers from
+// the store, failures are only logged as they leave unreferenced images
+// behind.
+func (s *Server) deleteCoverImages(covers ...*models.Cover) {
+	if err := deleteCoverImages(s.coverStore, covers); err != nil {
+		log.Printf("removing cover images failed: %v\n", err)
+	}
+}
+
+func deleteCoverImages(store blob.Store, covers []*models.Cover) error {
+	ctx, cancel := context.WithTimeout(context.Background(), coverStoreTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, c := range covers {
+		keys := []string{coverKey(c, coverOriginalName, c.ContentType)}
+		for _, t := range c.Thumbnails {
+			keys = append(keys, coverKey(c, t.Name, t.ContentType))
+		}
+		for _, key := range keys {
+			if err := store.Delete(ctx, key); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// coverReferences removes covers of deleted books together with their
+// images.
+type coverReferences struct {
+	covers repository.CoverRepo
+	store  blob.Store
+}
+
+func (c coverReferences) DeleteBookReferences(tenant, bookID string) error {
+	deleted, err := c.covers.DeleteCovers(tenant, bookID)
+	if err != nil {
+		return err
+	}
+	return deleteCoverImages(c.store, deleted)
+}
+
+// coverKey is the key of an image of the cover in the store, images of
+// every version of a cover have distinct keys.
+func coverKey(c *models.Cover, name, contentType string) string {
+	ext := "jpg"
+	switch contentType {
+	case imaging.PNG:
+		ext = "png"
+	case imaging.WebP:
+		ext = "webp"
+	}
+	return c.Tenant + "/" + c.BookID + "/" + c.Version + "-" + name + "." + ext
+}
+
+// declaredCoverType returns the media type of Content-Type header, or an
+// empty string when it is missing or application/octet-stream.
+func declaredCoverType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType == "application/octet-stream" {
+		return ""
+	}
+	return mediaType
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/auwendil/crud-app/internal/blob"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/references"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func Test_Server_Covers_ShouldUploadCoverWithThumbnails(t *testing.T) {
+	// setup
+	_, srv, _ := prepareCoverServer(t)
+
+	// given
+	data := encodeCoverPNG(t, 600, 900)
+
+	// when
+	var uploaded coverLinks
+	res := putCover(t, srv.URL+"/book/1/cover", "image/png", data, &uploaded)
+	var book bookDetails
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books/1", "", &book)
+
+	// then
+	if res.StatusCode != http.StatusOK || uploaded.ContentType != "image/png" || uploaded.Width != 600 || uploaded.Height != 900 {
+		t.Fatalf("Expected 600x900 PNG cover, received %d: %+v\n", res.StatusCode, uploaded)
+	}
+	if len(uploaded.Thumbnails) != len(coverSizes) {
+		t.Fatalf("Expected thumbnails of every size, has: %v\n", uploaded.Thumbnails)
+	}
+	if book.Cover == nil || book.Cover.URL != uploaded.URL || book.Cover.Thumbnails["small"] != uploaded.Thumbnails["small"] {
+		t.Fatalf("Expected cover in book details, has: %+v\n", book.Cover)
+	}
+
+	original := getCoverImage(t, srv.URL+uploaded.URL, "")
+	if !bytes.Equal(original.body, data) || original.res.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected original image, has %s of %d bytes\n", original.res.Header.Get("Content-Type"), len(original.body))
+	}
+
+	medium := getCoverImage(t, srv.URL+uploaded.Thumbnails["medium"], "")
+	img, err := png.Decode(bytes.NewReader(medium.body))
+	if err != nil {
+		t.Fatalf("Expected PNG thumbnail, has: %v\n", err)
+	}
+	if size := img.Bounds().Size(); size.X != 171 || size.Y != 256 {
+		t.Fatalf("Expected 171x256 thumbnail, has: %dx%d\n", size.X, size.Y)
+	}
+}
+
+func Test_Server_Covers_ShouldServeImagesWithCacheHeaders(t *testing.T) {
+	// setup
+	_, srv, _ := prepareCoverServer(t)
+
+	var uploaded coverLinks
+	putCover(t, srv.URL+"/book/1/cover", "", encodeCoverJPEG(t, 200, 300), &uploaded)
+
+	// when
+	fetched := getCoverImage(t, srv.URL+uploaded.Thumbnails["small"], "")
+	revalidated := getCoverImage(t, srv.URL+uploaded.Thumbnails["small"], fetched.res.Header.Get("ETag"))
+	missing := getCoverImage(t, srv.URL+"/covers/default/1/0000000000000000-small.jpg", "")
+	otherTenant := getCoverImage(t, srv.URL+"/covers/acme/1/0000000000000000-small.jpg", "")
+
+	// then
+	if fetched.res.StatusCode != http.StatusOK || fetched.res.Header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("Expected JPEG thumbnail, received %d: %s\n", fetched.res.StatusCode, fetched.res.Header.Get("Content-Type"))
+	}
+	if cc := fetched.res.Header.Get("Cache-Control"); cc != "public, max-age=31536000, immutable" {
+		t.Fatalf("Expected immutable public image, has: %q\n", cc)
+	}
+	if revalidated.res.StatusCode != http.StatusNotModified || len(revalidated.body) != 0 {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusNotModified, revalidated.res.StatusCode)
+	}
+	if missing.res.StatusCode != http.StatusNotFound || missing.res.Header.Get("Cache-Control") != "" {
+		t.Fatalf("Expected uncached 404 of missing image, received %d: %q\n", missing.res.StatusCode, missing.res.Header.Get("Cache-Control"))
+	}
+	if otherTenant.res.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusNotFound, otherTenant.res.StatusCode)
+	}
+}
+
+func Test_Server_Covers_ShouldValidateUploads(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		contentType  string
+		data         []byte
+		expectedCode int
+	}{
+		{name: "Should reject GIF", path: "/book/1/cover", contentType: "image/gif", data: encodeCoverGIF(t), expectedCode: http.StatusUnsupportedMediaType},
+		{name: "Should reject declared type of other image", path: "/book/1/cover", contentType: "image/jpeg", data: encodeCoverPNG(t, 10, 10), expectedCode: http.StatusUnsupportedMediaType},
+		{name: "Should reject truncated image", path: "/book/1/cover", contentType: "image/png", data: encodeCoverPNG(t, 10, 10)[:40], expectedCode: http.StatusUnprocessableEntity},
+		{name: "Should reject image over size limit", path: "/book/1/cover", contentType: "image/png", data: encodeCoverPNG(t, 600, 600), expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "Should reject cover of missing book", path: "/book/9/cover", contentType: "image/png", data: encodeCoverPNG(t, 10, 10), expectedCode: http.StatusNotFound},
+		{name: "Should accept octet stream", path: "/book/1/cover", contentType: "application/octet-stream", data: encodeCoverPNG(t, 10, 10), expectedCode: http.StatusOK},
+	}
+
+	// setup
+	s, srv, _ := prepareCoverServer(t)
+	s.routeLimits[uploadRoutes] = routeLimits{maxBodyBytes: 4096}
+	srv.Config.Handler = s.routes()
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			res := putCover(t, srv.URL+tt.path, tt.contentType, tt.data, nil)
+
+			// then
+			if res.StatusCode != tt.expectedCode {
+				t.Fatalf("Expected status %d but received: %d\n", tt.expectedCode, res.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_Server_Covers_ShouldRemoveImagesOfReplacedAndDeletedCovers(t *testing.T) {
+	// setup
+	s, srv, dir := prepareCoverServer(t)
+	s.dbRepo = references.New(s.dbRepo, []repository.BookReferences{coverReferences{covers: s.covers, store: s.coverStore}})
+	imagesOf := func(bookID string) int {
+		entries, _ := os.ReadDir(filepath.Join(dir, repository.DefaultTenant, bookID))
+		return len(entries) / 2 // every image has its content type next to it
+	}
+
+	var first, second coverLinks
+	putCover(t, srv.URL+"/book/1/cover", "image/png", encodeCoverPNG(t, 40, 60), &first)
+	putCover(t, srv.URL+"/book/2/cover", "image/png", encodeCoverPNG(t, 40, 60), nil)
+
+	// when
+	putCover(t, srv.URL+"/book/1/cover", "image/png", encodeCoverPNG(t, 60, 40), &second)
+
+	// then
+	if first.URL == second.URL || imagesOf("1") != 1+len(coverSizes) {
+		t.Fatalf("Expected only images of the new cover, has %d images\n", imagesOf("1"))
+	}
+	if res := getCoverImage(t, srv.URL+first.URL, ""); res.res.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected replaced image to be removed, received: %d\n", res.res.StatusCode)
+	}
+
+	// when
+	deleted := doJSON(t, http.MethodDelete, srv.URL+"/book/1/cover", "", nil)
+	deletedAgain := doJSON(t, http.MethodDelete, srv.URL+"/book/1/cover", "", nil)
+	deletedBook := doJSON(t, http.MethodDelete, srv.URL+"/book/2", "", nil)
+
+	// then
+	if deleted.StatusCode != http.StatusNoContent || deletedAgain.StatusCode != http.StatusNotFound || imagesOf("1") != 0 {
+		t.Fatalf("Expected cover to be deleted once, received %d and %d\n", deleted.StatusCode, deletedAgain.StatusCode)
+	}
+
+	if deletedBook.StatusCode != http.StatusNoContent || imagesOf("2") != 0 {
+		t.Fatalf("Images of deleted book 2 should be removed, has: %d\n", imagesOf("2"))
+	}
+}
+
+// utils
+
+func prepareCoverServer(t *testing.T) (*Server, *httptest.Server, string) {
+	dir := t.TempDir()
+	store, err := blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing cover store: %s\n", err)
+	}
+
+	s := NewServer("", prepareDbRepo(3))
+	s.covers = newCoverRepoStub()
+	s.coverStore = store
+	s.coverBaseURL = "/covers"
+
+	srv := httptest.NewServer(s.routes())
+	t.Cleanup(srv.Close)
+	return s, srv, dir
+}
+
+func putCover(t *testing.T, url, contentType string, data []byte, out any) *http.Response {
+	req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Encountered error while uploading cover to %s: %s\n", url, err)
+	}
+	defer res.Body.Close()
+
+	if out != nil {
+		_ = json.NewDecoder(res.Body).Decode(out)
+	}
+	return res
+}
+
+type coverImage struct {
+	res  *http.Response
+	body []byte
+}
+
+func getCoverImage(t *testing.T, url, etag string) *coverImage {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Encountered error while calling GET %s: %s\n", url, err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	return &coverImage{res: res, body: body}
+}
+
+// encodeCoverPNG encodes a gradient, so images of different sizes differ
+// and are not compressed below the size limits of tests.
+func encodeCoverPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, coverGradient(width, height)); err != nil {
+		t.Fatalf("[SETUP] Encountered error while encoding image: %s\n", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeCoverJPEG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, coverGradient(width, height), nil); err != nil {
+		t.Fatalf("[SETUP] Encountered error while encoding image: %s\n", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeCoverGIF(t *testing.T) []byte {
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("[SETUP] Encountered error while encoding image: %s\n", err)
+	}
+	return buf.Bytes()
+}
+
+func coverGradient(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 7), G: uint8(y * 13), B: uint8(x * y), A: 255})
+		}
+	}
+	return img
+}
+
+type coverRepoStub struct {
+	mu     sync.Mutex
+	covers map[string]*models.Cover
+}
+
+func newCoverRepoStub() *coverRepoStub {
+	return &coverRepoStub{covers: make(map[string]*models.Cover)}
+}
+
+func (r *coverRepoStub) GetCovers(tenant string, bookIDs []string) (map[string]*models.Cover, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	covers := make(map[string]*models.Cover)
+	for _, id := range bookIDs {
+		if c, ok := r.covers[tenant+"/"+id]; ok {
+			covers[id] = c
+		}
+	}
+	return covers, nil
+}
+
+func (r *coverRepoStub) SetCover(c *models.Cover) (*models.Cover, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := r.covers[c.Tenant+"/"+c.BookID]
+	r.covers[c.Tenant+"/"+c.BookID] = c
+	return previous, nil
+}
+
+func (r *coverRepoStub) DeleteCovers(tenant, bookID string) ([]*models.Cover, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := []*models.Cover{}
+	for key, c := range r.covers {
+		if c.Tenant == tenant && (bookID == "" || c.BookID == bookID) {
+			deleted = append(deleted, c)
+			delete(r.covers, key)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultEventsHistory       = 1000
+	defaultEventsBuffer        = 64
+	defaultMaxEventSubscribers = 1000
+
+	sseRetry     = 2 * time.Second
+	sseHeartbeat = 15 * time.Second
+
+	wsWriteWait    = 10 * time.Second
+	wsPongWait     = time.Minute
+	wsPingInterval = wsPongWait * 9 / 10
+)
+
+var (
+	errInvalidLastEventID = newPublicError("last event ID must be an ID of received event")
+	errEventsExpired      = newPublicError("requested events are no longer available, reload books and subscribe again")
+	errTooManySubscribers = newPublicError("too many event subscribers, try again later")
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// handleBookEvents streams book changes as Server-Sent Events. Clients
+// reconnecting with Last-Event-ID get events they missed first.
+func (s *Server) handleBookEvents(w http.ResponseWriter, r *http.Request) {
+	sub, ok := s.subscribe(w, r, r.Header.Get("Last-Event-ID"))
+	if !ok {
+		return
+	}
+	defer sub.Close()
+	releaseConcurrencySlot(r)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		log.Println("Streaming events is not supported:", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-sub.Events():
+			if !ok {
+				// a dropped client reconnects with its last event ID and
+				// gets the rest from history
+				if err := sub.Err(); err != nil {
+					data, _ := json.Marshal(map[string]string{"message": err.Error()})
+					_, _ = fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+					_ = rc.Flush()
+				}
+				return
+			}
+
+			data, _ := json.Marshal(e)
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleBookEventsWS streams book changes as JSON messages over a
+// WebSocket. Browsers cannot set headers there, so the last received
+// event is passed as last_event_id parameter.
+func (s *Server) handleBookEventsWS(w http.ResponseWriter, r *http.Request) {
+	sub, ok := s.subscribe(w, r, r.URL.Query().Get("last_event_id"))
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	releaseConcurrencySlot(r)
+
+	// messages from clients are not expected, reading only processes
+	// pongs and close frames
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		conn.SetReadLimit(512)
+		_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		case e, ok := <-sub.Events():
+			if !ok {
+				code, reason := websocket.CloseNormalClosure, ""
+				if err := sub.Err(); err != nil {
+					code, reason = websocket.CloseTryAgainLater, err.Error()
+				}
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+				return
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = conn.WriteJSON(e)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// subscribe subscribes to events of the request tenant, filtered by
+// book_id (repeatable) and author parameters.
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request, lastEventID string) (*events.Subscription, bool) {
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			_ = handleErrorJSON(w, r, errInvalidLastEventID, http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	filter := events.Filter{
+		Tenant:  requestTenant(r.Context()),
+		BookIDs: r.URL.Query()["book_id"],
+		Author:  r.URL.Query().Get("author"),
+	}
+
+	sub, err := s.events.Subscribe(filter, lastID)
+	switch {
+	case errors.Is(err, events.ErrHistoryExpired):
+		_ = handleErrorJSON(w, r, errEventsExpired, http.StatusGone)
+		return nil, false
+	case errors.Is(err, events.ErrTooManySubscribers):
+		_ = handleErrorJSON(w, r, errTooManySubscribers, http.StatusServiceUnavailable, http.Header{"Retry-After": {"5"}})
+		return nil, false
+	case err != nil:
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return sub, true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/publish"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Server_Events_SSE(t *testing.T) {
+	// setup
+	srv, bus := prepareEventsServer(t)
+
+	t.Run("Streams filtered events", func(t *testing.T) {
+		// given
+		stream := openEventStream(t, srv.URL+"/v1/book/events?author=author1", "")
+
+		// when
+		for _, payload := range []string{`{"id":"10","name":"New","author":"Author2"}`, `{"id":"11","name":"New","author":"Author1"}`} {
+			res, err := http.Post(srv.URL+"/v1/book", "application/json", strings.NewReader(payload))
+			if err != nil {
+				t.Fatal("Encountered error while adding book:", err)
+			}
+			res.Body.Close()
+		}
+
+		// then
+		e := stream.next(t)
+		if e.Type != events.BookCreated || e.Book == nil || e.Book.Author != "Author1" {
+			t.Fatalf("Expected creation of book by Author1, received: %+v\n", e)
+		}
+	})
+
+	t.Run("Resumes after Last-Event-ID", func(t *testing.T) {
+		// given
+		last := bus.Publish(events.Event{Type: events.BookDeleted, Tenant: repository.DefaultTenant, BookID: "1"})
+		bus.Publish(events.Event{Type: events.BookDeleted, Tenant: repository.DefaultTenant, BookID: "2"})
+
+		// when
+		stream := openEventStream(t, srv.URL+"/book/events", strconv.FormatUint(last.ID, 10))
+
+		// then
+		if e := stream.next(t); e.BookID != "2" {
+			t.Fatalf("Expected missed deletion of book 2, received: %+v\n", e)
+		}
+	})
+
+	t.Run("Rejects forgotten Last-Event-ID", func(t *testing.T) {
+		// given
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/book/events", nil)
+		req.Header.Set("Last-Event-ID", "1")
+
+		// when
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Encountered error while subscribing:", err)
+		}
+		res.Body.Close()
+
+		// then
+		if res.StatusCode != http.StatusGone {
+			t.Fatalf("Expected status %d but received: %d\n", http.StatusGone, res.StatusCode)
+		}
+	})
+}
+
+func Test_Server_Events_WebSocket(t *testing.T) {
+	// setup
+	srv, _ := prepareEventsServer(t)
+
+	// given
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v2/books/events/ws?book_id=2", nil)
+	if err != nil {
+		t.Fatal("Encountered error while connecting:", err)
+	}
+	defer conn.Close()
+
+	// when
+	for _, id := range []string{"1", "2"} {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/v2/books/"+id, nil)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Encountered error while deleting book:", err)
+		}
+		res.Body.Close()
+	}
+
+	// then
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var e events.Event
+	if err = conn.ReadJSON(&e); err != nil {
+		t.Fatal("Encountered error while reading event:", err)
+	}
+
+	if e.Type != events.BookDeleted || e.BookID != "2" {
+		t.Fatalf("Expected deletion of book 2, received: %+v\n", e)
+	}
+}
+
+// utils
+
+func prepareEventsServer(t *testing.T) (*httptest.Server, *events.Bus) {
+	bus := events.NewBus(10, 10, 0)
+	ts := &Server{
+		dbRepo: publish.New(prepareDbRepo(3), bus),
+		events: bus,
+	}
+
+	srv := httptest.NewServer(ts.routes())
+	t.Cleanup(srv.Close)
+	return srv, bus
+}
+
+type eventStream struct {
+	events chan events.Event
+}
+
+// openEventStream subscribes and returns once the subscription is active.
+func openEventStream(t *testing.T, url, lastEventID string) *eventStream {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Encountered error while subscribing:", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected event stream but received: %d %s\n", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	stream := &eventStream{events: make(chan events.Event, 10)}
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+
+			var e events.Event
+			if json.Unmarshal([]byte(data), &e) == nil {
+				stream.events <- e
+			}
+		}
+	}()
+	return stream
+}
+
+func (s *eventStream) next(t *testing.T) events.Event {
+	select {
+	case e := <-s.events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event received")
+		return events.Event{}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"net/http"
+	"strconv"
+)
+
+// bookFacets counts listed books by their attributes, every facet lists
+// the most frequent values first. Tags, languages and decades are counted
+// only when taxonomy is enabled and are omitted when no book has them.
+type bookFacets struct {
+	Authors   []*models.FacetCount `json:"authors"`
+	Tags      []*models.FacetCount `json:"tags,omitempty"`
+	Languages []*models.FacetCount `json:"languages,omitempty"`
+	Decades   []*models.FacetCount `json:"decades,omitempty"`
+}
+
+// bookListMeta is sent in the meta field of v1 book lists.
+type bookListMeta struct {
+	Facets *bookFacets `json:"facets"`
+}
+
+// wantsFacets parses facets query parameter, which is false when missing.
+// Facets are counted only for current books.
+func wantsFacets(r *http.Request) (bool, error) {
+	query := r.URL.Query()
+	s := query.Get("facets")
+	if s == "" {
+		return false, nil
+	}
+
+	facets, err := strconv.ParseBool(s)
+	if err != nil {
+		v := &validationError{}
+		v.add("facets", "must be true or false")
+		return false, v.errOrNil()
+	}
+	if facets && query.Get("as_of") != "" {
+		return false, newPublicError("facets are available for current books only")
+	}
+	return facets, nil
+}
+
+// countFacets counts facets of books matching filter, books holds all of
+// them when filter is not zero.
+func (s *Server) countFacets(r *http.Request, repo repository.BookRepo, filter repository.TaxonomyFilter, books []*models.Book) (*bookFacets, error) {
+	counter, ok := repo.(repository.AuthorCounter)
+	if !ok {
+		return nil, fmt.Errorf("book repository does not count authors")
+	}
+
+	var ids []string
+	if !filter.IsZero() {
+		ids = make([]string, len(books))
+		for i, book := range books {
+			ids[i] = book.ID
+		}
+	}
+
+	facets := &bookFacets{Authors: []*models.FacetCount{}}
+	if ids == nil || len(ids) > 0 {
+		authors, err := counter.CountAuthors(ids)
+		if err != nil {
+			return nil, err
+		}
+		facets.Authors = authors
+	}
+
+	if s.taxonomy != nil {
+		t, err := s.taxonomy.GetFacets(requestTenant(r.Context()), filter)
+		if err != nil {
+			return nil, err
+		}
+		facets.Tags, facets.Languages, facets.Decades = t.Tags, t.Languages, t.Decades
+	}
+	return facets, nil
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultGraphQLMaxDepth      = 10
+	defaultGraphQLMaxComplexity = 5000
+)
+
+var (
+	errGraphQLInternal      = newPublicError("internal error")
+	errGraphQLQueryRequired = newPublicError("query is required")
+	errGraphQLGetMutation   = newPublicError("mutations are allowed only in POST requests")
+)
+
+// graphqlLimits reject queries before they are executed. Every field
+// costs 1 and costs of fields below a paginated field are multiplied by
+// its limit. Zero values disable the corresponding limit.
+type graphqlLimits struct {
+	maxDepth      int
+	maxComplexity int
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphqlContext carries the repository of a request and its loader to
+// resolvers.
+type graphqlContext struct {
+	repo   repository.BookRepo
+	loader *bookLoader
+}
+
+type graphqlContextKey struct{}
+
+// handleGraphiQL serves GraphiQL to GET requests without a query and
+// passes others to api. The page needs no credentials, role or tenant,
+// queries sent from it do.
+func handleGraphiQL(api http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") == "" {
+			_ = writeResponse(w, "text/html; charset=utf-8", []byte(graphiqlPage), http.StatusOK)
+			return
+		}
+		api.ServeHTTP(w, r)
+	}
+}
+
+// handleGraphQL executes queries sent as POST body or GET parameters.
+func (s *Server) handleGraphQL() http.HandlerFunc {
+	schema, err := s.graphqlSchema()
+	if err != nil {
+		panic(fmt.Errorf("building graphql schema: %w", err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+		if r.Method == http.MethodGet {
+			q := r.URL.Query()
+			req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					_ = handleErrorJSON(w, r, newPublicError("variables must be a JSON object"), http.StatusBadRequest)
+					return
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+			return
+		}
+
+		repo, err := s.bookRepo(r)
+		if err != nil {
+			_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), graphqlContextKey{}, &graphqlContext{
+			repo:   repo,
+			loader: newBookLoader(repo),
+		})
+
+		result, status := s.executeGraphQL(ctx, schema, req, r.Method == http.MethodGet)
+		_ = writeResource(w, result, status)
+	}
+}
+
+// executeGraphQL parses, validates and measures the query before running
+// it. Requests rejected before execution get 400, executed ones 200 even
+// when some resolvers failed, as their errors are part of the result.
+func (s *Server) executeGraphQL(ctx context.Context, schema graphql.Schema, req graphqlRequest, readOnly bool) (*graphql.Result, int) {
+	rejected := func(status int, errs ...gqlerrors.FormattedError) (*graphql.Result, int) {
+		return &graphql.Result{Errors: errs}, status
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		return rejected(http.StatusBadRequest, gqlerrors.FormatError(errGraphQLQueryRequired))
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return rejected(http.StatusBadRequest, gqlerrors.FormatError(err))
+	}
+
+	if validation := graphql.ValidateDocument(&schema, doc, nil); !validation.IsValid {
+		return rejected(http.StatusBadRequest, validation.Errors...)
+	}
+
+	if readOnly && hasMutation(doc, req.OperationName) {
+		return rejected(http.StatusMethodNotAllowed, gqlerrors.FormatError(errGraphQLGetMutation))
+	}
+
+	if err = s.graphqlLimits.check(&schema, doc, req.Variables); err != nil {
+		return rejected(http.StatusBadRequest, gqlerrors.FormatError(err))
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	}), http.StatusOK
+}
+
+func hasMutation(doc *ast.Document, operationName string) bool {
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok || op.Operation != ast.OperationTypeMutation {
+			continue
+		}
+		if operationName == "" || (op.Name != nil && op.Name.Value == operationName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) graphqlSchema() (graphql.Schema, error) {
+	bookType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Book",
+		Fields: graphql.Fields{
+			"id":     &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"author": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	bookInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "BookInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":   &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"author": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	pageArgs := func(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		args["offset"] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0}
+		args["limit"] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageLimit}
+		return args
+	}
+	bookList := graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(bookType)))
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"book": &graphql.Field{
+				Type: bookType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+					load := gc.loader.load(p.Args["id"].(string))
+					return func() (interface{}, error) {
+						book, err := load()
+						if err != nil || book == nil {
+							return nil, graphqlError(p.Context, err)
+						}
+						return book, nil
+					}, nil
+				},
+			},
+			"books": &graphql.Field{
+				Type: bookList,
+				Args: pageArgs(graphql.FieldConfigArgument{}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					offset, limit, err := graphqlPage(p.Args)
+					if err != nil {
+						return nil, err
+					}
+
+					gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+					books, err := gc.repo.GetBooksBatch(offset, limit)
+					return books, graphqlError(p.Context, err)
+				},
+			},
+			"searchBooks": &graphql.Field{
+				Type: bookList,
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"phrase": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					offset, limit, err := graphqlPage(p.Args)
+					if err != nil {
+						return nil, err
+					}
+
+					phrase := strings.TrimSpace(p.Args["phrase"].(string))
+					if phrase == "" {
+						v := &validationError{}
+						v.add("phrase", "is required")
+						return nil, v
+					}
+
+					gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+					books, err := gc.repo.SearchBooks(phrase, offset, limit)
+					return books, graphqlError(p.Context, err)
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"addBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					book, err := s.graphqlBookInput(p, auth.RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+					book, err = gc.repo.AddBook(book)
+					return book, graphqlError(p.Context, err)
+				},
+			},
+			"updateBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					book, err := s.graphqlBookInput(p, auth.RoleEditor)
+					if err != nil {
+						return nil, err
+					}
+
+					gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+					book.ID = p.Args["id"].(string)
+					if err = gc.repo.UpdateBook(book.ID, book); err != nil {
+						return nil, graphqlError(p.Context, err)
+					}
+					return book, nil
+				},
+			},
+			"deleteBook": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if err := s.graphqlRequireRole(p.Context, auth.RoleEditor); err != nil {
+						return nil, err
+					}
+
+					gc := p.Context.Value(graphqlContextKey{}).(*graphqlContext)
+					if err := gc.repo.DeleteBook(p.Args["id"].(string)); err != nil {
+						return nil, graphqlError(p.Context, err)
+					}
+					return true, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// graphqlRequireRole checks roles of mutations, since the whole endpoint
+// is available to readers.
+func (s *Server) graphqlRequireRole(ctx context.Context, role auth.Role) error {
+	if s.authenticator == nil {
+		return nil
+	}
+
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return expose(auth.ErrMissingCredentials)
+	}
+	if !principal.Role.Allows(role) {
+		return expose(fmt.Errorf("role %q is not allowed to access this resource, requires %q", principal.Role, role))
+	}
+	return nil
+}
+
+func (s *Server) graphqlBookInput(p graphql.ResolveParams, role auth.Role) (*models.Book, error) {
+	if err := s.graphqlRequireRole(p.Context, role); err != nil {
+		return nil, err
+	}
+
+	input, _ := p.Args["input"].(map[string]interface{})
+	name, _ := input["name"].(string)
+	author, _ := input["author"].(string)
+
+	book := &models.Book{Name: name, Author: author}
+	if err := validateBook(book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+func graphqlPage(args map[string]interface{}) (offset, limit int, err error) {
+	offset, _ = args["offset"].(int)
+	limit, _ = args["limit"].(int)
+
+	v := &validationError{}
+	if offset < 0 {
+		v.add("offset", "must be a non-negative integer")
+	}
+	if limit < 1 || limit > maxPageLimit {
+		v.add("limit", fmt.Sprintf("must be an integer between 1 and %d", maxPageLimit))
+	}
+	return offset, limit, v.errOrNil()
+}
+
+// graphqlError hides messages of internal errors from clients the same
+// way handleErrorJSON does.
+func graphqlError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var validationErr *validationError
+	var publicErr publicError
+	switch {
+	case errors.As(err, &validationErr), errors.As(err, &publicErr):
+		return err
+	case errors.Is(err, repository.ErrBookNotFound):
+		return expose(err)
+	}
+
+	log.Printf("request %s: graphql resolver failed: %s\n", middleware.GetReqID(ctx), err)
+	return errGraphQLInternal
+}
+
+// bookLoader batches books requested by resolvers of one request, so
+// sibling fields are loaded with a single GetBooksByIDs call instead of a
+// GetBook call each. Resolvers get a thunk, which graphql-go calls only
+// after resolving the whole level of the query.
+type bookLoader struct {
+	repo repository.BookRepo
+
+	mu      sync.Mutex
+	pending []string
+	loaded  map[string]*models.Book
+	failed  map[string]error
+}
+
+func newBookLoader(repo repository.BookRepo) *bookLoader {
+	return &bookLoader{
+		repo:   repo,
+		loaded: make(map[string]*models.Book),
+		failed: make(map[string]error),
+	}
+}
+
+// load registers id for the next batch and returns a function waiting for
+// it. Missing books are returned as nil without error.
+func (l *bookLoader) load(id string) func() (*models.Book, error) {
+	l.mu.Lock()
+	if _, ok := l.loaded[id]; !ok {
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (*models.Book, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.flush()
+		if err, ok := l.failed[id]; ok {
+			return nil, err
+		}
+		return l.loaded[id], nil
+	}
+}
+
+// flush loads all pending books, l.mu must be held.
+func (l *bookLoader) flush() {
+	if len(l.pending) == 0 {
+		return
+	}
+
+	ids := l.pending
+	l.pending = nil
+
+	books, err := l.repo.GetBooksByIDs(ids)
+	for _, id := range ids {
+		if err != nil {
+			l.failed[id] = err
+		} else {
+			l.loaded[id] = nil
+		}
+	}
+	for _, book := range books {
+		l.loaded[book.ID] = book
+	}
+}
+
+// check rejects documents with an operation deeper or more complex than
+// the limits. Introspection fields are not counted, so GraphiQL works with
+// low limits as well.
+func (l graphqlLimits) check(schema *graphql.Schema, doc *ast.Document, variables map[string]interface{}) error {
+	m := &queryMeter{
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+		schema:    schema,
+	}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			m.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		root := schema.QueryType()
+		if op.Operation == ast.OperationTypeMutation {
+			root = schema.MutationType()
+		}
+
+		depth, complexity := m.measure(root, op.SelectionSet, map[string]bool{})
+		if l.maxDepth > 0 && depth > l.maxDepth {
+			return newPublicError(fmt.Sprintf("query depth %d exceeds the limit of %d", depth, l.maxDepth))
+		}
+		if l.maxComplexity > 0 && complexity > l.maxComplexity {
+			return newPublicError(fmt.Sprintf("query complexity %d exceeds the limit of %d", complexity, l.maxComplexity))
+		}
+	}
+	return nil
+}
+
+type queryMeter struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	schema    *graphql.Schema
+}
+
+func (m *queryMeter) measure(parent *graphql.Object, set *ast.SelectionSet, spread map[string]bool) (depth, complexity int) {
+	if parent == nil || set == nil {
+		return 0, 0
+	}
+
+	for _, selection := range set.Selections {
+		var d, c int
+		switch sel := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name.Value, "__") {
+				continue
+			}
+
+			def, ok := parent.Fields()[sel.Name.Value]
+			if !ok {
+				continue
+			}
+
+			d, c = m.measure(objectType(def.Type), sel.SelectionSet, spread)
+			d, c = d+1, 1+m.multiplier(def, sel)*c
+		case *ast.InlineFragment:
+			d, c = m.measure(m.fragmentType(parent, sel.TypeCondition), sel.SelectionSet, spread)
+		case *ast.FragmentSpread:
+			fragment, ok := m.fragments[sel.Name.Value]
+			if !ok || spread[sel.Name.Value] {
+				continue
+			}
+
+			spread[sel.Name.Value] = true
+			d, c = m.measure(m.fragmentType(parent, fragment.TypeCondition), fragment.SelectionSet, spread)
+			delete(spread, sel.Name.Value)
+		}
+
+		if d > depth {
+			depth = d
+		}
+		complexity += c
+	}
+	return depth, complexity
+}
+
+// multiplier is the limit argument of paginated fields and 1 for others.
+func (m *queryMeter) multiplier(def *graphql.FieldDefinition, field *ast.Field) int {
+	for _, arg := range def.Args {
+		if arg.Name() != "limit" {
+			continue
+		}
+
+		limit, _ := arg.DefaultValue.(int)
+		for _, a := range field.Arguments {
+			if a.Name.Value != "limit" {
+				continue
+			}
+			switch v := a.Value.(type) {
+			case *ast.IntValue:
+				limit, _ = strconv.Atoi(v.Value)
+			case *ast.Variable:
+				switch n := m.variables[v.Name.Value].(type) {
+				case float64:
+					limit = int(n)
+				case int:
+					limit = n
+				}
+			}
+		}
+
+		if limit < 1 {
+			return 1
+		}
+		return limit
+	}
+	return 1
+}
+
+func (m *queryMeter) fragmentType(parent *graphql.Object, condition *ast.Named) *graphql.Object {
+	if condition == nil {
+		return parent
+	}
+	if t, ok := m.schema.Type(condition.Name.Value).(*graphql.Object); ok {
+		return t
+	}
+	return parent
+}
+
+func objectType(t graphql.Type) *graphql.Object {
+	for {
+		switch v := t.(type) {
+		case *graphql.NonNull:
+			t = v.OfType
+		case *graphql.List:
+			t = v.OfType
+		case *graphql.Object:
+			return v
+		default:
+			return nil
+		}
+	}
+}
+
+const graphiqlPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>crud-app GraphiQL</title>
+  <style>body { margin: 0; height: 100vh; } #graphiql { height: 100vh; }</style>
+  <link rel="stylesheet" href="https://unpkg.com/graphiql@3/graphiql.min.css">
+  <script crossorigin src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/graphiql@3/graphiql.min.js"></script>
+</head>
+<body>
+  <div id="graphiql">Loading...</div>
+  <script>
+    const fetcher = GraphiQL.createFetcher({ url: window.location.pathname });
+    ReactDOM.createRoot(document.getElementById('graphiql')).render(
+      React.createElement(GraphiQL, { fetcher: fetcher, defaultEditorToolsVisibility: true })
+    );
+  </script>
+</body>
+</html>
+`
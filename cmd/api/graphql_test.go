@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_GraphQL_ShouldBatchBookLookups(t *testing.T) {
+	// setup
+	repo := prepareDbRepo(3)
+	ts := &Server{
+		dbRepo: repo,
+	}
+
+	// given
+	query := `{
+		first: book(id: "1") { id name }
+		second: book(id: "2") { id name }
+		missing: book(id: "404") { id name }
+	}`
+
+	// when
+	w := postGraphQL(ts, "", query, nil)
+
+	// then
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but received: %d (%s)\n", http.StatusOK, w.Code, w.Body)
+	}
+
+	var res struct {
+		Data   map[string]*models.Book `json:"data"`
+		Errors []interface{}           `json:"errors"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal("Response is not a GraphQL result:", err)
+	}
+
+	if len(res.Errors) > 0 {
+		t.Fatal("Encountered errors but there should be none:", res.Errors)
+	}
+
+	if !bookEquals(res.Data["first"], &models.Book{ID: "1", Name: "Name1"}) || !bookEquals(res.Data["second"], &models.Book{ID: "2", Name: "Name2"}) {
+		t.Fatalf("Returned wrong books: %+v\n", res.Data)
+	}
+
+	if res.Data["missing"] != nil {
+		t.Fatalf("Missing book should be null, has: %+v\n", res.Data["missing"])
+	}
+
+	if repo.getBooksByIDsCalls != 1 {
+		t.Fatalf("Books should be loaded with a single call, loaded with %d\n", repo.getBooksByIDsCalls)
+	}
+}
+
+func Test_GraphQL_ShouldListAndSearchBooks(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo: prepareDbRepo(3),
+	}
+
+	// given
+	query := `query ($phrase: String!) {
+		books(offset: 1, limit: 1) { id }
+		searchBooks(phrase: $phrase) { id }
+	}`
+
+	// when
+	w := postGraphQL(ts, "", query, map[string]interface{}{"phrase": "AUTHOR3"})
+
+	// then
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but received: %d (%s)\n", http.StatusOK, w.Code, w.Body)
+	}
+
+	var res struct {
+		Data map[string][]*models.Book `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal("Response is not a GraphQL result:", err)
+	}
+
+	if books := res.Data["books"]; len(books) != 1 || books[0].ID != "2" {
+		t.Fatalf("List should contain only second book, has: %v\n", books)
+	}
+
+	if books := res.Data["searchBooks"]; len(books) != 1 || books[0].ID != "3" {
+		t.Fatalf("Search should find only third book, has: %v\n", books)
+	}
+}
+
+func Test_GraphQL_Mutations(t *testing.T) {
+	// setup
+	keys := &apiKeyRepoStub{m: map[string]*models.APIKey{}}
+	readerKey := addAPIKey(t, keys, auth.RoleReader)
+	editorKey := addAPIKey(t, keys, auth.RoleEditor)
+
+	tests := []struct {
+		name          string
+		apiKey        string
+		query         string
+		expectedError string
+	}{
+		{"Editor can add book", editorKey, `mutation { addBook(input: {name: "New", author: "Author"}) { id } }`, ""},
+		{"Editor can update book", editorKey, `mutation { updateBook(id: "1", input: {name: "New", author: "Author"}) { id } }`, ""},
+		{"Editor can delete book", editorKey, `mutation { deleteBook(id: "1") }`, ""},
+		{"Reader cannot add book", readerKey, `mutation { addBook(input: {name: "New", author: "Author"}) { id } }`, "not allowed"},
+		{"Invalid book is rejected", editorKey, `mutation { addBook(input: {name: "", author: "Author"}) { id } }`, "name: is required"},
+		{"Update of missing book fails", editorKey, `mutation { updateBook(id: "404", input: {name: "New", author: "Author"}) { id } }`, "not found"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ts := &Server{
+				dbRepo:        prepareDbRepo(3),
+				authenticator: auth.NewAuthenticator(keys, nil),
+			}
+
+			// when
+			w := postGraphQL(ts, tt.apiKey, tt.query, nil)
+
+			// then
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d but received: %d (%s)\n", http.StatusOK, w.Code, w.Body)
+			}
+
+			var res struct {
+				Errors []struct {
+					Message string `json:"message"`
+				} `json:"errors"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal("Response is not a GraphQL result:", err)
+			}
+
+			if tt.expectedError == "" && len(res.Errors) > 0 {
+				t.Fatal("Encountered errors but there should be none:", res.Errors)
+			}
+			if tt.expectedError != "" && (len(res.Errors) != 1 || !strings.Contains(res.Errors[0].Message, tt.expectedError)) {
+				t.Fatalf("Expected error containing %q, has: %v\n", tt.expectedError, res.Errors)
+			}
+		})
+	}
+}
+
+func Test_GraphQL_ShouldRejectRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		limits         graphqlLimits
+		method         string
+		query          string
+		expectedStatus int
+	}{
+		{"Too deep query", graphqlLimits{maxDepth: 1}, http.MethodPost, `{ book(id: "1") { name } }`, http.StatusBadRequest},
+		{"Too complex query", graphqlLimits{maxComplexity: 10}, http.MethodPost, `{ books(limit: 5) { id name author } }`, http.StatusBadRequest},
+		{"Query within limits", graphqlLimits{maxDepth: 2, maxComplexity: 16}, http.MethodPost, `{ books(limit: 5) { id name author } }`, http.StatusOK},
+		{"Invalid query", graphqlLimits{}, http.MethodPost, `{ books { unknown } }`, http.StatusBadRequest},
+		{"Mutation sent with GET", graphqlLimits{}, http.MethodGet, `mutation { deleteBook(id: "1") }`, http.StatusMethodNotAllowed},
+		{"Query sent with GET", graphqlLimits{}, http.MethodGet, `{ book(id: "1") { name } }`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ts := &Server{
+				dbRepo:        prepareDbRepo(3),
+				graphqlLimits: tt.limits,
+			}
+
+			var w *httptest.ResponseRecorder
+			if tt.method == http.MethodGet {
+				w = httptest.NewRecorder()
+				ts.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape(tt.query), nil))
+			} else {
+				w = postGraphQL(ts, "", tt.query, nil)
+			}
+
+			// then
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d but received: %d (%s)\n", tt.expectedStatus, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func Test_GraphQL_ShouldServeGraphiQL(t *testing.T) {
+	// setup
+	keys := &apiKeyRepoStub{m: map[string]*models.APIKey{}}
+	ts := &Server{
+		dbRepo:        prepareDbRepo(3),
+		authenticator: auth.NewAuthenticator(keys, nil),
+	}
+
+	t.Run("Should serve page without credentials", func(t *testing.T) {
+		// given
+		req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+		w := httptest.NewRecorder()
+
+		// when
+		ts.routes().ServeHTTP(w, req)
+
+		// then
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d but received: %d\n", http.StatusOK, w.Code)
+		}
+
+		if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+			t.Fatalf("GraphiQL should be served as HTML, has: %q\n", contentType)
+		}
+	})
+
+	t.Run("Should authenticate queries", func(t *testing.T) {
+		// given
+		req := httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape("{ books { id } }"), nil)
+		w := httptest.NewRecorder()
+
+		// when
+		ts.routes().ServeHTTP(w, req)
+
+		// then
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d but received: %d\n", http.StatusUnauthorized, w.Code)
+		}
+	})
+}
+
+// utils
+
+func postGraphQL(ts *Server, apiKey, query string, variables map[string]interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	if apiKey != "" {
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+	}
+
+	w := httptest.NewRecorder()
+	ts.routes().ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	bookv1 "github.com/auwendil/crud-app/pkg/pb/book/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"net/http"
+)
+
+const defaultListBatchSize = 100
+
+// grpcMethodRoles are the minimal roles required to call BookService
+// methods, they match roles of the corresponding REST routes.
+var grpcMethodRoles = map[string]auth.Role{
+	bookv1.BookService_ListBooks_FullMethodName:      auth.RoleReader,
+	bookv1.BookService_ListBooksPage_FullMethodName:  auth.RoleReader,
+	bookv1.BookService_GetBook_FullMethodName:        auth.RoleReader,
+	bookv1.BookService_AddBook_FullMethodName:        auth.RoleEditor,
+	bookv1.BookService_UpdateBook_FullMethodName:     auth.RoleEditor,
+	bookv1.BookService_DeleteBook_FullMethodName:     auth.RoleEditor,
+	bookv1.BookService_DeleteAllBooks_FullMethodName: auth.RoleAdmin,
+}
+
+// grpcRouteGroups are the route groups whose rate limits apply to
+// BookService methods of the given role, like to the corresponding REST
+// routes.
+var grpcRouteGroups = map[auth.Role]string{
+	auth.RoleReader: readRoutes,
+	auth.RoleEditor: writeRoutes,
+	auth.RoleAdmin:  adminRoutes,
+}
+
+// bookService implements gRPC BookService on top of the same repository,
+// authentication and tenancy as the REST API.
+type bookService struct {
+	bookv1.UnimplementedBookServiceServer
+	s *Server
+}
+
+func (s *Server) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	)
+
+	bookv1.RegisterBookServiceServer(srv, &bookService{s: s})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(bookv1.BookService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, healthServer)
+
+	reflection.Register(srv)
+	return srv
+}
+
+func (s *Server) ServeGRPC(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Starting gRPC server on", addr)
+	return s.newGRPCServer().Serve(lis)
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	release, err := s.acquireGRPCSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, err = s.grpcContext(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	release, err := s.acquireGRPCSlot(ss.Context())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, err := s.grpcContext(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// acquireGRPCSlot takes a slot of the concurrency limiter shared with REST
+// routes, release frees it once the call is done.
+func (s *Server) acquireGRPCSlot(ctx context.Context) (release func(), err error) {
+	if s.concurrency == nil {
+		return func() {}, nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, s.concurrencyWait)
+	defer cancel()
+
+	if !s.concurrency.Acquire(waitCtx) {
+		return nil, status.Error(codes.ResourceExhausted, errServerBusy.Error())
+	}
+	return s.concurrency.Release, nil
+}
+
+// grpcContext authenticates the caller of BookService methods, applies the
+// rate limit of their route group and resolves their tenant from metadata.
+// Health and reflection services are public.
+func (s *Server) grpcContext(ctx context.Context, method string) (context.Context, error) {
+	role, ok := grpcMethodRoles[method]
+	if !ok {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := http.Header{}
+	for k, v := range md {
+		header[http.CanonicalHeaderKey(k)] = v
+	}
+
+	if s.authenticator != nil {
+		principal, err := s.authenticator.AuthenticateHeader(header)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if !principal.Role.Allows(role) {
+			return nil, status.Errorf(codes.PermissionDenied, "role %q is not allowed to call this method, requires %q", principal.Role, role)
+		}
+		ctx = auth.WithPrincipal(ctx, principal)
+	}
+
+	if limiter := s.rateLimiter(grpcRouteGroups[role]); limiter != nil {
+		var remoteAddr string
+		if p, ok := peer.FromContext(ctx); ok {
+			remoteAddr = p.Addr.String()
+		}
+
+		if res := limiter.Allow(clientKeyOf(ctx, remoteAddr)); !res.Allowed {
+			return nil, status.Errorf(codes.ResourceExhausted, "%s, retry after %ds", errTooManyRequests, ceilSeconds(res.RetryAfter))
+		}
+	}
+
+	if s.tenants != nil {
+		tenant, err := chooseTenant(ctx, header.Get(tenantHeader))
+		switch {
+		case errors.Is(err, errTenantForbidden):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case err != nil:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		ctx = context.WithValue(ctx, tenantKey{}, tenant)
+	}
+
+	return ctx, nil
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (cs *contextStream) Context() context.Context {
+	return cs.ctx
+}
+
+func (b *bookService) ListBooks(req *bookv1.ListBooksRequest, stream bookv1.BookService_ListBooksServer) error {
+	repo, err := b.s.tenantBookRepo(stream.Context())
+	if err != nil {
+		return grpcError(err)
+	}
+
+	batchSize := int(req.GetBatchSize())
+	if batchSize <= 0 {
+		batchSize = defaultListBatchSize
+	}
+	if batchSize > maxPageLimit {
+		return status.Errorf(codes.InvalidArgument, "batch_size must be at most %d", maxPageLimit)
+	}
+
+	for offset := 0; ; offset += batchSize {
+		if err = stream.Context().Err(); err != nil {
+			return grpcError(err)
+		}
+
+		books, err := repo.GetBooksBatch(offset, batchSize)
+		if err != nil {
+			return grpcError(err)
+		}
+
+		for _, book := range books {
+			if err = stream.Send(toProtoBook(book)); err != nil {
+				return err
+			}
+		}
+
+		if len(books) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (b *bookService) ListBooksPage(ctx context.Context, req *bookv1.ListBooksPageRequest) (*bookv1.ListBooksPageResponse, error) {
+	offset, limit := int(req.GetOffset()), int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultPageLimit
+	}
+	if offset < 0 || limit < 1 || limit > maxPageLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "offset must be non-negative and limit between 1 and %d", maxPageLimit)
+	}
+
+	repo, err := b.s.tenantBookRepo(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	books, err := repo.GetBooksBatch(offset, limit)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	res := &bookv1.ListBooksPageResponse{Books: make([]*bookv1.Book, 0, len(books))}
+	for _, book := range books {
+		res.Books = append(res.Books, toProtoBook(book))
+	}
+	if len(books) == limit {
+		next := int32(offset + limit)
+		res.NextOffset = &next
+	}
+	return res, nil
+}
+
+func (b *bookService) GetBook(ctx context.Context, req *bookv1.GetBookRequest) (*bookv1.Book, error) {
+	repo, err := b.s.tenantBookRepo(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	book, err := repo.GetBook(req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoBook(book), nil
+}
+
+func (b *bookService) AddBook(ctx context.Context, req *bookv1.AddBookRequest) (*bookv1.Book, error) {
+	book := &models.Book{Name: req.GetName(), Author: req.GetAuthor()}
+	if err := validateBook(book); err != nil {
+		return nil, grpcError(err)
+	}
+
+	repo, err := b.s.tenantBookRepo(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	created, err := repo.AddBook(book)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoBook(created), nil
+}
+
+func (b *bookService) UpdateBook(ctx context.Context, req *bookv1.UpdateBookRequest) (*bookv1.Book, error) {
+	book := &models.Book{Name: req.GetName(), Author: req.GetAuthor()}
+	if err := validateBook(book); err != nil {
+		return nil, grpcError(err)
+	}
+
+	repo, err := b.s.tenantBookRepo(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	if err = repo.UpdateBook(req.GetId(), book); err != nil {
+		return nil, grpcError(err)
+	}
+	return &bookv1.Book{Id: req.GetId(), Name: book.Name, Author: book.Author}, nil
+}
+
+func (b *bookService) DeleteBook(ctx context.Context, req *bookv1.DeleteBookRequest) (*bookv1.DeleteBookResponse, error) {
+	repo, err := b.s.tenantBookRepo(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	if err = repo.DeleteBook(req.GetId()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &bookv1.DeleteBookResponse{}, nil
+}
+
+func (b *bookService) DeleteAllBooks(ctx context.Context, _ *bookv1.DeleteAllBooksRequest) (*bookv1.DeleteAllBooksResponse, error) {
+	repo, err := b.s.tenantBookRepo(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	if err = repo.DeleteAllBooks(); err != nil {
+		return nil, grpcError(err)
+	}
+	return &bookv1.DeleteAllBooksResponse{}, nil
+}
+
+// grpcError maps errors to gRPC status codes the same way REST handlers map
+// them to HTTP ones, internal error messages are only logged.
+func grpcError(err error) error {
+	var validationErr *validationError
+	var publicErr publicError
+	switch {
+	case errors.Is(err, repository.ErrBookNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.As(err, &validationErr):
+		return status.Error(codes.InvalidArgument, validationErr.Error())
+	case errors.As(err, &publicErr):
+		return status.Error(codes.InvalidArgument, publicErr.Error())
+	}
+
+	log.Println("grpc: request failed:", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func toProtoBook(b *models.Book) *bookv1.Book {
+	return &bookv1.Book{Id: b.ID, Name: b.Name, Author: b.Author}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/ratelimit"
+	bookv1 "github.com/auwendil/crud-app/pkg/pb/book/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_GRPC_BookService(t *testing.T) {
+	// setup
+	conn := prepareGRPCConn(t, &Server{dbRepo: prepareDbRepo(3)})
+	client := bookv1.NewBookServiceClient(conn)
+	ctx := context.Background()
+
+	t.Run("Should stream all books", func(t *testing.T) {
+		// when
+		stream, err := client.ListBooks(ctx, &bookv1.ListBooksRequest{BatchSize: 2})
+		if err != nil {
+			t.Fatal("Encountered error while listing books:", err)
+		}
+
+		var received []*bookv1.Book
+		for {
+			book, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal("Encountered error while receiving books:", err)
+			}
+			received = append(received, book)
+		}
+
+		// then
+		if len(received) != len(storedBooks) {
+			t.Fatalf("Streamed %d books, should be %d\n", len(received), len(storedBooks))
+		}
+	})
+
+	t.Run("Should add book", func(t *testing.T) {
+		// when
+		book, err := client.AddBook(ctx, &bookv1.AddBookRequest{Name: "Name4", Author: "Author4"})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while adding book:", err)
+		}
+
+		if book.GetName() != "Name4" || book.GetAuthor() != "Author4" {
+			t.Fatalf("Returned book is different than added: %v\n", book)
+		}
+	})
+
+	tests := []struct {
+		name         string
+		call         func() error
+		expectedCode codes.Code
+	}{
+		{"Should map missing book to NotFound", func() error {
+			_, err := client.GetBook(ctx, &bookv1.GetBookRequest{Id: "-1"})
+			return err
+		}, codes.NotFound},
+		{"Should reject invalid book", func() error {
+			_, err := client.UpdateBook(ctx, &bookv1.UpdateBookRequest{Id: "1", Name: "Name1"})
+			return err
+		}, codes.InvalidArgument},
+		{"Should reject too large page", func() error {
+			_, err := client.ListBooksPage(ctx, &bookv1.ListBooksPageRequest{Limit: maxPageLimit + 1})
+			return err
+		}, codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			err := tt.call()
+
+			// then
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Fatalf("Expected code %s but received: %s (%v)\n", tt.expectedCode, code, err)
+			}
+		})
+	}
+}
+
+func Test_GRPC_Authorization(t *testing.T) {
+	// setup
+	keys := &apiKeyRepoStub{m: map[string]*models.APIKey{}}
+	readerKey := addAPIKey(t, keys, auth.RoleReader)
+
+	conn := prepareGRPCConn(t, &Server{dbRepo: prepareDbRepo(3), authenticator: auth.NewAuthenticator(keys, nil)})
+	client := bookv1.NewBookServiceClient(conn)
+
+	tests := []struct {
+		name         string
+		apiKey       string
+		call         func(ctx context.Context) error
+		expectedCode codes.Code
+	}{
+		{"Rejects call without credentials", "", func(ctx context.Context) error {
+			_, err := client.GetBook(ctx, &bookv1.GetBookRequest{Id: "1"})
+			return err
+		}, codes.Unauthenticated},
+		{"Reader can get book", readerKey, func(ctx context.Context) error {
+			_, err := client.GetBook(ctx, &bookv1.GetBookRequest{Id: "1"})
+			return err
+		}, codes.OK},
+		{"Reader cannot delete all books", readerKey, func(ctx context.Context) error {
+			_, err := client.DeleteAllBooks(ctx, &bookv1.DeleteAllBooksRequest{})
+			return err
+		}, codes.PermissionDenied},
+		{"Health check does not require credentials", "", func(ctx context.Context) error {
+			res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: bookv1.BookService_ServiceDesc.ServiceName})
+			if err == nil && res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+				t.Fatalf("Service should be serving, is: %s\n", res.GetStatus())
+			}
+			return err
+		}, codes.OK},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			if tt.apiKey != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", tt.apiKey)
+			}
+
+			// when
+			err := tt.call(ctx)
+
+			// then
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Fatalf("Expected code %s but received: %s (%v)\n", tt.expectedCode, code, err)
+			}
+		})
+	}
+}
+
+func Test_GRPC_Limits(t *testing.T) {
+	t.Run("Shares rate limit with REST routes", func(t *testing.T) {
+		// setup
+		keys := &apiKeyRepoStub{m: map[string]*models.APIKey{}}
+		readerKey := addAPIKey(t, keys, auth.RoleReader)
+		ts := &Server{
+			dbRepo:        prepareDbRepo(3),
+			authenticator: auth.NewAuthenticator(keys, nil),
+			routeLimits:   map[string]routeLimits{readRoutes: {rate: 0.001, burst: 1}},
+		}
+		client := bookv1.NewBookServiceClient(prepareGRPCConn(t, ts))
+
+		// given
+		req := httptest.NewRequest(http.MethodGet, "/book", nil)
+		req.Header.Set("X-API-Key", readerKey)
+		w := httptest.NewRecorder()
+		ts.routes().ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("[SETUP] Expected status %d but received: %d\n", http.StatusOK, w.Code)
+		}
+
+		// when
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", readerKey)
+		_, err := client.GetBook(ctx, &bookv1.GetBookRequest{Id: "1"})
+
+		// then
+		if code := status.Code(err); code != codes.ResourceExhausted {
+			t.Fatalf("Expected code %s but received: %s (%v)\n", codes.ResourceExhausted, code, err)
+		}
+	})
+
+	t.Run("Rejects call when server is busy", func(t *testing.T) {
+		// setup
+		ts := &Server{
+			dbRepo:          prepareDbRepo(3),
+			concurrency:     ratelimit.NewConcurrencyLimiter(1),
+			concurrencyWait: 10 * time.Millisecond,
+		}
+		client := bookv1.NewBookServiceClient(prepareGRPCConn(t, ts))
+
+		// given
+		ts.concurrency.Acquire(context.Background())
+		defer ts.concurrency.Release()
+
+		// when
+		stream, err := client.ListBooks(context.Background(), &bookv1.ListBooksRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+
+		// then
+		if code := status.Code(err); code != codes.ResourceExhausted {
+			t.Fatalf("Expected code %s but received: %s (%v)\n", codes.ResourceExhausted, code, err)
+		}
+	})
+}
+
+// utils
+
+func prepareGRPCConn(t *testing.T, s *Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := s.newGRPCServer()
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while connecting to gRPC server: %s\n", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
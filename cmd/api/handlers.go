@@ -2,42 +2,119 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
 	"github.com/go-chi/chi/v5"
 	"net/http"
 )
 
 func (s *Server) handleGetAllBooks(w http.ResponseWriter, r *http.Request) {
-	books, err := s.dbRepo.GetAllBooks()
+	byRating, err := s.sortByRating(r)
 	if err != nil {
-		_ = handleErrorJSON(w, err, http.StatusInternalServerError)
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	_ = handleSuccessfulJSON(w, "", books, http.StatusOK)
+	filter, err := s.taxonomyFilter(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	facets, err := wantsFacets(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var books []*models.Book
+	switch {
+	case !filter.IsZero():
+		books, err = s.findBooks(r, repo, filter)
+	case byRating:
+		books, err = booksByRating(repo, 0, 0)
+	default:
+		books, err = repo.GetAllBooks()
+	}
+	if errors.Is(err, repository.ErrRatingSortUnsupported) {
+		_ = handleErrorJSON(w, r, expose(err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	items, err := s.withRatings(r, books, byRating && !filter.IsZero())
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var meta any
+	if facets {
+		counts, err := s.countFacets(r, repo, filter, books)
+		if err != nil {
+			_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		meta = &bookListMeta{Facets: counts}
+	}
+
+	_ = handleSuccessfulJSONWithMeta(w, items, meta, http.StatusOK)
 }
 
 func (s *Server) handleGetBook(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	book, err := s.dbRepo.GetBook(id)
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	book, err := repo.GetBook(id)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	payload, err := s.withDetails(r, book)
 	if err != nil {
-		_ = handleErrorJSON(w, err, http.StatusNotFound)
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	_ = handleSuccessfulJSON(w, "", book, http.StatusOK)
+	_ = handleSuccessfulJSON(w, "", payload, http.StatusOK)
 }
 
 func (s *Server) handleAddBook(w http.ResponseWriter, r *http.Request) {
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
 	var book *models.Book
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		_ = handleErrorJSON(w, err, http.StatusBadRequest)
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	if err = validateBook(book); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	book, err := s.dbRepo.AddBook(book)
+	book, err = repo.AddBook(book)
 	if err != nil {
-		_ = handleErrorJSON(w, err, http.StatusInternalServerError)
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -46,15 +123,26 @@ func (s *Server) handleAddBook(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleUpdateBook(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
 	var book *models.Book
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		_ = handleErrorJSON(w, err, http.StatusBadRequest)
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	if err = validateBook(book); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	err := s.dbRepo.UpdateBook(id, book)
+	err = repo.UpdateBook(id, book)
 	if err != nil {
-		_ = handleErrorJSON(w, err, http.StatusInternalServerError)
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -63,10 +151,15 @@ func (s *Server) handleUpdateBook(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleDeleteBook(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
 
-	err := s.dbRepo.DeleteBook(id)
+	err = repo.DeleteBook(id)
 	if err != nil {
-		_ = handleErrorJSON(w, err, http.StatusBadRequest)
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -74,11 +167,48 @@ func (s *Server) handleDeleteBook(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleDeleteAll(w http.ResponseWriter, r *http.Request) {
-	err := s.dbRepo.DeleteAllBooks()
+	repo, err := s.bookRepo(r)
 	if err != nil {
-		_ = handleErrorJSON(w, err, http.StatusInternalServerError)
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = repo.DeleteAllBooks()
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	_ = handleSuccessfulJSON(w, "", nil, http.StatusNoContent)
 }
+
+func (s *Server) handleReconciliation(w http.ResponseWriter, r *http.Request) {
+	report, err := s.reconciler.Reconcile()
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = handleSuccessfulJSON(w, "", report, http.StatusOK)
+}
+
+// maxBookFieldLength matches the size of name and author columns.
+const maxBookFieldLength = 40
+
+func validateBook(b *models.Book) error {
+	if b == nil {
+		return newPublicError("request body must be a book")
+	}
+
+	v := &validationError{}
+	fields := []struct{ name, value string }{{"name", b.Name}, {"author", b.Author}}
+	for _, f := range fields {
+		switch {
+		case f.value == "":
+			v.add(f.name, "is required")
+		case len([]rune(f.value)) > maxBookFieldLength:
+			v.add(f.name, fmt.Sprintf("must have at most %d characters", maxBookFieldLength))
+		}
+	}
+	return v.errOrNil()
+}
@@ -5,11 +5,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/auwendil/crud-app/internal/idmap"
 	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/dualwrite"
 	"github.com/go-chi/chi/v5"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -412,10 +418,62 @@ func Test_Server_HandleDeleteAllBooksShouldDeleteAllBooks(t *testing.T) {
 	}
 }
 
+func Test_Server_HandleReconciliation(t *testing.T) {
+	// setup
+	mapping, err := idmap.OpenFileStore(filepath.Join(t.TempDir(), "mapping.csv"))
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while opening mapping file: %s\n", err)
+	}
+	defer mapping.Close()
+
+	primary, secondary := prepareDbRepo(3), prepareDbRepo(0)
+	ts := &Server{
+		dbRepo: primary,
+	}
+	ts.reconciler = dualwrite.New(primary, secondary, mapping, false)
+
+	// given
+	req := httptest.NewRequest(http.MethodGet, "/admin/reconciliation", nil)
+	w := httptest.NewRecorder()
+
+	// when
+	ts.handleReconciliation(w, req)
+
+	httpResponse := w.Result()
+	defer httpResponse.Body.Close()
+
+	// then
+	if httpResponse.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d(%s) but received: %d(%s)\n",
+			http.StatusOK, http.StatusText(http.StatusOK),
+			httpResponse.StatusCode, http.StatusText(httpResponse.StatusCode))
+	}
+
+	jsonResponse := parseHttpResponse(t, httpResponse)
+	if jsonResponse.Error {
+		t.Fatal("Encountered error but there should be none:", jsonResponse)
+	}
+
+	parsedData, _ := json.Marshal(jsonResponse.Data)
+	var report dualwrite.Reconciliation
+	if err = json.Unmarshal(parsedData, &report); err != nil {
+		t.Fatalf("Encountered error while unmarshalling received data (%+v): %s\n", jsonResponse.Data, err)
+	}
+
+	if len(report.Differences) != len(storedBooks) {
+		t.Fatalf("Every unmapped book should be reported, has %d differences, should be %d\n", len(report.Differences), len(storedBooks))
+	}
+}
+
 // utils
 
 type dbRepoStub struct {
 	m map[string]*models.Book
+	// ratings stand in for ratings copied to books, books cannot be
+	// sorted by rating without them
+	ratings repository.ReviewRepo
+
+	getBooksByIDsCalls int
 }
 
 func (r *dbRepoStub) GetAllBooks() ([]*models.Book, error) {
@@ -426,10 +484,94 @@ func (r *dbRepoStub) GetAllBooks() ([]*models.Book, error) {
 	return books, nil
 }
 
+func (r *dbRepoStub) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	books, _ := r.GetAllBooks()
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+
+	if offset >= len(books) {
+		return []*models.Book{}, nil
+	}
+	if offset+limit > len(books) {
+		limit = len(books) - offset
+	}
+	return books[offset : offset+limit], nil
+}
+
+func (r *dbRepoStub) GetBooksByRating(offset, limit int) ([]*models.Book, error) {
+	if r.ratings == nil {
+		return nil, repository.ErrRatingSortUnsupported
+	}
+
+	books, _ := r.GetAllBooks()
+	ids := make([]string, len(books))
+	for i, b := range books {
+		ids[i] = b.ID
+	}
+	ratings, err := r.ratings.GetRatings(repository.DefaultTenant, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(books, func(i, j int) bool {
+		a, b := ratings[books[i].ID], ratings[books[j].ID]
+		switch {
+		case a == nil && b == nil:
+			return books[i].ID < books[j].ID
+		case a == nil || b == nil:
+			return b == nil
+		case a.Average != b.Average:
+			return a.Average > b.Average
+		case a.Count != b.Count:
+			return a.Count > b.Count
+		}
+		return books[i].ID < books[j].ID
+	})
+
+	if offset >= len(books) {
+		return []*models.Book{}, nil
+	}
+	if limit == 0 || offset+limit > len(books) {
+		limit = len(books) - offset
+	}
+	return books[offset : offset+limit], nil
+}
+
+func (r *dbRepoStub) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	r.getBooksByIDsCalls++
+	books := []*models.Book{}
+	for _, id := range ids {
+		if b, ok := r.m[id]; ok {
+			books = append(books, b)
+		}
+	}
+	return books, nil
+}
+
+func (r *dbRepoStub) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	books, _ := r.GetAllBooks()
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+
+	phrase = strings.ToLower(phrase)
+	found := []*models.Book{}
+	for _, b := range books {
+		if strings.Contains(strings.ToLower(b.Name), phrase) || strings.Contains(strings.ToLower(b.Author), phrase) {
+			found = append(found, b)
+		}
+	}
+
+	if offset >= len(found) {
+		return []*models.Book{}, nil
+	}
+	if offset+limit > len(found) {
+		limit = len(found) - offset
+	}
+	return found[offset : offset+limit], nil
+}
+
 func (r *dbRepoStub) GetBook(id string) (*models.Book, error) {
 	b, ok := r.m[id]
 	if !ok {
-		return nil, fmt.Errorf("book (id=%s) not found", id)
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
 	}
 	return b, nil
 }
@@ -445,7 +587,7 @@ func (r *dbRepoStub) AddBook(b *models.Book) (*models.Book, error) {
 
 func (r *dbRepoStub) UpdateBook(id string, updatedBook *models.Book) error {
 	if _, ok := r.m[id]; !ok {
-		return fmt.Errorf("Book does not exist")
+		return fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
 	}
 	r.m[id] = updatedBook
 	return nil
@@ -464,6 +606,35 @@ func (r *dbRepoStub) DeleteAllBooks() error {
 	return nil
 }
 
+func (r *dbRepoStub) CountAuthors(ids []string) ([]*models.FacetCount, error) {
+	books, _ := r.GetAllBooks()
+	if ids != nil {
+		books, _ = r.GetBooksByIDs(ids)
+	}
+
+	byAuthor := make(map[string]int)
+	for _, b := range books {
+		byAuthor[b.Author]++
+	}
+	return sortedFacetCounts(byAuthor), nil
+}
+
+// sortedFacetCounts lists counts the way repositories do, most frequent
+// values first.
+func sortedFacetCounts(byValue map[string]int) []*models.FacetCount {
+	counts := []*models.FacetCount{}
+	for value, count := range byValue {
+		counts = append(counts, &models.FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+	return counts
+}
+
 func prepareDbRepo(amountOfBooksLoaded int) *dbRepoStub {
 	repo := &dbRepoStub{
 		m: make(map[string]*models.Book),
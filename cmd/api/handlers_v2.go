@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// bookPage is a page of books returned by v2 list endpoint. NextOffset
+// is omitted on the last page, Facets count books of all pages and are
+// sent only when requested.
+type bookPage struct {
+	Items      []*bookDetails `json:"items"`
+	Offset     int            `json:"offset"`
+	Limit      int            `json:"limit"`
+	NextOffset *int           `json:"next_offset,omitempty"`
+	Facets     *bookFacets    `json:"facets,omitempty"`
+}
+
+// bookDetails is a book together with availability of its copies, when
+// circulation is enabled, its rating, when reviews are enabled and the
+// book has approved ones, its tags, categories and publication, when
+// taxonomy is enabled, and its cover, when covers are enabled and the book
+// has one.
+type bookDetails struct {
+	*models.Book
+	Availability  *models.Availability `json:"availability,omitempty"`
+	Rating        *models.Rating       `json:"rating,omitempty"`
+	Tags          []string             `json:"tags,omitempty"`
+	Categories    []string             `json:"categories,omitempty"`
+	Language      string               `json:"language,omitempty"`
+	PublishedYear int                  `json:"published_year,omitempty"`
+	Cover         *coverLinks          `json:"cover,omitempty"`
+}
+
+func (s *Server) handleListBooksV2(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	byRating, err := s.sortByRating(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	filter, err := s.taxonomyFilter(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	facets, err := wantsFacets(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	repo, ok := s.readBookRepo(w, r)
+	if !ok {
+		return
+	}
+
+	// filtered lists are sorted and paged once all matching books are known
+	inMemory := !filter.IsZero()
+	var books []*models.Book
+	switch {
+	case inMemory:
+		books, err = s.findBooks(r, repo, filter)
+	case byRating:
+		books, err = booksByRating(repo, offset, limit)
+	default:
+		books, err = repo.GetBooksBatch(offset, limit)
+	}
+	if errors.Is(err, repository.ErrRatingSortUnsupported) {
+		_ = handleErrorJSON(w, r, expose(err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	items, err := s.withRatings(r, books, byRating && inMemory)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if inMemory {
+		items = pageOf(items, offset, limit)
+	}
+
+	page := bookPage{Items: items, Offset: offset, Limit: limit}
+	if len(items) == limit {
+		next := offset + limit
+		page.NextOffset = &next
+	}
+	if facets {
+		if page.Facets, err = s.countFacets(r, repo, filter, books); err != nil {
+			_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_ = writeResource(w, page, http.StatusOK)
+}
+
+func (s *Server) handleGetBookV2(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	repo, ok := s.readBookRepo(w, r)
+	if !ok {
+		return
+	}
+
+	book, err := repo.GetBook(id)
+	if err != nil {
+		handleRepoError(w, r, err)
+		return
+	}
+
+	payload, err := s.withDetails(r, book)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, payload, http.StatusOK)
+}
+
+func (s *Server) handleAddBookV2(w http.ResponseWriter, r *http.Request) {
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	book, ok := readBook(w, r)
+	if !ok {
+		return
+	}
+
+	book, err = repo.AddBook(book)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	headers := http.Header{"Location": []string{"/v2/books/" + book.ID}}
+	_ = writeResource(w, book, http.StatusCreated, headers)
+}
+
+func (s *Server) handleUpdateBookV2(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	book, ok := readBook(w, r)
+	if !ok {
+		return
+	}
+
+	if err = repo.UpdateBook(id, book); err != nil {
+		handleRepoError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, &models.Book{ID: id, Name: book.Name, Author: book.Author}, http.StatusOK)
+}
+
+func (s *Server) handleDeleteBookV2(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err = repo.DeleteBook(id); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteAllV2(w http.ResponseWriter, r *http.Request) {
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err = repo.DeleteAllBooks(); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRepoError responds with 404 for missing books and hides every
+// other repository error behind 500.
+func handleRepoError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrBookNotFound) {
+		_ = handleErrorJSON(w, r, expose(err), http.StatusNotFound)
+		return
+	}
+	_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+}
+
+// readBook decodes and validates the book sent in request body, responding
+// with an error when it is not valid.
+func readBook(w http.ResponseWriter, r *http.Request) (*models.Book, bool) {
+	var book *models.Book
+	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return nil, false
+	}
+
+	if err := validateBook(book); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	return book, true
+}
+
+func pageParams(r *http.Request) (offset, limit int, err error) {
+	offset, limit = 0, defaultPageLimit
+	v := &validationError{}
+
+	if s := r.URL.Query().Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			v.add("offset", "must be a non-negative integer")
+		}
+	}
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxPageLimit {
+			v.add("limit", fmt.Sprintf("must be an integer between 1 and %d", maxPageLimit))
+		}
+	}
+
+	return offset, limit, v.errOrNil()
+}
+
+// writeResource writes payload as JSON without the JSONResponse envelope.
+func writeResource(w http.ResponseWriter, payload any, statusCode int, headers ...http.Header) error {
+	out, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return writeResponse(w, "application/json", out, statusCode, headers...)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/auwendil/crud-app/internal/repository/eventsourced"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// prepareEventSourcedRepo opens the event store named by spec, either
+// "postgresql" keeping events in the main PostgreSQL database or
+// "file=DIR" keeping them in files of DIR.
+func prepareEventSourcedRepo(spec, dbType, connString string, snapshotEvery int) (*eventsourced.Repo, error) {
+	kind, target, _ := strings.Cut(spec, "=")
+	switch {
+	case kind == "postgresql" && target == "":
+		if dbType != "postgresql" {
+			return nil, fmt.Errorf("postgresql event store requires postgresql database, has: %q", dbType)
+		}
+		db, err := database.OpenPostgreSQL(connString)
+		if err != nil {
+			return nil, err
+		}
+		return eventsourced.New(eventsourced.NewPostgreSQLStore(db), snapshotEvery)
+	case kind == "file" && target != "":
+		store, err := eventsourced.OpenFileStore(target)
+		if err != nil {
+			return nil, err
+		}
+		return eventsourced.New(store, snapshotEvery)
+	}
+
+	return nil, fmt.Errorf("invalid event store %q, available: [postgresql, file=DIR]", spec)
+}
+
+// readBookRepo returns the repository serving reads of the request. With
+// as_of query parameter it is the catalog replayed up to that time, which
+// only the event-sourced repository keeps. Errors are already written to
+// w when ok is false.
+func (s *Server) readBookRepo(w http.ResponseWriter, r *http.Request) (repo repository.BookRepo, ok bool) {
+	asOf := r.URL.Query().Get("as_of")
+	if asOf == "" {
+		repo, err := s.bookRepo(r)
+		if err != nil {
+			_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+			return nil, false
+		}
+		return repo, true
+	}
+
+	at, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		v := &validationError{}
+		v.add("as_of", "must be an RFC 3339 time")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if s.history == nil {
+		_ = handleErrorJSON(w, r, newPublicError("as_of is supported only with an event store"), http.StatusBadRequest)
+		return nil, false
+	}
+
+	history, err := s.history.Tenant(requestTenant(r.Context()))
+	if err == nil {
+		repo, err = history.AsOf(at)
+	}
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return repo, true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository/eventsourced"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_Server_History_V2ShouldReadPastCatalog(t *testing.T) {
+	// setup
+	store, err := eventsourced.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	history, err := eventsourced.New(store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// given
+	book, _ := history.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+	createdAt := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	_ = history.UpdateBook(book.ID, &models.Book{Name: "Book2", Author: "Author1"})
+
+	tests := []struct {
+		name           string
+		history        *eventsourced.Repo
+		asOf           string
+		expectedStatus int
+		expectedName   string
+	}{
+		{"Current catalog", history, "", http.StatusOK, "Book2"},
+		{"Past catalog", history, createdAt.Format(time.RFC3339Nano), http.StatusOK, "Book1"},
+		{"Invalid time", history, "yesterday", http.StatusBadRequest, ""},
+		{"Without event store", nil, createdAt.Format(time.RFC3339Nano), http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &Server{dbRepo: history, history: tt.history}
+			req := httptest.NewRequest(http.MethodGet, "/v2/books/"+book.ID+"?as_of="+url.QueryEscape(tt.asOf), nil)
+			w := httptest.NewRecorder()
+
+			// when
+			ts.routes().ServeHTTP(w, req)
+
+			// then
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d but received: %d, body: %s\n", tt.expectedStatus, w.Code, w.Body)
+			}
+
+			if tt.expectedName != "" {
+				var received models.Book
+				if err := json.Unmarshal(w.Body.Bytes(), &received); err != nil {
+					t.Fatal(err)
+				}
+				if received.Name != tt.expectedName {
+					t.Fatalf("Expected book %s, has: %s\n", tt.expectedName, received.Name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	defaultIdempotencyKeysTTL = 24 * time.Hour
+	// defaultIdempotencyKeyLease is renewed while the request is processed,
+	// a retry arriving after it ran out, e.g. because the server stopped,
+	// runs the request again.
+	defaultIdempotencyKeyLease = time.Minute
+)
+
+var (
+	errInvalidIdempotencyKey    = newPublicError("idempotency key must have 1 to 255 characters")
+	errIdempotencyKeyReused     = newPublicError("idempotency key was already used with a different request")
+	errIdempotencyKeyInProgress = newPublicError("request with this idempotency key is still in progress")
+)
+
+// idempotent makes POST requests carrying an Idempotency-Key header safe
+// to retry. The first request reserves the key together with a fingerprint
+// of the request, its response is stored and replayed to every retry.
+// Keys are scoped to the client and tenant, so clients cannot replay
+// responses of each other. The reservation is leased for idempotencyLease
+// and renewed while the request is processed, so a key of a request which
+// never completed, e.g. because the server stopped, can be used again
+// soon; only a stored response is kept for idempotencyTTL.
+func (s *Server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if s.idempotencyKeys == nil || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			_ = handleErrorJSON(w, r, errInvalidIdempotencyKey, http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		leaseID, err := newLeaseID()
+		if err != nil {
+			_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now().UTC()
+		rec := &models.IdempotencyRecord{
+			Key:         idempotencyStoreKey(r, key),
+			Fingerprint: requestFingerprint(r, body),
+			LeaseID:     leaseID,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.idempotencyLease),
+		}
+
+		existing, err := s.idempotencyKeys.ReserveIdempotencyKey(rec)
+		if err != nil {
+			_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		if existing != nil {
+			replayResponse(w, r, existing, rec.Fingerprint)
+			return
+		}
+
+		stopRenewal := s.renewIdempotencyLease(rec.Key, rec.LeaseID)
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		stopRenewal()
+
+		// server errors are not stored, so the client can retry them
+		if recorder.statusCode >= http.StatusInternalServerError {
+			if err = s.idempotencyKeys.DeleteIdempotencyKey(rec.Key, rec.LeaseID); err != nil {
+				log.Printf("idempotency: releasing key %q failed: %s\n", rec.Key, err)
+			}
+			return
+		}
+
+		rec.StatusCode = recorder.statusCode
+		rec.ContentType = recorder.Header().Get("Content-Type")
+		rec.ResponseBody = recorder.body.Bytes()
+		rec.ExpiresAt = time.Now().UTC().Add(s.idempotencyTTL)
+		if err = s.idempotencyKeys.CompleteIdempotencyKey(rec); err != nil {
+			log.Printf("idempotency: storing response of key %q failed: %s\n", rec.Key, err)
+		}
+	})
+}
+
+// renewIdempotencyLease extends the reservation of key every third of the
+// lease until the returned function is called, so retries cannot take over
+// the key of a request which is still processed.
+func (s *Server) renewIdempotencyLease(key, leaseID string) (stop func()) {
+	interval := s.idempotencyLease / 3
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				expiresAt := time.Now().UTC().Add(s.idempotencyLease)
+				if err := s.idempotencyKeys.ExtendIdempotencyKey(key, leaseID, expiresAt); err != nil {
+					log.Printf("idempotency: renewing lease of key %q failed: %s\n", key, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// newLeaseID returns a random ID of the request holding a key.
+func newLeaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func replayResponse(w http.ResponseWriter, r *http.Request, rec *models.IdempotencyRecord, fingerprint string) {
+	switch {
+	case rec.Fingerprint != fingerprint:
+		_ = handleErrorJSON(w, r, errIdempotencyKeyReused, http.StatusUnprocessableEntity)
+		return
+	case rec.StatusCode == 0:
+		w.Header().Set("Retry-After", "1")
+		_ = handleErrorJSON(w, r, errIdempotencyKeyInProgress, http.StatusConflict)
+		return
+	}
+
+	if rec.ContentType != "" {
+		w.Header().Set("Content-Type", rec.ContentType)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(rec.StatusCode)
+	_, _ = w.Write(rec.ResponseBody)
+}
+
+// idempotencyStoreKey scopes key to the tenant and the client. The parts
+// are length prefixed and hashed, so the stored key has a fixed length
+// whatever the lengths of the key and of the subject of the client are.
+func idempotencyStoreKey(r *http.Request, key string) string {
+	tenant, _ := r.Context().Value(tenantKey{}).(string)
+	if tenant == "" {
+		tenant = repository.DefaultTenant
+	}
+
+	h := sha256.New()
+	for _, part := range []string{tenant, clientKey(r), key} {
+		_, _ = fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// expireIdempotencyKeys removes expired keys every interval until stop
+// is closed.
+func (s *Server) expireIdempotencyKeys(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deleted, err := s.idempotencyKeys.DeleteExpiredIdempotencyKeys(time.Now().UTC())
+			if err != nil {
+				log.Println("idempotency: deleting expired keys failed:", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("idempotency: deleted %d expired keys\n", deleted)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// responseRecorder passes the response to the client and keeps a copy
+// of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if !rr.wroteHeader {
+		rr.statusCode = statusCode
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if !rr.wroteHeader {
+		rr.WriteHeader(http.StatusOK)
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Server_Idempotency_ShouldReplayResponse(t *testing.T) {
+	// setup
+	repo := prepareDbRepo(0)
+	ts := &Server{
+		dbRepo:          repo,
+		idempotencyKeys: &idempotencyRepoStub{m: map[string]*models.IdempotencyRecord{}},
+		idempotencyTTL:  time.Hour,
+	}
+
+	// given
+	payload := `{"id":"10","name":"Name10","author":"Author10"}`
+	first := postBook(ts, "retry-1", payload)
+
+	// when
+	retry := postBook(ts, "retry-1", payload)
+
+	// then
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d for both requests but received: %d and %d\n", http.StatusCreated, first.Code, retry.Code)
+	}
+
+	if retry.Body.String() != first.Body.String() {
+		t.Fatalf("Replayed response differs from original one: %s vs %s\n", retry.Body.String(), first.Body.String())
+	}
+
+	if retry.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Fatalf("Replayed response should have %s header\n", idempotentReplayedHeader)
+	}
+
+	if len(repo.m) != 1 {
+		t.Fatalf("Book should be added once, repository has %d books\n", len(repo.m))
+	}
+}
+
+func Test_Server_Idempotency_ShouldRejectReusedKey(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo:          prepareDbRepo(0),
+		idempotencyKeys: &idempotencyRepoStub{m: map[string]*models.IdempotencyRecord{}},
+		idempotencyTTL:  time.Hour,
+	}
+
+	// given
+	_ = postBook(ts, "retry-1", `{"id":"10","name":"Name10","author":"Author10"}`)
+
+	// when
+	w := postBook(ts, "retry-1", `{"id":"11","name":"Name11","author":"Author11"}`)
+
+	// then
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func Test_Server_Idempotency_ShouldAcceptExpiredKey(t *testing.T) {
+	// setup
+	repo := prepareDbRepo(0)
+	ts := &Server{
+		dbRepo:          repo,
+		idempotencyKeys: &idempotencyRepoStub{m: map[string]*models.IdempotencyRecord{}},
+		idempotencyTTL:  -time.Second,
+	}
+
+	// given
+	_ = postBook(ts, "retry-1", `{"id":"10","name":"Name10","author":"Author10"}`)
+
+	// when
+	w := postBook(ts, "retry-1", `{"id":"11","name":"Name11","author":"Author11"}`)
+
+	// then
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusCreated, w.Code)
+	}
+
+	if len(repo.m) != 2 {
+		t.Fatalf("Both books should be added, repository has %d books\n", len(repo.m))
+	}
+}
+
+func Test_Server_Idempotency_ShouldStoreHashedKey(t *testing.T) {
+	// setup
+	keys := &idempotencyRepoStub{m: map[string]*models.IdempotencyRecord{}}
+	ts := &Server{
+		dbRepo:          prepareDbRepo(0),
+		idempotencyKeys: keys,
+		idempotencyTTL:  time.Hour,
+	}
+
+	// when
+	w := postBook(ts, strings.Repeat("k", maxIdempotencyKeyLength), `{"id":"10","name":"Name10","author":"Author10"}`)
+
+	// then
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusCreated, w.Code)
+	}
+
+	for key := range keys.m {
+		if len(key) != sha256.Size*2 {
+			t.Fatalf("Stored key should be a hex encoded hash, has: %q\n", key)
+		}
+	}
+}
+
+func Test_Server_Idempotency_ShouldLeaseKeyUntilResponseIsStored(t *testing.T) {
+	tests := []struct {
+		name           string
+		leaseLeft      time.Duration
+		expectedStatus int
+		expectedBooks  int
+	}{
+		{"Rejects retry while original request holds the key", time.Minute, http.StatusConflict, 0},
+		{"Takes over key of request which never completed", -time.Second, http.StatusCreated, 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			repo := prepareDbRepo(0)
+			keys := &idempotencyRepoStub{m: map[string]*models.IdempotencyRecord{}}
+			ts := &Server{
+				dbRepo:           repo,
+				idempotencyKeys:  keys,
+				idempotencyTTL:   time.Hour,
+				idempotencyLease: time.Minute,
+			}
+
+			// given
+			payload := `{"id":"10","name":"Name10","author":"Author10"}`
+			req := httptest.NewRequest(http.MethodPost, "/book", strings.NewReader(payload))
+			key := idempotencyStoreKey(req, "retry-1")
+			keys.m[key] = &models.IdempotencyRecord{
+				Key:         key,
+				Fingerprint: requestFingerprint(req, []byte(payload)),
+				CreatedAt:   time.Now().UTC().Add(-time.Minute),
+				ExpiresAt:   time.Now().UTC().Add(tt.leaseLeft),
+			}
+
+			// when
+			w := postBook(ts, "retry-1", payload)
+
+			// then
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d but received: %d\n", tt.expectedStatus, w.Code)
+			}
+			if len(repo.m) != tt.expectedBooks {
+				t.Fatalf("Expected %d books, repository has %d books\n", tt.expectedBooks, len(repo.m))
+			}
+			if tt.expectedStatus == http.StatusCreated {
+				if rec := keys.m[key]; rec.StatusCode != http.StatusCreated || rec.ExpiresAt.Before(time.Now().Add(59*time.Minute)) {
+					t.Fatalf("Stored response should be kept for the TTL, has: %d expiring at %s\n", rec.StatusCode, rec.ExpiresAt)
+				}
+			}
+		})
+	}
+}
+
+func Test_Server_Idempotency_ShouldReserveKeyForLeaseOnly(t *testing.T) {
+	// setup
+	keys := &idempotencyRepoStub{m: map[string]*models.IdempotencyRecord{}}
+	var reserved models.IdempotencyRecord
+	ts := &Server{
+		dbRepo:           prepareDbRepo(0),
+		idempotencyKeys:  &reservationSpy{idempotencyRepoStub: keys, reserved: &reserved},
+		idempotencyTTL:   time.Hour,
+		idempotencyLease: time.Minute,
+	}
+
+	// when
+	_ = postBook(ts, "retry-1", `{"id":"10","name":"Name10","author":"Author10"}`)
+
+	// then
+	if lease := reserved.ExpiresAt.Sub(reserved.CreatedAt); lease != time.Minute || reserved.StatusCode != 0 {
+		t.Fatalf("Key should be reserved in progress for the lease, has: %d for %s\n", reserved.StatusCode, lease)
+	}
+}
+
+func Test_Server_Idempotency_ShouldRenewLeaseOfSlowRequest(t *testing.T) {
+	// setup
+	repo := prepareDbRepo(0)
+	ts := &Server{
+		idempotencyKeys:  &idempotencyRepoStub{m: map[string]*models.IdempotencyRecord{}},
+		idempotencyTTL:   time.Hour,
+		idempotencyLease: 30 * time.Millisecond,
+	}
+
+	// given
+	payload := `{"id":"10","name":"Name10","author":"Author10"}`
+	var retry *httptest.ResponseRecorder
+	ts.dbRepo = &addBookHook{dbRepoStub: repo, fn: func(b *models.Book) (*models.Book, error) {
+		// the request outlasts its lease several times before the retry
+		time.Sleep(100 * time.Millisecond)
+		retry = postBook(ts, "retry-1", payload)
+		return repo.AddBook(b)
+	}}
+
+	// when
+	first := postBook(ts, "retry-1", payload)
+
+	// then
+	if first.Code != http.StatusCreated || retry.Code != http.StatusConflict {
+		t.Fatalf("Expected statuses %d and %d but received: %d and %d\n", http.StatusCreated, http.StatusConflict, first.Code, retry.Code)
+	}
+
+	if len(repo.m) != 1 {
+		t.Fatalf("Book should be added once, repository has %d books\n", len(repo.m))
+	}
+}
+
+func Test_Server_Idempotency_ShouldReleaseOwnLeaseOnly(t *testing.T) {
+	// setup
+	keys := &idempotencyRepoStub{m: map[string]*models.IdempotencyRecord{}}
+	ts := &Server{
+		idempotencyKeys:  keys,
+		idempotencyTTL:   time.Hour,
+		idempotencyLease: time.Minute,
+	}
+
+	// given
+	ts.dbRepo = &addBookHook{dbRepoStub: prepareDbRepo(0), fn: func(b *models.Book) (*models.Book, error) {
+		keys.takeOver("retry-lease")
+		return nil, errors.New("insert failed")
+	}}
+
+	// when
+	w := postBook(ts, "retry-1", `{"id":"10","name":"Name10","author":"Author10"}`)
+
+	// then
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusInternalServerError, w.Code)
+	}
+
+	if len(keys.m) != 1 {
+		t.Fatalf("Key taken over by another request should stay, has %d keys\n", len(keys.m))
+	}
+}
+
+// utils
+
+// reservationSpy keeps a copy of the last reserved record.
+type reservationSpy struct {
+	*idempotencyRepoStub
+	reserved *models.IdempotencyRecord
+}
+
+func (r *reservationSpy) ReserveIdempotencyKey(rec *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	*r.reserved = *rec
+	return r.idempotencyRepoStub.ReserveIdempotencyKey(rec)
+}
+
+type idempotencyRepoStub struct {
+	mu sync.Mutex
+	m  map[string]*models.IdempotencyRecord
+}
+
+func (r *idempotencyRepoStub) ReserveIdempotencyKey(rec *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.m[rec.Key]; ok && existing.ExpiresAt.After(rec.CreatedAt) {
+		return existing, nil
+	}
+	stored := *rec
+	r.m[rec.Key] = &stored
+	return nil, nil
+}
+
+func (r *idempotencyRepoStub) ExtendIdempotencyKey(key, leaseID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.m[key]; ok && stored.LeaseID == leaseID && stored.StatusCode == 0 {
+		stored.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (r *idempotencyRepoStub) CompleteIdempotencyKey(rec *models.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.m[rec.Key]; ok && stored.LeaseID == rec.LeaseID {
+		completed := *rec
+		r.m[rec.Key] = &completed
+	}
+	return nil
+}
+
+func (r *idempotencyRepoStub) DeleteIdempotencyKey(key, leaseID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.m[key]; ok && stored.LeaseID == leaseID {
+		delete(r.m, key)
+	}
+	return nil
+}
+
+// takeOver leases every key to another request, as if their leases ran
+// out and retries reserved them.
+func (r *idempotencyRepoStub) takeOver(leaseID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rec := range r.m {
+		rec.LeaseID = leaseID
+	}
+}
+
+func (r *idempotencyRepoStub) DeleteExpiredIdempotencyKeys(now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for key, rec := range r.m {
+		if !rec.ExpiresAt.After(now) {
+			delete(r.m, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// addBookHook runs fn instead of adding books.
+type addBookHook struct {
+	*dbRepoStub
+	fn func(b *models.Book) (*models.Book, error)
+}
+
+func (r *addBookHook) AddBook(b *models.Book) (*models.Book, error) {
+	return r.fn(b)
+}
+
+func postBook(ts *Server, idempotencyKey, payload string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/book", strings.NewReader(payload))
+	req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+	w := httptest.NewRecorder()
+
+	ts.routes().ServeHTTP(w, req)
+	return w
+}
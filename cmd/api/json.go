@@ -9,14 +9,36 @@ type JSONResponse struct {
 	Error   bool        `json:"error"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
+	// Meta describes Data as a whole, e.g. facets of listed books.
+	Meta interface{} `json:"meta,omitempty"`
 }
 
 func handleSuccessfulJSON(w http.ResponseWriter, msg string, payload any, statusCode int, headers ...http.Header) error {
 	return writeJSON(w, false, msg, payload, statusCode, headers...)
 }
 
-func handleErrorJSON(w http.ResponseWriter, err error, statusCode int, headers ...http.Header) error {
-	return writeJSON(w, true, err.Error(), nil, statusCode, headers...)
+// handleSuccessfulJSONWithMeta responds like handleSuccessfulJSON, adding
+// meta to the envelope unless it is nil.
+func handleSuccessfulJSONWithMeta(w http.ResponseWriter, payload, meta any, statusCode int, headers ...http.Header) error {
+	out, err := json.Marshal(JSONResponse{Data: payload, Meta: meta})
+	if err != nil {
+		return err
+	}
+
+	return writeResponse(w, "application/json", out, statusCode, headers...)
+}
+
+// handleErrorJSON responds with problem+json when the client accepts it or
+// the route requires it, and with the JSONResponse envelope otherwise.
+// Messages of errors which are not public are logged and never sent to
+// the client.
+func handleErrorJSON(w http.ResponseWriter, r *http.Request, err error, statusCode int, headers ...http.Header) error {
+	p := newProblem(r, err, statusCode)
+
+	if problemDetailsRequired(r) || acceptsProblemJSON(r) {
+		return writeProblem(w, p, headers...)
+	}
+	return writeJSON(w, true, p.message(), nil, statusCode, headers...)
 }
 
 func writeJSON(w http.ResponseWriter, hasError bool, msg string, payload interface{}, statusCode int, headers ...http.Header) error {
@@ -25,16 +47,20 @@ func writeJSON(w http.ResponseWriter, hasError bool, msg string, payload interfa
 		return err
 	}
 
+	return writeResponse(w, "application/json", out, statusCode, headers...)
+}
+
+func writeResponse(w http.ResponseWriter, contentType string, out []byte, statusCode int, headers ...http.Header) error {
 	if len(headers) > 0 {
 		for k, v := range headers[0] {
 			w.Header()[k] = v
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(statusCode)
 
-	_, err = w.Write(out)
+	_, err := w.Write(out)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	readRoutes   = "read"
+	writeRoutes  = "write"
+	adminRoutes  = "admin"
+	uploadRoutes = "upload"
+)
+
+var (
+	errTooManyRequests = newPublicError("too many requests")
+	errServerBusy      = newPublicError("server is busy, try again later")
+	errRequestTooLarge = newPublicError("request body too large")
+)
+
+// routeLimits configures throttling of one route group. Zero values
+// disable the corresponding limit.
+type routeLimits struct {
+	rate         float64
+	burst        int
+	maxBodyBytes int64
+}
+
+// rateFlag parses rate limits given as "requests_per_second:burst".
+type rateFlag struct {
+	limits *routeLimits
+}
+
+func (f rateFlag) String() string {
+	if f.limits == nil {
+		return ""
+	}
+	return fmt.Sprintf("%g:%d", f.limits.rate, f.limits.burst)
+}
+
+func (f rateFlag) Set(s string) error {
+	rate, burst, ok := strings.Cut(s, ":")
+	if !ok {
+		return errors.New(`expected "requests_per_second:burst"`)
+	}
+
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r < 0 {
+		return fmt.Errorf("invalid rate %q", rate)
+	}
+
+	b, err := strconv.Atoi(burst)
+	if err != nil || b < 0 {
+		return fmt.Errorf("invalid burst %q", burst)
+	}
+
+	f.limits.rate, f.limits.burst = r, b
+	return nil
+}
+
+// rateLimiter returns the limiter of the given route group, created once
+// so REST routes and gRPC methods of the group share client buckets. It
+// returns nil when the group is not rate limited.
+func (s *Server) rateLimiter(group string) *ratelimit.Limiter {
+	s.rateLimitersMu.Lock()
+	defer s.rateLimitersMu.Unlock()
+
+	if limiter, ok := s.rateLimiters[group]; ok {
+		return limiter
+	}
+
+	var limiter *ratelimit.Limiter
+	if limits := s.routeLimits[group]; limits.rate > 0 && limits.burst > 0 {
+		limiter = ratelimit.NewLimiter(limits.rate, limits.burst)
+	}
+
+	if s.rateLimiters == nil {
+		s.rateLimiters = make(map[string]*ratelimit.Limiter)
+	}
+	s.rateLimiters[group] = limiter
+	return limiter
+}
+
+// limit applies rate and body size limits of the given route group.
+// Clients are identified by their principal when authenticated and by
+// remote IP otherwise.
+func (s *Server) limit(group string) func(http.Handler) http.Handler {
+	limits := s.routeLimits[group]
+	limiter := s.rateLimiter(group)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limiter != nil {
+				res := limiter.Allow(clientKey(r))
+				setRateLimitHeaders(w, res)
+
+				if !res.Allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+					_ = handleErrorJSON(w, r, errTooManyRequests, http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			if limits.maxBodyBytes > 0 {
+				if r.ContentLength > limits.maxBodyBytes {
+					_ = handleErrorJSON(w, r, errRequestTooLarge, http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limits.maxBodyBytes)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitConcurrency protects the database pool by bounding the amount of
+// requests processed at once. Requests waiting longer than the configured
+// time for a free slot are rejected.
+func (s *Server) limitConcurrency(next http.Handler) http.Handler {
+	if s.concurrency == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.concurrencyWait)
+		defer cancel()
+
+		if !s.concurrency.Acquire(ctx) {
+			w.Header().Set("Retry-After", "1")
+			_ = handleErrorJSON(w, r, errServerBusy, http.StatusServiceUnavailable)
+			return
+		}
+
+		var once sync.Once
+		release := func() { once.Do(s.concurrency.Release) }
+		defer release()
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), concurrencySlotKey{}, release)))
+	})
+}
+
+type concurrencySlotKey struct{}
+
+// releaseConcurrencySlot frees the slot of a long-lived request, such as
+// an event stream, so it does not count against concurrent requests.
+func releaseConcurrencySlot(r *http.Request) {
+	if release, ok := r.Context().Value(concurrencySlotKey{}).(func()); ok {
+		release()
+	}
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+}
+
+func clientKey(r *http.Request) string {
+	return clientKeyOf(r.Context(), r.RemoteAddr)
+}
+
+func clientKeyOf(ctx context.Context, remoteAddr string) string {
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		return principal.Subject
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// decodeErrorStatus maps errors returned while decoding a request body to
+// the status code reported to the client.
+func decodeErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Server_RateLimit(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo:      prepareDbRepo(3),
+		routeLimits: map[string]routeLimits{readRoutes: {rate: 1, burst: 2}},
+	}
+	router := ts.routes()
+
+	// given
+	var httpResponse *http.Response
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/book", nil))
+		httpResponse = w.Result()
+		httpResponse.Body.Close()
+	}
+
+	// then
+	if httpResponse.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d(%s) but received: %d(%s)\n",
+			http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests),
+			httpResponse.StatusCode, http.StatusText(httpResponse.StatusCode))
+	}
+
+	expectedHeaders := map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"Retry-After":         "1",
+	}
+	for header, expected := range expectedHeaders {
+		if value := httpResponse.Header.Get(header); value != expected {
+			t.Errorf("Header %s has value %q, should be %q\n", header, value, expected)
+		}
+	}
+}
+
+func Test_Server_MaxBodySize(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo:      prepareDbRepo(3),
+		routeLimits: map[string]routeLimits{writeRoutes: {maxBodyBytes: 64}},
+	}
+	router := ts.routes()
+
+	t.Run("Should reject body over declared limit", func(t *testing.T) {
+		// given
+		payload := preparePayload(t, &models.Book{Name: strings.Repeat("a", 100), Author: "Author"})
+		req := httptest.NewRequest(http.MethodPost, "/book", payload)
+		w := httptest.NewRecorder()
+
+		// when
+		router.ServeHTTP(w, req)
+
+		// then
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("Expected status %d but received: %d\n", http.StatusRequestEntityTooLarge, w.Code)
+		}
+	})
+
+	t.Run("Should reject streamed body over limit", func(t *testing.T) {
+		// given
+		payload := preparePayload(t, &models.Book{Name: strings.Repeat("a", 100), Author: "Author"})
+		req := httptest.NewRequest(http.MethodPost, "/book", payload)
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+
+		// when
+		router.ServeHTTP(w, req)
+
+		// then
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("Expected status %d but received: %d\n", http.StatusRequestEntityTooLarge, w.Code)
+		}
+	})
+
+	t.Run("Should accept body within limit", func(t *testing.T) {
+		// given
+		payload := preparePayload(t, &models.Book{ID: "9", Name: "Short", Author: "Author"})
+		req := httptest.NewRequest(http.MethodPost, "/book", payload)
+		w := httptest.NewRecorder()
+
+		// when
+		router.ServeHTTP(w, req)
+
+		// then
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d but received: %d\n", http.StatusCreated, w.Code)
+		}
+	})
+}
+
+func Test_Server_ConcurrencyLimit_ShouldRejectWhenBusy(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo:          prepareDbRepo(3),
+		concurrency:     ratelimit.NewConcurrencyLimiter(1),
+		concurrencyWait: 10 * time.Millisecond,
+	}
+	router := ts.routes()
+
+	// given
+	ts.concurrency.Acquire(context.Background())
+	defer ts.concurrency.Release()
+
+	w := httptest.NewRecorder()
+
+	// when
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/book", nil))
+
+	// then
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusServiceUnavailable, w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"net/http"
+)
+
+// authenticate attaches the principal of the request to its context.
+// It is a no-op when authentication is disabled.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authenticator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := s.authenticator.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="books"`)
+			_ = handleErrorJSON(w, r, expose(err), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+func (s *Server) requireRole(role auth.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.authenticator == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				_ = handleErrorJSON(w, r, expose(auth.ErrMissingCredentials), http.StatusUnauthorized)
+				return
+			}
+
+			if !principal.Role.Allows(role) {
+				err := fmt.Errorf("role %q is not allowed to access this resource, requires %q", principal.Role, role)
+				_ = handleErrorJSON(w, r, expose(err), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
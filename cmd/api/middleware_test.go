@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Server_Authorization(t *testing.T) {
+	// setup
+	keys := &apiKeyRepoStub{m: map[string]*models.APIKey{}}
+	readerKey := addAPIKey(t, keys, auth.RoleReader)
+	editorKey := addAPIKey(t, keys, auth.RoleEditor)
+	adminKey := addAPIKey(t, keys, auth.RoleAdmin)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		apiKey         string
+		expectedStatus int
+	}{
+		{"Rejects request without credentials", http.MethodGet, "/book", "", http.StatusUnauthorized},
+		{"Rejects unknown key", http.MethodGet, "/book", "bk_unknown", http.StatusUnauthorized},
+		{"Reader can list books", http.MethodGet, "/book", readerKey, http.StatusOK},
+		{"Reader cannot delete book", http.MethodDelete, "/book/1", readerKey, http.StatusForbidden},
+		{"Editor can delete book", http.MethodDelete, "/book/1", editorKey, http.StatusNoContent},
+		{"Editor cannot delete all books", http.MethodDelete, "/book", editorKey, http.StatusForbidden},
+		{"Admin can delete all books", http.MethodDelete, "/book", adminKey, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ts := &Server{
+				dbRepo:        prepareDbRepo(3),
+				authenticator: auth.NewAuthenticator(keys, nil),
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set(auth.APIKeyHeader, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+
+			// when
+			ts.routes().ServeHTTP(w, req)
+
+			httpResponse := w.Result()
+			defer httpResponse.Body.Close()
+
+			// then
+			if httpResponse.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d(%s) but received: %d(%s)\n",
+					tt.expectedStatus, http.StatusText(tt.expectedStatus),
+					httpResponse.StatusCode, http.StatusText(httpResponse.StatusCode))
+			}
+		})
+	}
+}
+
+// utils
+
+type apiKeyRepoStub struct {
+	m map[string]*models.APIKey
+}
+
+func (r *apiKeyRepoStub) GetAPIKeyByHash(hash string) (*models.APIKey, error) {
+	k, ok := r.m[hash]
+	if !ok {
+		return nil, fmt.Errorf("api key not found")
+	}
+	return k, nil
+}
+
+func (r *apiKeyRepoStub) AddAPIKey(k *models.APIKey) (*models.APIKey, error) {
+	k.ID = fmt.Sprint(len(r.m) + 1)
+	r.m[k.Hash] = k
+	return k, nil
+}
+
+func (r *apiKeyRepoStub) DeleteAPIKey(id string) error {
+	for hash, k := range r.m {
+		if k.ID == id {
+			delete(r.m, hash)
+		}
+	}
+	return nil
+}
+
+func addAPIKey(t *testing.T, keys *apiKeyRepoStub, role auth.Role) string {
+	key, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while generating api key: %s\n", err)
+	}
+
+	_, _ = keys.AddAPIKey(&models.APIKey{Name: string(role), Hash: auth.HashAPIKey(key), Role: string(role)})
+	return key
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/notify"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/auwendil/crud-app/internal/repository/notification"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNotifyInterval       = 15 * time.Minute
+	defaultNotifyDueSoon        = 48 * time.Hour
+	defaultNotifyChannelTimeout = 10 * time.Second
+)
+
+// notificationStore keeps notifications and elects the replica sending
+// them, both are kept in the db_type database.
+type notificationStore interface {
+	repository.NotificationRepo
+	repository.LeaderElector
+}
+
+type preferencesRequest struct {
+	Channels *[]string `json:"channels"`
+	DueSoon  *bool     `json:"due_soon"`
+	Overdue  *bool     `json:"overdue"`
+}
+
+func prepareNotificationRepo(dbType, connString string) (notificationStore, error) {
+	switch dbType {
+	case "postgresql":
+		db, err := database.OpenPostgreSQL(connString)
+		if err != nil {
+			return nil, err
+		}
+		return notification.NewPostgreSQLRepo(db), nil
+	case "mongodb":
+		db, err := database.OpenMongoDB(connString)
+		if err != nil {
+			return nil, err
+		}
+		repo := notification.NewMongoDBRepo(db)
+		return repo, repo.CreateIndexes()
+	}
+
+	return nil, fmt.Errorf("unsupported database type: %q", dbType)
+}
+
+// parseNotifyChannels builds channels from a comma separated list of kind
+// or kind=target entries, e.g. "log,email=smtp.example.com:587". Email is
+// sent from smtpFrom, authenticated when smtpUsername is not empty, webhook
+// requests are signed with webhookSecret unless it is empty.
+func parseNotifyChannels(spec, smtpFrom, smtpUsername, smtpPassword, webhookSecret string) ([]notify.Channel, error) {
+	var channels []notify.Channel
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kind, target, _ := strings.Cut(entry, "=")
+		switch {
+		case kind == notify.LogChannelName && target == "":
+			channels = append(channels, notify.NewLogChannel(log.New(os.Stdout, "", log.LstdFlags)))
+		case kind == notify.EmailChannelName && target != "":
+			channels = append(channels, notify.NewEmailChannel(target, smtpFrom, smtpUsername, smtpPassword))
+		case kind == notify.WebhookChannelName && target != "":
+			channels = append(channels, notify.NewWebhookChannel(target, webhookSecret, nil))
+		default:
+			return nil, fmt.Errorf("invalid notification channel %q, available: [log, email=HOST:PORT, webhook=URL]", entry)
+		}
+	}
+	return channels, nil
+}
+
+// notificationRoutes mounts preferences of members and the log of sent
+// notifications, for editors.
+func (s *Server) notificationRoutes(r chi.Router) {
+	r.Get("/member/{id}/preferences", s.handleGetPreferences)
+	r.Put("/member/{id}/preferences", s.handleSetPreferences)
+	r.Get("/notification", s.handleGetNotifications)
+}
+
+// handleGetPreferences responds with the defaults when the member did not
+// set any preferences.
+func (s *Server) handleGetPreferences(w http.ResponseWriter, r *http.Request) {
+	p, err := s.preferences(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, p, http.StatusOK)
+}
+
+// handleSetPreferences changes the fields sent in request body, others
+// keep their current values.
+func (s *Server) handleSetPreferences(w http.ResponseWriter, r *http.Request) {
+	p, err := s.preferences(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	var req *preferencesRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+	if err = s.validatePreferences(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.Channels != nil {
+		p.Channels = *req.Channels
+	}
+	if req.DueSoon != nil {
+		p.DueSoon = *req.DueSoon
+	}
+	if req.Overdue != nil {
+		p.Overdue = *req.Overdue
+	}
+
+	if err = s.notifications.SetPreferences(p); err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, p, http.StatusOK)
+}
+
+// handleGetNotifications lists sent notifications, newest first,
+// optionally only those of member_id or loan_id.
+func (s *Server) handleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := repository.NotificationFilter{MemberID: query.Get("member_id"), LoanID: query.Get("loan_id")}
+
+	notifications, err := s.notifications.GetNotifications(requestTenant(r.Context()), filter)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, notifications, http.StatusOK)
+}
+
+// preferences returns preferences of the existing member, the defaults
+// when it has none.
+func (s *Server) preferences(tenant, memberID string) (*models.NotificationPreferences, error) {
+	if _, err := s.circulation.GetMember(tenant, memberID); err != nil {
+		return nil, err
+	}
+
+	p, err := s.notifications.GetPreferences(tenant, memberID)
+	if errors.Is(err, repository.ErrPreferencesNotFound) {
+		return s.notifier.DefaultPreferences(tenant, memberID), nil
+	}
+	return p, err
+}
+
+func (s *Server) validatePreferences(req *preferencesRequest) error {
+	if req == nil {
+		return newPublicError("request body must be notification preferences")
+	}
+
+	v := &validationError{}
+	if req.Channels != nil {
+		available := s.notifier.ChannelNames()
+		seen := make(map[string]bool)
+		for _, name := range *req.Channels {
+			if seen[name] || !contains(available, name) {
+				v.add("channels", fmt.Sprintf("must be distinct channels of: %s", strings.Join(available, ", ")))
+				break
+			}
+			seen[name] = true
+		}
+	}
+	return v.errOrNil()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
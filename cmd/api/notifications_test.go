@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/notify"
+	"github.com/auwendil/crud-app/internal/repository"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Server_Notifications_ShouldKeepPreferencesOfMembers(t *testing.T) {
+	// setup
+	srv := prepareNotificationServer(t)
+
+	var member models.Member
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member1","email":"member1@example.com"}`, &member)
+
+	// given
+	var defaults models.NotificationPreferences
+	doJSON(t, http.MethodGet, srv.URL+"/member/"+member.ID+"/preferences", "", &defaults)
+
+	// when
+	var updated, stored models.NotificationPreferences
+	res := doJSON(t, http.MethodPut, srv.URL+"/member/"+member.ID+"/preferences", `{"channels":["log"],"due_soon":false}`, &updated)
+	doJSON(t, http.MethodGet, srv.URL+"/member/"+member.ID+"/preferences", "", &stored)
+
+	// then
+	if len(defaults.Channels) != 2 || !defaults.DueSoon || !defaults.Overdue {
+		t.Fatalf("Member should have default preferences, has: %+v\n", defaults)
+	}
+	if res.StatusCode != http.StatusOK || len(updated.Channels) != 1 || updated.DueSoon || !updated.Overdue {
+		t.Fatalf("Expected updated preferences, received %d: %+v\n", res.StatusCode, updated)
+	}
+	if fmt.Sprint(stored) != fmt.Sprint(updated) {
+		t.Fatalf("Stored preferences differ: %+v\n", stored)
+	}
+}
+
+func Test_Server_Notifications_ShouldRejectInvalidPreferences(t *testing.T) {
+	tests := []struct {
+		name           string
+		memberID       string
+		body           string
+		expectedStatus int
+	}{
+		{"Unknown channel", "1", `{"channels":["sms"]}`, http.StatusBadRequest},
+		{"Repeated channel", "1", `{"channels":["log","log"]}`, http.StatusBadRequest},
+		{"Missing member", "99", `{"overdue":false}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			srv := prepareNotificationServer(t)
+			doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member1","email":"member1@example.com"}`, nil)
+
+			// when
+			res := doJSON(t, http.MethodPut, srv.URL+"/member/"+tt.memberID+"/preferences", tt.body, nil)
+
+			// then
+			if res.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected %d, received %d\n", tt.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+}
+
+// utils
+
+func prepareNotificationServer(t *testing.T) *httptest.Server {
+	ts := NewServer("", prepareDbRepo(3))
+	ts.circulation = newCirculationRepoStub()
+	repo := &notificationRepoStub{preferences: map[string]*models.NotificationPreferences{}}
+	channels := []notify.Channel{
+		notify.NewLogChannel(log.New(io.Discard, "", 0)),
+		notify.NewWebhookChannel("http://localhost", "", nil),
+	}
+	ts.notifications = repo
+	ts.notifier = notify.NewScheduler(ts.circulation, repo, nil, channels, "test", time.Minute, time.Hour, time.Second)
+
+	srv := httptest.NewServer(ts.routes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type notificationRepoStub struct {
+	preferences map[string]*models.NotificationPreferences
+}
+
+func (r *notificationRepoStub) GetPreferences(tenant, memberID string) (*models.NotificationPreferences, error) {
+	p, ok := r.preferences[memberID]
+	if !ok {
+		return nil, repository.ErrPreferencesNotFound
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (r *notificationRepoStub) SetPreferences(p *models.NotificationPreferences) error {
+	stored := *p
+	r.preferences[p.MemberID] = &stored
+	return nil
+}
+
+func (r *notificationRepoStub) GetNotifications(tenant string, filter repository.NotificationFilter) ([]*models.Notification, error) {
+	return []*models.Notification{}, nil
+}
+
+func (r *notificationRepoStub) RecordNotification(n *models.Notification) (*models.Notification, error) {
+	return n, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/outbox"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/book"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	outboxrepo "github.com/auwendil/crud-app/internal/repository/outbox"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOutboxInterval    = 500 * time.Millisecond
+	defaultOutboxBatchSize   = 100
+	defaultOutboxSinkTimeout = 10 * time.Second
+)
+
+func prepareOutboxRepo(dbType, connString string) (repository.OutboxRepo, error) {
+	switch dbType {
+	case "postgresql":
+		db, err := database.OpenPostgreSQL(connString)
+		if err != nil {
+			return nil, err
+		}
+		return outboxrepo.NewPostgreSQLRepo(db), nil
+	case "mongodb":
+		db, err := database.OpenMongoDB(connString)
+		if err != nil {
+			return nil, err
+		}
+		return outboxrepo.NewMongoDBRepo(db), nil
+	}
+
+	return nil, fmt.Errorf("unsupported database type: %q", dbType)
+}
+
+func enableOutbox(repo repository.BookRepo) error {
+	switch r := repo.(type) {
+	case *book.PostgreSQLRepo:
+		r.SetOutbox(true)
+		return nil
+	case *book.MongoDBRepo:
+		r.SetOutbox(true)
+		return nil
+	}
+	return fmt.Errorf("repository does not support the outbox")
+}
+
+// parseOutboxSinks builds sinks from a comma separated list of kind or
+// kind=target entries, e.g. "log,file=events.jsonl,nats=localhost:4222".
+// Webhook sinks sign requests with webhookSecret unless it is empty, NATS
+// subjects start with natsPrefix.
+func parseOutboxSinks(spec, webhookSecret, natsPrefix string) ([]outbox.Sink, error) {
+	var sinks []outbox.Sink
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kind, target, _ := strings.Cut(entry, "=")
+		switch {
+		case kind == "log" && target == "":
+			sinks = append(sinks, outbox.NewLogSink(log.New(os.Stdout, "", log.LstdFlags)))
+		case kind == "file" && target != "":
+			sink, err := outbox.NewFileSink(target)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case kind == "webhook" && target != "":
+			sinks = append(sinks, outbox.NewWebhookSink(target, webhookSecret, nil))
+		case kind == "nats" && target != "":
+			sinks = append(sinks, outbox.NewNATSSink(target, natsPrefix))
+		default:
+			return nil, fmt.Errorf("invalid outbox sink %q, available: [log, file=PATH, webhook=URL, nats=HOST:PORT]", entry)
+		}
+	}
+	return sinks, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseOutboxSinks(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "events.jsonl")
+	tests := []struct {
+		name          string
+		spec          string
+		expectedSinks []string
+		expectedError bool
+	}{
+		{"Empty", "", nil, false},
+		{"Every kind", "log, file=" + file + ",webhook=https://example.com/events,nats=localhost:4222", []string{"log", "file", "webhook", "nats"}, false},
+		{"Missing target", "file", nil, true},
+		{"Unknown kind", "kafka=localhost:9092", nil, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			sinks, err := parseOutboxSinks(tt.spec, "", "books")
+
+			// then
+			if (err != nil) != tt.expectedError {
+				t.Fatalf("Expected error %t, has: %v\n", tt.expectedError, err)
+			}
+
+			if len(sinks) != len(tt.expectedSinks) {
+				t.Fatalf("Parsed %d sinks, should be %v\n", len(sinks), tt.expectedSinks)
+			}
+			for i, sink := range sinks {
+				if sink.Name() != tt.expectedSinks[i] {
+					t.Fatalf("Sink %d is %s, should be %s\n", i, sink.Name(), tt.expectedSinks[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:crud-app:problem:"
+)
+
+// problemTypes are stable identifiers of problems, clients should rely on
+// them instead of titles or details.
+var problemTypes = map[int]string{
+	http.StatusBadRequest:            "bad-request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not-found",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "request-too-large",
+	http.StatusUnsupportedMediaType:  "unsupported-media-type",
+	http.StatusUnprocessableEntity:   "unprocessable-entity",
+	http.StatusTooManyRequests:       "too-many-requests",
+	http.StatusInternalServerError:   "internal-error",
+	http.StatusServiceUnavailable:    "service-unavailable",
+}
+
+const validationProblemType = "validation-failed"
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// publicError marks errors whose message is meant for clients.
+type publicError struct {
+	err error
+}
+
+func (e publicError) Error() string {
+	return e.err.Error()
+}
+
+func (e publicError) Unwrap() error {
+	return e.err
+}
+
+func newPublicError(msg string) error {
+	return publicError{err: errors.New(msg)}
+}
+
+// expose marks err as safe to be shown to clients.
+func expose(err error) error {
+	return publicError{err: err}
+}
+
+// validationError lists every invalid field of a request body.
+type validationError struct {
+	fields []fieldError
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, 0, len(e.fields))
+	for _, f := range e.fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *validationError) add(field, msg string) {
+	e.fields = append(e.fields, fieldError{Field: field, Message: msg})
+}
+
+func (e *validationError) errOrNil() error {
+	if len(e.fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func newProblem(r *http.Request, err error, statusCode int) *problem {
+	p := &problem{
+		Type:      problemType(statusCode),
+		Title:     http.StatusText(statusCode),
+		Status:    statusCode,
+		Instance:  r.URL.Path,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+
+	var validationErr *validationError
+	var publicErr publicError
+	switch {
+	case errors.As(err, &validationErr):
+		p.Type = problemTypePrefix + validationProblemType
+		p.Detail = "request has invalid fields"
+		p.Errors = validationErr.fields
+	case errors.As(err, &publicErr):
+		p.Detail = publicErr.Error()
+	default:
+		log.Printf("request %s: %s %s failed with status %d: %s\n", p.RequestID, r.Method, r.URL.Path, statusCode, err)
+	}
+
+	return p
+}
+
+// message is the text sent in the JSONResponse envelope.
+func (p *problem) message() string {
+	switch {
+	case len(p.Errors) > 0:
+		return (&validationError{fields: p.Errors}).Error()
+	case p.Detail != "":
+		return p.Detail
+	}
+	return p.Title
+}
+
+func problemType(statusCode int) string {
+	if t, ok := problemTypes[statusCode]; ok {
+		return problemTypePrefix + t
+	}
+	return "about:blank"
+}
+
+func writeProblem(w http.ResponseWriter, p *problem, headers ...http.Header) error {
+	out, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return writeResponse(w, problemContentType, out, p.Status, headers...)
+}
+
+func acceptsProblemJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err == nil && mediaType == problemContentType && params["q"] != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exposeRequestID returns the ID of every request, assigned by
+// middleware.RequestID, in the X-Request-Id header.
+func exposeRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set(middleware.RequestIDHeader, id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeError turns errors of decoding a request body into public ones,
+// pointing at the invalid field when it is known.
+func decodeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return errRequestTooLarge
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		v := &validationError{}
+		v.add(typeErr.Field, fmt.Sprintf("must be %s", typeErr.Type.Kind()))
+		return v
+	}
+	return newPublicError("request body is not valid JSON")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Server_ErrorResponses_ShouldNotLeakInternalErrors(t *testing.T) {
+	tests := []struct {
+		name                string
+		accept              string
+		expectedContentType string
+	}{
+		{"Legacy envelope by default", "", "application/json"},
+		{"Problem details when accepted", "application/json;q=0.5, application/problem+json", problemContentType},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			ts := &Server{
+				dbRepo: prepareDbRepo(3),
+			}
+
+			// given
+			req := httptest.NewRequest(http.MethodGet, "/book/-1", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+
+			// when
+			ts.routes().ServeHTTP(w, req)
+
+			// then
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("Expected status %d but received: %d\n", http.StatusNotFound, w.Code)
+			}
+
+			if contentType := w.Header().Get("Content-Type"); contentType != tt.expectedContentType {
+				t.Fatalf("Expected content type %q but received: %q\n", tt.expectedContentType, contentType)
+			}
+
+			if strings.Contains(w.Body.String(), "id=-1") {
+				t.Fatalf("Response leaks repository error: %s\n", w.Body.String())
+			}
+
+			if w.Header().Get(middleware.RequestIDHeader) == "" {
+				t.Fatal("Response should carry request ID")
+			}
+		})
+	}
+}
+
+func Test_Server_ErrorResponses_ShouldDescribeProblem(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo: prepareDbRepo(3),
+	}
+
+	// given
+	req := httptest.NewRequest(http.MethodPost, "/book", strings.NewReader(`{"name":"Name"}`))
+	req.Header.Set("Accept", problemContentType)
+	w := httptest.NewRecorder()
+
+	// when
+	ts.routes().ServeHTTP(w, req)
+
+	// then
+	var p problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal("Response is not a valid problem:", err)
+	}
+
+	if p.Status != http.StatusBadRequest || p.Type != problemTypePrefix+validationProblemType {
+		t.Fatalf("Unexpected problem: %+v\n", p)
+	}
+
+	if p.RequestID == "" || p.RequestID != w.Header().Get(middleware.RequestIDHeader) {
+		t.Fatalf("Problem request ID (%q) should match response header\n", p.RequestID)
+	}
+
+	if len(p.Errors) != 1 || p.Errors[0].Field != "author" {
+		t.Fatalf("Problem should point at missing author, has: %+v\n", p.Errors)
+	}
+}
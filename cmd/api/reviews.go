@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/auwendil/crud-app/internal/repository/review"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	minReviewRating     = 1
+	maxReviewRating     = 5
+	maxReviewTextLength = 4000
+)
+
+// reviewStatuses are statuses moderators can set.
+var reviewStatuses = map[string]bool{
+	models.ReviewPending:  true,
+	models.ReviewApproved: true,
+	models.ReviewHidden:   true,
+}
+
+type reviewRequest struct {
+	MemberID string `json:"member_id"`
+	Rating   int    `json:"rating"`
+	Text     string `json:"text"`
+}
+
+type reviewStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// prepareReviewRepo opens reviews next to books kept with tenancy, ratings
+// are copied to the books so they can be sorted by rating.
+func prepareReviewRepo(dbType, connString string, tenancy repository.Tenancy) (repository.ReviewRepo, error) {
+	switch dbType {
+	case "postgresql":
+		db, err := database.OpenPostgreSQL(connString)
+		if err != nil {
+			return nil, err
+		}
+		repo := review.NewPostgreSQLRepo(db)
+		repo.SetTenancy(tenancy)
+		return repo, nil
+	case "mongodb":
+		db, err := database.OpenMongoDB(connString)
+		if err != nil {
+			return nil, err
+		}
+		repo := review.NewMongoDBRepo(db)
+		repo.SetTenancy(tenancy)
+		return repo, repo.CreateIndexes()
+	}
+
+	return nil, fmt.Errorf("unsupported database type: %q", dbType)
+}
+
+// reviewRoutes mounts reviews of books. Readers see approved reviews,
+// editors write reviews on behalf of members and see all of them, and
+// admins moderate them.
+func (s *Server) reviewRoutes(r chi.Router, limits map[string]func(http.Handler) http.Handler) {
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleReader))
+		r.Use(limits[readRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Get("/book/{id}/reviews", s.handleGetReviews)
+		r.Get("/book/{id}/reviews/{reviewID}", s.handleGetReview)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleEditor))
+		r.Use(limits[writeRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Post("/book/{id}/reviews", s.handleAddReview)
+		r.Put("/book/{id}/reviews/{reviewID}", s.handleUpdateReview)
+		r.Delete("/book/{id}/reviews/{reviewID}", s.handleDeleteReview)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleAdmin))
+		r.Use(limits[adminRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Put("/book/{id}/reviews/{reviewID}/status", s.handleSetReviewStatus)
+	})
+}
+
+// withDetails adds availability of copies, the rating, the taxonomy and
+// the cover to the book when circulation, reviews, taxonomy and covers are
+// enabled. Past versions of books have none of them.
+func (s *Server) withDetails(r *http.Request, book *models.Book) (*bookDetails, error) {
+	details := &bookDetails{Book: book}
+	if r.URL.Query().Get("as_of") != "" {
+		return details, nil
+	}
+
+	tenant := requestTenant(r.Context())
+	if s.circulation != nil {
+		a, err := s.circulation.GetAvailability(tenant, book.ID)
+		if err != nil {
+			return nil, err
+		}
+		details.Availability = a
+	}
+
+	if s.reviews != nil {
+		ratings, err := s.reviews.GetRatings(tenant, []string{book.ID})
+		if err != nil {
+			return nil, err
+		}
+		details.Rating = ratings[book.ID]
+	}
+
+	if err := s.withTaxonomies(tenant, []*bookDetails{details}); err != nil {
+		return nil, err
+	}
+	if err := s.withCovers(tenant, []*bookDetails{details}); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// withRatings adds ratings, taxonomies and covers to books when reviews,
+// taxonomy and covers are enabled, ordering them from the best rated ones when
+// byRating is set. Books rated equally are ordered by the amount of
+// reviews, unrated books come last and keep their order. Only filtered
+// lists are sorted here, whole catalogs come sorted from booksByRating.
+func (s *Server) withRatings(r *http.Request, books []*models.Book, byRating bool) ([]*bookDetails, error) {
+	items := make([]*bookDetails, len(books))
+	ids := make([]string, len(books))
+	for i, book := range books {
+		items[i] = &bookDetails{Book: book}
+		ids[i] = book.ID
+	}
+
+	if r.URL.Query().Get("as_of") != "" {
+		return items, nil
+	}
+
+	tenant := requestTenant(r.Context())
+	if err := s.withTaxonomies(tenant, items); err != nil {
+		return nil, err
+	}
+	if err := s.withCovers(tenant, items); err != nil {
+		return nil, err
+	}
+	if s.reviews == nil {
+		return items, nil
+	}
+
+	ratings, err := s.reviews.GetRatings(tenant, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		item.Rating = ratings[item.ID]
+	}
+
+	if byRating {
+		sort.SliceStable(items, func(i, j int) bool {
+			a, b := items[i].Rating, items[j].Rating
+			switch {
+			case a == nil || b == nil:
+				return b == nil && a != nil
+			case a.Average != b.Average:
+				return a.Average > b.Average
+			}
+			return a.Count > b.Count
+		})
+	}
+	return items, nil
+}
+
+// booksByRating returns the page of books from the best rated ones, all of
+// them from offset when limit is 0. The book repository sorts them by
+// ratings copied to books by the review repository.
+func booksByRating(repo repository.BookRepo, offset, limit int) ([]*models.Book, error) {
+	sorter, ok := repo.(repository.RatingSorter)
+	if !ok {
+		return nil, repository.ErrRatingSortUnsupported
+	}
+	return sorter.GetBooksByRating(offset, limit)
+}
+
+// sortByRating parses sort query parameter, books are ordered by their
+// IDs when it is missing.
+func (s *Server) sortByRating(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("sort") {
+	case "":
+		return false, nil
+	case "rating":
+		if s.reviews != nil && r.URL.Query().Get("as_of") == "" {
+			return true, nil
+		}
+		return false, newPublicError("sort=rating requires reviews of current books")
+	}
+
+	v := &validationError{}
+	v.add("sort", "must be rating")
+	return false, v.errOrNil()
+}
+
+// handleGetReviews lists reviews of the book, newest first. Readers see
+// approved reviews only, editors see all of them unless they ask for a
+// status. Reviews of a member are listed with member_id.
+func (s *Server) handleGetReviews(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	query := r.URL.Query()
+	filter := repository.ReviewFilter{MemberID: query.Get("member_id"), Status: query.Get("status")}
+
+	if filter.Status != "" && !reviewStatuses[filter.Status] {
+		v := &validationError{}
+		v.add("status", "must be one of: pending, approved, hidden")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+	if !s.allows(r, auth.RoleEditor) {
+		if filter.Status != "" && filter.Status != models.ReviewApproved {
+			err := fmt.Errorf("only approved reviews are available to role %q", auth.RoleReader)
+			_ = handleErrorJSON(w, r, expose(err), http.StatusForbidden)
+			return
+		}
+		filter.Status = models.ReviewApproved
+	}
+
+	if !s.bookExists(w, r, bookID) {
+		return
+	}
+
+	reviews, err := s.reviews.GetReviews(requestTenant(r.Context()), bookID, filter)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, reviews, http.StatusOK)
+}
+
+func (s *Server) handleGetReview(w http.ResponseWriter, r *http.Request) {
+	rv, err := s.bookReview(r)
+	if err == nil && rv.Status != models.ReviewApproved && !s.allows(r, auth.RoleEditor) {
+		err = fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, rv.ID)
+	}
+	if err != nil {
+		handleReviewError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, rv, http.StatusOK)
+}
+
+// handleAddReview adds the review of the member, it waits for approval
+// when reviews are moderated.
+func (s *Server) handleAddReview(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	req, ok := readReview(w, r)
+	if !ok {
+		return
+	}
+	if req.MemberID == "" {
+		v := &validationError{}
+		v.add("member_id", "is required")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	if !s.bookExists(w, r, bookID) {
+		return
+	}
+
+	status := models.ReviewApproved
+	if s.reviewModeration {
+		status = models.ReviewPending
+	}
+
+	rv, err := s.reviews.AddReview(&models.Review{
+		Tenant:    requestTenant(r.Context()),
+		BookID:    bookID,
+		MemberID:  req.MemberID,
+		Rating:    req.Rating,
+		Text:      req.Text,
+		Status:    status,
+		CreatedAt: time.Now().UTC(),
+	})
+	if errors.Is(err, repository.ErrMemberNotFound) {
+		handleReferenceError(w, r, err)
+		return
+	}
+	if err != nil {
+		handleReviewError(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Location": []string{"/book/" + bookID + "/reviews/" + rv.ID}}
+	_ = writeResource(w, rv, http.StatusCreated, headers)
+}
+
+// handleUpdateReview replaces rating and text of the review, which waits
+// for approval again when reviews are moderated. It stays a review of the
+// same member.
+func (s *Server) handleUpdateReview(w http.ResponseWriter, r *http.Request) {
+	existing, err := s.bookReview(r)
+	if err != nil {
+		handleReviewError(w, r, err)
+		return
+	}
+
+	req, ok := readReview(w, r)
+	if !ok {
+		return
+	}
+	if req.MemberID != "" && req.MemberID != existing.MemberID {
+		v := &validationError{}
+		v.add("member_id", "cannot be changed")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	existing.Rating = req.Rating
+	existing.Text = req.Text
+	existing.UpdatedAt = time.Now().UTC()
+	if s.reviewModeration {
+		existing.Status = models.ReviewPending
+	}
+
+	if err = s.reviews.UpdateReview(existing); err != nil {
+		handleReviewError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, existing, http.StatusOK)
+}
+
+func (s *Server) handleDeleteReview(w http.ResponseWriter, r *http.Request) {
+	rv, err := s.bookReview(r)
+	if err == nil {
+		err = s.reviews.DeleteReview(rv.Tenant, rv.ID)
+	}
+	if err != nil {
+		handleReviewError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetReviewStatus moderates the review, only approved reviews are
+// shown to readers and counted in the rating of the book.
+func (s *Server) handleSetReviewStatus(w http.ResponseWriter, r *http.Request) {
+	var req *reviewStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	if req == nil {
+		_ = handleErrorJSON(w, r, newPublicError("request body must be a review status"), http.StatusBadRequest)
+		return
+	}
+	if !reviewStatuses[req.Status] {
+		v := &validationError{}
+		v.add("status", "must be one of: pending, approved, hidden")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	rv, err := s.bookReview(r)
+	if err != nil {
+		handleReviewError(w, r, err)
+		return
+	}
+
+	rv.Status = req.Status
+	if err = s.reviews.UpdateReview(rv); err != nil {
+		handleReviewError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, rv, http.StatusOK)
+}
+
+// bookReview looks up the review named in the path, reviews of other
+// books are not found.
+func (s *Server) bookReview(r *http.Request) (*models.Review, error) {
+	id := chi.URLParam(r, "reviewID")
+	rv, err := s.reviews.GetReview(requestTenant(r.Context()), id)
+	if err != nil {
+		return nil, err
+	}
+	if rv.BookID != chi.URLParam(r, "id") {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, id)
+	}
+	return rv, nil
+}
+
+// bookExists responds with 404 when the book named in the path does not
+// exist.
+func (s *Server) bookExists(w http.ResponseWriter, r *http.Request, id string) bool {
+	repo, err := s.bookRepo(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+	if _, err = repo.GetBook(id); err != nil {
+		handleRepoError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// allows reports whether the caller has role, everyone has it when
+// authentication is disabled.
+func (s *Server) allows(r *http.Request, role auth.Role) bool {
+	if s.authenticator == nil {
+		return true
+	}
+
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	return ok && principal.Role.Allows(role)
+}
+
+// handleReviewError responds with 404 for missing reviews, 409 for second
+// reviews of members and hides every other error behind 500.
+func handleReviewError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrReviewNotFound):
+		_ = handleErrorJSON(w, r, expose(err), http.StatusNotFound)
+	case errors.Is(err, repository.ErrReviewExists):
+		_ = handleErrorJSON(w, r, expose(err), http.StatusConflict)
+	default:
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// readReview decodes and validates the review sent in request body,
+// responding with an error when it is not valid.
+func readReview(w http.ResponseWriter, r *http.Request) (*reviewRequest, bool) {
+	var req *reviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return nil, false
+	}
+
+	if err := validateReview(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+	return req, true
+}
+
+func validateReview(req *reviewRequest) error {
+	if req == nil {
+		return newPublicError("request body must be a review")
+	}
+
+	v := &validationError{}
+	if req.Rating < minReviewRating || req.Rating > maxReviewRating {
+		v.add("rating", fmt.Sprintf("must be an integer between %d and %d", minReviewRating, maxReviewRating))
+	}
+	if len([]rune(req.Text)) > maxReviewTextLength {
+		v.add("text", fmt.Sprintf("must have at most %d characters", maxReviewTextLength))
+	}
+	return v.errOrNil()
+}
+
+// pageOf returns the page of items starting at offset.
+func pageOf(items []*bookDetails, offset, limit int) []*bookDetails {
+	if offset >= len(items) {
+		return []*bookDetails{}
+	}
+	if end := offset + limit; end < len(items) {
+		return items[offset:end]
+	}
+	return items[offset:]
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/references"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func Test_Server_Reviews_ShouldRateBooksWithApprovedReviews(t *testing.T) {
+	// setup
+	srv := prepareReviewServer(t, &Server{reviewModeration: true})
+	first, second := addReviewMembers(t, srv)
+
+	// given
+	var pending models.Review
+	res := doJSON(t, http.MethodPost, srv.URL+"/book/1/reviews", fmt.Sprintf(`{"member_id":%q,"rating":4,"text":"Good"}`, first), &pending)
+	again := doJSON(t, http.MethodPost, srv.URL+"/book/1/reviews", fmt.Sprintf(`{"member_id":%q,"rating":1}`, first), nil)
+	var other models.Review
+	doJSON(t, http.MethodPost, srv.URL+"/book/1/reviews", fmt.Sprintf(`{"member_id":%q,"rating":5}`, second), &other)
+	var unrated bookDetails
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books/1", "", &unrated)
+
+	// when
+	doJSON(t, http.MethodPut, srv.URL+"/book/1/reviews/"+pending.ID+"/status", `{"status":"approved"}`, nil)
+	doJSON(t, http.MethodPut, srv.URL+"/book/1/reviews/"+other.ID+"/status", `{"status":"approved"}`, nil)
+	var rated bookDetails
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books/1", "", &rated)
+
+	var edited models.Review
+	doJSON(t, http.MethodPut, srv.URL+"/book/1/reviews/"+other.ID, `{"rating":2}`, &edited)
+	var afterEdit bookDetails
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books/1", "", &afterEdit)
+
+	// then
+	if res.StatusCode != http.StatusCreated || pending.Status != models.ReviewPending {
+		t.Fatalf("Expected created pending review, received %d: %+v\n", res.StatusCode, pending)
+	}
+	if again.StatusCode != http.StatusConflict {
+		t.Fatalf("Second review of member should conflict, received %d\n", again.StatusCode)
+	}
+	if unrated.Book == nil || unrated.Rating != nil {
+		t.Fatalf("Book without approved reviews should have no rating: %+v\n", unrated.Rating)
+	}
+	if rated.Rating == nil || *rated.Rating != (models.Rating{Average: 4.5, Count: 2}) {
+		t.Fatalf("Expected rating of both approved reviews, has: %+v\n", rated.Rating)
+	}
+	if edited.Status != models.ReviewPending || afterEdit.Rating == nil || *afterEdit.Rating != (models.Rating{Average: 4, Count: 1}) {
+		t.Fatalf("Edited review should wait for approval again, has: %+v, rating %+v\n", edited, afterEdit.Rating)
+	}
+}
+
+func Test_Server_Reviews_ShouldSortBooksByRating(t *testing.T) {
+	// setup
+	srv := prepareReviewServer(t, &Server{})
+	first, second := addReviewMembers(t, srv)
+
+	// given
+	doJSON(t, http.MethodPost, srv.URL+"/book/2/reviews", fmt.Sprintf(`{"member_id":%q,"rating":3}`, first), nil)
+	doJSON(t, http.MethodPost, srv.URL+"/book/3/reviews", fmt.Sprintf(`{"member_id":%q,"rating":5}`, first), nil)
+	doJSON(t, http.MethodPost, srv.URL+"/book/3/reviews", fmt.Sprintf(`{"member_id":%q,"rating":4}`, second), nil)
+
+	// when
+	var page bookPage
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books?sort=rating&limit=2", "", &page)
+	var rest bookPage
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books?sort=rating&offset=2&limit=2", "", &rest)
+	var v1 struct {
+		Data []*bookDetails `json:"data"`
+	}
+	doJSON(t, http.MethodGet, srv.URL+"/v1/book?sort=rating", "", &v1)
+
+	// then
+	if ids := bookDetailsIDs(page.Items); ids != "[3 2]" || page.NextOffset == nil || *page.NextOffset != 2 {
+		t.Fatalf("Expected books 3 and 2 followed by another page, has: %s %v\n", ids, page.NextOffset)
+	}
+	if ids := bookDetailsIDs(rest.Items); ids != "[1]" || rest.NextOffset != nil || rest.Items[0].Rating != nil {
+		t.Fatalf("Expected unrated book 1 on the last page, has: %s\n", ids)
+	}
+	if ids := bookDetailsIDs(v1.Data); ids != "[3 2 1]" || v1.Data[0].Rating == nil || v1.Data[0].Rating.Average != 4.5 {
+		t.Fatalf("Expected v1 books ordered by rating, has: %s\n", ids)
+	}
+}
+
+func Test_Server_Reviews_ShouldRejectSortByRatingOfBooksWithoutRatings(t *testing.T) {
+	// setup
+	s := NewServer("", prepareDbRepo(3))
+	s.reviews = newReviewRepoStub(nil)
+	srv := httptest.NewServer(s.routes())
+	defer srv.Close()
+
+	for _, path := range []string{"/v2/books?sort=rating", "/v1/book?sort=rating"} {
+		// when
+		res := doJSON(t, http.MethodGet, srv.URL+path, "", nil)
+
+		// then
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected %d for %s, received %d\n", http.StatusBadRequest, path, res.StatusCode)
+		}
+	}
+}
+
+func Test_Server_Reviews_ShouldShowOnlyApprovedReviewsToReaders(t *testing.T) {
+	// setup
+	keys := &apiKeyRepoStub{m: map[string]*models.APIKey{}}
+	readerKey := addAPIKey(t, keys, auth.RoleReader)
+	ts := &Server{reviewModeration: true, authenticator: auth.NewAuthenticator(keys, nil)}
+	ts.reviews = newReviewRepoStub(nil)
+	rv, _ := ts.reviews.AddReview(&models.Review{BookID: "1", MemberID: "9", Rating: 2, Status: models.ReviewPending})
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"Lists approved reviews", "/book/1/reviews", http.StatusOK, 0},
+		{"Rejects other statuses", "/book/1/reviews?status=pending", http.StatusForbidden, 0},
+		{"Hides pending review", "/book/1/reviews/" + rv.ID, http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			srv := prepareReviewServer(t, ts)
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			req.Header.Set(auth.APIKeyHeader, readerKey)
+
+			// when
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			// then
+			if res.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected %d, received %d\n", tt.expectedStatus, res.StatusCode)
+			}
+			if res.StatusCode == http.StatusOK {
+				var reviews []*models.Review
+				_ = json.NewDecoder(res.Body).Decode(&reviews)
+				if len(reviews) != tt.expectedCount {
+					t.Fatalf("Expected %d reviews, has: %d\n", tt.expectedCount, len(reviews))
+				}
+			}
+		})
+	}
+}
+
+func Test_Server_Reviews_ShouldRemoveReviewsOfDeletedBooks(t *testing.T) {
+	// setup
+	reviews := newReviewRepoStub(nil)
+	srv := prepareReviewServer(t, &Server{reviews: reviews})
+
+	// given
+	doJSON(t, http.MethodPost, srv.URL+"/book/1/reviews", `{"member_id":"1","rating":4}`, nil)
+	doJSON(t, http.MethodPost, srv.URL+"/book/1/reviews", `{"member_id":"2","rating":2}`, nil)
+	doJSON(t, http.MethodPost, srv.URL+"/book/2/reviews", `{"member_id":"1","rating":5}`, nil)
+
+	// when
+	res := doJSON(t, http.MethodDelete, srv.URL+"/book/1", "", nil)
+
+	// then
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, received %d\n", http.StatusNoContent, res.StatusCode)
+	}
+	if deleted, _ := reviews.GetReviews(repository.DefaultTenant, "1", repository.ReviewFilter{}); len(deleted) != 0 {
+		t.Fatalf("Reviews of deleted book 1 should be removed, has: %d\n", len(deleted))
+	}
+	if kept, _ := reviews.GetReviews(repository.DefaultTenant, "2", repository.ReviewFilter{}); len(kept) != 1 {
+		t.Fatalf("Reviews of book 2 should be kept, has: %d\n", len(kept))
+	}
+	if ratings, _ := reviews.GetRatings(repository.DefaultTenant, []string{"1", "2"}); len(ratings) != 1 || ratings["2"] == nil {
+		t.Fatalf("Only book 2 should stay rated, has: %+v\n", ratings)
+	}
+}
+
+func Test_Server_Reviews_ShouldRejectInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		method, path   string
+		body           string
+		expectedStatus int
+	}{
+		{"Review of missing book", http.MethodPost, "/book/99/reviews", `{"member_id":"1","rating":3}`, http.StatusNotFound},
+		{"Review of missing member", http.MethodPost, "/book/1/reviews", `{"member_id":"99","rating":3}`, http.StatusUnprocessableEntity},
+		{"Review without member", http.MethodPost, "/book/1/reviews", `{"rating":3}`, http.StatusBadRequest},
+		{"Review without rating", http.MethodPost, "/book/1/reviews", `{"member_id":"1"}`, http.StatusBadRequest},
+		{"Review with too high rating", http.MethodPost, "/book/1/reviews", `{"member_id":"1","rating":6}`, http.StatusBadRequest},
+		{"Missing review", http.MethodGet, "/book/1/reviews/99", "", http.StatusNotFound},
+		{"Reviews in unknown status", http.MethodGet, "/book/1/reviews?status=lost", "", http.StatusBadRequest},
+		{"Books in unknown order", http.MethodGet, "/v2/books?sort=name", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			srv := prepareReviewServer(t, &Server{})
+			addReviewMembers(t, srv)
+
+			// when
+			res := doJSON(t, tt.method, srv.URL+tt.path, tt.body, nil)
+
+			// then
+			if res.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected %d, received %d\n", tt.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+}
+
+// utils
+
+// prepareReviewServer serves books "1".."3" with circulation and reviews
+// configured like ts, reviews of deleted books are removed with them.
+func prepareReviewServer(t *testing.T, ts *Server) *httptest.Server {
+	circulation := newCirculationRepoStub()
+	reviews := ts.reviews
+	if reviews == nil {
+		reviews = newReviewRepoStub(circulation)
+	}
+
+	books := prepareDbRepo(3)
+	books.ratings = reviews
+	s := NewServer("", references.New(books, []repository.BookReferences{reviews}))
+	s.circulation = circulation
+	s.reviews = reviews
+	s.reviewModeration = ts.reviewModeration
+	s.authenticator = ts.authenticator
+
+	srv := httptest.NewServer(s.routes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func addReviewMembers(t *testing.T, srv *httptest.Server) (string, string) {
+	var first, second models.Member
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member1","email":"member1@example.com"}`, &first)
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Member2","email":"member2@example.com"}`, &second)
+	if first.ID == "" || second.ID == "" {
+		t.Fatal("[SETUP] Members were not added")
+	}
+	return first.ID, second.ID
+}
+
+func bookDetailsIDs(items []*bookDetails) string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return fmt.Sprint(ids)
+}
+
+// reviewRepoStub looks members up in circulation, it accepts every member
+// when circulation is nil.
+type reviewRepoStub struct {
+	mu          sync.Mutex
+	lastID      int
+	reviews     map[string]*models.Review
+	circulation repository.CirculationRepo
+}
+
+func newReviewRepoStub(circulation repository.CirculationRepo) *reviewRepoStub {
+	return &reviewRepoStub{reviews: map[string]*models.Review{}, circulation: circulation}
+}
+
+func (r *reviewRepoStub) GetReviews(tenant, bookID string, filter repository.ReviewFilter) ([]*models.Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reviews := []*models.Review{}
+	for _, rv := range r.reviews {
+		if rv.BookID == bookID && (filter.Status == "" || rv.Status == filter.Status) &&
+			(filter.MemberID == "" || rv.MemberID == filter.MemberID) {
+			copied := *rv
+			reviews = append(reviews, &copied)
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		a, _ := strconv.Atoi(reviews[i].ID)
+		b, _ := strconv.Atoi(reviews[j].ID)
+		return a > b
+	})
+	return reviews, nil
+}
+
+func (r *reviewRepoStub) GetReview(tenant, id string) (*models.Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rv, ok := r.reviews[id]
+	if !ok {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, id)
+	}
+	copied := *rv
+	return &copied, nil
+}
+
+func (r *reviewRepoStub) AddReview(rv *models.Review) (*models.Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.circulation != nil {
+		if _, err := r.circulation.GetMember(rv.Tenant, rv.MemberID); err != nil {
+			return nil, err
+		}
+	}
+	for _, existing := range r.reviews {
+		if existing.BookID == rv.BookID && existing.MemberID == rv.MemberID {
+			return nil, fmt.Errorf("%w (book_id=%s, member_id=%s)", repository.ErrReviewExists, rv.BookID, rv.MemberID)
+		}
+	}
+
+	r.lastID++
+	created := *rv
+	created.ID = strconv.Itoa(r.lastID)
+	r.reviews[created.ID] = &created
+	copied := created
+	return &copied, nil
+}
+
+func (r *reviewRepoStub) UpdateReview(rv *models.Review) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.reviews[rv.ID]; !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, rv.ID)
+	}
+	updated := *rv
+	r.reviews[rv.ID] = &updated
+	return nil
+}
+
+func (r *reviewRepoStub) DeleteReview(tenant, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.reviews[id]; !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, id)
+	}
+	delete(r.reviews, id)
+	return nil
+}
+
+func (r *reviewRepoStub) DeleteBookReferences(tenant, bookID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, rv := range r.reviews {
+		if rv.Tenant == tenant && (bookID == "" || rv.BookID == bookID) {
+			delete(r.reviews, id)
+		}
+	}
+	return nil
+}
+
+func (r *reviewRepoStub) GetRatings(tenant string, bookIDs []string) (map[string]*models.Rating, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ratings := make(map[string]*models.Rating)
+	for _, id := range bookIDs {
+		sum, count := 0, 0
+		for _, rv := range r.reviews {
+			if rv.BookID == id && rv.Status == models.ReviewApproved {
+				sum, count = sum+rv.Rating, count+1
+			}
+		}
+		if count > 0 {
+			ratings[id] = &models.Rating{BookID: id, Average: float64(sum) / float64(count), Count: count}
+		}
+	}
+	return ratings, nil
+}
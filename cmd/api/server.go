@@ -1,39 +1,263 @@
 package main
 
 import (
+	"expvar"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/blob"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/notify"
+	"github.com/auwendil/crud-app/internal/ratelimit"
 	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/dualwrite"
+	"github.com/auwendil/crud-app/internal/repository/eventsourced"
+	"github.com/auwendil/crud-app/internal/webhook"
 	"github.com/go-chi/chi/v5/middleware"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type Server struct {
-	addr   string
-	dbRepo repository.BookRepo
+	addr          string
+	dbRepo        repository.BookRepo
+	reconciler    *dualwrite.Repo
+	authenticator *auth.Authenticator
+	tenants       *tenantResolver
+	events        *events.Bus
+
+	// history serves reads of the past catalog, it is set only when books
+	// are event-sourced.
+	history *eventsourced.Repo
+
+	webhooks          repository.WebhookRepo
+	webhookDispatcher *webhook.Dispatcher
+
+	// circulation keeps copies, members and loans, routes managing them
+	// are mounted only when it is set.
+	circulation repository.CirculationRepo
+	loanPolicy  loanPolicy
+
+	// notifications keeps preferences of members and sent notifications,
+	// it is set only together with notifier, which sends them.
+	notifications repository.NotificationRepo
+	notifier      *notify.Scheduler
+
+	// reviews keeps reviews and ratings of books, routes managing them are
+	// mounted only when it is set. New and edited reviews wait for approval
+	// when reviewModeration is set.
+	reviews          repository.ReviewRepo
+	reviewModeration bool
+
+	// taxonomy keeps categories and tags of books, routes managing them
+	// are mounted only when it is set.
+	taxonomy repository.TaxonomyRepo
+
+	// shelves keeps shelves of members, routes managing them are mounted
+	// only when it is set.
+	shelves repository.ShelfRepo
+
+	// covers keeps metadata of covers of books, their images are kept in
+	// coverStore and linked under coverBaseURL. Routes managing them are
+	// mounted only when it is set.
+	covers       repository.CoverRepo
+	coverStore   blob.Store
+	coverBaseURL string
+
+	idempotencyKeys  repository.IdempotencyRepo
+	idempotencyTTL   time.Duration
+	idempotencyLease time.Duration
+
+	// legacySunset is announced in the Sunset header of unversioned
+	// routes, it is omitted when zero.
+	legacySunset time.Time
+
+	routeLimits     map[string]routeLimits
+	rateLimiters    map[string]*ratelimit.Limiter
+	rateLimitersMu  sync.Mutex
+	concurrency     *ratelimit.ConcurrencyLimiter
+	concurrencyWait time.Duration
+
+	graphqlLimits graphqlLimits
 }
 
 func NewServer(listenAddr string, repo repository.BookRepo) *Server {
 	return &Server{
-		addr:   listenAddr,
-		dbRepo: repo,
+		addr:             listenAddr,
+		dbRepo:           repo,
+		routeLimits:      make(map[string]routeLimits),
+		idempotencyLease: defaultIdempotencyKeyLease,
+		loanPolicy:       loanPolicy{period: defaultLoanPeriod, maxRenewals: defaultMaxRenewals, holdPickup: defaultHoldPickup},
+		graphqlLimits: graphqlLimits{
+			maxDepth:      defaultGraphQLMaxDepth,
+			maxComplexity: defaultGraphQLMaxComplexity,
+		},
 	}
 }
 
-func (s *Server) Start() {
+func (s *Server) routes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(exposeRequestID)
 	r.Use(middleware.Logger)
+	r.Use(s.limitConcurrency)
+
+	// limiters are shared by all API versions and gRPC, so switching
+	// between them does not reset limits of a client
+	limits := make(map[string]func(http.Handler) http.Handler)
+	for _, group := range []string{readRoutes, writeRoutes, adminRoutes, uploadRoutes} {
+		limits[group] = s.limit(group)
+	}
+
+	// a single GraphQL request may contain mutations, so all of them count
+	// against write limits, roles of mutations are checked by resolvers.
+	// GraphiQL is a static page, so browsers load it without credentials.
+	graphqlAPI := chi.Chain(s.authenticate, s.requireRole(auth.RoleReader), limits[writeRoutes], s.resolveTenant).Handler(s.handleGraphQL())
+	r.Get("/graphql", handleGraphiQL(graphqlAPI))
+	r.Post("/graphql", graphqlAPI.ServeHTTP)
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.authenticate)
+
+		v1 := bookHandlers{
+			list:      s.handleGetAllBooks,
+			get:       s.handleGetBook,
+			add:       s.handleAddBook,
+			update:    s.handleUpdateBook,
+			delete:    s.handleDeleteBook,
+			deleteAll: s.handleDeleteAll,
+			events:    s.handleBookEvents,
+			eventsWS:  s.handleBookEventsWS,
+		}
+		v2 := bookHandlers{
+			list:      s.handleListBooksV2,
+			get:       s.handleGetBookV2,
+			add:       s.handleAddBookV2,
+			update:    s.handleUpdateBookV2,
+			delete:    s.handleDeleteBookV2,
+			deleteAll: s.handleDeleteAllV2,
+			events:    s.handleBookEvents,
+			eventsWS:  s.handleBookEventsWS,
+		}
+
+		r.Route("/v1", func(r chi.Router) {
+			s.bookRoutes(r, "/book", v1, limits)
+		})
+
+		r.Route("/v2", func(r chi.Router) {
+			r.Use(problemDetailsOnly)
+			s.bookRoutes(r, "/books", v2, limits)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.deprecated("/v1"))
+			s.bookRoutes(r, "/book", v1, limits)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.requireRole(auth.RoleAdmin))
+			r.Use(limits[adminRoutes])
 
-	r.Get("/book", s.handleGetAllBooks)
-	r.Get("/book/{id}", s.handleGetBook)
-	r.Post("/book", s.handleAddBook)
-	r.Put("/book/{id}", s.handleUpdateBook)
-	r.Delete("/book/{id}", s.handleDeleteBook)
-	r.Delete("/book", s.handleDeleteAll)
+			if s.reconciler != nil {
+				r.Get("/admin/reconciliation", s.handleReconciliation)
+			}
+			r.Handle("/debug/vars", expvar.Handler())
+		})
 
+		if s.webhooks != nil {
+			r.Group(func(r chi.Router) {
+				r.Use(problemDetailsOnly)
+				r.Use(s.requireRole(auth.RoleAdmin))
+				r.Use(limits[adminRoutes])
+				r.Use(s.resolveTenant)
+
+				s.webhookRoutes(r)
+			})
+		}
+
+		if s.circulation != nil {
+			r.Group(func(r chi.Router) {
+				r.Use(problemDetailsOnly)
+				s.circulationRoutes(r, limits)
+			})
+		}
+
+		if s.reviews != nil {
+			r.Group(func(r chi.Router) {
+				r.Use(problemDetailsOnly)
+				s.reviewRoutes(r, limits)
+			})
+		}
+
+		if s.taxonomy != nil {
+			r.Group(func(r chi.Router) {
+				r.Use(problemDetailsOnly)
+				s.taxonomyRoutes(r, limits)
+			})
+		}
+
+		if s.shelves != nil {
+			r.Group(func(r chi.Router) {
+				r.Use(problemDetailsOnly)
+				s.shelfRoutes(r, limits)
+			})
+		}
+
+		if s.covers != nil {
+			r.Group(func(r chi.Router) {
+				r.Use(problemDetailsOnly)
+				s.coverRoutes(r, limits)
+			})
+		}
+	})
+
+	return r
+}
+
+type bookHandlers struct {
+	list, get, add, update, delete, deleteAll http.HandlerFunc
+	events, eventsWS                          http.HandlerFunc
+}
+
+// bookRoutes mounts handlers of one API version under path, guarded by
+// the role and limits of their route group.
+func (s *Server) bookRoutes(r chi.Router, path string, h bookHandlers, limits map[string]func(http.Handler) http.Handler) {
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleReader))
+		r.Use(limits[readRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Get(path, h.list)
+		r.Get(path+"/{id}", h.get)
+
+		if s.events != nil {
+			r.Get(path+"/events", h.events)
+			r.Get(path+"/events/ws", h.eventsWS)
+		}
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleEditor))
+		r.Use(limits[writeRoutes])
+		r.Use(s.resolveTenant)
+
+		r.With(s.idempotent).Post(path, h.add)
+		r.Put(path+"/{id}", h.update)
+		r.Delete(path+"/{id}", h.delete)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleAdmin))
+		r.Use(limits[adminRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Delete(path, h.deleteAll)
+	})
+}
+
+func (s *Server) Start() error {
 	log.Println("Starting server on", s.addr)
-	if err := http.ListenAndServe(s.addr, r); err != nil {
-		panic(err)
-	}
+	return http.ListenAndServe(s.addr, s.routes())
 }
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/auwendil/crud-app/internal/repository/shelf"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxShelfNameLength = 255
+	maxShelfNoteLength = 2000
+	maxShelfProgress   = 100
+)
+
+// defaultShelves every member has, in the order they are listed.
+var defaultShelves = []string{models.ShelfToRead, models.ShelfReading, models.ShelfRead}
+
+type shelfRequest struct {
+	Name       string `json:"name"`
+	Visibility string `json:"visibility"`
+}
+
+type shelfEntryRequest struct {
+	BookID   string `json:"book_id"`
+	Note     string `json:"note"`
+	Progress int    `json:"progress"`
+}
+
+type shelfPositionRequest struct {
+	Position int `json:"position"`
+}
+
+type shelfOrderRequest struct {
+	BookIDs []string `json:"book_ids"`
+}
+
+func prepareShelfRepo(dbType, connString string) (repository.ShelfRepo, error) {
+	switch dbType {
+	case "postgresql":
+		db, err := database.OpenPostgreSQL(connString)
+		if err != nil {
+			return nil, err
+		}
+		return shelf.NewPostgreSQLRepo(db), nil
+	case "mongodb":
+		db, err := database.OpenMongoDB(connString)
+		if err != nil {
+			return nil, err
+		}
+		repo := shelf.NewMongoDBRepo(db)
+		return repo, repo.CreateIndexes()
+	}
+
+	return nil, fmt.Errorf("unsupported database type: %q", dbType)
+}
+
+// shelfRoutes mounts shelves of members. Editors manage them on behalf of
+// members like the rest of their records, readers see public shelves
+// through their share links.
+func (s *Server) shelfRoutes(r chi.Router, limits map[string]func(http.Handler) http.Handler) {
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleReader))
+		r.Use(limits[readRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Get("/shelf/shared/{token}", s.handleGetSharedShelf)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleEditor))
+		r.Use(limits[writeRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Get("/member/{id}/shelves", s.handleGetShelves)
+		r.Post("/member/{id}/shelves", s.handleAddShelf)
+		r.Get("/member/{id}/shelves/{shelfID}", s.handleGetShelf)
+		r.Put("/member/{id}/shelves/{shelfID}", s.handleUpdateShelf)
+		r.Delete("/member/{id}/shelves/{shelfID}", s.handleDeleteShelf)
+		r.Post("/member/{id}/shelves/{shelfID}/share", s.handleShareShelf)
+		r.Put("/member/{id}/shelves/{shelfID}/order", s.handleReorderShelf)
+
+		r.Post("/member/{id}/shelves/{shelfID}/entries", s.handleAddShelfEntry)
+		r.Put("/member/{id}/shelves/{shelfID}/entries/{bookID}", s.handleUpdateShelfEntry)
+		r.Delete("/member/{id}/shelves/{shelfID}/entries/{bookID}", s.handleDeleteShelfEntry)
+		r.Put("/member/{id}/shelves/{shelfID}/entries/{bookID}/position", s.handleMoveShelfEntry)
+	})
+}
+
+// handleGetShelves lists shelves of the member without their entries,
+// default shelves are added on the first listing.
+func (s *Server) handleGetShelves(w http.ResponseWriter, r *http.Request) {
+	tenant, memberID := requestTenant(r.Context()), chi.URLParam(r, "id")
+	if _, err := s.circulation.GetMember(tenant, memberID); err != nil {
+		handleCirculationError(w, r, err)
+		return
+	}
+
+	shelves, err := s.shelves.GetShelves(tenant, memberID)
+	if err == nil && missingDefaultShelves(shelves) {
+		err = s.addDefaultShelves(tenant, memberID, shelves)
+		if err == nil {
+			shelves, err = s.shelves.GetShelves(tenant, memberID)
+		}
+	}
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, shelves, http.StatusOK)
+}
+
+func (s *Server) handleGetShelf(w http.ResponseWriter, r *http.Request) {
+	sh, err := s.memberShelf(r)
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, sh, http.StatusOK)
+}
+
+// handleGetSharedShelf returns the public shelf of the share link, private
+// shelves are not found.
+func (s *Server) handleGetSharedShelf(w http.ResponseWriter, r *http.Request) {
+	sh, err := s.shelves.GetSharedShelf(requestTenant(r.Context()), chi.URLParam(r, "token"))
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, sh, http.StatusOK)
+}
+
+// handleAddShelf adds a custom shelf of the member, private unless asked
+// otherwise.
+func (s *Server) handleAddShelf(w http.ResponseWriter, r *http.Request) {
+	req, ok := readShelf(w, r)
+	if !ok {
+		return
+	}
+	if err := reservedShelfName(req.Name); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	memberID := chi.URLParam(r, "id")
+	sh, err := s.shelves.AddShelf(&models.Shelf{
+		Tenant:     requestTenant(r.Context()),
+		MemberID:   memberID,
+		Name:       req.Name,
+		Visibility: req.Visibility,
+		ShareToken: token,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Location": []string{"/member/" + memberID + "/shelves/" + sh.ID}}
+	_ = writeResource(w, sh, http.StatusCreated, headers)
+}
+
+// handleUpdateShelf renames the shelf and changes its visibility, default
+// shelves keep their names.
+func (s *Server) handleUpdateShelf(w http.ResponseWriter, r *http.Request) {
+	sh, err := s.memberShelf(r)
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	req, ok := readShelf(w, r)
+	if !ok {
+		return
+	}
+	if sh.Default && req.Name != sh.Name {
+		handleShelfError(w, r, fmt.Errorf("%w (name=%s)", repository.ErrShelfDefault, sh.Name))
+		return
+	}
+	if !sh.Default {
+		if err = reservedShelfName(req.Name); err != nil {
+			_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	sh.Name = req.Name
+	sh.Visibility = req.Visibility
+	sh.UpdatedAt = time.Now().UTC()
+	if err = s.shelves.UpdateShelf(sh); err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, sh, http.StatusOK)
+}
+
+func (s *Server) handleDeleteShelf(w http.ResponseWriter, r *http.Request) {
+	sh, err := s.memberShelf(r)
+	if err == nil && sh.Default {
+		err = fmt.Errorf("%w (name=%s)", repository.ErrShelfDefault, sh.Name)
+	}
+	if err == nil {
+		err = s.shelves.DeleteShelf(sh.Tenant, sh.ID)
+	}
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleShareShelf replaces the share token of the shelf, links with the
+// previous one stop working.
+func (s *Server) handleShareShelf(w http.ResponseWriter, r *http.Request) {
+	sh, err := s.memberShelf(r)
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	if sh.ShareToken, err = newShareToken(); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	sh.UpdatedAt = time.Now().UTC()
+	if err = s.shelves.UpdateShelf(sh); err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, sh, http.StatusOK)
+}
+
+// handleReorderShelf orders entries of the shelf as book_ids, which must
+// list every book of the shelf once.
+func (s *Server) handleReorderShelf(w http.ResponseWriter, r *http.Request) {
+	var req *shelfOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+	if req == nil || req.BookIDs == nil {
+		v := &validationError{}
+		v.add("book_ids", "is required")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	sh, err := s.memberShelf(r)
+	if err == nil {
+		err = s.shelves.ReorderShelf(sh.Tenant, sh.ID, req.BookIDs)
+	}
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	s.writeShelf(w, r, sh.ID)
+}
+
+// handleAddShelfEntry puts the book last on the shelf.
+func (s *Server) handleAddShelfEntry(w http.ResponseWriter, r *http.Request) {
+	req, ok := readShelfEntry(w, r)
+	if !ok {
+		return
+	}
+	if req.BookID == "" {
+		v := &validationError{}
+		v.add("book_id", "is required")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	sh, err := s.memberShelf(r)
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	repo, err := s.bookRepo(r)
+	if err == nil {
+		_, err = repo.GetBook(req.BookID)
+	}
+	if err != nil {
+		handleReferenceError(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	e, err := s.shelves.AddShelfEntry(sh.Tenant, sh.ID, &models.ShelfEntry{BookID: req.BookID, Note: req.Note, Progress: req.Progress, AddedAt: now})
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Location": []string{"/member/" + sh.MemberID + "/shelves/" + sh.ID + "/entries/" + e.BookID}}
+	_ = writeResource(w, e, http.StatusCreated, headers)
+}
+
+// handleUpdateShelfEntry replaces the note and the reading progress of the
+// book on the shelf.
+func (s *Server) handleUpdateShelfEntry(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "bookID")
+	req, ok := readShelfEntry(w, r)
+	if !ok {
+		return
+	}
+	if req.BookID != "" && req.BookID != bookID {
+		v := &validationError{}
+		v.add("book_id", "cannot be changed")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	sh, err := s.memberShelf(r)
+	if err == nil {
+		err = s.shelves.UpdateShelfEntry(sh.Tenant, sh.ID, &models.ShelfEntry{BookID: bookID, Note: req.Note, Progress: req.Progress, UpdatedAt: time.Now().UTC()})
+	}
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	s.writeShelfEntry(w, r, sh.ID, bookID)
+}
+
+func (s *Server) handleDeleteShelfEntry(w http.ResponseWriter, r *http.Request) {
+	sh, err := s.memberShelf(r)
+	if err == nil {
+		err = s.shelves.DeleteShelfEntry(sh.Tenant, sh.ID, chi.URLParam(r, "bookID"))
+	}
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMoveShelfEntry moves the book to position, counted from 1, and
+// responds with the reordered shelf. Positions past the last entry move
+// the book last.
+func (s *Server) handleMoveShelfEntry(w http.ResponseWriter, r *http.Request) {
+	var req *shelfPositionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+	if req == nil || req.Position < 1 {
+		v := &validationError{}
+		v.add("position", "must be a positive integer")
+		_ = handleErrorJSON(w, r, v.errOrNil(), http.StatusBadRequest)
+		return
+	}
+
+	sh, err := s.memberShelf(r)
+	if err == nil {
+		err = s.shelves.MoveShelfEntry(sh.Tenant, sh.ID, chi.URLParam(r, "bookID"), req.Position)
+	}
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	s.writeShelf(w, r, sh.ID)
+}
+
+// memberShelf looks up the shelf named in the path, shelves of other
+// members are not found.
+func (s *Server) memberShelf(r *http.Request) (*models.Shelf, error) {
+	id := chi.URLParam(r, "shelfID")
+	sh, err := s.shelves.GetShelf(requestTenant(r.Context()), id)
+	if err != nil {
+		return nil, err
+	}
+	if sh.MemberID != chi.URLParam(r, "id") {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, id)
+	}
+	return sh, nil
+}
+
+// addDefaultShelves adds default shelves missing among shelves of the
+// member. Shelves added at once by another request are skipped.
+func (s *Server) addDefaultShelves(tenant, memberID string, shelves []*models.Shelf) error {
+	existing := make(map[string]bool, len(shelves))
+	for _, sh := range shelves {
+		existing[sh.Name] = true
+	}
+
+	now := time.Now().UTC()
+	for _, name := range defaultShelves {
+		if existing[name] {
+			continue
+		}
+
+		token, err := newShareToken()
+		if err != nil {
+			return err
+		}
+		_, err = s.shelves.AddShelf(&models.Shelf{
+			Tenant:     tenant,
+			MemberID:   memberID,
+			Name:       name,
+			Default:    true,
+			Visibility: models.ShelfPrivate,
+			ShareToken: token,
+			CreatedAt:  now,
+		})
+		if err != nil && !errors.Is(err, repository.ErrShelfExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeShelf responds with the shelf and its entries.
+func (s *Server) writeShelf(w http.ResponseWriter, r *http.Request, id string) {
+	sh, err := s.shelves.GetShelf(requestTenant(r.Context()), id)
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, sh, http.StatusOK)
+}
+
+// writeShelfEntry responds with the entry of the book on the shelf.
+func (s *Server) writeShelfEntry(w http.ResponseWriter, r *http.Request, id, bookID string) {
+	sh, err := s.shelves.GetShelf(requestTenant(r.Context()), id)
+	if err != nil {
+		handleShelfError(w, r, err)
+		return
+	}
+
+	for _, e := range sh.Entries {
+		if e.BookID == bookID {
+			_ = writeResource(w, e, http.StatusOK)
+			return
+		}
+	}
+	handleShelfError(w, r, fmt.Errorf("%w (shelf_id=%s, book_id=%s)", repository.ErrShelfEntryNotFound, id, bookID))
+}
+
+// handleShelfError responds with 404 for missing shelves, entries and
+// members, 409 for changes conflicting with shelves of the member or their
+// entries and hides every other error behind 500.
+func handleShelfError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrShelfNotFound),
+		errors.Is(err, repository.ErrShelfEntryNotFound),
+		errors.Is(err, repository.ErrMemberNotFound):
+		_ = handleErrorJSON(w, r, expose(err), http.StatusNotFound)
+	case errors.Is(err, repository.ErrShelfExists),
+		errors.Is(err, repository.ErrShelfDefault),
+		errors.Is(err, repository.ErrShelfEntryExists),
+		errors.Is(err, repository.ErrShelfOrderMismatch):
+		_ = handleErrorJSON(w, r, expose(err), http.StatusConflict)
+	default:
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// readShelf decodes and validates the shelf sent in request body,
+// responding with an error when it is not valid.
+func readShelf(w http.ResponseWriter, r *http.Request) (*shelfRequest, bool) {
+	var req *shelfRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return nil, false
+	}
+
+	if err := validateShelf(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+	return req, true
+}
+
+// validateShelf trims the name and defaults visibility to private.
+func validateShelf(req *shelfRequest) error {
+	if req == nil {
+		return newPublicError("request body must be a shelf")
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Visibility == "" {
+		req.Visibility = models.ShelfPrivate
+	}
+
+	v := &validationError{}
+	switch {
+	case req.Name == "":
+		v.add("name", "is required")
+	case len([]rune(req.Name)) > maxShelfNameLength:
+		v.add("name", fmt.Sprintf("must have at most %d characters", maxShelfNameLength))
+	}
+	if req.Visibility != models.ShelfPrivate && req.Visibility != models.ShelfPublic {
+		v.add("visibility", "must be one of: private, public")
+	}
+	return v.errOrNil()
+}
+
+// readShelfEntry decodes and validates the entry sent in request body,
+// responding with an error when it is not valid.
+func readShelfEntry(w http.ResponseWriter, r *http.Request) (*shelfEntryRequest, bool) {
+	var req *shelfEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return nil, false
+	}
+
+	if err := validateShelfEntry(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+	return req, true
+}
+
+func validateShelfEntry(req *shelfEntryRequest) error {
+	if req == nil {
+		return newPublicError("request body must be a shelf entry")
+	}
+
+	v := &validationError{}
+	if len([]rune(req.Note)) > maxShelfNoteLength {
+		v.add("note", fmt.Sprintf("must have at most %d characters", maxShelfNoteLength))
+	}
+	if req.Progress < 0 || req.Progress > maxShelfProgress {
+		v.add("progress", fmt.Sprintf("must be a percentage between 0 and %d", maxShelfProgress))
+	}
+	return v.errOrNil()
+}
+
+// reservedShelfName rejects names of default shelves for custom ones.
+func reservedShelfName(name string) error {
+	for _, reserved := range defaultShelves {
+		if strings.EqualFold(name, reserved) {
+			v := &validationError{}
+			v.add("name", "is reserved for a default shelf")
+			return v.errOrNil()
+		}
+	}
+	return nil
+}
+
+// missingDefaultShelves reports whether some default shelf is missing
+// among shelves of a member.
+func missingDefaultShelves(shelves []*models.Shelf) bool {
+	defaults := 0
+	for _, sh := range shelves {
+		if sh.Default {
+			defaults++
+		}
+	}
+	return defaults < len(defaultShelves)
+}
+
+// newShareToken returns a random token of a share link.
+func newShareToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/references"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func Test_Server_Shelves_ShouldKeepEntriesInOrder(t *testing.T) {
+	// setup
+	_, srv := prepareShelfServer(t)
+	memberID := addShelfMember(t, srv)
+	shelves := srv.URL + "/member/" + memberID + "/shelves"
+
+	// given
+	var defaults []*models.Shelf
+	doJSON(t, http.MethodGet, shelves, "", &defaults)
+	var favourites models.Shelf
+	res := doJSON(t, http.MethodPost, shelves, `{"name":" Favourites "}`, &favourites)
+	entries := shelves + "/" + favourites.ID + "/entries"
+	for _, id := range []string{"1", "2", "3"} {
+		doJSON(t, http.MethodPost, entries, fmt.Sprintf(`{"book_id":%q}`, id), nil)
+	}
+
+	// when
+	var moved models.Shelf
+	doJSON(t, http.MethodPut, entries+"/3/position", `{"position":1}`, &moved)
+	var updated models.ShelfEntry
+	doJSON(t, http.MethodPut, entries+"/2", `{"note":"Halfway","progress":50}`, &updated)
+	var reordered models.Shelf
+	doJSON(t, http.MethodPut, shelves+"/"+favourites.ID+"/order", `{"book_ids":["2","1","3"]}`, &reordered)
+	var listed []*models.Shelf
+	doJSON(t, http.MethodGet, shelves, "", &listed)
+
+	// then
+	if names := shelfNames(defaults); names != "[to-read reading read]" {
+		t.Fatalf("Expected default shelves of the member, has: %s\n", names)
+	}
+	if res.StatusCode != http.StatusCreated || favourites.Name != "Favourites" || favourites.Visibility != models.ShelfPrivate {
+		t.Fatalf("Expected private shelf Favourites, received %d: %+v\n", res.StatusCode, favourites)
+	}
+	if books := shelfBooks(&moved); books != "[3 1 2]" {
+		t.Fatalf("Expected book 3 moved first, has: %s\n", books)
+	}
+	if updated.Note != "Halfway" || updated.Progress != 50 || updated.Position != 3 {
+		t.Fatalf("Expected progress of book 2, has: %+v\n", updated)
+	}
+	if books := shelfBooks(&reordered); books != "[2 1 3]" || reordered.Entries[0].Position != 1 {
+		t.Fatalf("Expected reordered books, has: %s\n", books)
+	}
+	if names := shelfNames(listed); names != "[to-read reading read Favourites]" || listed[3].Entries != nil {
+		t.Fatalf("Expected shelves without entries, has: %s\n", names)
+	}
+}
+
+func Test_Server_Shelves_ShouldShareOnlyPublicShelves(t *testing.T) {
+	// setup
+	_, srv := prepareShelfServer(t)
+	memberID := addShelfMember(t, srv)
+	shelves := srv.URL + "/member/" + memberID + "/shelves"
+
+	var sh models.Shelf
+	doJSON(t, http.MethodPost, shelves, `{"name":"Summer"}`, &sh)
+	doJSON(t, http.MethodPost, shelves+"/"+sh.ID+"/entries", `{"book_id":"1","note":"Beach"}`, nil)
+
+	// when
+	private := doJSON(t, http.MethodGet, srv.URL+"/shelf/shared/"+sh.ShareToken, "", nil)
+	doJSON(t, http.MethodPut, shelves+"/"+sh.ID, `{"name":"Summer","visibility":"public"}`, nil)
+	var shared models.Shelf
+	public := doJSON(t, http.MethodGet, srv.URL+"/shelf/shared/"+sh.ShareToken, "", &shared)
+	var rotated models.Shelf
+	doJSON(t, http.MethodPost, shelves+"/"+sh.ID+"/share", "", &rotated)
+	revoked := doJSON(t, http.MethodGet, srv.URL+"/shelf/shared/"+sh.ShareToken, "", nil)
+
+	// then
+	if private.StatusCode != http.StatusNotFound {
+		t.Fatalf("Private shelf should not be shared, received %d\n", private.StatusCode)
+	}
+	if public.StatusCode != http.StatusOK || shelfBooks(&shared) != "[1]" || shared.Entries[0].Note != "Beach" {
+		t.Fatalf("Expected public shelf with its entries, received %d: %+v\n", public.StatusCode, shared)
+	}
+	if rotated.ShareToken == sh.ShareToken || revoked.StatusCode != http.StatusNotFound {
+		t.Fatalf("Previous share link should stop working, received %d\n", revoked.StatusCode)
+	}
+}
+
+func Test_Server_Shelves_ShouldRejectInvalidRequests(t *testing.T) {
+	// setup
+	_, srv := prepareShelfServer(t)
+	memberID := addShelfMember(t, srv)
+	otherID := addShelfMember(t, srv)
+	shelves := "/member/" + memberID + "/shelves"
+
+	var defaults []*models.Shelf
+	doJSON(t, http.MethodGet, srv.URL+shelves, "", &defaults)
+	var sh models.Shelf
+	doJSON(t, http.MethodPost, srv.URL+shelves, `{"name":"Classics"}`, &sh)
+	doJSON(t, http.MethodPost, srv.URL+shelves+"/"+sh.ID+"/entries", `{"book_id":"1"}`, nil)
+	doJSON(t, http.MethodPost, srv.URL+shelves+"/"+sh.ID+"/entries", `{"book_id":"2"}`, nil)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Rejects shelves of missing member", http.MethodGet, "/member/99/shelves", "", http.StatusNotFound},
+		{"Rejects empty name", http.MethodPost, shelves, `{"name":" "}`, http.StatusBadRequest},
+		{"Rejects name of default shelf", http.MethodPost, shelves, `{"name":"Read"}`, http.StatusBadRequest},
+		{"Rejects unknown visibility", http.MethodPost, shelves, `{"name":"Later","visibility":"friends"}`, http.StatusBadRequest},
+		{"Rejects duplicate name", http.MethodPost, shelves, `{"name":"Classics"}`, http.StatusConflict},
+		{"Keeps name of default shelf", http.MethodPut, shelves + "/" + defaults[0].ID, `{"name":"Someday"}`, http.StatusConflict},
+		{"Keeps default shelf", http.MethodDelete, shelves + "/" + defaults[0].ID, "", http.StatusConflict},
+		{"Hides shelf of other member", http.MethodGet, "/member/" + otherID + "/shelves/" + sh.ID, "", http.StatusNotFound},
+		{"Rejects missing book", http.MethodPost, shelves + "/" + sh.ID + "/entries", `{"book_id":"9"}`, http.StatusUnprocessableEntity},
+		{"Rejects book already on shelf", http.MethodPost, shelves + "/" + sh.ID + "/entries", `{"book_id":"1"}`, http.StatusConflict},
+		{"Rejects progress over 100", http.MethodPut, shelves + "/" + sh.ID + "/entries/1", `{"progress":101}`, http.StatusBadRequest},
+		{"Rejects missing entry", http.MethodPut, shelves + "/" + sh.ID + "/entries/3", `{"progress":10}`, http.StatusNotFound},
+		{"Rejects zero position", http.MethodPut, shelves + "/" + sh.ID + "/entries/1/position", `{"position":0}`, http.StatusBadRequest},
+		{"Rejects incomplete order", http.MethodPut, shelves + "/" + sh.ID + "/order", `{"book_ids":["2"]}`, http.StatusConflict},
+		{"Rejects repeated book in order", http.MethodPut, shelves + "/" + sh.ID + "/order", `{"book_ids":["1","1"]}`, http.StatusConflict},
+		{"Deletes custom shelf", http.MethodDelete, shelves + "/" + sh.ID, "", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			res := doJSON(t, tt.method, srv.URL+tt.path, tt.body, nil)
+
+			// then
+			if res.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d, received %d\n", tt.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_Server_Shelves_ShouldForgetDeletedBooks(t *testing.T) {
+	// setup
+	s, srv := prepareShelfServer(t)
+	s.dbRepo = references.New(s.dbRepo, []repository.BookReferences{s.shelves})
+	memberID := addShelfMember(t, srv)
+
+	var sh models.Shelf
+	doJSON(t, http.MethodPost, srv.URL+"/member/"+memberID+"/shelves", `{"name":"Classics"}`, &sh)
+	doJSON(t, http.MethodPost, srv.URL+"/member/"+memberID+"/shelves/"+sh.ID+"/entries", `{"book_id":"1"}`, nil)
+	doJSON(t, http.MethodPost, srv.URL+"/member/"+memberID+"/shelves/"+sh.ID+"/entries", `{"book_id":"2"}`, nil)
+
+	// when
+	res := doJSON(t, http.MethodDelete, srv.URL+"/book/1", "", nil)
+
+	// then
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, received %d\n", http.StatusNoContent, res.StatusCode)
+	}
+	if found, _ := s.shelves.GetShelf(repository.DefaultTenant, sh.ID); shelfBooks(found) != "[2]" {
+		t.Fatalf("Deleted book 1 should be removed from the shelf, has: %s\n", shelfBooks(found))
+	}
+}
+
+// utils
+
+// prepareShelfServer serves books "1".."3" with circulation and shelves.
+func prepareShelfServer(t *testing.T) (*Server, *httptest.Server) {
+	s := NewServer("", prepareDbRepo(3))
+	s.circulation = newCirculationRepoStub()
+	s.shelves = newShelfRepoStub(s.circulation)
+
+	srv := httptest.NewServer(s.routes())
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func addShelfMember(t *testing.T, srv *httptest.Server) string {
+	var m models.Member
+	doJSON(t, http.MethodPost, srv.URL+"/member", `{"name":"Reader","email":"reader@example.com"}`, &m)
+	if m.ID == "" {
+		t.Fatal("[SETUP] Member was not added")
+	}
+	return m.ID
+}
+
+func shelfNames(shelves []*models.Shelf) string {
+	names := make([]string, len(shelves))
+	for i, sh := range shelves {
+		names[i] = sh.Name
+	}
+	return fmt.Sprint(names)
+}
+
+func shelfBooks(sh *models.Shelf) string {
+	if sh == nil {
+		return "<nil>"
+	}
+
+	ids := make([]string, len(sh.Entries))
+	for i, e := range sh.Entries {
+		ids[i] = e.BookID
+	}
+	return fmt.Sprint(ids)
+}
+
+// shelfRepoStub looks members up in circulation and keeps entries of
+// every shelf in their order.
+type shelfRepoStub struct {
+	mu          sync.Mutex
+	lastID      int
+	shelves     map[string]*models.Shelf
+	circulation repository.CirculationRepo
+}
+
+func newShelfRepoStub(circulation repository.CirculationRepo) *shelfRepoStub {
+	return &shelfRepoStub{shelves: map[string]*models.Shelf{}, circulation: circulation}
+}
+
+func (r *shelfRepoStub) GetShelves(tenant, memberID string) ([]*models.Shelf, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shelves := []*models.Shelf{}
+	for _, sh := range r.shelves {
+		if sh.MemberID == memberID {
+			copied := *sh
+			copied.Entries = nil
+			shelves = append(shelves, &copied)
+		}
+	}
+	sort.Slice(shelves, func(i, j int) bool {
+		if shelves[i].Default != shelves[j].Default {
+			return shelves[i].Default
+		}
+		a, _ := strconv.Atoi(shelves[i].ID)
+		b, _ := strconv.Atoi(shelves[j].ID)
+		return a < b
+	})
+	return shelves, nil
+}
+
+func (r *shelfRepoStub) GetShelf(tenant, id string) (*models.Shelf, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sh, ok := r.shelves[id]
+	if !ok {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, id)
+	}
+	return copyShelf(sh), nil
+}
+
+func (r *shelfRepoStub) GetSharedShelf(tenant, token string) (*models.Shelf, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sh := range r.shelves {
+		if sh.ShareToken == token && sh.Visibility == models.ShelfPublic {
+			return copyShelf(sh), nil
+		}
+	}
+	return nil, fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, token)
+}
+
+func (r *shelfRepoStub) AddShelf(sh *models.Shelf) (*models.Shelf, error) {
+	if _, err := r.circulation.GetMember(sh.Tenant, sh.MemberID); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkName(sh); err != nil {
+		return nil, err
+	}
+
+	r.lastID++
+	created := *sh
+	created.ID = strconv.Itoa(r.lastID)
+	created.UpdatedAt = created.CreatedAt
+	created.Entries = []*models.ShelfEntry{}
+	r.shelves[created.ID] = &created
+
+	copied := created
+	copied.Entries = nil
+	return &copied, nil
+}
+
+func (r *shelfRepoStub) UpdateShelf(sh *models.Shelf) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.shelves[sh.ID]
+	if !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, sh.ID)
+	}
+	if err := r.checkName(sh); err != nil {
+		return err
+	}
+
+	existing.Name, existing.Visibility, existing.ShareToken, existing.UpdatedAt = sh.Name, sh.Visibility, sh.ShareToken, sh.UpdatedAt
+	return nil
+}
+
+func (r *shelfRepoStub) DeleteShelf(tenant, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.shelves[id]; !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, id)
+	}
+	delete(r.shelves, id)
+	return nil
+}
+
+func (r *shelfRepoStub) AddShelfEntry(tenant, shelfID string, e *models.ShelfEntry) (*models.ShelfEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sh, ok := r.shelves[shelfID]
+	if !ok {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, shelfID)
+	}
+	if entryIndex(sh, e.BookID) >= 0 {
+		return nil, fmt.Errorf("%w (book_id=%s)", repository.ErrShelfEntryExists, e.BookID)
+	}
+
+	created := *e
+	created.UpdatedAt = created.AddedAt
+	sh.Entries = append(sh.Entries, &created)
+
+	copied := created
+	copied.Position = len(sh.Entries)
+	return &copied, nil
+}
+
+func (r *shelfRepoStub) UpdateShelfEntry(tenant, shelfID string, e *models.ShelfEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sh, i, err := r.entry(shelfID, e.BookID)
+	if err != nil {
+		return err
+	}
+	sh.Entries[i].Note, sh.Entries[i].Progress, sh.Entries[i].UpdatedAt = e.Note, e.Progress, e.UpdatedAt
+	return nil
+}
+
+func (r *shelfRepoStub) DeleteShelfEntry(tenant, shelfID, bookID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sh, i, err := r.entry(shelfID, bookID)
+	if err != nil {
+		return err
+	}
+	sh.Entries = append(sh.Entries[:i:i], sh.Entries[i+1:]...)
+	return nil
+}
+
+func (r *shelfRepoStub) MoveShelfEntry(tenant, shelfID, bookID string, position int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sh, i, err := r.entry(shelfID, bookID)
+	if err != nil {
+		return err
+	}
+
+	moved := sh.Entries[i]
+	others := append(sh.Entries[:i:i], sh.Entries[i+1:]...)
+	if position > len(others)+1 {
+		position = len(others) + 1
+	}
+	sh.Entries = append(append(append([]*models.ShelfEntry{}, others[:position-1]...), moved), others[position-1:]...)
+	return nil
+}
+
+func (r *shelfRepoStub) ReorderShelf(tenant, shelfID string, bookIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sh, ok := r.shelves[shelfID]
+	if !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, shelfID)
+	}
+
+	reordered := []*models.ShelfEntry{}
+	for _, id := range bookIDs {
+		if i := entryIndex(sh, id); i >= 0 && entryIndex(&models.Shelf{Entries: reordered}, id) < 0 {
+			reordered = append(reordered, sh.Entries[i])
+		}
+	}
+	if len(reordered) != len(bookIDs) || len(reordered) != len(sh.Entries) {
+		return fmt.Errorf("%w (shelf_id=%s)", repository.ErrShelfOrderMismatch, shelfID)
+	}
+	sh.Entries = reordered
+	return nil
+}
+
+func (r *shelfRepoStub) DeleteBookReferences(tenant, bookID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sh := range r.shelves {
+		kept := []*models.ShelfEntry{}
+		for _, e := range sh.Entries {
+			if bookID != "" && e.BookID != bookID {
+				kept = append(kept, e)
+			}
+		}
+		sh.Entries = kept
+	}
+	return nil
+}
+
+func (r *shelfRepoStub) checkName(sh *models.Shelf) error {
+	for _, existing := range r.shelves {
+		if existing.ID != sh.ID && existing.MemberID == sh.MemberID && existing.Name == sh.Name {
+			return fmt.Errorf("%w (name=%s)", repository.ErrShelfExists, sh.Name)
+		}
+	}
+	return nil
+}
+
+func (r *shelfRepoStub) entry(shelfID, bookID string) (*models.Shelf, int, error) {
+	sh, ok := r.shelves[shelfID]
+	if !ok {
+		return nil, 0, fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, shelfID)
+	}
+
+	i := entryIndex(sh, bookID)
+	if i < 0 {
+		return nil, 0, fmt.Errorf("%w (book_id=%s)", repository.ErrShelfEntryNotFound, bookID)
+	}
+	return sh, i, nil
+}
+
+func entryIndex(sh *models.Shelf, bookID string) int {
+	for i, e := range sh.Entries {
+		if e.BookID == bookID {
+			return i
+		}
+	}
+	return -1
+}
+
+// copyShelf copies the shelf with its entries numbered from 1.
+func copyShelf(sh *models.Shelf) *models.Shelf {
+	copied := *sh
+	copied.Entries = make([]*models.ShelfEntry, len(sh.Entries))
+	for i, e := range sh.Entries {
+		entry := *e
+		entry.Position = i + 1
+		copied.Entries[i] = &entry
+	}
+	return &copied
+}
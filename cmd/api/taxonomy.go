@@ -0,0 +1,520 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/auwendil/crud-app/internal/repository/taxonomy"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+const (
+	maxTagLength          = 64
+	maxBookTags           = 50
+	maxBookCategories     = 20
+	maxCategoryNameLength = 255
+)
+
+type categoryRequest struct {
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+}
+
+type bookTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type bookCategoriesRequest struct {
+	Categories []string `json:"categories"`
+}
+
+type bookPublicationRequest struct {
+	Language      string `json:"language"`
+	PublishedYear int    `json:"published_year"`
+}
+
+// categoryNode is a category together with its subcategories ordered by
+// name.
+type categoryNode struct {
+	*models.Category
+	Children []*categoryNode `json:"children"`
+}
+
+func prepareTaxonomyRepo(dbType, connString string) (repository.TaxonomyRepo, error) {
+	switch dbType {
+	case "postgresql":
+		db, err := database.OpenPostgreSQL(connString)
+		if err != nil {
+			return nil, err
+		}
+		return taxonomy.NewPostgreSQLRepo(db), nil
+	case "mongodb":
+		db, err := database.OpenMongoDB(connString)
+		if err != nil {
+			return nil, err
+		}
+		repo := taxonomy.NewMongoDBRepo(db)
+		return repo, repo.CreateIndexes()
+	}
+
+	return nil, fmt.Errorf("unsupported database type: %q", dbType)
+}
+
+// taxonomyRoutes mounts the category tree and tags and categories of
+// books. Readers browse them, editors change them.
+func (s *Server) taxonomyRoutes(r chi.Router, limits map[string]func(http.Handler) http.Handler) {
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleReader))
+		r.Use(limits[readRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Get("/category", s.handleGetCategories)
+		r.Get("/category/{id}", s.handleGetCategory)
+		r.Get("/tag", s.handleGetTags)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireRole(auth.RoleEditor))
+		r.Use(limits[writeRoutes])
+		r.Use(s.resolveTenant)
+
+		r.Post("/category", s.handleAddCategory)
+		r.Put("/category/{id}", s.handleUpdateCategory)
+		r.Delete("/category/{id}", s.handleDeleteCategory)
+		r.Put("/book/{id}/tags", s.handleSetBookTags)
+		r.Put("/book/{id}/categories", s.handleSetBookCategories)
+		r.Put("/book/{id}/publication", s.handleSetBookPublication)
+	})
+}
+
+// taxonomyFilter parses tag and category query parameters of book lists,
+// they require taxonomy of current books.
+func (s *Server) taxonomyFilter(r *http.Request) (repository.TaxonomyFilter, error) {
+	query := r.URL.Query()
+	filter := repository.TaxonomyFilter{Tag: normalizeTag(query.Get("tag")), CategoryID: query.Get("category")}
+	if filter.IsZero() {
+		return filter, nil
+	}
+
+	if s.taxonomy == nil || query.Get("as_of") != "" {
+		return filter, newPublicError("tag and category filters require taxonomy of current books")
+	}
+	return filter, nil
+}
+
+// findBooks returns books matching filter ordered by their IDs.
+func (s *Server) findBooks(r *http.Request, repo repository.BookRepo, filter repository.TaxonomyFilter) ([]*models.Book, error) {
+	ids, err := s.taxonomy.FindBooks(requestTenant(r.Context()), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	books, err := repo.GetBooksByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(books, func(i, j int) bool { return bookIDLess(books[i].ID, books[j].ID) })
+	return books, nil
+}
+
+// withTaxonomies adds tags, categories and publications to books when
+// taxonomy is enabled.
+func (s *Server) withTaxonomies(tenant string, items []*bookDetails) error {
+	if s.taxonomy == nil || len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+
+	taxonomies, err := s.taxonomy.GetBookTaxonomies(tenant, ids)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if t, ok := taxonomies[item.ID]; ok {
+			item.Tags, item.Categories = t.Tags, t.Categories
+			item.Language, item.PublishedYear = t.Language, t.PublishedYear
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := s.taxonomy.GetCategories(requestTenant(r.Context()))
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	roots, _ := categoryTree(categories)
+	_ = writeResource(w, roots, http.StatusOK)
+}
+
+// handleGetCategory responds with the category and its subcategories.
+func (s *Server) handleGetCategory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	categories, err := s.taxonomy.GetCategories(requestTenant(r.Context()))
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_, nodes := categoryTree(categories)
+	node, ok := nodes[id]
+	if !ok {
+		handleTaxonomyError(w, r, fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id))
+		return
+	}
+
+	_ = writeResource(w, node, http.StatusOK)
+}
+
+func (s *Server) handleAddCategory(w http.ResponseWriter, r *http.Request) {
+	req, ok := readCategory(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := s.taxonomy.AddCategory(&models.Category{
+		Tenant:    requestTenant(r.Context()),
+		ParentID:  req.ParentID,
+		Name:      req.Name,
+		CreatedAt: time.Now().UTC(),
+	})
+	if errors.Is(err, repository.ErrCategoryNotFound) {
+		_ = handleErrorJSON(w, r, expose(fmt.Errorf("parent %w", err)), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		handleTaxonomyError(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Location": []string{"/category/" + c.ID}}
+	_ = writeResource(w, c, http.StatusCreated, headers)
+}
+
+// handleUpdateCategory renames the category and moves it together with
+// its subcategories under parent_id, an empty one makes it a root.
+func (s *Server) handleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	c, err := s.taxonomy.GetCategory(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleTaxonomyError(w, r, err)
+		return
+	}
+
+	req, ok := readCategory(w, r)
+	if !ok {
+		return
+	}
+
+	c.Name = req.Name
+	c.ParentID = req.ParentID
+	err = s.taxonomy.UpdateCategory(c)
+	if errors.Is(err, repository.ErrCategoryNotFound) {
+		_ = handleErrorJSON(w, r, expose(fmt.Errorf("parent %w", err)), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		handleTaxonomyError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, c, http.StatusOK)
+}
+
+// handleDeleteCategory removes the category from the tree and from its
+// books, categories with subcategories are kept.
+func (s *Server) handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	if err := s.taxonomy.DeleteCategory(requestTenant(r.Context()), chi.URLParam(r, "id")); err != nil {
+		handleTaxonomyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetTags counts books by their tags, the most used tags first.
+// Counted books can be narrowed down with tag and category query
+// parameters like book lists.
+func (s *Server) handleGetTags(w http.ResponseWriter, r *http.Request) {
+	filter, err := s.taxonomyFilter(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	counts, err := s.taxonomy.GetTagCounts(requestTenant(r.Context()), filter)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, counts, http.StatusOK)
+}
+
+// handleSetBookTags replaces tags of the book, they are stored trimmed
+// and lowercase.
+func (s *Server) handleSetBookTags(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	var req *bookTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	tags, err := validateBookTags(req)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !s.bookExists(w, r, bookID) {
+		return
+	}
+
+	if err = s.taxonomy.SetBookTags(requestTenant(r.Context()), bookID, tags); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeBookTaxonomy(w, r, bookID)
+}
+
+func (s *Server) handleSetBookCategories(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	var req *bookCategoriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	if err := validateBookCategories(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !s.bookExists(w, r, bookID) {
+		return
+	}
+
+	err := s.taxonomy.SetBookCategories(requestTenant(r.Context()), bookID, req.Categories)
+	if errors.Is(err, repository.ErrCategoryNotFound) {
+		_ = handleErrorJSON(w, r, expose(err), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeBookTaxonomy(w, r, bookID)
+}
+
+// handleSetBookPublication replaces the language and the publication year
+// of the book, which are counted by facets of book lists. Missing ones are
+// unknown.
+func (s *Server) handleSetBookPublication(w http.ResponseWriter, r *http.Request) {
+	bookID := chi.URLParam(r, "id")
+	var req *bookPublicationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return
+	}
+
+	if err := validateBookPublication(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !s.bookExists(w, r, bookID) {
+		return
+	}
+
+	err := s.taxonomy.SetBookPublication(requestTenant(r.Context()), bookID, req.Language, req.PublishedYear)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeBookTaxonomy(w, r, bookID)
+}
+
+// writeBookTaxonomy responds with tags and categories of the book.
+func (s *Server) writeBookTaxonomy(w http.ResponseWriter, r *http.Request, bookID string) {
+	tenant := requestTenant(r.Context())
+	taxonomies, err := s.taxonomy.GetBookTaxonomies(tenant, []string{bookID})
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	t, ok := taxonomies[bookID]
+	if !ok {
+		t = &models.BookTaxonomy{Tenant: tenant, BookID: bookID, Tags: []string{}, Categories: []string{}}
+	}
+	_ = writeResource(w, t, http.StatusOK)
+}
+
+// handleTaxonomyError responds with 404 for missing categories, 409 for
+// changes breaking the category tree and hides every other error behind
+// 500.
+func handleTaxonomyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrCategoryNotFound):
+		_ = handleErrorJSON(w, r, expose(err), http.StatusNotFound)
+	case errors.Is(err, repository.ErrCategoryExists),
+		errors.Is(err, repository.ErrCategoryHasChildren),
+		errors.Is(err, repository.ErrCategoryCycle):
+		_ = handleErrorJSON(w, r, expose(err), http.StatusConflict)
+	default:
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// readCategory decodes and validates the category sent in request body,
+// responding with an error when it is not valid.
+func readCategory(w http.ResponseWriter, r *http.Request) (*categoryRequest, bool) {
+	var req *categoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return nil, false
+	}
+
+	if err := validateCategory(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+	return req, true
+}
+
+func validateCategory(req *categoryRequest) error {
+	if req == nil {
+		return newPublicError("request body must be a category")
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	v := &validationError{}
+	if req.Name == "" {
+		v.add("name", "is required")
+	} else if len([]rune(req.Name)) > maxCategoryNameLength {
+		v.add("name", fmt.Sprintf("must have at most %d characters", maxCategoryNameLength))
+	}
+	return v.errOrNil()
+}
+
+// validateBookTags returns normalized tags of the request without
+// duplicates.
+func validateBookTags(req *bookTagsRequest) ([]string, error) {
+	if req == nil || req.Tags == nil {
+		return nil, newPublicError("request body must have a list of tags")
+	}
+
+	v := &validationError{}
+	if len(req.Tags) > maxBookTags {
+		v.add("tags", fmt.Sprintf("must have at most %d tags", maxBookTags))
+	}
+
+	seen := make(map[string]bool, len(req.Tags))
+	tags := make([]string, 0, len(req.Tags))
+	for i, tag := range req.Tags {
+		tag = normalizeTag(tag)
+		field := fmt.Sprintf("tags[%d]", i)
+		switch {
+		case tag == "":
+			v.add(field, "must not be empty")
+		case len([]rune(tag)) > maxTagLength:
+			v.add(field, fmt.Sprintf("must have at most %d characters", maxTagLength))
+		case !seen[tag]:
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, v.errOrNil()
+}
+
+func validateBookCategories(req *bookCategoriesRequest) error {
+	if req == nil || req.Categories == nil {
+		return newPublicError("request body must have a list of categories")
+	}
+
+	v := &validationError{}
+	if len(req.Categories) > maxBookCategories {
+		v.add("categories", fmt.Sprintf("must have at most %d categories", maxBookCategories))
+	}
+	for i, id := range req.Categories {
+		if id == "" {
+			v.add(fmt.Sprintf("categories[%d]", i), "must not be empty")
+		}
+	}
+	return v.errOrNil()
+}
+
+// validateBookPublication accepts two or three letter ISO 639 language
+// codes, stored lowercase, and years up to the next one.
+func validateBookPublication(req *bookPublicationRequest) error {
+	if req == nil {
+		return newPublicError("request body must be a publication")
+	}
+
+	req.Language = strings.ToLower(strings.TrimSpace(req.Language))
+	v := &validationError{}
+	if req.Language != "" && !languagePattern.MatchString(req.Language) {
+		v.add("language", "must be a two or three letter ISO 639 code")
+	}
+	if maxYear := time.Now().Year() + 1; req.PublishedYear < 0 || req.PublishedYear > maxYear {
+		v.add("published_year", fmt.Sprintf("must be a year between 1 and %d", maxYear))
+	}
+	return v.errOrNil()
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// categoryTree links categories to their parents, keeping their order
+// among siblings. It returns root categories and all nodes by their IDs.
+func categoryTree(categories []*models.Category) ([]*categoryNode, map[string]*categoryNode) {
+	nodes := make(map[string]*categoryNode, len(categories))
+	for _, c := range categories {
+		nodes[c.ID] = &categoryNode{Category: c, Children: []*categoryNode{}}
+	}
+
+	roots := []*categoryNode{}
+	for _, c := range categories {
+		parent, ok := nodes[c.ParentID]
+		if !ok {
+			roots = append(roots, nodes[c.ID])
+			continue
+		}
+		parent.Children = append(parent.Children, nodes[c.ID])
+	}
+	return roots, nodes
+}
+
+// bookIDLess orders numeric IDs by their value and other IDs as strings,
+// like books are listed.
+func bookIDLess(a, b string) bool {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/references"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Server_Taxonomy_ShouldFilterBooksByTagAndCategoryTree(t *testing.T) {
+	// setup
+	s, srv := prepareTaxonomyServer(t)
+
+	// given
+	var fiction, fantasy models.Category
+	doJSON(t, http.MethodPost, srv.URL+"/category", `{"name":"Fiction"}`, &fiction)
+	doJSON(t, http.MethodPost, srv.URL+"/category", fmt.Sprintf(`{"name":"Fantasy","parent_id":%q}`, fiction.ID), &fantasy)
+
+	var tagged models.BookTaxonomy
+	res := doJSON(t, http.MethodPut, srv.URL+"/book/1/tags", `{"tags":[" Classic","magic","classic"]}`, &tagged)
+	doJSON(t, http.MethodPut, srv.URL+"/book/1/categories", fmt.Sprintf(`{"categories":[%q]}`, fantasy.ID), nil)
+	doJSON(t, http.MethodPut, srv.URL+"/book/2/tags", `{"tags":["classic"]}`, nil)
+	doJSON(t, http.MethodPut, srv.URL+"/book/2/categories", fmt.Sprintf(`{"categories":[%q]}`, fiction.ID), nil)
+	doJSON(t, http.MethodPut, srv.URL+"/book/3/tags", `{"tags":["magic"]}`, nil)
+
+	// when
+	var inFiction bookPage
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books?category="+fiction.ID, "", &inFiction)
+	var magicFiction bookPage
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books?tag=MAGIC&category="+fiction.ID, "", &magicFiction)
+	var classics struct {
+		Data []*bookDetails `json:"data"`
+	}
+	doJSON(t, http.MethodGet, srv.URL+"/v1/book?tag=classic", "", &classics)
+	var counts []*models.TagCount
+	doJSON(t, http.MethodGet, srv.URL+"/tag?category="+fantasy.ID, "", &counts)
+
+	// then
+	if res.StatusCode != http.StatusOK || fmt.Sprint(tagged.Tags) != "[classic magic]" {
+		t.Fatalf("Expected normalized tags of book 1, received %d: %+v\n", res.StatusCode, tagged)
+	}
+	if ids := bookDetailsIDs(inFiction.Items); ids != "[1 2]" {
+		t.Fatalf("Expected books of the category and its subcategories, has: %s\n", ids)
+	}
+	if ids := bookDetailsIDs(magicFiction.Items); ids != "[1]" || fmt.Sprint(magicFiction.Items[0].Categories) != fmt.Sprint([]string{fantasy.ID}) {
+		t.Fatalf("Expected book 1 with its categories, has: %s\n", ids)
+	}
+	if ids := bookDetailsIDs(classics.Data); ids != "[1 2]" {
+		t.Fatalf("Expected v1 books tagged classic, has: %s\n", ids)
+	}
+	if len(counts) != 2 || *counts[0] != (models.TagCount{Tag: "classic", Count: 1}) || *counts[1] != (models.TagCount{Tag: "magic", Count: 1}) {
+		t.Fatalf("Expected tags of book 1, has: %+v\n", counts)
+	}
+	if s.dbRepo.(*dbRepoStub).getBooksByIDsCalls != 3 {
+		t.Fatalf("Filtered books should be loaded by their IDs, loaded %d times\n", s.dbRepo.(*dbRepoStub).getBooksByIDsCalls)
+	}
+}
+
+func Test_Server_Taxonomy_ShouldKeepCategoryTreeConsistent(t *testing.T) {
+	// setup
+	_, srv := prepareTaxonomyServer(t)
+
+	var fiction, fantasy, poetry models.Category
+	doJSON(t, http.MethodPost, srv.URL+"/category", `{"name":"Fiction"}`, &fiction)
+	doJSON(t, http.MethodPost, srv.URL+"/category", fmt.Sprintf(`{"name":"Fantasy","parent_id":%q}`, fiction.ID), &fantasy)
+	doJSON(t, http.MethodPost, srv.URL+"/category", `{"name":"Poetry"}`, &poetry)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Rejects duplicate sibling", http.MethodPost, "/category", fmt.Sprintf(`{"name":"Fantasy","parent_id":%q}`, fiction.ID), http.StatusConflict},
+		{"Rejects missing parent", http.MethodPost, "/category", `{"name":"Drama","parent_id":"99"}`, http.StatusUnprocessableEntity},
+		{"Rejects empty name", http.MethodPost, "/category", `{"name":"  "}`, http.StatusBadRequest},
+		{"Rejects move under descendant", http.MethodPut, "/category/" + fiction.ID, fmt.Sprintf(`{"name":"Fiction","parent_id":%q}`, fantasy.ID), http.StatusConflict},
+		{"Keeps category with children", http.MethodDelete, "/category/" + fiction.ID, "", http.StatusConflict},
+		{"Rejects missing category of book", http.MethodPut, "/book/1/categories", `{"categories":["99"]}`, http.StatusUnprocessableEntity},
+		{"Rejects tags of missing book", http.MethodPut, "/book/9/tags", `{"tags":["classic"]}`, http.StatusNotFound},
+		{"Rejects empty tag", http.MethodPut, "/book/1/tags", `{"tags":[" "]}`, http.StatusBadRequest},
+		{"Rejects filters of past books", http.MethodGet, "/v2/books?tag=classic&as_of=2020-01-01T00:00:00Z", "", http.StatusBadRequest},
+		{"Moves category with children", http.MethodPut, "/category/" + fiction.ID, fmt.Sprintf(`{"name":"Prose","parent_id":%q}`, poetry.ID), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			res := doJSON(t, tt.method, srv.URL+tt.path, tt.body, nil)
+
+			// then
+			if res.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d, received %d\n", tt.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+
+	var tree []*categoryNode
+	doJSON(t, http.MethodGet, srv.URL+"/category", "", &tree)
+	if len(tree) != 1 || tree[0].Name != "Poetry" || len(tree[0].Children) != 1 ||
+		tree[0].Children[0].Name != "Prose" || len(tree[0].Children[0].Children) != 1 {
+		t.Fatalf("Expected Poetry > Prose > Fantasy, has: %+v\n", tree)
+	}
+}
+
+func Test_Server_Taxonomy_ShouldForgetDeletedBooks(t *testing.T) {
+	// setup
+	s, srv := prepareTaxonomyServer(t)
+	s.dbRepo = references.New(s.dbRepo, []repository.BookReferences{s.taxonomy})
+	doJSON(t, http.MethodPut, srv.URL+"/book/1/tags", `{"tags":["classic"]}`, nil)
+	doJSON(t, http.MethodPut, srv.URL+"/book/2/tags", `{"tags":["classic"]}`, nil)
+
+	// when
+	res := doJSON(t, http.MethodDelete, srv.URL+"/book/1", "", nil)
+
+	// then
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, received %d\n", http.StatusNoContent, res.StatusCode)
+	}
+	if ids, _ := s.taxonomy.FindBooks(repository.DefaultTenant, repository.TaxonomyFilter{Tag: "classic"}); fmt.Sprint(ids) != "[2]" {
+		t.Fatalf("Tags of deleted book 1 should be removed, books tagged: %v\n", ids)
+	}
+}
+
+func Test_Server_Taxonomy_ShouldCountFacetsOfListedBooks(t *testing.T) {
+	// setup
+	_, srv := prepareTaxonomyServer(t)
+
+	// given
+	doJSON(t, http.MethodPut, srv.URL+"/book/1/tags", `{"tags":["classic","dystopia"]}`, nil)
+	doJSON(t, http.MethodPut, srv.URL+"/book/2/tags", `{"tags":["classic"]}`, nil)
+	var published bookDetails
+	res := doJSON(t, http.MethodPut, srv.URL+"/book/1/publication", `{"language":"EN","published_year":1949}`, &published)
+	doJSON(t, http.MethodPut, srv.URL+"/book/2/publication", `{"language":"en","published_year":1932}`, nil)
+	doJSON(t, http.MethodPut, srv.URL+"/book/3/publication", `{"language":"pl","published_year":1945}`, nil)
+
+	// when
+	var classics struct {
+		Data []*bookDetails `json:"data"`
+		Meta *bookListMeta  `json:"meta"`
+	}
+	doJSON(t, http.MethodGet, srv.URL+"/v1/book?tag=classic&facets=true", "", &classics)
+	var page bookPage
+	doJSON(t, http.MethodGet, srv.URL+"/v2/books?limit=1&facets=true", "", &page)
+	var unfaceted map[string]any
+	doJSON(t, http.MethodGet, srv.URL+"/v1/book", "", &unfaceted)
+
+	// then
+	if res.StatusCode != http.StatusOK || published.Language != "en" || published.PublishedYear != 1949 {
+		t.Fatalf("Expected normalized publication of book 1, received %d: %+v\n", res.StatusCode, published)
+	}
+	if classics.Meta == nil || classics.Meta.Facets == nil {
+		t.Fatalf("Expected facets in meta of v1 list, has: %+v\n", classics.Meta)
+	}
+	facets := classics.Meta.Facets
+	if counts := facetCounts(facets.Authors); counts != "[Author1:1 Author2:1]" {
+		t.Fatalf("Expected authors of classics, has: %s\n", counts)
+	}
+	if counts := facetCounts(facets.Tags); counts != "[classic:2 dystopia:1]" {
+		t.Fatalf("Expected tags of classics, has: %s\n", counts)
+	}
+	if counts := facetCounts(facets.Languages); counts != "[en:2]" {
+		t.Fatalf("Expected languages of classics, has: %s\n", counts)
+	}
+	if counts := facetCounts(facets.Decades); counts != "[1930s:1 1940s:1]" {
+		t.Fatalf("Expected decades of classics, has: %s\n", counts)
+	}
+	if len(page.Items) != 1 || page.Facets == nil || facetCounts(page.Facets.Decades) != "[1940s:2 1930s:1]" {
+		t.Fatalf("Expected facets of all pages, has: %+v\n", page.Facets)
+	}
+	if _, ok := unfaceted["meta"]; ok {
+		t.Fatalf("Facets should be counted only when requested, has: %v\n", unfaceted["meta"])
+	}
+}
+
+func Test_Server_Taxonomy_ShouldValidatePublicationAndFacets(t *testing.T) {
+	// setup
+	_, srv := prepareTaxonomyServer(t)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Rejects invalid language", http.MethodPut, "/book/1/publication", `{"language":"english"}`, http.StatusBadRequest},
+		{"Rejects future year", http.MethodPut, "/book/1/publication", fmt.Sprintf(`{"published_year":%d}`, time.Now().Year()+2), http.StatusBadRequest},
+		{"Rejects publication of missing book", http.MethodPut, "/book/9/publication", `{"language":"en"}`, http.StatusNotFound},
+		{"Clears publication", http.MethodPut, "/book/1/publication", `{}`, http.StatusOK},
+		{"Rejects invalid facets", http.MethodGet, "/v1/book?facets=maybe", "", http.StatusBadRequest},
+		{"Rejects facets of past books", http.MethodGet, "/v2/books?facets=true&as_of=2020-01-01T00:00:00Z", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			res := doJSON(t, tt.method, srv.URL+tt.path, tt.body, nil)
+
+			// then
+			if res.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d, received %d\n", tt.expectedStatus, res.StatusCode)
+			}
+		})
+	}
+}
+
+// utils
+
+func facetCounts(counts []*models.FacetCount) string {
+	values := make([]string, len(counts))
+	for i, c := range counts {
+		values[i] = fmt.Sprintf("%s:%d", c.Value, c.Count)
+	}
+	return fmt.Sprint(values)
+}
+
+// prepareTaxonomyServer serves books "1".."3" with taxonomy.
+func prepareTaxonomyServer(t *testing.T) (*Server, *httptest.Server) {
+	s := NewServer("", prepareDbRepo(3))
+	s.taxonomy = newTaxonomyRepoStub()
+
+	srv := httptest.NewServer(s.routes())
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+type taxonomyRepoStub struct {
+	mu         sync.Mutex
+	lastID     int
+	categories map[string]*models.Category
+	books      map[string]*models.BookTaxonomy
+}
+
+func newTaxonomyRepoStub() *taxonomyRepoStub {
+	return &taxonomyRepoStub{categories: map[string]*models.Category{}, books: map[string]*models.BookTaxonomy{}}
+}
+
+func (r *taxonomyRepoStub) GetCategories(tenant string) ([]*models.Category, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	categories := []*models.Category{}
+	for _, c := range r.categories {
+		copied := *c
+		categories = append(categories, &copied)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories, nil
+}
+
+func (r *taxonomyRepoStub) GetCategory(tenant, id string) (*models.Category, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.categories[id]
+	if !ok {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (r *taxonomyRepoStub) AddCategory(c *models.Category) (*models.Category, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkPlacement(c); err != nil {
+		return nil, err
+	}
+
+	r.lastID++
+	created := *c
+	created.ID = strconv.Itoa(r.lastID)
+	r.categories[created.ID] = &created
+	copied := created
+	return &copied, nil
+}
+
+func (r *taxonomyRepoStub) UpdateCategory(c *models.Category) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.categories[c.ID]; !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, c.ID)
+	}
+	if err := r.checkPlacement(c); err != nil {
+		return err
+	}
+	for id := c.ParentID; id != ""; id = r.categories[id].ParentID {
+		if id == c.ID {
+			return fmt.Errorf("%w (id=%s, parent_id=%s)", repository.ErrCategoryCycle, c.ID, c.ParentID)
+		}
+	}
+
+	updated := *c
+	r.categories[c.ID] = &updated
+	return nil
+}
+
+func (r *taxonomyRepoStub) DeleteCategory(tenant, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.categories[id]; !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+	}
+	for _, c := range r.categories {
+		if c.ParentID == id {
+			return fmt.Errorf("%w (id=%s)", repository.ErrCategoryHasChildren, id)
+		}
+	}
+	delete(r.categories, id)
+	return nil
+}
+
+func (r *taxonomyRepoStub) GetBookTaxonomies(tenant string, bookIDs []string) (map[string]*models.BookTaxonomy, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	taxonomies := make(map[string]*models.BookTaxonomy)
+	for _, id := range bookIDs {
+		if t, ok := r.books[id]; ok {
+			copied := *t
+			taxonomies[id] = &copied
+		}
+	}
+	return taxonomies, nil
+}
+
+func (r *taxonomyRepoStub) SetBookTags(tenant, bookID string, tags []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	r.book(bookID).Tags = sorted
+	return nil
+}
+
+func (r *taxonomyRepoStub) SetBookCategories(tenant, bookID string, categoryIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range categoryIDs {
+		if _, ok := r.categories[id]; !ok {
+			return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+		}
+	}
+	r.book(bookID).Categories = append([]string{}, categoryIDs...)
+	return nil
+}
+
+func (r *taxonomyRepoStub) FindBooks(tenant string, filter repository.TaxonomyFilter) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := []string{}
+	for id, t := range r.books {
+		if r.matches(t, filter) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (r *taxonomyRepoStub) GetTagCounts(tenant string, filter repository.TaxonomyFilter) ([]*models.TagCount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byTag := make(map[string]int)
+	for _, t := range r.books {
+		if r.matches(t, filter) {
+			for _, tag := range t.Tags {
+				byTag[tag]++
+			}
+		}
+	}
+
+	counts := []*models.TagCount{}
+	for tag, count := range byTag {
+		counts = append(counts, &models.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+	return counts, nil
+}
+
+func (r *taxonomyRepoStub) SetBookPublication(tenant, bookID, language string, year int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.book(bookID)
+	t.Language, t.PublishedYear = language, year
+	return nil
+}
+
+func (r *taxonomyRepoStub) GetFacets(tenant string, filter repository.TaxonomyFilter) (*models.TaxonomyFacets, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byTag, byLanguage, byDecade := make(map[string]int), make(map[string]int), make(map[string]int)
+	for _, t := range r.books {
+		if !r.matches(t, filter) {
+			continue
+		}
+		for _, tag := range t.Tags {
+			byTag[tag]++
+		}
+		if t.Language != "" {
+			byLanguage[t.Language]++
+		}
+		if t.PublishedYear != 0 {
+			byDecade[fmt.Sprintf("%ds", t.PublishedYear/10*10)]++
+		}
+	}
+	return &models.TaxonomyFacets{
+		Tags:      sortedFacetCounts(byTag),
+		Languages: sortedFacetCounts(byLanguage),
+		Decades:   sortedFacetCounts(byDecade),
+	}, nil
+}
+
+func (r *taxonomyRepoStub) DeleteBookReferences(tenant, bookID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if bookID == "" {
+		r.books = map[string]*models.BookTaxonomy{}
+	}
+	delete(r.books, bookID)
+	return nil
+}
+
+func (r *taxonomyRepoStub) checkPlacement(c *models.Category) error {
+	if _, ok := r.categories[c.ParentID]; c.ParentID != "" && !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, c.ParentID)
+	}
+	for _, existing := range r.categories {
+		if existing.ID != c.ID && existing.ParentID == c.ParentID && existing.Name == c.Name {
+			return fmt.Errorf("%w (name=%s)", repository.ErrCategoryExists, c.Name)
+		}
+	}
+	return nil
+}
+
+func (r *taxonomyRepoStub) book(id string) *models.BookTaxonomy {
+	t, ok := r.books[id]
+	if !ok {
+		t = &models.BookTaxonomy{BookID: id, Tags: []string{}, Categories: []string{}}
+		r.books[id] = t
+	}
+	return t
+}
+
+// matches reports whether the book has the tag and a category within the
+// category of filter.
+func (r *taxonomyRepoStub) matches(t *models.BookTaxonomy, filter repository.TaxonomyFilter) bool {
+	if filter.Tag != "" {
+		found := false
+		for _, tag := range t.Tags {
+			found = found || tag == filter.Tag
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if filter.CategoryID != "" {
+		for _, id := range t.Categories {
+			for ; id != ""; id = r.categories[id].ParentID {
+				if id == filter.CategoryID {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/book"
+	"github.com/auwendil/crud-app/internal/repository/eventsourced"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const tenantHeader = "X-Tenant-ID"
+
+var (
+	errTenantRequired  = newPublicError("tenant is required")
+	errTenantForbidden = newPublicError("credentials do not grant access to requested tenant")
+)
+
+// tenantResolver finds the tenant of a request. A tenant bound to the
+// credentials always wins, a different tenant requested by header or
+// subdomain is rejected. Otherwise the header is used, falling back to the
+// subdomain of baseDomain when it is configured.
+type tenantResolver struct {
+	baseDomain string
+}
+
+func (t *tenantResolver) resolve(r *http.Request) (string, error) {
+	requested := r.Header.Get(tenantHeader)
+	if requested == "" {
+		requested = t.subdomain(r.Host)
+	}
+
+	return chooseTenant(r.Context(), requested)
+}
+
+// chooseTenant picks the tenant bound to the principal of ctx and
+// otherwise the requested one.
+func chooseTenant(ctx context.Context, requested string) (string, error) {
+	if principal, ok := auth.PrincipalFromContext(ctx); ok && principal.Tenant != "" {
+		if requested != "" && requested != principal.Tenant {
+			return "", errTenantForbidden
+		}
+		return principal.Tenant, nil
+	}
+
+	if requested == "" {
+		return "", errTenantRequired
+	}
+
+	if err := repository.ValidateTenant(requested); err != nil {
+		return "", expose(err)
+	}
+	return requested, nil
+}
+
+func (t *tenantResolver) subdomain(host string) string {
+	if t.baseDomain == "" {
+		return ""
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+t.baseDomain)
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+type tenantKey struct{}
+
+func (s *Server) resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.tenants == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tenant, err := s.tenants.resolve(r)
+		switch {
+		case errors.Is(err, errTenantForbidden):
+			_ = handleErrorJSON(w, r, err, http.StatusForbidden)
+			return
+		case err != nil:
+			_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	})
+}
+
+// requestTenant returns the tenant resolved for ctx, or the default one
+// when tenancy is disabled.
+func requestTenant(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	return repository.DefaultTenant
+}
+
+// bookRepo returns the repository scoped to the tenant of the request,
+// or the shared one when tenancy is disabled.
+func (s *Server) bookRepo(r *http.Request) (repository.BookRepo, error) {
+	return s.tenantBookRepo(r.Context())
+}
+
+func (s *Server) tenantBookRepo(ctx context.Context) (repository.BookRepo, error) {
+	if s.tenants == nil {
+		return s.dbRepo, nil
+	}
+
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok {
+		return nil, errTenantRequired
+	}
+
+	tenantRepos, ok := s.dbRepo.(repository.TenantBookRepos)
+	if !ok {
+		return nil, fmt.Errorf("repository does not support tenants")
+	}
+	return tenantRepos.ForTenant(tenant)
+}
+
+func enableTenancy(repo repository.BookRepo, tenancy repository.Tenancy) error {
+	switch r := repo.(type) {
+	case *book.PostgreSQLRepo:
+		r.SetTenancy(tenancy)
+		return nil
+	case *book.MongoDBRepo:
+		r.SetTenancy(tenancy)
+		return r.CreateIndexes()
+	case *eventsourced.Repo:
+		// events of all tenants share the stream, so both strategies
+		// work the same way
+		return nil
+	}
+	return fmt.Errorf("repository does not support tenants")
+}
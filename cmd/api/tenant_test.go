@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/auwendil/crud-app/internal/auth"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Server_Tenancy_ShouldKeepTenantsApart(t *testing.T) {
+	// setup
+	repos := tenantReposStub{"acme": prepareDbRepo(3), "globex": prepareDbRepo(3)}
+	ts := &Server{
+		dbRepo:  repos,
+		tenants: &tenantResolver{baseDomain: "books.example.com"},
+	}
+
+	// given
+	req := httptest.NewRequest(http.MethodDelete, "/book", nil)
+	req.Header.Set(tenantHeader, "acme")
+	w := httptest.NewRecorder()
+
+	// when
+	ts.routes().ServeHTTP(w, req)
+
+	// then
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusNoContent, w.Code)
+	}
+
+	if len(repos["acme"].m) != 0 {
+		t.Fatalf("Books of tenant acme should be deleted, has: %d\n", len(repos["acme"].m))
+	}
+
+	if len(repos["globex"].m) != 3 {
+		t.Fatalf("Books of tenant globex should not be touched, has: %d\n", len(repos["globex"].m))
+	}
+}
+
+func Test_Server_Tenancy_ResolveTenant(t *testing.T) {
+	// setup
+	keys := &apiKeyRepoStub{m: map[string]*models.APIKey{}}
+	acmeKey := addTenantAPIKey(t, keys, "acme")
+
+	tests := []struct {
+		name           string
+		host           string
+		header         string
+		apiKey         string
+		expectedStatus int
+	}{
+		{"Rejects request without tenant", "localhost", "", "", http.StatusBadRequest},
+		{"Rejects invalid tenant", "localhost", "Not Valid", "", http.StatusBadRequest},
+		{"Resolves tenant from header", "localhost", "acme", "", http.StatusOK},
+		{"Resolves tenant from subdomain", "acme.books.example.com:3000", "", "", http.StatusOK},
+		{"Resolves tenant from credentials", "localhost", "", acmeKey, http.StatusOK},
+		{"Rejects other tenant than in credentials", "localhost", "globex", acmeKey, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			ts := &Server{
+				dbRepo:  tenantReposStub{"acme": prepareDbRepo(3)},
+				tenants: &tenantResolver{baseDomain: "books.example.com"},
+			}
+			if tt.apiKey != "" {
+				ts.authenticator = auth.NewAuthenticator(keys, nil)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/book", nil)
+			req.Host = tt.host
+			if tt.header != "" {
+				req.Header.Set(tenantHeader, tt.header)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set(auth.APIKeyHeader, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+
+			// when
+			ts.routes().ServeHTTP(w, req)
+
+			// then
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d(%s) but received: %d(%s)\n",
+					tt.expectedStatus, http.StatusText(tt.expectedStatus),
+					w.Code, http.StatusText(w.Code))
+			}
+		})
+	}
+}
+
+// utils
+
+type tenantReposStub map[string]*dbRepoStub
+
+func (r tenantReposStub) ForTenant(tenant string) (repository.BookRepo, error) {
+	repo, ok := r[tenant]
+	if !ok {
+		repo = prepareDbRepo(0)
+		r[tenant] = repo
+	}
+	return repo, nil
+}
+
+func (r tenantReposStub) GetAllBooks() ([]*models.Book, error) {
+	return r[repository.DefaultTenant].GetAllBooks()
+}
+
+func (r tenantReposStub) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return r[repository.DefaultTenant].GetBooksBatch(offset, limit)
+}
+
+func (r tenantReposStub) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	return r[repository.DefaultTenant].GetBooksByIDs(ids)
+}
+
+func (r tenantReposStub) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return r[repository.DefaultTenant].SearchBooks(phrase, offset, limit)
+}
+
+func (r tenantReposStub) GetBook(id string) (*models.Book, error) {
+	return r[repository.DefaultTenant].GetBook(id)
+}
+
+func (r tenantReposStub) AddBook(b *models.Book) (*models.Book, error) {
+	return r[repository.DefaultTenant].AddBook(b)
+}
+
+func (r tenantReposStub) UpdateBook(id string, updatedBook *models.Book) error {
+	return r[repository.DefaultTenant].UpdateBook(id, updatedBook)
+}
+
+func (r tenantReposStub) DeleteBook(id string) error {
+	return r[repository.DefaultTenant].DeleteBook(id)
+}
+
+func (r tenantReposStub) DeleteAllBooks() error {
+	return r[repository.DefaultTenant].DeleteAllBooks()
+}
+
+func addTenantAPIKey(t *testing.T, keys *apiKeyRepoStub, tenant string) string {
+	key, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while generating api key: %s\n", err)
+	}
+
+	_, _ = keys.AddAPIKey(&models.APIKey{Name: tenant, Hash: auth.HashAPIKey(key), Role: string(auth.RoleAdmin), Tenant: tenant})
+	return key
+}
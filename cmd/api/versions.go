@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type problemDetailsKey struct{}
+
+// problemDetailsOnly makes every error of the wrapped routes a problem+json
+// response regardless of the Accept header.
+func problemDetailsOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), problemDetailsKey{}, true)))
+	})
+}
+
+func problemDetailsRequired(r *http.Request) bool {
+	required, _ := r.Context().Value(problemDetailsKey{}).(bool)
+	return required
+}
+
+// deprecated marks responses of routes kept only for backward
+// compatibility and points clients at the same route under successorPrefix.
+func (s *Server) deprecated(successorPrefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			if !s.legacySunset.IsZero() {
+				w.Header().Set("Sunset", s.legacySunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Set("Link", "<"+successorPrefix+r.URL.Path+`>; rel="successor-version"`)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Server_Versions_LegacyRoutesShouldBeDeprecated(t *testing.T) {
+	tests := []struct {
+		name               string
+		path               string
+		expectedDeprecated bool
+	}{
+		{"Versioned route", "/v1/book", false},
+		{"Unversioned route", "/book", true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			ts := &Server{
+				dbRepo:       prepareDbRepo(3),
+				legacySunset: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+			}
+
+			// given
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			// when
+			ts.routes().ServeHTTP(w, req)
+
+			// then
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d but received: %d\n", http.StatusOK, w.Code)
+			}
+
+			if deprecated := w.Header().Get("Deprecation") == "true"; deprecated != tt.expectedDeprecated {
+				t.Fatalf("Expected deprecated=%t, headers: %v\n", tt.expectedDeprecated, w.Header())
+			}
+
+			if tt.expectedDeprecated {
+				if sunset := w.Header().Get("Sunset"); sunset != "Tue, 01 Jan 2030 00:00:00 GMT" {
+					t.Fatalf("Unexpected Sunset header: %q\n", sunset)
+				}
+				if link := w.Header().Get("Link"); link != `</v1/book>; rel="successor-version"` {
+					t.Fatalf("Unexpected Link header: %q\n", link)
+				}
+			}
+
+			receivedBooks := getBooksFromResponse(t, parseHttpResponse(t, w.Result()).Data)
+			if len(receivedBooks) != 3 {
+				t.Fatalf("Returned books array has wrong size: has %d, should be: %d\n", len(receivedBooks), 3)
+			}
+		})
+	}
+}
+
+func Test_Server_Versions_V2ShouldPaginateBooks(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo: prepareDbRepo(3),
+	}
+
+	// given
+	req := httptest.NewRequest(http.MethodGet, "/v2/books?offset=1&limit=1", nil)
+	w := httptest.NewRecorder()
+
+	// when
+	ts.routes().ServeHTTP(w, req)
+
+	// then
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusOK, w.Code)
+	}
+
+	var page bookPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal("Response is not a page of books:", err)
+	}
+
+	if len(page.Items) != 1 || page.Items[0].ID != storedBooks[1].ID {
+		t.Fatalf("Page should contain only second book, has: %v\n", page.Items)
+	}
+
+	if page.NextOffset == nil || *page.NextOffset != 2 {
+		t.Fatalf("Page should point at next one, has: %+v\n", page)
+	}
+}
+
+func Test_Server_Versions_V2ShouldReturnCreatedBook(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo: prepareDbRepo(3),
+	}
+
+	// given
+	req := httptest.NewRequest(http.MethodPost, "/v2/books", strings.NewReader(`{"id":"5","name":"Name5","author":"Author5"}`))
+	w := httptest.NewRecorder()
+
+	// when
+	ts.routes().ServeHTTP(w, req)
+
+	// then
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusCreated, w.Code)
+	}
+
+	if location := w.Header().Get("Location"); location != "/v2/books/5" {
+		t.Fatalf("Unexpected Location header: %q\n", location)
+	}
+
+	var book map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&book); err != nil {
+		t.Fatal("Response is not a book:", err)
+	}
+
+	if _, enveloped := book["error"]; enveloped || book["name"] != "Name5" {
+		t.Fatalf("Response should be a bare book, has: %v\n", book)
+	}
+}
+
+func Test_Server_Versions_V2ShouldAlwaysReturnProblemDetails(t *testing.T) {
+	// setup
+	ts := &Server{
+		dbRepo: prepareDbRepo(3),
+	}
+
+	// given
+	req := httptest.NewRequest(http.MethodGet, "/v2/books/-1", nil)
+	w := httptest.NewRecorder()
+
+	// when
+	ts.routes().ServeHTTP(w, req)
+
+	// then
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d but received: %d\n", http.StatusNotFound, w.Code)
+	}
+
+	if contentType := w.Header().Get("Content-Type"); contentType != problemContentType {
+		t.Fatalf("Expected content type %q but received: %q\n", problemContentType, contentType)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/webhook"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultDeliveriesLimit = 100
+	maxWebhookURLLength    = 2048
+)
+
+// webhookEventTypes are event types webhooks can subscribe to.
+var webhookEventTypes = map[string]bool{
+	string(events.BookCreated):  true,
+	string(events.BookUpdated):  true,
+	string(events.BookDeleted):  true,
+	string(events.BooksCleared): true,
+}
+
+// webhookRequest is the body of requests creating and replacing webhooks,
+// new webhooks are active unless Active is false.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+func (s *Server) webhookRoutes(r chi.Router) {
+	r.Get("/webhook", s.handleGetWebhooks)
+	r.Post("/webhook", s.handleAddWebhook)
+	r.Get("/webhook/dead-letters", s.handleGetDeadLetters)
+	r.Get("/webhook/{id}", s.handleGetWebhook)
+	r.Put("/webhook/{id}", s.handleUpdateWebhook)
+	r.Delete("/webhook/{id}", s.handleDeleteWebhook)
+	r.Get("/webhook/{id}/deliveries", s.handleGetWebhookDeliveries)
+	r.Post("/webhook/{id}/test", s.handleTestWebhook)
+}
+
+func (s *Server) handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.webhooks.GetWebhooks(requestTenant(r.Context()))
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	for _, wh := range webhooks {
+		wh.Secret = ""
+	}
+	_ = writeResource(w, webhooks, http.StatusOK)
+}
+
+func (s *Server) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	wh, ok := s.findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	wh.Secret = ""
+	_ = writeResource(w, wh, http.StatusOK)
+}
+
+// handleAddWebhook responds with the secret of the created webhook, it is
+// not returned afterwards.
+func (s *Server) handleAddWebhook(w http.ResponseWriter, r *http.Request) {
+	wh, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	wh.Tenant = requestTenant(r.Context())
+	wh.Secret = secret
+
+	wh, err = s.webhooks.AddWebhook(wh)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	headers := http.Header{"Location": []string{"/webhook/" + wh.ID}}
+	_ = writeResource(w, wh, http.StatusCreated, headers)
+}
+
+func (s *Server) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	existing, ok := s.findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	wh, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+	wh.ID = existing.ID
+	wh.Tenant = existing.Tenant
+	wh.CreatedAt = existing.CreatedAt
+
+	if err := s.webhooks.UpdateWebhook(wh); err != nil {
+		handleWebhookError(w, r, err)
+		return
+	}
+
+	_ = writeResource(w, wh, http.StatusOK)
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.webhooks.DeleteWebhook(requestTenant(r.Context()), chi.URLParam(r, "id")); err != nil {
+		handleWebhookError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit, err := deliveriesLimit(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	wh, ok := s.findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := s.webhooks.GetWebhookDeliveries(wh.ID, limit)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, deliveries, http.StatusOK)
+}
+
+func (s *Server) handleGetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, err := deliveriesLimit(r)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := s.webhooks.GetDeadLetters(requestTenant(r.Context()), limit)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, deliveries, http.StatusOK)
+}
+
+// handleTestWebhook sends a test event to the webhook, even an inactive
+// one, and responds with the recorded attempt.
+func (s *Server) handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	wh, ok := s.findWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := s.webhookDispatcher.SendTest(wh)
+	if err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeResource(w, delivery, http.StatusOK)
+}
+
+// findWebhook loads the webhook of the request tenant named in the path,
+// responding with an error when it cannot be found.
+func (s *Server) findWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	wh, err := s.webhooks.GetWebhook(requestTenant(r.Context()), chi.URLParam(r, "id"))
+	if err != nil {
+		handleWebhookError(w, r, err)
+		return nil, false
+	}
+	return wh, true
+}
+
+func handleWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrWebhookNotFound) {
+		_ = handleErrorJSON(w, r, expose(err), http.StatusNotFound)
+		return
+	}
+	_ = handleErrorJSON(w, r, err, http.StatusInternalServerError)
+}
+
+// readWebhook decodes and validates the webhook sent in request body,
+// responding with an error when it is not valid.
+func readWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	var req *webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_ = handleErrorJSON(w, r, decodeError(err), decodeErrorStatus(err))
+		return nil, false
+	}
+
+	if err := validateWebhook(req); err != nil {
+		_ = handleErrorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	wh := &models.Webhook{URL: req.URL, Events: req.Events, Active: req.Active == nil || *req.Active}
+	if wh.Events == nil {
+		wh.Events = []string{}
+	}
+	return wh, true
+}
+
+func validateWebhook(req *webhookRequest) error {
+	if req == nil {
+		return newPublicError("request body must be a webhook")
+	}
+
+	v := &validationError{}
+	u, err := url.Parse(req.URL)
+	switch {
+	case req.URL == "":
+		v.add("url", "is required")
+	case len(req.URL) > maxWebhookURLLength:
+		v.add("url", fmt.Sprintf("must have at most %d characters", maxWebhookURLLength))
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		v.add("url", "must be an absolute http or https URL")
+	}
+
+	seen := make(map[string]bool)
+	for i, t := range req.Events {
+		switch {
+		case !webhookEventTypes[t]:
+			v.add(fmt.Sprintf("events[%d]", i), fmt.Sprintf("unknown event type %q", t))
+		case seen[t]:
+			v.add(fmt.Sprintf("events[%d]", i), "is repeated")
+		}
+		seen[t] = true
+	}
+	return v.errOrNil()
+}
+
+func deliveriesLimit(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return defaultDeliveriesLimit, nil
+	}
+
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		v := &validationError{}
+		v.add("limit", fmt.Sprintf("must be an integer between 1 and %d", maxPageLimit))
+		return 0, v
+	}
+	return limit, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/publish"
+	"github.com/auwendil/crud-app/internal/webhook"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Server_Webhooks_ShouldDeliverSignedBookEvents(t *testing.T) {
+	// setup
+	srv, _ := prepareWebhooksServer(t)
+
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan delivery, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- delivery{header: r.Header, body: body}
+	}))
+	defer receiver.Close()
+
+	// given
+	var created models.Webhook
+	res := doJSON(t, http.MethodPost, srv.URL+"/webhook", `{"url":"`+receiver.URL+`","events":["book.created"]}`, &created)
+	if res.StatusCode != http.StatusCreated || created.Secret == "" || !created.Active {
+		t.Fatalf("Expected active webhook with secret, received %d: %+v\n", res.StatusCode, created)
+	}
+
+	// when
+	doJSON(t, http.MethodDelete, srv.URL+"/v2/books/1", "", nil)
+	doJSON(t, http.MethodPost, srv.URL+"/v2/books", `{"id":"10","name":"New","author":"Author1"}`, nil)
+
+	// then
+	var d delivery
+	select {
+	case d = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No delivery received")
+	}
+
+	if !webhook.Verify(created.Secret, d.header, d.body, time.Minute) {
+		t.Fatalf("Signature %q does not match the payload\n", d.header.Get(webhook.SignatureHeader))
+	}
+
+	var payload webhook.Payload
+	if err := json.Unmarshal(d.body, &payload); err != nil || payload.Type != string(events.BookCreated) || payload.BookID != "10" {
+		t.Fatalf("Expected creation of book 10, received: %s\n", d.body)
+	}
+
+	var deliveries []*models.WebhookDelivery
+	deadline := time.Now().Add(5 * time.Second)
+	for len(deliveries) == 0 && time.Now().Before(deadline) {
+		doJSON(t, http.MethodGet, srv.URL+"/webhook/"+created.ID+"/deliveries", "", &deliveries)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(deliveries) != 1 || !deliveries[0].Succeeded || deliveries[0].EventID != payload.ID {
+		t.Fatalf("Delivery log should contain the successful attempt, has: %+v\n", deliveries)
+	}
+}
+
+func Test_Server_Webhooks_CRUD(t *testing.T) {
+	// setup
+	srv, _ := prepareWebhooksServer(t)
+
+	var created models.Webhook
+	doJSON(t, http.MethodPost, srv.URL+"/webhook", `{"url":"https://example.com/hook","active":false}`, &created)
+
+	t.Run("Hides secret", func(t *testing.T) {
+		// when
+		var listed []models.Webhook
+		doJSON(t, http.MethodGet, srv.URL+"/webhook", "", &listed)
+
+		// then
+		if len(listed) != 1 || listed[0].ID != created.ID || listed[0].Secret != "" || listed[0].Active {
+			t.Fatalf("Expected inactive webhook without secret, received: %+v\n", listed)
+		}
+	})
+
+	t.Run("Replaces webhook", func(t *testing.T) {
+		// when
+		var updated models.Webhook
+		res := doJSON(t, http.MethodPut, srv.URL+"/webhook/"+created.ID, `{"url":"https://example.com/new","events":["book.deleted"]}`, &updated)
+
+		// then
+		if res.StatusCode != http.StatusOK || updated.URL != "https://example.com/new" || !updated.Active || updated.Secret != "" {
+			t.Fatalf("Expected replaced active webhook, received %d: %+v\n", res.StatusCode, updated)
+		}
+	})
+
+	t.Run("Rejects invalid webhook", func(t *testing.T) {
+		// when
+		var p problem
+		res := doJSON(t, http.MethodPost, srv.URL+"/webhook", `{"url":"ftp://example.com","events":["book.read"]}`, &p)
+
+		// then
+		if res.StatusCode != http.StatusBadRequest || len(p.Errors) != 2 {
+			t.Fatalf("Expected two invalid fields, received %d: %+v\n", res.StatusCode, p)
+		}
+	})
+
+	t.Run("Deletes webhook", func(t *testing.T) {
+		// when
+		res := doJSON(t, http.MethodDelete, srv.URL+"/webhook/"+created.ID, "", nil)
+		missing := doJSON(t, http.MethodGet, srv.URL+"/webhook/"+created.ID, "", nil)
+
+		// then
+		if res.StatusCode != http.StatusNoContent || missing.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected deletion, received %d and then %d\n", res.StatusCode, missing.StatusCode)
+		}
+	})
+}
+
+func Test_Server_Webhooks_SendTestEvent(t *testing.T) {
+	// setup
+	srv, _ := prepareWebhooksServer(t)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer receiver.Close()
+
+	var created models.Webhook
+	doJSON(t, http.MethodPost, srv.URL+"/webhook", `{"url":"`+receiver.URL+`"}`, &created)
+
+	// when
+	var d models.WebhookDelivery
+	res := doJSON(t, http.MethodPost, srv.URL+"/webhook/"+created.ID+"/test", "", &d)
+
+	// then
+	if res.StatusCode != http.StatusOK || d.EventType != webhook.TestEventType || d.StatusCode != http.StatusGone || d.Succeeded {
+		t.Fatalf("Expected failed test delivery, received %d: %+v\n", res.StatusCode, d)
+	}
+
+	var deadLetters []*models.WebhookDelivery
+	doJSON(t, http.MethodGet, srv.URL+"/webhook/dead-letters", "", &deadLetters)
+	if len(deadLetters) != 0 {
+		t.Fatalf("Test deliveries should not become dead letters: %+v\n", deadLetters)
+	}
+}
+
+// utils
+
+func prepareWebhooksServer(t *testing.T) (*httptest.Server, *webhookRepoStub) {
+	bus := events.NewBus(10, 10, 0)
+	repo := &webhookRepoStub{webhooks: map[string]*models.Webhook{}}
+	dispatcher := webhook.NewDispatcher(repo, nil, webhook.Config{MaxAttempts: 2, Backoff: time.Millisecond, Timeout: time.Second, QueueSize: 10, Workers: 1})
+	t.Cleanup(dispatcher.Close)
+	bus.OnPublish(dispatcher.Handle)
+
+	ts := &Server{
+		dbRepo:            publish.New(prepareDbRepo(3), bus),
+		events:            bus,
+		webhooks:          repo,
+		webhookDispatcher: dispatcher,
+	}
+
+	srv := httptest.NewServer(ts.routes())
+	t.Cleanup(srv.Close)
+	return srv, repo
+}
+
+// doJSON sends body and decodes the response into out when it is not nil.
+func doJSON(t *testing.T, method, url, body string, out any) *http.Response {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Encountered error while calling %s %s: %s\n", method, url, err)
+	}
+	defer res.Body.Close()
+
+	if out != nil {
+		_ = json.NewDecoder(res.Body).Decode(out)
+	}
+	return res
+}
+
+type webhookRepoStub struct {
+	mu         sync.Mutex
+	webhooks   map[string]*models.Webhook
+	deliveries []*models.WebhookDelivery
+}
+
+func (r *webhookRepoStub) GetWebhooks(tenant string) ([]*models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhooks := []*models.Webhook{}
+	for _, w := range r.webhooks {
+		if w.Tenant == tenant {
+			copied := *w
+			webhooks = append(webhooks, &copied)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+func (r *webhookRepoStub) GetWebhook(tenant, id string) (*models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.webhooks[id]
+	if !ok || w.Tenant != tenant {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, id)
+	}
+	copied := *w
+	return &copied, nil
+}
+
+func (r *webhookRepoStub) AddWebhook(w *models.Webhook) (*models.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	created := *w
+	created.ID = fmt.Sprint(len(r.webhooks) + 1)
+	created.CreatedAt = time.Now().UTC()
+	r.webhooks[created.ID] = &created
+
+	copied := created
+	return &copied, nil
+}
+
+func (r *webhookRepoStub) UpdateWebhook(w *models.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.webhooks[w.ID]
+	if !ok || existing.Tenant != w.Tenant {
+		return fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, w.ID)
+	}
+	existing.URL, existing.Events, existing.Active = w.URL, w.Events, w.Active
+	return nil
+}
+
+func (r *webhookRepoStub) DeleteWebhook(tenant, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if w, ok := r.webhooks[id]; !ok || w.Tenant != tenant {
+		return fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, id)
+	}
+	delete(r.webhooks, id)
+	return nil
+}
+
+func (r *webhookRepoStub) AddWebhookDelivery(d *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	created := *d
+	created.ID = fmt.Sprint(len(r.deliveries) + 1)
+	r.deliveries = append(r.deliveries, &created)
+	return &created, nil
+}
+
+func (r *webhookRepoStub) GetWebhookDeliveries(webhookID string, limit int) ([]*models.WebhookDelivery, error) {
+	return r.findDeliveries(func(d *models.WebhookDelivery) bool { return d.WebhookID == webhookID }, limit), nil
+}
+
+func (r *webhookRepoStub) GetDeadLetters(tenant string, limit int) ([]*models.WebhookDelivery, error) {
+	return r.findDeliveries(func(d *models.WebhookDelivery) bool { return d.Tenant == tenant && d.DeadLetter }, limit), nil
+}
+
+func (r *webhookRepoStub) findDeliveries(match func(*models.WebhookDelivery) bool, limit int) []*models.WebhookDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deliveries := []*models.WebhookDelivery{}
+	for i := len(r.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if match(r.deliveries[i]) {
+			deliveries = append(deliveries, r.deliveries[i])
+		}
+	}
+	return deliveries
+}
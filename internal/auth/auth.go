@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/repository"
+	"net/http"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+var roleLevels = map[Role]int{
+	RoleReader: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleLevels[role]; !ok {
+		return "", fmt.Errorf("unknown role %q, available: [reader, editor, admin]", s)
+	}
+	return role, nil
+}
+
+// Allows reports whether a principal with role r may access a route
+// requiring role required. Roles are hierarchical, admins can do
+// everything editors can and editors everything readers can.
+func (r Role) Allows(required Role) bool {
+	return roleLevels[r] >= roleLevels[required]
+}
+
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal is the authenticated caller. Tenant is empty for credentials
+// not bound to any tenant.
+type Principal struct {
+	Subject string
+	Role    Role
+	Method  string
+	Tenant  string
+}
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// Authenticator resolves the principal of a request from an API key sent
+// in the X-API-Key header or a JWT bearer token. Either source is optional.
+type Authenticator struct {
+	keys repository.APIKeyRepo
+	jwks *JWKS
+}
+
+func NewAuthenticator(keys repository.APIKeyRepo, jwks *JWKS) *Authenticator {
+	return &Authenticator{
+		keys: keys,
+		jwks: jwks,
+	}
+}
+
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	return a.AuthenticateHeader(r.Header)
+}
+
+// AuthenticateHeader resolves the principal from request headers, it lets
+// transports other than HTTP, e.g. gRPC metadata, share the same credentials.
+func (a *Authenticator) AuthenticateHeader(h http.Header) (*Principal, error) {
+	if key := h.Get(APIKeyHeader); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+
+	if header := h.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return a.authenticateJWT(token)
+	}
+
+	return nil, ErrMissingCredentials
+}
+
+func (a *Authenticator) authenticateAPIKey(key string) (*Principal, error) {
+	if a.keys == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	stored, err := a.keys.GetAPIKeyByHash(HashAPIKey(key))
+	if err != nil || stored == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	role, err := ParseRole(stored.Role)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{Subject: "api_key:" + stored.ID, Role: role, Method: MethodAPIKey, Tenant: stored.Tenant}, nil
+}
+
+func (a *Authenticator) authenticateJWT(token string) (*Principal, error) {
+	if a.jwks == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims, err := a.jwks.Verify(token)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	role, err := ParseRole(claims.Role)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{Subject: "jwt:" + claims.Subject, Role: role, Method: MethodJWT, Tenant: claims.Tenant}, nil
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Role_Allows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		allowed  bool
+	}{
+		{RoleReader, RoleReader, true},
+		{RoleReader, RoleEditor, false},
+		{RoleEditor, RoleReader, true},
+		{RoleEditor, RoleAdmin, false},
+		{RoleAdmin, RoleAdmin, true},
+		{Role("unknown"), RoleReader, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.allowed {
+			t.Errorf("%s.Allows(%s) = %v, should be %v\n", tt.role, tt.required, got, tt.allowed)
+		}
+	}
+}
+
+func Test_Authenticator_Authenticate_WithAPIKey(t *testing.T) {
+	// setup
+	key, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while generating key: %s\n", err)
+	}
+	keys := &apiKeyRepoStub{m: map[string]*models.APIKey{
+		HashAPIKey(key): {ID: "1", Name: "ops", Role: "editor"},
+	}}
+	ts := NewAuthenticator(keys, nil)
+
+	t.Run("Should authenticate stored key", func(t *testing.T) {
+		// given
+		req := httptest.NewRequest("GET", "/book", nil)
+		req.Header.Set(APIKeyHeader, key)
+
+		// when
+		principal, err := ts.Authenticate(req)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while authenticating:", err)
+		}
+
+		if principal.Role != RoleEditor || principal.Method != MethodAPIKey {
+			t.Fatalf("Unexpected principal: %+v\n", principal)
+		}
+	})
+
+	t.Run("Should reject unknown key", func(t *testing.T) {
+		// given
+		req := httptest.NewRequest("GET", "/book", nil)
+		req.Header.Set(APIKeyHeader, "bk_unknown")
+
+		// when
+		_, err := ts.Authenticate(req)
+
+		// then
+		if err != ErrInvalidCredentials {
+			t.Fatalf("Expected %v but received: %v\n", ErrInvalidCredentials, err)
+		}
+	})
+
+	t.Run("Should require credentials", func(t *testing.T) {
+		// given
+		req := httptest.NewRequest("GET", "/book", nil)
+
+		// when
+		_, err := ts.Authenticate(req)
+
+		// then
+		if err != ErrMissingCredentials {
+			t.Fatalf("Expected %v but received: %v\n", ErrMissingCredentials, err)
+		}
+	})
+}
+
+// utils
+
+type apiKeyRepoStub struct {
+	m map[string]*models.APIKey
+}
+
+func (r *apiKeyRepoStub) GetAPIKeyByHash(hash string) (*models.APIKey, error) {
+	k, ok := r.m[hash]
+	if !ok {
+		return nil, fmt.Errorf("api key not found")
+	}
+	return k, nil
+}
+
+func (r *apiKeyRepoStub) AddAPIKey(k *models.APIKey) (*models.APIKey, error) {
+	r.m[k.Hash] = k
+	return k, nil
+}
+
+func (r *apiKeyRepoStub) DeleteAPIKey(id string) error {
+	for hash, k := range r.m {
+		if k.ID == id {
+			delete(r.m, hash)
+		}
+	}
+	return nil
+}
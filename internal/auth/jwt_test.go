@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_JWKS_Verify(t *testing.T) {
+	// setup
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while generating rsa key: %s\n", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while generating ec key: %s\n", err)
+	}
+	ts := prepareJWKS(t, rsaKey, ecKey)
+
+	validClaims := Claims{Subject: "alice", Role: "editor", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+
+	t.Run("Should accept RS256 token", func(t *testing.T) {
+		// given
+		token := signRS256(t, rsaKey, "rsa-key", validClaims)
+
+		// when
+		claims, err := ts.Verify(token)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while verifying valid token:", err)
+		}
+
+		if claims.Subject != "alice" || claims.Role != "editor" {
+			t.Fatalf("Unexpected claims: %+v\n", claims)
+		}
+	})
+
+	t.Run("Should accept ES256 token", func(t *testing.T) {
+		// given
+		token := signES256(t, ecKey, "ec-key", validClaims)
+
+		// when
+		_, err := ts.Verify(token)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while verifying valid token:", err)
+		}
+	})
+
+	t.Run("Should reject expired token", func(t *testing.T) {
+		// given
+		expired := validClaims
+		expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		token := signRS256(t, rsaKey, "rsa-key", expired)
+
+		// when
+		_, err := ts.Verify(token)
+
+		// then
+		if err != ErrTokenExpired {
+			t.Fatalf("Expected %v but received: %v\n", ErrTokenExpired, err)
+		}
+	})
+
+	t.Run("Should reject tampered token", func(t *testing.T) {
+		// given
+		token := signRS256(t, rsaKey, "rsa-key", validClaims)
+		admin := validClaims
+		admin.Role = "admin"
+		forged := signRS256(t, rsaKey, "rsa-key", admin)
+		tampered := token[:strings.Index(token, ".")] + forged[strings.Index(forged, "."):strings.LastIndex(forged, ".")] + token[strings.LastIndex(token, "."):]
+
+		// when
+		_, err := ts.Verify(tampered)
+
+		// then
+		if err != ErrInvalidSignature {
+			t.Fatalf("Expected %v but received: %v\n", ErrInvalidSignature, err)
+		}
+	})
+
+	t.Run("Should reject token signed with unknown key", func(t *testing.T) {
+		// given
+		token := signRS256(t, rsaKey, "other-key", validClaims)
+
+		// when
+		_, err := ts.Verify(token)
+
+		// then
+		if err != ErrUnknownKey {
+			t.Fatalf("Expected %v but received: %v\n", ErrUnknownKey, err)
+		}
+	})
+}
+
+// utils
+
+func prepareJWKS(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) *JWKS {
+	enc := base64.RawURLEncoding.EncodeToString
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-key", "n": enc(rsaKey.N.Bytes()), "e": enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-key", "crv": "P-256", "x": enc(ecKey.X.FillBytes(make([]byte, 32))), "y": enc(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while encoding jwks: %s\n", err)
+	}
+
+	jwks, err := ParseJWKS(data)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while parsing jwks: %s\n", err)
+	}
+	return jwks
+}
+
+func signingInput(t *testing.T, alg, kid string, claims Claims) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while encoding claims: %s\n", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims Claims) string {
+	input := signingInput(t, "RS256", kid, claims)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while signing token: %s\n", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims Claims) string {
+	input := signingInput(t, "ES256", kid, claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while signing token: %s\n", err)
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return fmt.Sprintf("%s.%s", input, base64.RawURLEncoding.EncodeToString(signature))
+}
@@ -0,0 +1,147 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// keyPattern allows slash separated segments of letters, digits, dots,
+// dashes and underscores, segments cannot start with a dot, so keys never
+// leave the directory of a FileStore.
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+// Store keeps immutable blobs under keys. Writing a key again replaces the
+// blob, deleting a missing key is not an error.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the blob of the key, or ErrNotFound when it is missing.
+	// The caller closes Body of the returned object.
+	Get(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is a blob read from a Store.
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+}
+
+// ValidKey reports whether key can be stored in every Store.
+func ValidKey(key string) bool {
+	return len(key) <= 1024 && keyPattern.MatchString(key)
+}
+
+// FileStore keeps blobs as files under a directory, using keys as their
+// paths. Blobs are written to temporary files first and renamed, so readers
+// never see partially written blobs. Content types are kept next to blobs
+// in files with ".type" suffix, which keys cannot have.
+type FileStore struct {
+	dir string
+}
+
+const fileStoreTypeSuffix = ".type"
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err = writeFile(path+fileStoreTypeSuffix, []byte(contentType)); err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (*Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w (key=%s)", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	contentType, err := os.ReadFile(path + fileStoreTypeSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &Object{Body: f, ContentType: string(contentType), Size: info.Size()}, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{path, path + fileStoreTypeSuffix} {
+		if err = os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) path(key string) (string, error) {
+	if !ValidKey(key) || strings.HasSuffix(key, fileStoreTypeSuffix) {
+		return "", fmt.Errorf("%w (key=%s)", ErrInvalidKey, key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// writeFile replaces the file at path with data through a temporary file
+// in the same directory.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_FileStore_ShouldReplaceAndDeleteBlobs(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing store: %s\n", err)
+	}
+	ctx := context.Background()
+
+	// given
+	if err = store.Put(ctx, "acme/1/cover.jpg", []byte("old"), "image/jpeg"); err != nil {
+		t.Fatalf("Expected no error, has: %v\n", err)
+	}
+
+	// when
+	err = store.Put(ctx, "acme/1/cover.jpg", []byte("new"), "image/png")
+
+	// then
+	if err != nil {
+		t.Fatalf("Expected no error, has: %v\n", err)
+	}
+	obj, err := store.Get(ctx, "acme/1/cover.jpg")
+	if err != nil {
+		t.Fatalf("Expected no error, has: %v\n", err)
+	}
+	data, _ := io.ReadAll(obj.Body)
+	_ = obj.Body.Close()
+	if string(data) != "new" || obj.ContentType != "image/png" || obj.Size != 3 {
+		t.Fatalf("Expected replaced image/png blob, has: %s %q of %d bytes\n", obj.ContentType, data, obj.Size)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "acme", "1"))
+	if len(entries) != 2 {
+		t.Fatalf("Expected blob and its content type without temporary files, has: %d files\n", len(entries))
+	}
+
+	// when
+	err = store.Delete(ctx, "acme/1/cover.jpg")
+
+	// then
+	if err != nil {
+		t.Fatalf("Expected no error, has: %v\n", err)
+	}
+	if _, err = store.Get(ctx, "acme/1/cover.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected %v, has: %v\n", ErrNotFound, err)
+	}
+	if err = store.Delete(ctx, "acme/1/cover.jpg"); err != nil {
+		t.Fatalf("Expected deleting missing blob to succeed, has: %v\n", err)
+	}
+}
+
+func Test_FileStore_ShouldRejectKeysLeavingItsDirectory(t *testing.T) {
+	// setup
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing store: %s\n", err)
+	}
+
+	for _, key := range []string{"", "../cover.jpg", "acme/../../cover.jpg", "/etc/passwd", "acme//cover.jpg", "acme/.hidden", "acme/cover.jpg.type"} {
+		// when
+		err := store.Put(context.Background(), key, []byte("image"), "image/jpeg")
+
+		// then
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("Expected %v for key %q, has: %v\n", ErrInvalidKey, key, err)
+		}
+	}
+}
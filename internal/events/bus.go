@@ -0,0 +1,230 @@
+package events
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	BookCreated  Type = "book.created"
+	BookUpdated  Type = "book.updated"
+	BookDeleted  Type = "book.deleted"
+	BooksCleared Type = "books.cleared"
+)
+
+var (
+	ErrSlowConsumer       = errors.New("subscriber did not keep up with events")
+	ErrHistoryExpired     = errors.New("requested events are no longer available")
+	ErrTooManySubscribers = errors.New("too many subscribers")
+)
+
+// Event describes a change of the catalog of Tenant. Book holds the book
+// after the change, or before it when it was deleted.
+type Event struct {
+	ID     uint64       `json:"id"`
+	Type   Type         `json:"type"`
+	Tenant string       `json:"-"`
+	BookID string       `json:"book_id,omitempty"`
+	Book   *models.Book `json:"book,omitempty"`
+	Time   time.Time    `json:"time"`
+}
+
+// Filter selects events of one tenant. Empty BookIDs and Author match
+// every book, BooksCleared events match every filter of their tenant.
+type Filter struct {
+	Tenant  string
+	BookIDs []string
+	Author  string
+}
+
+func (f Filter) Match(e Event) bool {
+	if e.Tenant != f.Tenant {
+		return false
+	}
+	if e.Type == BooksCleared {
+		return true
+	}
+
+	if len(f.BookIDs) > 0 {
+		found := false
+		for _, id := range f.BookIDs {
+			found = found || id == e.BookID
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Author != "" && (e.Book == nil || !strings.EqualFold(e.Book.Author, f.Author)) {
+		return false
+	}
+	return true
+}
+
+// Bus fans published events out to subscribers and keeps the latest ones,
+// so reconnecting subscribers can resume where they stopped.
+//
+// Publishing never blocks, a subscriber whose buffer is full is dropped
+// with ErrSlowConsumer and has to resubscribe from its last event.
+type Bus struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	next    int
+	subs    map[*Subscription]struct{}
+	hooks   []func(Event)
+
+	historySize    int
+	bufferSize     int
+	maxSubscribers int
+}
+
+// NewBus creates a Bus remembering historySize events and buffering
+// bufferSize events of every subscriber. maxSubscribers of 0 means no
+// limit.
+func NewBus(historySize, bufferSize, maxSubscribers int) *Bus {
+	return &Bus{
+		// IDs continue from the start time, so IDs issued before a restart
+		// are older than the history and are reported as expired instead of
+		// being confused with new events
+		lastID:         uint64(time.Now().UnixNano()),
+		history:        make([]Event, 0, historySize),
+		subs:           make(map[*Subscription]struct{}),
+		historySize:    historySize,
+		bufferSize:     bufferSize,
+		maxSubscribers: maxSubscribers,
+	}
+}
+
+// OnPublish registers fn to be called with every published event. It is
+// called while publishing, so it must not block.
+func (b *Bus) OnPublish(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hooks = append(b.hooks, fn)
+}
+
+// Publish assigns the event its ID and time and delivers it to matching
+// subscribers and hooks.
+func (b *Bus) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	if b.historySize > 0 {
+		if len(b.history) < b.historySize {
+			b.history = append(b.history, e)
+		} else {
+			b.history[b.next] = e
+			b.next = (b.next + 1) % b.historySize
+		}
+	}
+
+	for sub := range b.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+
+		select {
+		case sub.ch <- e:
+		default:
+			b.drop(sub, ErrSlowConsumer)
+		}
+	}
+
+	for _, fn := range b.hooks {
+		fn(e)
+	}
+
+	return e
+}
+
+// Subscribe starts delivering events matching filter. When lastID is not
+// zero, remembered events published after it are delivered first, or
+// ErrHistoryExpired is returned when some of them were already forgotten.
+func (b *Bus) Subscribe(filter Filter, lastID uint64) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxSubscribers > 0 && len(b.subs) >= b.maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	var replay []Event
+	if lastID != 0 && lastID != b.lastID {
+		ordered := b.ordered()
+		if lastID > b.lastID || len(ordered) == 0 || lastID < ordered[0].ID-1 {
+			return nil, ErrHistoryExpired
+		}
+
+		for _, e := range ordered {
+			if e.ID > lastID && filter.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	sub := &Subscription{
+		bus:    b,
+		filter: filter,
+		ch:     make(chan Event, b.bufferSize+len(replay)),
+	}
+	for _, e := range replay {
+		sub.ch <- e
+	}
+
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// ordered returns remembered events from the oldest one.
+func (b *Bus) ordered() []Event {
+	return append(append([]Event{}, b.history[b.next:]...), b.history[:b.next]...)
+}
+
+func (b *Bus) drop(sub *Subscription, err error) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+
+	delete(b.subs, sub)
+	sub.err = err
+	close(sub.ch)
+}
+
+type Subscription struct {
+	bus    *Bus
+	filter Filter
+	ch     chan Event
+	err    error
+}
+
+// Events returns the channel of delivered events, it is closed when the
+// subscription ends.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Err returns ErrSlowConsumer after the subscriber was dropped, nil
+// otherwise.
+func (s *Subscription) Err() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s, nil)
+}
@@ -0,0 +1,177 @@
+package events
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"testing"
+)
+
+func Test_Bus_ShouldDeliverMatchingEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []string
+	}{
+		{"All books of tenant", Filter{Tenant: "acme"}, []string{"1", "2", ""}},
+		{"By book ID", Filter{Tenant: "acme", BookIDs: []string{"2"}}, []string{"2", ""}},
+		{"By author ignoring case", Filter{Tenant: "acme", Author: "AUTHOR1"}, []string{"1", ""}},
+		{"Other tenant", Filter{Tenant: "other"}, nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			bus := NewBus(10, 10, 0)
+			sub, err := bus.Subscribe(tt.filter, 0)
+			if err != nil {
+				t.Fatal("Encountered error while subscribing:", err)
+			}
+			defer sub.Close()
+
+			// when
+			bus.Publish(Event{Type: BookCreated, Tenant: "acme", BookID: "1", Book: &models.Book{ID: "1", Author: "Author1"}})
+			bus.Publish(Event{Type: BookUpdated, Tenant: "acme", BookID: "2", Book: &models.Book{ID: "2", Author: "Author2"}})
+			bus.Publish(Event{Type: BooksCleared, Tenant: "acme"})
+
+			// then
+			if received := drain(sub); !equalIDs(received, tt.expected) {
+				t.Fatalf("Received events of books %v, should be %v\n", received, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_Bus_Subscribe_ShouldResumeAfterLastEvent(t *testing.T) {
+	// setup
+	bus := NewBus(2, 10, 0)
+	first := bus.Publish(Event{Tenant: "acme", BookID: "1"})
+	second := bus.Publish(Event{Tenant: "acme", BookID: "2"})
+	bus.Publish(Event{Tenant: "acme", BookID: "3"})
+
+	t.Run("Replays missed events", func(t *testing.T) {
+		// when
+		sub, err := bus.Subscribe(Filter{Tenant: "acme"}, second.ID)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while subscribing:", err)
+		}
+		defer sub.Close()
+
+		if received := drain(sub); !equalIDs(received, []string{"3"}) {
+			t.Fatalf("Received events of books %v, should be only the third\n", received)
+		}
+	})
+
+	t.Run("Rejects forgotten events", func(t *testing.T) {
+		// when
+		_, err := bus.Subscribe(Filter{Tenant: "acme"}, first.ID-1)
+
+		// then
+		if !errors.Is(err, ErrHistoryExpired) {
+			t.Fatalf("Expected %v, has: %v\n", ErrHistoryExpired, err)
+		}
+	})
+
+	t.Run("Rejects events of previous run", func(t *testing.T) {
+		// when
+		_, err := NewBus(2, 10, 0).Subscribe(Filter{Tenant: "acme"}, second.ID)
+
+		// then
+		if !errors.Is(err, ErrHistoryExpired) {
+			t.Fatalf("Expected %v, has: %v\n", ErrHistoryExpired, err)
+		}
+	})
+}
+
+func Test_Bus_ShouldDropSlowConsumer(t *testing.T) {
+	// setup
+	bus := NewBus(10, 2, 0)
+	slow, _ := bus.Subscribe(Filter{Tenant: "acme"}, 0)
+	fast, _ := bus.Subscribe(Filter{Tenant: "acme"}, 0)
+	defer fast.Close()
+
+	// when
+	for i := 0; i < 3; i++ {
+		bus.Publish(Event{Tenant: "acme"})
+		<-fast.Events()
+	}
+
+	// then
+	if received := drain(slow); len(received) != 2 {
+		t.Fatalf("Slow consumer should get buffered events only, got %d\n", len(received))
+	}
+
+	if !errors.Is(slow.Err(), ErrSlowConsumer) {
+		t.Fatalf("Expected %v, has: %v\n", ErrSlowConsumer, slow.Err())
+	}
+
+	if fast.Err() != nil {
+		t.Fatal("Fast consumer should not be dropped:", fast.Err())
+	}
+}
+
+func Test_Bus_ShouldLimitSubscribers(t *testing.T) {
+	// setup
+	bus := NewBus(10, 10, 1)
+	sub, _ := bus.Subscribe(Filter{}, 0)
+
+	// when
+	_, err := bus.Subscribe(Filter{}, 0)
+
+	// then
+	if !errors.Is(err, ErrTooManySubscribers) {
+		t.Fatalf("Expected %v, has: %v\n", ErrTooManySubscribers, err)
+	}
+
+	sub.Close()
+	if _, err = bus.Subscribe(Filter{}, 0); err != nil {
+		t.Fatal("Closed subscription should free its place:", err)
+	}
+}
+
+func Test_Bus_OnPublish_ShouldCallHooksWithAssignedID(t *testing.T) {
+	// setup
+	bus := NewBus(0, 0, 0)
+	var received []Event
+	bus.OnPublish(func(e Event) { received = append(received, e) })
+
+	// when
+	published := bus.Publish(Event{Type: BookDeleted, Tenant: "acme", BookID: "1"})
+
+	// then
+	if len(received) != 1 || received[0].ID != published.ID || received[0].Time.IsZero() {
+		t.Fatalf("Hook should receive the published event %+v, received: %+v\n", published, received)
+	}
+}
+
+// utils
+
+// drain returns book IDs of buffered events and closes the subscription.
+func drain(sub *Subscription) []string {
+	var ids []string
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return ids
+			}
+			ids = append(ids, e.BookID)
+		default:
+			sub.Close()
+		}
+	}
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
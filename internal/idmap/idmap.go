@@ -0,0 +1,158 @@
+package idmap
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// Store keeps track of which destination ID was assigned to a record
+// that originally lived under a source ID in another backend.
+type Store interface {
+	Get(sourceID string) (string, bool)
+	Put(sourceID, destinationID string) error
+	Delete(sourceID string) error
+	Clear() error
+	Len() int
+	Range(fn func(sourceID, destinationID string) bool)
+}
+
+// FileStore is an append-only CSV log of "source,destination" pairs.
+// Deletions are written as records with an empty destination so the
+// file can be replayed on startup to rebuild the mapping.
+type FileStore struct {
+	mu      sync.RWMutex
+	file    *os.File
+	writer  *csv.Writer
+	mapping map[string]string
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, err := readMapping(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	store := &FileStore{
+		file:    file,
+		writer:  csv.NewWriter(file),
+		mapping: mapping,
+	}
+	return store, nil
+}
+
+func readMapping(r io.Reader) (map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+
+	mapping := make(map[string]string)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if record[1] == "" {
+			delete(mapping, record[0])
+			continue
+		}
+		mapping[record[0]] = record[1]
+	}
+	return mapping, nil
+}
+
+func (s *FileStore) Get(sourceID string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.mapping[sourceID]
+	return id, ok
+}
+
+func (s *FileStore) Put(sourceID, destinationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(sourceID, destinationID); err != nil {
+		return err
+	}
+	s.mapping[sourceID] = destinationID
+	return nil
+}
+
+func (s *FileStore) Delete(sourceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.mapping[sourceID]; !ok {
+		return nil
+	}
+
+	if err := s.append(sourceID, ""); err != nil {
+		return err
+	}
+	delete(s.mapping, sourceID)
+	return nil
+}
+
+func (s *FileStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	s.mapping = make(map[string]string)
+	return nil
+}
+
+func (s *FileStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.mapping)
+}
+
+func (s *FileStore) Range(fn func(sourceID, destinationID string) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for sourceID, destinationID := range s.mapping {
+		if !fn(sourceID, destinationID) {
+			return
+		}
+	}
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// append writes a single record and syncs it to disk, so an interrupted
+// migration never forgets a book that was already copied.
+func (s *FileStore) append(sourceID, destinationID string) error {
+	if err := s.writer.Write([]string{sourceID, destinationID}); err != nil {
+		return err
+	}
+
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
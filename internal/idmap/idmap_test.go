@@ -0,0 +1,48 @@
+package idmap
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_FileStore_ShouldPersistMappingBetweenReopens(t *testing.T) {
+	// setup
+	path := filepath.Join(t.TempDir(), "mapping.csv")
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while opening mapping file: %s\n", err)
+	}
+
+	// given
+	if err = store.Put("64f0c0ffee0000000000000a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Put("64f0c0ffee0000000000000b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete("64f0c0ffee0000000000000a"); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	// when
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal("Encountered error while reopening mapping file:", err)
+	}
+	defer reopened.Close()
+
+	// then
+	if reopened.Len() != 1 {
+		t.Fatalf("Reopened mapping has %d entries, should be 1\n", reopened.Len())
+	}
+
+	if _, ok := reopened.Get("64f0c0ffee0000000000000a"); ok {
+		t.Error("Deleted mapping should not be restored")
+	}
+
+	if id, ok := reopened.Get("64f0c0ffee0000000000000b"); !ok || id != "2" {
+		t.Errorf("Mapping was not restored correctly, has: %q, should be: %q\n", id, "2")
+	}
+}
@@ -0,0 +1,125 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// Content types of supported images, WebP images are decoded only.
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	WebP = "image/webp"
+)
+
+const jpegQuality = 85
+
+var (
+	ErrUnsupportedType = errors.New("image must be JPEG, PNG or WebP")
+	ErrInvalidImage    = errors.New("image cannot be decoded")
+	ErrTooManyPixels   = errors.New("image has too many pixels")
+)
+
+// Info describes an image without decoding its pixels.
+type Info struct {
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Inspect detects the type of the image from its content and reads its
+// dimensions. Images of other types than JPEG, PNG and WebP and images with
+// more than maxPixels pixels are rejected before they are decoded, maxPixels
+// of 0 disables the limit.
+func Inspect(data []byte, maxPixels int) (*Info, error) {
+	contentType := http.DetectContentType(data)
+	if contentType != JPEG && contentType != PNG && contentType != WebP {
+		return nil, fmt.Errorf("%w (type=%s)", ErrUnsupportedType, contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if maxPixels > 0 && int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		return nil, fmt.Errorf("%w (%dx%d)", ErrTooManyPixels, config.Width, config.Height)
+	}
+
+	return &Info{ContentType: contentType, Width: config.Width, Height: config.Height}, nil
+}
+
+func Decode(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	return img, nil
+}
+
+// ThumbnailType is the content type of thumbnails of images of the type,
+// thumbnails of PNG images stay PNG to keep their transparency, others are
+// JPEG.
+func ThumbnailType(contentType string) string {
+	if contentType == PNG {
+		return PNG
+	}
+	return JPEG
+}
+
+// Thumbnail scales img down to fit in a square of maxSide pixels, keeping
+// its aspect ratio. Images fitting already keep their size.
+func Thumbnail(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			width, height = maxSide, scaleSide(height, maxSide, width)
+		} else {
+			width, height = scaleSide(width, maxSide, height), maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img as contentType, JPEG or PNG. Transparent pixels of JPEG
+// images become white.
+func Encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch contentType {
+	case JPEG:
+		opaque := image.NewRGBA(img.Bounds())
+		draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(opaque, opaque.Bounds(), img, img.Bounds().Min, draw.Over)
+		if err := jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+	case PNG:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w (type=%s)", ErrUnsupportedType, contentType)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleSide scales side by to/from, rounding to the nearest pixel but never
+// below one.
+func scaleSide(side, to, from int) int {
+	scaled := (side*to + from/2) / from
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
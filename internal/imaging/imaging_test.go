@@ -0,0 +1,111 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+// webpPixel is a lossless WebP image of a single pixel.
+var webpPixel = []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+
+func Test_Inspect(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		maxPixels int
+		expected  *Info
+		err       error
+	}{
+		{name: "Should read PNG dimensions", data: encodePNG(t, 40, 30), expected: &Info{ContentType: PNG, Width: 40, Height: 30}},
+		{name: "Should read WebP dimensions", data: webpPixel, expected: &Info{ContentType: WebP, Width: 1, Height: 1}},
+		{name: "Should reject GIF", data: encodeGIF(t), err: ErrUnsupportedType},
+		{name: "Should reject text", data: []byte("<svg></svg>"), err: ErrUnsupportedType},
+		{name: "Should reject truncated image", data: encodePNG(t, 40, 30)[:20], err: ErrInvalidImage},
+		{name: "Should reject too many pixels", data: encodePNG(t, 40, 30), maxPixels: 1000, err: ErrTooManyPixels},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			info, err := Inspect(tt.data, tt.maxPixels)
+
+			// then
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected %v, has: %v\n", tt.err, err)
+			}
+			if tt.expected != nil && *info != *tt.expected {
+				t.Fatalf("Expected %+v, has: %+v\n", tt.expected, info)
+			}
+		})
+	}
+}
+
+func Test_Thumbnail_ShouldFitLongerSideKeepingAspectRatio(t *testing.T) {
+	tests := []struct {
+		width, height, maxSide        int
+		expectedWidth, expectedHeight int
+	}{
+		{width: 600, height: 900, maxSide: 300, expectedWidth: 200, expectedHeight: 300},
+		{width: 900, height: 600, maxSide: 300, expectedWidth: 300, expectedHeight: 200},
+		{width: 1000, height: 1, maxSide: 100, expectedWidth: 100, expectedHeight: 1},
+		{width: 120, height: 80, maxSide: 300, expectedWidth: 120, expectedHeight: 80},
+	}
+
+	for _, tt := range tests {
+		// given
+		img := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+
+		// when
+		thumbnail := Thumbnail(img, tt.maxSide)
+
+		// then
+		if size := thumbnail.Bounds().Size(); size.X != tt.expectedWidth || size.Y != tt.expectedHeight {
+			t.Fatalf("Expected %dx%d, has: %dx%d\n", tt.expectedWidth, tt.expectedHeight, size.X, size.Y)
+		}
+	}
+}
+
+func Test_Encode_ShouldMakeTransparentPixelsOfJPEGWhite(t *testing.T) {
+	// given
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	// when
+	data, err := Encode(img, JPEG)
+
+	// then
+	if err != nil {
+		t.Fatalf("Expected no error, has: %v\n", err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Expected no error, has: %v\n", err)
+	}
+	if r, g, b, _ := decoded.At(4, 4).RGBA(); r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Fatalf("Expected white pixel, has: %v\n", decoded.At(4, 4))
+	}
+}
+
+// utils
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("[SETUP] Encountered error while encoding image: %s\n", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T) []byte {
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("[SETUP] Encountered error while encoding image: %s\n", err)
+	}
+	return buf.Bytes()
+}
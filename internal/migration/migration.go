@@ -0,0 +1,161 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/idmap"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"hash"
+	"sort"
+)
+
+const DefaultBatchSize = 100
+
+// Migrator copies books from one repository into another. Every copied
+// book is recorded in the ID mapping right after it is inserted, which
+// lets an interrupted run be restarted without creating duplicates.
+type Migrator struct {
+	source      repository.BookRepo
+	destination repository.BookRepo
+	mapping     idmap.Store
+	batchSize   int
+}
+
+type Report struct {
+	Copied  int
+	Skipped int
+}
+
+type Verification struct {
+	SourceCount         int
+	DestinationCount    int
+	MappedCount         int
+	Missing             []string
+	Mismatched          []string
+	SourceChecksum      string
+	DestinationChecksum string
+}
+
+func (v *Verification) OK() bool {
+	return v.SourceCount == v.MappedCount &&
+		len(v.Missing) == 0 &&
+		len(v.Mismatched) == 0 &&
+		v.SourceChecksum == v.DestinationChecksum
+}
+
+func New(source, destination repository.BookRepo, mapping idmap.Store, batchSize int) *Migrator {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	return &Migrator{
+		source:      source,
+		destination: destination,
+		mapping:     mapping,
+		batchSize:   batchSize,
+	}
+}
+
+func (m *Migrator) Run() (*Report, error) {
+	report := &Report{}
+
+	err := m.forEachBatch(m.source, func(books []*models.Book) error {
+		for _, b := range books {
+			if _, ok := m.mapping.Get(b.ID); ok {
+				report.Skipped++
+				continue
+			}
+
+			created, err := m.destination.AddBook(&models.Book{Name: b.Name, Author: b.Author})
+			if err != nil {
+				return fmt.Errorf("copying book (id=%s): %w", b.ID, err)
+			}
+
+			if err = m.mapping.Put(b.ID, created.ID); err != nil {
+				return fmt.Errorf("recording mapping for book (id=%s): %w", b.ID, err)
+			}
+			report.Copied++
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// Verify compares the source with the destination through the recorded
+// mapping. Checksums are computed over the source ordering, so both sides
+// hash the same sequence of books when the copy is complete.
+func (m *Migrator) Verify() (*Verification, error) {
+	v := &Verification{MappedCount: m.mapping.Len()}
+
+	var sourceBooks []*models.Book
+	err := m.forEachBatch(m.source, func(books []*models.Book) error {
+		sourceBooks = append(sourceBooks, books...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sourceBooks, func(i, j int) bool { return sourceBooks[i].ID < sourceBooks[j].ID })
+	v.SourceCount = len(sourceBooks)
+
+	err = m.forEachBatch(m.destination, func(books []*models.Book) error {
+		v.DestinationCount += len(books)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sourceHash, destinationHash := sha256.New(), sha256.New()
+	for _, b := range sourceBooks {
+		writeChecksum(sourceHash, b)
+
+		destinationID, ok := m.mapping.Get(b.ID)
+		if !ok {
+			v.Missing = append(v.Missing, b.ID)
+			continue
+		}
+
+		copied, err := m.destination.GetBook(destinationID)
+		if err != nil || copied == nil {
+			v.Missing = append(v.Missing, b.ID)
+			continue
+		}
+
+		writeChecksum(destinationHash, copied)
+		if copied.Name != b.Name || copied.Author != b.Author {
+			v.Mismatched = append(v.Mismatched, b.ID)
+		}
+	}
+
+	v.SourceChecksum = hex.EncodeToString(sourceHash.Sum(nil))
+	v.DestinationChecksum = hex.EncodeToString(destinationHash.Sum(nil))
+	return v, nil
+}
+
+func (m *Migrator) forEachBatch(repo repository.BookRepo, fn func([]*models.Book) error) error {
+	for offset := 0; ; offset += m.batchSize {
+		books, err := repo.GetBooksBatch(offset, m.batchSize)
+		if err != nil {
+			return fmt.Errorf("reading batch at offset %d: %w", offset, err)
+		}
+
+		if err = fn(books); err != nil {
+			return err
+		}
+
+		if len(books) < m.batchSize {
+			return nil
+		}
+	}
+}
+
+func writeChecksum(h hash.Hash, b *models.Book) {
+	_, _ = fmt.Fprintf(h, "%s\x00%s\n", b.Name, b.Author)
+}
@@ -0,0 +1,191 @@
+package migration
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/idmap"
+	"github.com/auwendil/crud-app/internal/models"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func Test_Migrator_Run_ShouldCopyAllBooksAndVerify(t *testing.T) {
+	// setup
+	source := prepareRepo(7)
+	destination := newRepoStub()
+	mapping := prepareMapping(t)
+
+	// given
+	m := New(source, destination, mapping, 3)
+
+	// when
+	report, err := m.Run()
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while migrating books:", err)
+	}
+
+	if report.Copied != 7 || report.Skipped != 0 {
+		t.Fatalf("Unexpected migration report: %+v\n", report)
+	}
+
+	verification, err := m.Verify()
+	if err != nil {
+		t.Fatal("Encountered error while verifying migration:", err)
+	}
+
+	if !verification.OK() {
+		t.Fatalf("Migration should be verified but is not: %+v\n", verification)
+	}
+}
+
+func Test_Migrator_Run_ShouldResumeAfterInterruption(t *testing.T) {
+	// setup
+	source := prepareRepo(5)
+	destination := newRepoStub()
+	mapping := prepareMapping(t)
+
+	// given
+	destination.failAfter = 2
+	_, err := New(source, destination, mapping, 2).Run()
+	if err == nil {
+		t.Fatal("[SETUP] Interrupted migration should return error")
+	}
+	destination.failAfter = -1
+
+	// when
+	report, err := New(source, destination, mapping, 2).Run()
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while resuming migration:", err)
+	}
+
+	if report.Copied != 3 || report.Skipped != 2 {
+		t.Fatalf("Resumed migration should copy only remaining books, report: %+v\n", report)
+	}
+
+	if len(destination.books) != 5 {
+		t.Fatalf("Destination has %d books, should have 5\n", len(destination.books))
+	}
+}
+
+func Test_Migrator_Verify_ShouldDetectMismatchedBooks(t *testing.T) {
+	// setup
+	source := prepareRepo(3)
+	destination := newRepoStub()
+	mapping := prepareMapping(t)
+
+	m := New(source, destination, mapping, 10)
+	if _, err := m.Run(); err != nil {
+		t.Fatalf("[SETUP] Encountered error while migrating books: %s\n", err)
+	}
+
+	// given
+	destination.books[0].Name = "Changed"
+
+	// when
+	verification, err := m.Verify()
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while verifying migration:", err)
+	}
+
+	if verification.OK() {
+		t.Fatal("Verification should fail for modified destination")
+	}
+
+	if len(verification.Mismatched) != 1 {
+		t.Fatalf("Expected exactly one mismatched book, has: %v\n", verification.Mismatched)
+	}
+}
+
+// utils
+
+type repoStub struct {
+	books     []*models.Book
+	nextID    int
+	failAfter int
+}
+
+func newRepoStub() *repoStub {
+	return &repoStub{nextID: 1, failAfter: -1}
+}
+
+func prepareRepo(size int) *repoStub {
+	repo := newRepoStub()
+	for i := 0; i < size; i++ {
+		_, _ = repo.AddBook(&models.Book{Name: fmt.Sprintf("Book%d", i), Author: fmt.Sprintf("Author%d", i)})
+	}
+	return repo
+}
+
+func prepareMapping(t *testing.T) *idmap.FileStore {
+	mapping, err := idmap.OpenFileStore(filepath.Join(t.TempDir(), "mapping.csv"))
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while opening mapping file: %s\n", err)
+	}
+	t.Cleanup(func() { _ = mapping.Close() })
+	return mapping
+}
+
+func (r *repoStub) GetAllBooks() ([]*models.Book, error) {
+	return r.books, nil
+}
+
+func (r *repoStub) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	books := append([]*models.Book{}, r.books...)
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+
+	if offset >= len(books) {
+		return []*models.Book{}, nil
+	}
+	if offset+limit > len(books) {
+		limit = len(books) - offset
+	}
+	return books[offset : offset+limit], nil
+}
+
+func (r *repoStub) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) GetBook(id string) (*models.Book, error) {
+	for _, b := range r.books {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("book (id=%s) not found", id)
+}
+
+func (r *repoStub) AddBook(b *models.Book) (*models.Book, error) {
+	if r.failAfter == 0 {
+		return nil, fmt.Errorf("connection lost")
+	}
+	r.failAfter--
+
+	created := &models.Book{ID: fmt.Sprintf("%04d", r.nextID), Name: b.Name, Author: b.Author}
+	r.nextID++
+	r.books = append(r.books, created)
+	return created, nil
+}
+
+func (r *repoStub) UpdateBook(id string, updatedBook *models.Book) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) DeleteBook(id string) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) DeleteAllBooks() error {
+	r.books = nil
+	return nil
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type APIKey struct {
+	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"-" bson:"hash"`
+	Role      string    `json:"role"`
+	Tenant    string    `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
@@ -6,6 +6,7 @@ type Book struct {
 	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
 	Name      string    `json:"name"`
 	Author    string    `json:"author"`
+	TenantID  string    `json:"-" bson:"tenant_id,omitempty"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// Copy is a physical copy of the book BookID, identified in the library by
+// its Barcode.
+type Copy struct {
+	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant    string    `json:"-" bson:"tenant"`
+	BookID    string    `json:"book_id" bson:"book_id"`
+	Barcode   string    `json:"barcode" bson:"barcode"`
+	Condition string    `json:"condition" bson:"condition"`
+	Location  string    `json:"location" bson:"location"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Member borrows copies of books.
+type Member struct {
+	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant    string    `json:"-" bson:"tenant"`
+	Name      string    `json:"name" bson:"name"`
+	Email     string    `json:"email" bson:"email"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Loan is a checkout of the copy CopyID by the member MemberID, it is
+// active until ReturnedAt is set.
+type Loan struct {
+	ID           string     `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant       string     `json:"-" bson:"tenant"`
+	CopyID       string     `json:"copy_id" bson:"copy_id"`
+	BookID       string     `json:"book_id" bson:"book_id"`
+	MemberID     string     `json:"member_id" bson:"member_id"`
+	CheckedOutAt time.Time  `json:"checked_out_at" bson:"checked_out_at"`
+	DueAt        time.Time  `json:"due_at" bson:"due_at"`
+	ReturnedAt   *time.Time `json:"returned_at,omitempty" bson:"returned_at"`
+	Renewals     int        `json:"renewals" bson:"renewals"`
+}
+
+func (l *Loan) Active() bool {
+	return l.ReturnedAt == nil
+}
+
+// Availability counts copies of a book, Available ones are neither on
+// loan nor set aside for a hold. Holds counts members waiting for a copy.
+type Availability struct {
+	Copies    int `json:"copies"`
+	Available int `json:"available"`
+	Holds     int `json:"holds"`
+}
+
+// Statuses of holds, waiting and ready holds are active.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldCollected = "collected"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+// Hold queues MemberID for a copy of BookID. When a copy is free, the
+// oldest waiting hold becomes ready with the copy CopyID set aside until
+// ExpiresAt, the hold is collected by checking out any copy of the book.
+type Hold struct {
+	ID       string `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant   string `json:"-" bson:"tenant"`
+	BookID   string `json:"book_id" bson:"book_id"`
+	MemberID string `json:"member_id" bson:"member_id"`
+	Status   string `json:"status" bson:"status"`
+	// Position is 1 for the next waiting hold of the book, it is 0 when
+	// the hold is not waiting.
+	Position  int        `json:"position,omitempty" bson:"-"`
+	CopyID    string     `json:"copy_id,omitempty" bson:"copy_id,omitempty"`
+	PlacedAt  time.Time  `json:"placed_at" bson:"placed_at"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty" bson:"ready_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+}
+
+func (h *Hold) Active() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
+}
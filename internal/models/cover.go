@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Cover is the image of the book BookID. The original and its thumbnails
+// are kept in a blob store under keys containing Version, a hash of the
+// original, so a replaced cover never shares keys with the new one.
+type Cover struct {
+	Tenant      string            `json:"-" bson:"tenant"`
+	BookID      string            `json:"-" bson:"book_id"`
+	Version     string            `json:"version" bson:"version"`
+	ContentType string            `json:"content_type" bson:"content_type"`
+	Width       int               `json:"width" bson:"width"`
+	Height      int               `json:"height" bson:"height"`
+	Size        int64             `json:"size" bson:"size"`
+	Thumbnails  []*CoverThumbnail `json:"thumbnails" bson:"thumbnails"`
+	UpdatedAt   time.Time         `json:"updated_at" bson:"updated_at"`
+}
+
+// CoverThumbnail is a scaled down copy of a cover, Name is the size it was
+// generated for, e.g. "small".
+type CoverThumbnail struct {
+	Name        string `json:"name" bson:"name"`
+	ContentType string `json:"content_type" bson:"content_type"`
+	Width       int    `json:"width" bson:"width"`
+	Height      int    `json:"height" bson:"height"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// IdempotencyRecord remembers a request sent with an Idempotency-Key header
+// and, once it is processed, the response returned to the client.
+// StatusCode stays 0 while the original request is still in progress.
+// LeaseID identifies the request holding the key, only that request may
+// renew, complete or release it.
+type IdempotencyRecord struct {
+	Key          string    `json:"key" bson:"_id"`
+	Fingerprint  string    `json:"fingerprint" bson:"fingerprint"`
+	LeaseID      string    `json:"lease_id" bson:"lease_id"`
+	StatusCode   int       `json:"status_code" bson:"status_code"`
+	ContentType  string    `json:"content_type" bson:"content_type"`
+	ResponseBody []byte    `json:"response_body" bson:"response_body"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at" bson:"expires_at"`
+}
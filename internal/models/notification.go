@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Kinds of notifications about loans.
+const (
+	NotificationDueSoon = "due_soon"
+	NotificationOverdue = "overdue"
+)
+
+// Notification is a reminder about the loan LoanID sent to the member
+// through Channel. It is sent once per kind, channel and due date, so a
+// renewed loan is reminded of again.
+type Notification struct {
+	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant    string    `json:"-" bson:"tenant"`
+	Kind      string    `json:"kind" bson:"kind"`
+	Channel   string    `json:"channel" bson:"channel"`
+	LoanID    string    `json:"loan_id" bson:"loan_id"`
+	BookID    string    `json:"book_id" bson:"book_id"`
+	MemberID  string    `json:"member_id" bson:"member_id"`
+	Recipient string    `json:"recipient" bson:"recipient"`
+	DueAt     time.Time `json:"due_at" bson:"due_at"`
+	SentAt    time.Time `json:"sent_at" bson:"sent_at"`
+}
+
+// NotificationPreferences tell which notifications the member receives
+// and through which channels.
+type NotificationPreferences struct {
+	Tenant   string   `json:"-" bson:"tenant"`
+	MemberID string   `json:"member_id" bson:"member_id"`
+	Channels []string `json:"channels" bson:"channels"`
+	DueSoon  bool     `json:"due_soon" bson:"due_soon"`
+	Overdue  bool     `json:"overdue" bson:"overdue"`
+}
+
+// Wants reports whether notifications of kind are sent to the member.
+func (p *NotificationPreferences) Wants(kind string) bool {
+	switch kind {
+	case NotificationDueSoon:
+		return p.DueSoon
+	case NotificationOverdue:
+		return p.Overdue
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// OutboxMessage is a book event stored in the same transaction as the
+// change it describes, until the outbox relay delivers it.
+type OutboxMessage struct {
+	ID        string    `json:"id" bson:"_id,omitempty"`
+	Tenant    string    `json:"tenant" bson:"tenant"`
+	Type      string    `json:"type" bson:"type"`
+	BookID    string    `json:"book_id,omitempty" bson:"book_id,omitempty"`
+	Book      *Book     `json:"book,omitempty" bson:"book,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
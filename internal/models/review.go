@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Statuses of reviews, only approved reviews are shown to readers and
+// counted in ratings of books.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewHidden   = "hidden"
+)
+
+// Review is the feedback of the member MemberID on the book BookID, a
+// member reviews every book at most once. MemberID is empty when the
+// member was deleted after writing the review.
+type Review struct {
+	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant    string    `json:"-" bson:"tenant"`
+	BookID    string    `json:"book_id" bson:"book_id"`
+	MemberID  string    `json:"member_id" bson:"member_id"`
+	Rating    int       `json:"rating" bson:"rating"`
+	Text      string    `json:"text" bson:"text"`
+	Status    string    `json:"status" bson:"status"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Rating aggregates approved reviews of the book BookID. It is kept next
+// to the book and updated together with every review.
+type Rating struct {
+	BookID  string  `json:"-" bson:"book_id"`
+	Average float64 `json:"average" bson:"average"`
+	Count   int     `json:"count" bson:"count"`
+}
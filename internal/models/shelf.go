@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Visibilities of shelves, public shelves are readable by anyone knowing
+// their share token.
+const (
+	ShelfPrivate = "private"
+	ShelfPublic  = "public"
+)
+
+// Names of default shelves every member has, they cannot be renamed or
+// deleted.
+const (
+	ShelfToRead  = "to-read"
+	ShelfReading = "reading"
+	ShelfRead    = "read"
+)
+
+// Shelf is a reading list of the member MemberID, names are unique among
+// shelves of a member. Entries are ordered by their position, they are
+// omitted from lists of shelves.
+type Shelf struct {
+	ID         string        `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant     string        `json:"-" bson:"tenant"`
+	MemberID   string        `json:"member_id" bson:"member_id"`
+	Name       string        `json:"name" bson:"name"`
+	Default    bool          `json:"default" bson:"default"`
+	Visibility string        `json:"visibility" bson:"visibility"`
+	ShareToken string        `json:"share_token" bson:"share_token"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at" bson:"updated_at"`
+	Entries    []*ShelfEntry `json:"entries,omitempty" bson:"entries"`
+}
+
+// ShelfEntry is the book BookID on a shelf. Progress is the percentage of
+// the book the member has read.
+type ShelfEntry struct {
+	BookID string `json:"book_id" bson:"book_id"`
+	// Position is 1 for the first entry of the shelf.
+	Position  int       `json:"position" bson:"-"`
+	Note      string    `json:"note" bson:"note"`
+	Progress  int       `json:"progress" bson:"progress"`
+	AddedAt   time.Time `json:"added_at" bson:"added_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
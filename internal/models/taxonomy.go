@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Category is a node of the category tree of a tenant, root categories
+// have no ParentID. Names are unique among siblings.
+type Category struct {
+	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant    string    `json:"-" bson:"tenant"`
+	ParentID  string    `json:"parent_id,omitempty" bson:"parent_id"`
+	Name      string    `json:"name" bson:"name"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// BookTaxonomy holds free-form tags of the book BookID, IDs of categories
+// it belongs to and, when known, the language and the year it was
+// published in.
+type BookTaxonomy struct {
+	Tenant        string   `json:"-" bson:"tenant"`
+	BookID        string   `json:"book_id" bson:"book_id"`
+	Tags          []string `json:"tags" bson:"tags"`
+	Categories    []string `json:"categories" bson:"categories"`
+	Language      string   `json:"language,omitempty" bson:"language,omitempty"`
+	PublishedYear int      `json:"published_year,omitempty" bson:"published_year,omitempty"`
+}
+
+// TagCount tells how many books have the tag.
+type TagCount struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// FacetCount tells how many books have the value of a facet.
+type FacetCount struct {
+	Value string `json:"value" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// TaxonomyFacets counts books by their tags, languages and publication
+// decades, e.g. "1940s". Every facet lists the most frequent values first.
+type TaxonomyFacets struct {
+	Tags      []*FacetCount `json:"tags" bson:"tags"`
+	Languages []*FacetCount `json:"languages" bson:"languages"`
+	Decades   []*FacetCount `json:"decades" bson:"decades"`
+}
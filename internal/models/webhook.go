@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook subscribes URL to book events of Tenant, all of them when
+// Events is empty. Secret signs every delivered payload.
+type Webhook struct {
+	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenant    string    `json:"-" bson:"tenant"`
+	URL       string    `json:"url" bson:"url"`
+	Secret    string    `json:"secret,omitempty" bson:"secret"`
+	Events    []string  `json:"events" bson:"events"`
+	Active    bool      `json:"active" bson:"active"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// WebhookDelivery records one attempt to deliver an event. The last
+// failed attempt is marked as DeadLetter.
+type WebhookDelivery struct {
+	ID         string          `json:"id,omitempty" bson:"_id,omitempty"`
+	WebhookID  string          `json:"webhook_id" bson:"webhook_id"`
+	Tenant     string          `json:"-" bson:"tenant"`
+	EventID    string          `json:"event_id" bson:"event_id"`
+	EventType  string          `json:"event_type" bson:"event_type"`
+	Payload    json.RawMessage `json:"payload,omitempty" bson:"payload"`
+	Attempt    int             `json:"attempt" bson:"attempt"`
+	StatusCode int             `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty" bson:"error,omitempty"`
+	Succeeded  bool            `json:"succeeded" bson:"succeeded"`
+	DeadLetter bool            `json:"dead_letter" bson:"dead_letter"`
+	CreatedAt  time.Time       `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,216 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/webhook"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of channels, members choose among them in their preferences.
+const (
+	LogChannelName     = "log"
+	EmailChannelName   = "email"
+	WebhookChannelName = "webhook"
+)
+
+// Channel delivers notifications to members. Delivery is at least once: a
+// notification is sent again when the scheduler stops before logging it,
+// receivers may deduplicate by DeliveryID.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, n *models.Notification) error
+}
+
+// DeliveryID identifies the notification before it is logged, it is the
+// same for every attempt to send it.
+func DeliveryID(n *models.Notification) string {
+	return fmt.Sprintf("%s/%s/%s/%d", n.Tenant, n.LoanID, n.Kind, n.DueAt.Unix())
+}
+
+// LogChannel writes every notification as JSON to its logger.
+type LogChannel struct {
+	logger *log.Logger
+}
+
+func NewLogChannel(logger *log.Logger) *LogChannel {
+	return &LogChannel{logger: logger}
+}
+
+func (c *LogChannel) Name() string {
+	return LogChannelName
+}
+
+func (c *LogChannel) Send(ctx context.Context, n *models.Notification) error {
+	out, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Printf("notification: %s\n", out)
+	return nil
+}
+
+// WebhookChannel posts every notification as JSON to a URL, with event
+// type loan.due_soon or loan.overdue. With a secret the requests are
+// signed like webhook deliveries.
+type WebhookChannel struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookChannel creates a WebhookChannel sending requests with client,
+// http.DefaultClient when nil.
+func NewWebhookChannel(url, secret string, client *http.Client) *WebhookChannel {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &WebhookChannel{url: url, secret: secret, client: client}
+}
+
+func (c *WebhookChannel) Name() string {
+	return WebhookChannelName
+}
+
+func (c *WebhookChannel) Send(ctx context.Context, n *models.Notification) error {
+	out, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(out))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.EventHeader, "loan."+n.Kind)
+	req.Header.Set(webhook.EventIDHeader, DeliveryID(n))
+
+	if c.secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(c.secret, timestamp, out))
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("receiver responded with %s", res.Status)
+	}
+	return nil
+}
+
+// EmailChannel mails notifications to the recipient through an SMTP
+// server. The connection is upgraded with STARTTLS when the server offers
+// it, credentials are sent only over TLS or to localhost.
+type EmailChannel struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+// NewEmailChannel creates an EmailChannel sending mail from the from
+// address through the server at addr, authenticating when username is
+// not empty.
+func NewEmailChannel(addr, from, username, password string) *EmailChannel {
+	return &EmailChannel{addr: addr, from: from, username: username, password: password}
+}
+
+func (c *EmailChannel) Name() string {
+	return EmailChannelName
+}
+
+func (c *EmailChannel) Send(ctx context.Context, n *models.Notification) error {
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if c.username != "" {
+		if err = client.Auth(smtp.PlainAuth("", c.username, c.password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(c.from); err != nil {
+		return err
+	}
+	if err = client.Rcpt(n.Recipient); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(c.message(n)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (c *EmailChannel) message(n *models.Notification) []byte {
+	due := n.DueAt.Format("Monday, 2 January 2006")
+	subject := "Reminder: a borrowed book is due on " + due
+	text := fmt.Sprintf("The copy of book %s you borrowed is due on %s, please return or renew it.", n.BookID, due)
+	if n.Kind == models.NotificationOverdue {
+		subject = "Overdue: a borrowed book was due on " + due
+		text = fmt.Sprintf("The copy of book %s you borrowed was due on %s, please return it as soon as possible.", n.BookID, due)
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + c.from + "\r\n")
+	b.WriteString("To: " + n.Recipient + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(text + "\r\n")
+	return []byte(b.String())
+}
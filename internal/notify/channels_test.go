@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/webhook"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_EmailChannel_ShouldMailRecipient(t *testing.T) {
+	// setup
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Encountered error while listening:", err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go serveSMTP(listener, received)
+
+	ts := NewEmailChannel(listener.Addr().String(), "library@example.com", "", "")
+
+	// when
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = ts.Send(ctx, &models.Notification{
+		Tenant:    "acme",
+		Kind:      models.NotificationOverdue,
+		LoanID:    "9",
+		BookID:    "42",
+		Recipient: "member1@example.com",
+		DueAt:     time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+	})
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while sending:", err)
+	}
+
+	mail := <-received
+	if mail[0] != "<library@example.com>" || mail[1] != "<member1@example.com>" {
+		t.Fatalf("Unexpected envelope: %v\n", mail[:2])
+	}
+	if !strings.Contains(mail[2], "Subject: Overdue: a borrowed book was due on Thursday, 1 October 2026") || !strings.Contains(mail[2], "book 42") {
+		t.Fatalf("Unexpected message:\n%s\n", mail[2])
+	}
+}
+
+func Test_WebhookChannel_ShouldSignNotifications(t *testing.T) {
+	// setup
+	var verified bool
+	var event string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified = webhook.Verify("secret", r.Header, body, time.Minute)
+		event = r.Header.Get(webhook.EventHeader)
+	}))
+	defer receiver.Close()
+
+	ts := NewWebhookChannel(receiver.URL, "secret", nil)
+
+	// when
+	err := ts.Send(context.Background(), &models.Notification{Tenant: "acme", Kind: models.NotificationDueSoon, LoanID: "9"})
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while sending:", err)
+	}
+
+	if !verified || event != "loan.due_soon" {
+		t.Fatalf("Receiver could not verify the signature of %q event\n", event)
+	}
+}
+
+// utils
+
+// serveSMTP accepts one connection and answers like an SMTP server
+// without extensions, passing sender, recipient and data of the mail.
+func serveSMTP(listener net.Listener, received chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP test")
+
+	reader := bufio.NewReader(conn)
+	var from, to string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			from = strings.TrimPrefix(command, "MAIL FROM:")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			to = strings.TrimPrefix(command, "RCPT TO:")
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err = reader.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			received <- []string{from, to, data.String()}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// SchedulerJob names the leadership of replicas running the scheduler.
+const SchedulerJob = "loan-notifications"
+
+type Stats struct {
+	Sent   uint64 `json:"sent"`
+	Failed uint64 `json:"failed"`
+}
+
+// Scheduler reminds members of loans due within dueSoon and of overdue
+// loans, through the channels they prefer. Members without preferences get
+// both kinds through every channel.
+//
+// Only the replica leading SchedulerJob scans loans, the others keep
+// trying to take over. Leadership not renewed for three intervals may be
+// taken over.
+type Scheduler struct {
+	loans    repository.CirculationRepo
+	repo     repository.NotificationRepo
+	elector  repository.LeaderElector
+	channels map[string]Channel
+	holder   string
+	interval time.Duration
+	dueSoon  time.Duration
+	timeout  time.Duration
+
+	sent   atomic.Uint64
+	failed atomic.Uint64
+}
+
+// NewScheduler creates a Scheduler scanning loans every interval as
+// holder. timeout limits sending one notification through one channel.
+func NewScheduler(loans repository.CirculationRepo, repo repository.NotificationRepo, elector repository.LeaderElector, channels []Channel, holder string, interval, dueSoon, timeout time.Duration) *Scheduler {
+	byName := make(map[string]Channel, len(channels))
+	for _, c := range channels {
+		byName[c.Name()] = c
+	}
+
+	return &Scheduler{
+		loans:    loans,
+		repo:     repo,
+		elector:  elector,
+		channels: byName,
+		holder:   holder,
+		interval: interval,
+		dueSoon:  dueSoon,
+		timeout:  timeout,
+	}
+}
+
+// DefaultPreferences are preferences of members which did not set any.
+func (s *Scheduler) DefaultPreferences(tenant, memberID string) *models.NotificationPreferences {
+	return &models.NotificationPreferences{
+		Tenant:   tenant,
+		MemberID: memberID,
+		Channels: s.ChannelNames(),
+		DueSoon:  true,
+		Overdue:  true,
+	}
+}
+
+// ChannelNames lists names of the channels the scheduler sends through.
+func (s *Scheduler) ChannelNames() []string {
+	names := []string{}
+	for _, name := range []string{LogChannelName, EmailChannelName, WebhookChannelName} {
+		if s.channels[name] != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Run scans loans every interval while leading, until stop is closed.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		leading, err := s.elector.TryLead(SchedulerJob, s.holder, 3*s.interval)
+		if err != nil {
+			log.Printf("notify: electing leader failed: %s\n", err)
+		}
+		if leading {
+			if _, err = s.Scan(time.Now().UTC()); err != nil {
+				log.Printf("notify: scanning loans failed: %s\n", err)
+			}
+		}
+
+		select {
+		case <-stop:
+			if leading {
+				if err = s.elector.Resign(SchedulerJob, s.holder); err != nil {
+					log.Printf("notify: resigning failed: %s\n", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan sends notifications of loans due before now+dueSoon which were not
+// sent yet and returns the amount of them sent. A failed notification is
+// sent again by the next scan.
+func (s *Scheduler) Scan(now time.Time) (int, error) {
+	loans, err := s.loans.GetDueLoans(now.Add(s.dueSoon))
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, l := range loans {
+		kind := models.NotificationDueSoon
+		if !l.DueAt.After(now) {
+			kind = models.NotificationOverdue
+		}
+
+		n, err := s.notify(l, kind, now)
+		sent += n
+		if err != nil {
+			log.Printf("notify: notifying of loan %s failed: %s\n", l.ID, err)
+		}
+	}
+
+	return sent, nil
+}
+
+// notify sends the notification of the loan through every channel the
+// member prefers and it was not sent through yet.
+func (s *Scheduler) notify(l *models.Loan, kind string, now time.Time) (int, error) {
+	prefs, err := s.repo.GetPreferences(l.Tenant, l.MemberID)
+	if errors.Is(err, repository.ErrPreferencesNotFound) {
+		prefs, err = s.DefaultPreferences(l.Tenant, l.MemberID), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !prefs.Wants(kind) || len(prefs.Channels) == 0 {
+		return 0, nil
+	}
+
+	logged, err := s.repo.GetNotifications(l.Tenant, repository.NotificationFilter{LoanID: l.ID})
+	if err != nil {
+		return 0, err
+	}
+	done := make(map[string]bool)
+	for _, n := range logged {
+		if n.Kind == kind && n.DueAt.Equal(l.DueAt) {
+			done[n.Channel] = true
+		}
+	}
+
+	var member *models.Member
+	sent := 0
+	for _, name := range prefs.Channels {
+		channel := s.channels[name]
+		if channel == nil || done[name] {
+			continue
+		}
+
+		if member == nil {
+			if member, err = s.loans.GetMember(l.Tenant, l.MemberID); err != nil {
+				return sent, err
+			}
+		}
+
+		n := &models.Notification{
+			Tenant:    l.Tenant,
+			Kind:      kind,
+			Channel:   name,
+			LoanID:    l.ID,
+			BookID:    l.BookID,
+			MemberID:  l.MemberID,
+			Recipient: member.Email,
+			DueAt:     l.DueAt,
+			SentAt:    now,
+		}
+		if err = s.send(channel, n); err != nil {
+			s.failed.Add(1)
+			log.Printf("notify: sending %s notification of loan %s failed: %s\n", kind, l.ID, err)
+			continue
+		}
+
+		s.sent.Add(1)
+		sent++
+		if _, err = s.repo.RecordNotification(n); err != nil && !errors.Is(err, repository.ErrNotificationSent) {
+			return sent, fmt.Errorf("logging notification: %w", err)
+		}
+	}
+
+	return sent, nil
+}
+
+func (s *Scheduler) send(channel Channel, n *models.Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := channel.Send(ctx, n); err != nil {
+		return fmt.Errorf("channel %s: %w", channel.Name(), err)
+	}
+	return nil
+}
+
+func (s *Scheduler) Stats() Stats {
+	return Stats{Sent: s.sent.Load(), Failed: s.failed.Load()}
+}
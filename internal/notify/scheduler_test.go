@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"testing"
+	"time"
+)
+
+func Test_Scheduler_Scan_ShouldNotifyOncePerDueDate(t *testing.T) {
+	// setup
+	now := time.Now().UTC()
+	loans := &loansStub{
+		loans: []*models.Loan{
+			{ID: "1", Tenant: "acme", BookID: "42", MemberID: "5", DueAt: now.Add(-time.Hour)},
+			{ID: "2", Tenant: "acme", BookID: "43", MemberID: "5", DueAt: now.Add(time.Hour)},
+			{ID: "3", Tenant: "acme", BookID: "44", MemberID: "6", DueAt: now.Add(time.Hour)},
+		},
+		members: map[string]*models.Member{
+			"5": {ID: "5", Email: "member5@example.com"},
+			"6": {ID: "6", Email: "member6@example.com"},
+		},
+	}
+	repo := &notificationRepoStub{preferences: map[string]*models.NotificationPreferences{
+		"6": {MemberID: "6", Channels: []string{LogChannelName}, Overdue: true},
+	}}
+	email := &channelStub{name: EmailChannelName, failures: 1}
+	logs := &channelStub{name: LogChannelName}
+	ts := NewScheduler(loans, repo, nil, []Channel{email, logs}, "replica-1", time.Minute, 24*time.Hour, time.Second)
+
+	t.Run("Sends through preferred channels", func(t *testing.T) {
+		// when
+		sent, err := ts.Scan(now)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while scanning:", err)
+		}
+
+		if sent != 3 || len(logs.sent) != 2 || len(email.sent) != 1 {
+			t.Fatalf("Sent %d notifications, %d logged and %d mailed, should be 3, 2 and 1\n", sent, len(logs.sent), len(email.sent))
+		}
+		if logs.sent[0].Kind != models.NotificationOverdue || logs.sent[1].Kind != models.NotificationDueSoon {
+			t.Fatalf("Unexpected kinds of notifications: %+v\n", logs.sent)
+		}
+		if email.sent[0].Recipient != "member5@example.com" {
+			t.Fatalf("Unexpected recipient: %+v\n", email.sent[0])
+		}
+	})
+
+	t.Run("Sends only failed notifications again", func(t *testing.T) {
+		// when
+		sent, err := ts.Scan(now)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while scanning:", err)
+		}
+
+		if sent != 1 || len(email.sent) != 2 || email.sent[1].LoanID != "1" {
+			t.Fatalf("Only the failed mail should be sent again, sent %d: %+v\n", sent, email.sent)
+		}
+		if stats := ts.Stats(); stats.Sent != 4 || stats.Failed != 1 {
+			t.Fatalf("Unexpected stats: %+v\n", stats)
+		}
+	})
+
+	t.Run("Reminds of renewed loan", func(t *testing.T) {
+		// given
+		loans.loans[1].DueAt = now.Add(2 * time.Hour)
+
+		// when
+		sent, err := ts.Scan(now)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while scanning:", err)
+		}
+
+		if sent != 2 {
+			t.Fatalf("Renewed loan should be reminded of through both channels, sent %d\n", sent)
+		}
+	})
+}
+
+// utils
+
+// loansStub serves due loans and members, other methods of the
+// circulation repository are not used by the scheduler.
+type loansStub struct {
+	repository.CirculationRepo
+	loans   []*models.Loan
+	members map[string]*models.Member
+}
+
+func (r *loansStub) GetDueLoans(before time.Time) ([]*models.Loan, error) {
+	var loans []*models.Loan
+	for _, l := range r.loans {
+		if l.DueAt.Before(before) {
+			loans = append(loans, l)
+		}
+	}
+	return loans, nil
+}
+
+func (r *loansStub) GetMember(tenant, id string) (*models.Member, error) {
+	m, ok := r.members[id]
+	if !ok {
+		return nil, repository.ErrMemberNotFound
+	}
+	return m, nil
+}
+
+type notificationRepoStub struct {
+	preferences   map[string]*models.NotificationPreferences
+	notifications []*models.Notification
+}
+
+func (r *notificationRepoStub) GetPreferences(tenant, memberID string) (*models.NotificationPreferences, error) {
+	p, ok := r.preferences[memberID]
+	if !ok {
+		return nil, repository.ErrPreferencesNotFound
+	}
+	return p, nil
+}
+
+func (r *notificationRepoStub) SetPreferences(p *models.NotificationPreferences) error {
+	r.preferences[p.MemberID] = p
+	return nil
+}
+
+func (r *notificationRepoStub) GetNotifications(tenant string, filter repository.NotificationFilter) ([]*models.Notification, error) {
+	var notifications []*models.Notification
+	for _, n := range r.notifications {
+		if (filter.LoanID == "" || n.LoanID == filter.LoanID) && (filter.MemberID == "" || n.MemberID == filter.MemberID) {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications, nil
+}
+
+func (r *notificationRepoStub) RecordNotification(n *models.Notification) (*models.Notification, error) {
+	created := *n
+	created.ID = fmt.Sprint(len(r.notifications) + 1)
+	r.notifications = append(r.notifications, &created)
+	return &created, nil
+}
+
+// channelStub fails the first failures notifications.
+type channelStub struct {
+	name     string
+	failures int
+	sent     []*models.Notification
+}
+
+func (c *channelStub) Name() string {
+	return c.name
+}
+
+func (c *channelStub) Send(ctx context.Context, n *models.Notification) error {
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("channel unavailable")
+	}
+	c.sent = append(c.sent, n)
+	return nil
+}
@@ -0,0 +1,136 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const natsConnectTimeout = 5 * time.Second
+
+// NATSSink publishes messages to a NATS compatible server speaking the
+// core text protocol, without depending on a client library. Subjects are
+// the prefix followed by the tenant and message type, e.g.
+// books.acme.book.created. Every publish is followed by PING, so a message
+// counts as sent only once the server answered PONG.
+type NATSSink struct {
+	addr   string
+	prefix string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewNATSSink(addr, prefix string) *NATSSink {
+	return &NATSSink{addr: addr, prefix: prefix}
+}
+
+func (s *NATSSink) Name() string {
+	return "nats"
+}
+
+func (s *NATSSink) Send(ctx context.Context, m *models.OutboxMessage) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err = s.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.conn.SetDeadline(deadline)
+	} else {
+		_ = s.conn.SetDeadline(time.Time{})
+	}
+
+	// a broken connection is replaced on the next send
+	if err = s.publish(s.prefix+"."+m.Tenant+"."+m.Type, payload); err != nil {
+		s.closeConn()
+		return err
+	}
+	return nil
+}
+
+func (s *NATSSink) connect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, natsConnectTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	reader := bufio.NewReader(conn)
+	info, err := reader.ReadString('\n')
+	if err == nil && !strings.HasPrefix(info, "INFO ") {
+		err = fmt.Errorf("unexpected greeting %q", strings.TrimSpace(info))
+	}
+	if err == nil {
+		_, err = conn.Write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"crud-app-outbox\"}\r\n"))
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("connecting to %s: %w", s.addr, err)
+	}
+
+	s.conn, s.reader = conn, reader
+	return nil
+}
+
+func (s *NATSSink) publish(subject string, payload []byte) error {
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload)
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		return err
+	}
+
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "PONG"):
+			return nil
+		case strings.HasPrefix(line, "PING"):
+			if _, err = s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("server error: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (s *NATSSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.reader = nil, nil
+	}
+}
+
+func (s *NATSSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeConn()
+	return nil
+}
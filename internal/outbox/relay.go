@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Sink receives messages drained from the outbox. Delivery is at least
+// once: a message is sent again when the relay stops before deleting it
+// or when another sink fails, receivers should deduplicate by message ID.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, m *models.OutboxMessage) error
+}
+
+type Stats struct {
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+}
+
+// Relay moves messages from the outbox to every sink and deletes those
+// all sinks received.
+//
+// Messages of one book are delivered in the order they were written:
+// after a failed message, later messages of the same book wait for the
+// next drain. A failed or held back books.cleared message holds back all
+// later messages of its tenant. Only one relay should drain an outbox at once.
+type Relay struct {
+	repo      repository.OutboxRepo
+	sinks     []Sink
+	interval  time.Duration
+	batchSize int
+	timeout   time.Duration
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// NewRelay creates a Relay reading batchSize messages at once, every
+// interval when the outbox was drained. timeout limits sending one message
+// to one sink.
+func NewRelay(repo repository.OutboxRepo, sinks []Sink, interval time.Duration, batchSize int, timeout time.Duration) *Relay {
+	return &Relay{
+		repo:      repo,
+		sinks:     sinks,
+		interval:  interval,
+		batchSize: batchSize,
+		timeout:   timeout,
+	}
+}
+
+// Run drains the outbox until stop is closed.
+func (r *Relay) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		// full batches are followed immediately, there may be more
+		for {
+			delivered, err := r.Drain()
+			if err != nil {
+				log.Printf("outbox: draining failed: %s\n", err)
+			}
+			if err != nil || delivered < r.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain delivers one batch of messages and returns the amount of them
+// delivered to every sink. Messages held back by failed ones are skipped
+// and the outbox is read further, so they cannot fill the batch and stall
+// other books and tenants.
+func (r *Relay) Drain() (int, error) {
+	blockedBooks := make(map[string]bool)
+	blockedTenants := make(map[string]bool)
+	failedTenants := make(map[string]bool)
+
+	var afterID string
+	sent, delivered := 0, 0
+	for sent < r.batchSize {
+		messages, err := r.repo.GetOutboxMessages(afterID, r.batchSize)
+		if err != nil {
+			return delivered, err
+		}
+
+		var deliveredIDs []string
+		for _, m := range messages {
+			if sent == r.batchSize {
+				break
+			}
+			afterID = m.ID
+
+			book := m.Tenant + "/" + m.BookID
+			cleared := m.BookID == ""
+			if blockedTenants[m.Tenant] || blockedBooks[book] {
+				continue
+			}
+			if cleared && failedTenants[m.Tenant] {
+				// held back books.cleared must not be overtaken by later
+				// messages of the tenant either
+				blockedTenants[m.Tenant] = true
+				continue
+			}
+
+			sent++
+			if err = r.send(m); err != nil {
+				log.Printf("outbox: delivering message %s (%s) failed: %s\n", m.ID, m.Type, err)
+				r.failed.Add(1)
+
+				failedTenants[m.Tenant] = true
+				if cleared {
+					blockedTenants[m.Tenant] = true
+				} else {
+					blockedBooks[book] = true
+				}
+				continue
+			}
+
+			deliveredIDs = append(deliveredIDs, m.ID)
+		}
+
+		if err = r.repo.DeleteOutboxMessages(deliveredIDs); err != nil {
+			return delivered, fmt.Errorf("deleting delivered messages: %w", err)
+		}
+		r.delivered.Add(uint64(len(deliveredIDs)))
+		delivered += len(deliveredIDs)
+
+		if len(messages) < r.batchSize {
+			break
+		}
+	}
+
+	return delivered, nil
+}
+
+func (r *Relay) send(m *models.OutboxMessage) error {
+	for _, sink := range r.sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		err := sink.Send(ctx, m)
+		cancel()
+
+		if err != nil {
+			return fmt.Errorf("sink %s: %w", sink.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (r *Relay) Stats() Stats {
+	return Stats{Delivered: r.delivered.Load(), Failed: r.failed.Load()}
+}
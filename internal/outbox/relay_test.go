@@ -0,0 +1,173 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Relay_Drain_ShouldKeepOrderOfBooks(t *testing.T) {
+	// setup
+	repo := &repoStub{messages: []*models.OutboxMessage{
+		{ID: "1", Tenant: "acme", Type: "book.created", BookID: "1"},
+		{ID: "2", Tenant: "acme", Type: "book.created", BookID: "2"},
+		{ID: "3", Tenant: "acme", Type: "book.updated", BookID: "1"},
+		{ID: "4", Tenant: "other", Type: "books.cleared"},
+		{ID: "5", Tenant: "acme", Type: "books.cleared"},
+		{ID: "6", Tenant: "acme", Type: "book.created", BookID: "3"},
+	}}
+	sink := &sinkStub{failures: map[string]int{"1": 1}}
+	ts := NewRelay(repo, []Sink{sink}, time.Second, 10, time.Second)
+
+	t.Run("Holds back messages after failed one", func(t *testing.T) {
+		// when
+		delivered, err := ts.Drain()
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while draining:", err)
+		}
+
+		if delivered != 2 || !equalIDs(sink.sent, []string{"2", "4"}) {
+			t.Fatalf("Delivered %d messages %v, should be 2 and 4\n", delivered, sink.sent)
+		}
+	})
+
+	t.Run("Delivers held back messages in order", func(t *testing.T) {
+		// when
+		delivered, err := ts.Drain()
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while draining:", err)
+		}
+
+		if delivered != 4 || !equalIDs(sink.sent[2:], []string{"1", "3", "5", "6"}) {
+			t.Fatalf("Delivered %d messages %v, should be 1, 3, 5 and 6\n", delivered, sink.sent[2:])
+		}
+
+		if len(repo.messages) != 0 {
+			t.Fatalf("Delivered messages should be deleted, left: %d\n", len(repo.messages))
+		}
+
+		if stats := ts.Stats(); stats.Delivered != 6 || stats.Failed != 1 {
+			t.Fatalf("Unexpected stats: %+v\n", stats)
+		}
+	})
+}
+
+func Test_Relay_Drain_ShouldRequireEverySink(t *testing.T) {
+	// setup
+	repo := &repoStub{messages: []*models.OutboxMessage{{ID: "1", Tenant: "acme", Type: "book.created", BookID: "1"}}}
+	first, second := &sinkStub{}, &sinkStub{failures: map[string]int{"1": 1}}
+	ts := NewRelay(repo, []Sink{first, second}, time.Second, 10, time.Second)
+
+	// when
+	_, _ = ts.Drain()
+	_, _ = ts.Drain()
+
+	// then
+	if !equalIDs(first.sent, []string{"1", "1"}) || !equalIDs(second.sent, []string{"1"}) {
+		t.Fatalf("Message should be sent again to every sink, sent %v and %v\n", first.sent, second.sent)
+	}
+}
+
+func Test_Relay_Drain_ShouldNotStallBehindHeldBackMessages(t *testing.T) {
+	// setup
+	repo := &repoStub{}
+	for i := 1; i <= 5; i++ {
+		repo.messages = append(repo.messages, &models.OutboxMessage{ID: strconv.Itoa(i), Tenant: "acme", Type: "book.updated", BookID: "1"})
+	}
+	repo.messages = append(repo.messages,
+		&models.OutboxMessage{ID: "6", Tenant: "other", Type: "book.created", BookID: "1"},
+		&models.OutboxMessage{ID: "7", Tenant: "acme", Type: "book.created", BookID: "2"},
+	)
+	sink := &sinkStub{failures: map[string]int{"1": 10}}
+	ts := NewRelay(repo, []Sink{sink}, time.Second, 3, time.Second)
+
+	// when
+	delivered, err := ts.Drain()
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while draining:", err)
+	}
+
+	if delivered != 2 || !equalIDs(sink.sent, []string{"6", "7"}) {
+		t.Fatalf("Delivered %d messages %v, should be 6 and 7\n", delivered, sink.sent)
+	}
+
+	if len(repo.messages) != 5 {
+		t.Fatalf("Held back messages should be kept, left: %d\n", len(repo.messages))
+	}
+}
+
+// utils
+
+type repoStub struct {
+	messages []*models.OutboxMessage
+}
+
+// GetOutboxMessages compares IDs as numbers, like the Postgres repository.
+func (r *repoStub) GetOutboxMessages(afterID string, limit int) ([]*models.OutboxMessage, error) {
+	after, _ := strconv.Atoi(afterID)
+
+	messages := []*models.OutboxMessage{}
+	for _, m := range r.messages {
+		if id, _ := strconv.Atoi(m.ID); id > after && len(messages) < limit {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+func (r *repoStub) DeleteOutboxMessages(ids []string) error {
+	deleted := make(map[string]bool)
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	var kept []*models.OutboxMessage
+	for _, m := range r.messages {
+		if !deleted[m.ID] {
+			kept = append(kept, m)
+		}
+	}
+	r.messages = kept
+	return nil
+}
+
+// sinkStub fails the given amount of times for message IDs in failures.
+type sinkStub struct {
+	failures map[string]int
+	sent     []string
+}
+
+func (s *sinkStub) Name() string {
+	return "stub"
+}
+
+func (s *sinkStub) Send(ctx context.Context, m *models.OutboxMessage) error {
+	if s.failures[m.ID] > 0 {
+		s.failures[m.ID]--
+		return errors.New("sink unavailable")
+	}
+
+	s.sent = append(s.sent, m.ID)
+	return nil
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/webhook"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Publisher receives events of delivered messages, it is implemented by
+// events.Bus.
+type Publisher interface {
+	Publish(e events.Event) events.Event
+}
+
+// BusSink publishes messages as events, so change feeds and webhooks see
+// only committed changes.
+type BusSink struct {
+	publisher Publisher
+}
+
+func NewBusSink(publisher Publisher) *BusSink {
+	return &BusSink{publisher: publisher}
+}
+
+func (s *BusSink) Name() string {
+	return "bus"
+}
+
+func (s *BusSink) Send(ctx context.Context, m *models.OutboxMessage) error {
+	s.publisher.Publish(events.Event{
+		Type:   events.Type(m.Type),
+		Tenant: m.Tenant,
+		BookID: m.BookID,
+		Book:   m.Book,
+		Time:   m.CreatedAt,
+	})
+	return nil
+}
+
+// LogSink writes every message as JSON to its logger.
+type LogSink struct {
+	logger *log.Logger
+}
+
+func NewLogSink(logger *log.Logger) *LogSink {
+	return &LogSink{logger: logger}
+}
+
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+func (s *LogSink) Send(ctx context.Context, m *models.OutboxMessage) error {
+	out, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Printf("outbox message: %s\n", out)
+	return nil
+}
+
+// FileSink appends every message as a line of JSON to a file, synced to
+// disk before the message is reported as sent.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Send(ctx context.Context, m *models.OutboxMessage) error {
+	out, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err = s.file.Write(append(out, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// WebhookSink posts every message as JSON to a URL. With a secret the
+// requests are signed like webhook deliveries.
+type WebhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookSink creates a WebhookSink sending requests with client,
+// http.DefaultClient when nil.
+func NewWebhookSink(url, secret string, client *http.Client) *WebhookSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &WebhookSink{url: url, secret: secret, client: client}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Send(ctx context.Context, m *models.OutboxMessage) error {
+	out, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(out))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.EventHeader, m.Type)
+	req.Header.Set(webhook.EventIDHeader, m.ID)
+
+	if s.secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(s.secret, timestamp, out))
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("receiver responded with %s", res.Status)
+	}
+	return nil
+}
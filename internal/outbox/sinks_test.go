@@ -0,0 +1,129 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/webhook"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FileSink_ShouldAppendJSONLines(t *testing.T) {
+	// setup
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	ts, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal("Encountered error while opening file:", err)
+	}
+	defer ts.Close()
+
+	// when
+	for _, id := range []string{"1", "2"} {
+		if err = ts.Send(context.Background(), &models.OutboxMessage{ID: id, Tenant: "acme", Type: "book.created"}); err != nil {
+			t.Fatal("Encountered error while sending:", err)
+		}
+	}
+
+	// then
+	content, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"id":"2"`) {
+		t.Fatalf("File should contain a line per message, has: %s\n", content)
+	}
+}
+
+func Test_WebhookSink_ShouldSignMessages(t *testing.T) {
+	// setup
+	var verified bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified = webhook.Verify("secret", r.Header, body, time.Minute)
+	}))
+	defer receiver.Close()
+
+	ts := NewWebhookSink(receiver.URL, "secret", nil)
+
+	// when
+	err := ts.Send(context.Background(), &models.OutboxMessage{ID: "1", Tenant: "acme", Type: "book.created"})
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while sending:", err)
+	}
+
+	if !verified {
+		t.Fatal("Receiver could not verify the signature")
+	}
+}
+
+func Test_NATSSink_ShouldPublishWithConfirmation(t *testing.T) {
+	// setup
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Encountered error while listening:", err)
+	}
+	defer listener.Close()
+
+	published := make(chan []string, 1)
+	go serveNATS(listener, published)
+
+	ts := NewNATSSink(listener.Addr().String(), "books")
+	defer ts.Close()
+
+	// when
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = ts.Send(ctx, &models.OutboxMessage{ID: "1", Tenant: "acme", Type: "book.created", BookID: "3"})
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while sending:", err)
+	}
+
+	msg := <-published
+	var m models.OutboxMessage
+	if msg[0] != "books.acme.book.created" || json.Unmarshal([]byte(msg[1]), &m) != nil || m.BookID != "3" {
+		t.Fatalf("Unexpected message published: %v\n", msg)
+	}
+}
+
+// utils
+
+// serveNATS accepts one connection and answers like a NATS server,
+// passing subject and payload of the first published message.
+func serveNATS(listener net.Listener, published chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+	reader := bufio.NewReader(conn)
+	var subject string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 3 && fields[0] == "PUB":
+			subject = fields[1]
+			payload, _ := reader.ReadString('\n')
+			published <- []string{subject, strings.TrimSpace(payload)}
+		case len(fields) > 0 && fields[0] == "PING":
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_Limiter_Allow(t *testing.T) {
+	// setup
+	now := time.Now()
+	ts := NewLimiter(1, 2)
+	ts.now = func() time.Time { return now }
+
+	t.Run("Should allow requests up to burst", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if res := ts.Allow("client"); !res.Allowed {
+				t.Fatalf("Request %d should be allowed: %+v\n", i+1, res)
+			}
+		}
+	})
+
+	t.Run("Should reject request over burst with retry time", func(t *testing.T) {
+		// when
+		res := ts.Allow("client")
+
+		// then
+		if res.Allowed {
+			t.Fatal("Request over burst should be rejected")
+		}
+
+		if res.Remaining != 0 || res.RetryAfter != time.Second {
+			t.Fatalf("Unexpected result of rejected request: %+v\n", res)
+		}
+	})
+
+	t.Run("Should track clients separately", func(t *testing.T) {
+		if res := ts.Allow("other"); !res.Allowed {
+			t.Fatalf("Request of other client should be allowed: %+v\n", res)
+		}
+	})
+
+	t.Run("Should refill tokens over time", func(t *testing.T) {
+		// given
+		now = now.Add(time.Second)
+
+		// when
+		res := ts.Allow("client")
+
+		// then
+		if !res.Allowed {
+			t.Fatalf("Request after refill should be allowed: %+v\n", res)
+		}
+	})
+}
+
+func Test_ConcurrencyLimiter_ShouldRejectWhenFull(t *testing.T) {
+	// setup
+	ts := NewConcurrencyLimiter(1)
+
+	// given
+	if !ts.Acquire(context.Background()) {
+		t.Fatal("[SETUP] First acquire should succeed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// when
+	acquired := ts.Acquire(ctx)
+
+	// then
+	if acquired {
+		t.Fatal("Acquire over limit should fail")
+	}
+
+	ts.Release()
+	if !ts.Acquire(context.Background()) {
+		t.Fatal("Acquire after release should succeed")
+	}
+}
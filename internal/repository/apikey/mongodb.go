@@ -0,0 +1,90 @@
+package apikey
+
+import (
+	"context"
+	"github.com/auwendil/crud-app/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type MongoDBRepo struct {
+	collection *mongo.Collection
+}
+
+const mongoCollectionName = "api_keys"
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		collection: db.Collection(mongoCollectionName),
+	}
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (r *MongoDBRepo) GetAPIKeyByHash(hash string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "hash", Value: hash}}
+	result := r.collection.FindOne(ctx, filter)
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	var key *models.APIKey
+	if err := result.Decode(&key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (r *MongoDBRepo) AddAPIKey(k *models.APIKey) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	createdKey := &models.APIKey{
+		Name:      k.Name,
+		Hash:      k.Hash,
+		Role:      k.Role,
+		Tenant:    k.Tenant,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	result, err := r.collection.InsertOne(ctx, createdKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		createdKey.ID = oid.Hex()
+	}
+
+	return createdKey, nil
+}
+
+func (r *MongoDBRepo) DeleteAPIKey(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{{Key: "_id", Value: objID}}
+	_, err = r.collection.DeleteOne(ctx, filter)
+	return err
+}
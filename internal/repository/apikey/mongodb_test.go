@@ -0,0 +1,90 @@
+package apikey
+
+import (
+	"github.com/auwendil/crud-app/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func Test_MongoDB_GetAPIKeyByHash(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should return key with matching hash", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		objID := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "db.api_keys", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: objID},
+			{Key: "name", Value: "ops"},
+			{Key: "hash", Value: "abc"},
+			{Key: "role", Value: "admin"},
+		}))
+
+		// when
+		key, err := ts.GetAPIKeyByHash("abc")
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while retrieving key:", err)
+		}
+
+		if key.ID != objID.Hex() || key.Role != "admin" {
+			t.Fatalf("Returned key is different than expected: %+v\n", key)
+		}
+	})
+
+	mt.Run("Should return error when key does not exist", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.api_keys", mtest.FirstBatch))
+
+		// when
+		key, err := ts.GetAPIKeyByHash("unknown")
+
+		// then
+		if err == nil {
+			t.Fatal("Expected to return error but returned nil instead")
+		}
+
+		if key != nil {
+			t.Fatal("Returned key should be nil")
+		}
+	})
+}
+
+func Test_MongoDB_AddAPIKey(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should create key", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// when
+		key, err := ts.AddAPIKey(&models.APIKey{Name: "ops", Hash: "abc", Role: "editor"})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while creating key:", err)
+		}
+
+		if key.ID == "" || key.CreatedAt.IsZero() {
+			t.Fatalf("Created key should have id and creation time: %+v\n", key)
+		}
+	})
+}
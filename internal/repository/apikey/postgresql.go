@@ -0,0 +1,84 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"time"
+)
+
+type PostgreSQLRepo struct {
+	DB *sql.DB
+}
+
+const postgresDBTimeout = time.Second * 3
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{
+		DB: db,
+	}
+}
+
+func (r *PostgreSQLRepo) GetAPIKeyByHash(hash string) (*models.APIKey, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, name, hash, role, COALESCE(tenant, ''), created_at
+		FROM api_keys
+		WHERE hash=$1;
+	`
+
+	var key models.APIKey
+	row := r.DB.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(&key.ID, &key.Name, &key.Hash, &key.Role, &key.Tenant, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (r *PostgreSQLRepo) AddAPIKey(k *models.APIKey) (*models.APIKey, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO api_keys (name, hash, role, tenant, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		RETURNING id;
+	`
+
+	createdAt := time.Now().UTC()
+
+	var newId int
+	err := r.DB.QueryRowContext(ctx, query, k.Name, k.Hash, k.Role, k.Tenant, createdAt).Scan(&newId)
+	if err != nil {
+		return nil, err
+	}
+
+	createdKey := &models.APIKey{
+		ID:        fmt.Sprintf("%d", newId),
+		Name:      k.Name,
+		Hash:      k.Hash,
+		Role:      k.Role,
+		Tenant:    k.Tenant,
+		CreatedAt: createdAt,
+	}
+	return createdKey, nil
+}
+
+func (r *PostgreSQLRepo) DeleteAPIKey(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancel()
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1;
+	`
+
+	_, err := r.DB.ExecContext(ctx, query, id)
+	return err
+}
@@ -0,0 +1,95 @@
+package apikey
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var apiKeysPostgresqlRows = []string{"id", "name", "hash", "role", "tenant", "created_at"}
+
+func Test_Postgresql_GetAPIKeyByHash_ShouldCallSelectQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	expectedKey := &models.APIKey{ID: "1", Name: "ops", Hash: "abc", Role: "admin", CreatedAt: time.Now()}
+
+	dbRows := sqlmock.NewRows(apiKeysPostgresqlRows)
+	dbRows.AddRow(expectedKey.ID, expectedKey.Name, expectedKey.Hash, expectedKey.Role, expectedKey.Tenant, expectedKey.CreatedAt)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, hash, role, COALESCE(tenant, ''), created_at FROM api_keys WHERE hash=$1;`)).
+		WithArgs(expectedKey.Hash).
+		WillReturnRows(dbRows)
+
+	// when
+	key, err := testServer.GetAPIKeyByHash(expectedKey.Hash)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.ID != expectedKey.ID || key.Role != expectedKey.Role {
+		t.Errorf("Keys not match: %+v vs %+v\n", key, expectedKey)
+	}
+}
+
+func Test_Postgresql_AddAPIKey_ShouldCallInsertQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	testKey := &models.APIKey{Name: "ops", Hash: "abc", Role: "editor"}
+
+	dbRows := sqlmock.NewRows([]string{"id"})
+	dbRows.AddRow("7")
+
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO api_keys (name, hash, role, tenant, created_at) VALUES ($1, $2, $3, NULLIF($4, ''), $5) RETURNING id;`)).
+		WithArgs(testKey.Name, testKey.Hash, testKey.Role, testKey.Tenant, sqlmock.AnyArg()).
+		WillReturnRows(dbRows)
+
+	// when
+	key, err := testServer.AddAPIKey(testKey)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.ID != "7" {
+		t.Fatalf("Returned id (%s) is different than expected: %s\n", key.ID, "7")
+	}
+}
+
+func Test_Postgresql_DeleteAPIKey_ShouldCallDeleteQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	res := sqlmock.NewResult(1, 1)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM api_keys WHERE id = $1;`)).
+		WithArgs("7").
+		WillReturnResult(res)
+
+	// when
+	err := testServer.DeleteAPIKey("7")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
@@ -0,0 +1,11 @@
+package repository
+
+import (
+	"github.com/auwendil/crud-app/internal/models"
+)
+
+type APIKeyRepo interface {
+	GetAPIKeyByHash(hash string) (*models.APIKey, error)
+	AddAPIKey(k *models.APIKey) (*models.APIKey, error)
+	DeleteAPIKey(id string) error
+}
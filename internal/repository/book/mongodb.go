@@ -2,41 +2,164 @@ package book
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
 	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/auwendil/crud-app/internal/repository/outbox"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
 	"time"
 )
 
+// MongoDBRepo scopes every query to its tenant. With shared tenancy books
+// of all tenants live in one collection and are tagged with tenant_id, with
+// isolated tenancy every tenant gets its own collection.
+//
+// With the outbox enabled every write runs in a session transaction which
+// also stores its event in the outbox collection, this requires a replica
+// set.
 type MongoDBRepo struct {
+	db         *mongo.Database
 	collection *mongo.Collection
+
+	tenant  string
+	tenancy repository.Tenancy
+	outbox  bool
 }
 
-const (
-	mongoDBName         = "db"
-	mongoCollectionName = "books"
-)
+const mongoCollectionName = "books"
 
 func NewMongoDBRepo(connectionURI string) (*MongoDBRepo, error) {
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(connectionURI))
+	db, err := database.OpenMongoDB(connectionURI)
 	if err != nil {
 		return nil, err
 	}
 
 	mongoDB := &MongoDBRepo{
-		collection: client.Database(mongoDBName).Collection(mongoCollectionName),
+		db:         db,
+		collection: db.Collection(mongoCollectionName),
+		tenant:     repository.DefaultTenant,
+		tenancy:    repository.TenancyShared,
 	}
 
 	return mongoDB, nil
 }
 
+func (r *MongoDBRepo) SetTenancy(tenancy repository.Tenancy) {
+	r.tenancy = tenancy
+}
+
+func (r *MongoDBRepo) SetOutbox(enabled bool) {
+	r.outbox = enabled
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "rating_average", Value: -1}, {Key: "rating_count", Value: -1}, {Key: "_id", Value: 1}}},
+	})
+	return err
+}
+
+// CheckSchema pings the database and makes sure the collection has the
+// tenant index created by CreateIndexes.
+func (r *MongoDBRepo) CheckSchema() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.db.Client().Ping(ctx, nil); err != nil {
+		return err
+	}
+
+	specs, err := r.collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if _, err = spec.KeysDocument.LookupErr("tenant_id"); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("collection %s has no tenant_id index", r.collection.Name())
+}
+
+func (r *MongoDBRepo) ForTenant(tenant string) (repository.BookRepo, error) {
+	if err := repository.ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	scoped := *r
+	scoped.tenant = tenant
+
+	if r.tenancy == repository.TenancyIsolated {
+		scoped.collection = tenantCollection(r.db, r.tenancy, tenant)
+	}
+
+	return &scoped, nil
+}
+
+// tenantCollection returns the collection keeping books of the tenant.
+func tenantCollection(db *mongo.Database, tenancy repository.Tenancy, tenant string) *mongo.Collection {
+	if tenancy == repository.TenancyIsolated {
+		return db.Collection(mongoCollectionName + "_" + tenant)
+	}
+	return db.Collection(mongoCollectionName)
+}
+
+func (r *MongoDBRepo) tenantFilter() bson.E {
+	return tenantFilter(r.tenant)
+}
+
+// tenantFilter matches documents of the tenant. Documents created before
+// tenancy was introduced have no tenant_id and belong to the default tenant.
+func tenantFilter(tenant string) bson.E {
+	if tenant == repository.DefaultTenant {
+		return bson.E{Key: "tenant_id", Value: bson.D{{Key: "$in", Value: bson.A{repository.DefaultTenant, nil}}}}
+	}
+	return bson.E{Key: "tenant_id", Value: tenant}
+}
+
+// inOutboxTx runs fn in a session transaction when the outbox is enabled,
+// fn may be retried on transient transaction errors.
+func (r *MongoDBRepo) inOutboxTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !r.outbox {
+		return fn(ctx)
+	}
+
+	session, err := r.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+func (r *MongoDBRepo) appendOutbox(ctx context.Context, t events.Type, id string, book *models.Book) error {
+	if !r.outbox {
+		return nil
+	}
+
+	return outbox.AppendMongoDB(ctx, r.db, &models.OutboxMessage{Tenant: r.tenant, Type: string(t), BookID: id, Book: book})
+}
+
 func (r *MongoDBRepo) GetAllBooks() ([]*models.Book, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	allValuesFilter := bson.D{}
+	allValuesFilter := bson.D{r.tenantFilter()}
 	cursor, err := r.collection.Find(ctx, allValuesFilter)
 	if err != nil {
 		return nil, err
@@ -53,18 +176,183 @@ func (r *MongoDBRepo) GetAllBooks() ([]*models.Book, error) {
 	return books, nil
 }
 
-func (r *MongoDBRepo) GetBook(id string) (*models.Book, error) {
+func (r *MongoDBRepo) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	allValuesFilter := bson.D{r.tenantFilter()}
+	cursor, err := r.collection.Find(ctx, allValuesFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []*models.Book{}
+	if err = cursor.All(ctx, &books); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// GetBooksByRating orders unrated books last, they have no rating fields
+// which sort below every rating.
+func (r *MongoDBRepo) GetBooksByRating(offset, limit int) ([]*models.Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "rating_average", Value: -1}, {Key: "rating_count", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, bson.D{r.tenantFilter()}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []*models.Book{}
+	if err = cursor.All(ctx, &books); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// SetRatingMongoDB copies the rating of the book to its document, so books
+// can be sorted by rating. The review repository calls it with the context
+// of the session transaction writing a review.
+func SetRatingMongoDB(ctx context.Context, db *mongo.Database, tenancy repository.Tenancy, tenant, id string, rating *models.Rating) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
+		// such books cannot exist
+		return nil
+	}
+
+	filter := bson.D{{Key: "_id", Value: objID}, tenantFilter(tenant)}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "rating_average", Value: rating.Average},
+		{Key: "rating_count", Value: rating.Count},
+	}}}
+
+	_, err = tenantCollection(db, tenancy, tenant).UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *MongoDBRepo) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objIDs := bson.A{}
+	for _, id := range ids {
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	if len(objIDs) == 0 {
+		return []*models.Book{}, nil
+	}
+
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: objIDs}}}, r.tenantFilter()}
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []*models.Book{}
+	if err = cursor.All(ctx, &books); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+func (r *MongoDBRepo) CountAuthors(ids []string) ([]*models.FacetCount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	match := bson.D{r.tenantFilter()}
+	if ids != nil {
+		objIDs := bson.A{}
+		for _, id := range ids {
+			if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+				objIDs = append(objIDs, objID)
+			}
+		}
+		if len(objIDs) == 0 {
+			return []*models.FacetCount{}, nil
+		}
+		match = append(match, bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: objIDs}}})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$author"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []*models.FacetCount{}
+	if err = cursor.All(ctx, &counts); err != nil {
 		return nil, err
 	}
 
-	filter := bson.D{{"_id", objID}}
+	return counts, nil
+}
+
+func (r *MongoDBRepo) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	contains := primitive.Regex{Pattern: regexp.QuoteMeta(phrase), Options: "i"}
+	filter := bson.D{
+		r.tenantFilter(),
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "name", Value: contains}},
+			bson.D{{Key: "author", Value: contains}},
+		}},
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []*models.Book{}
+	if err = cursor.All(ctx, &books); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+func (r *MongoDBRepo) GetBook(id string) (*models.Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+
+	filter := bson.D{{Key: "_id", Value: objID}, r.tenantFilter()}
 	result := r.collection.FindOne(ctx, filter)
-	if err = result.Err(); err != nil {
+	if err = result.Err(); errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+	if err != nil {
 		return nil, err
 	}
 
@@ -82,22 +370,29 @@ func (r *MongoDBRepo) AddBook(b *models.Book) (*models.Book, error) {
 
 	b.CreatedAt = time.Now()
 	b.UpdatedAt = time.Now()
+	b.TenantID = r.tenant
 
 	bytes, err := bson.Marshal(b)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := r.collection.InsertOne(ctx, bytes)
+	var id string
+	err = r.inOutboxTx(ctx, func(ctx context.Context) error {
+		result, err := r.collection.InsertOne(ctx, bytes)
+		if err != nil {
+			return err
+		}
+
+		if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+			id = oid.Hex()
+		}
+		return r.appendOutbox(ctx, events.BookCreated, id, &models.Book{ID: id, Name: b.Name, Author: b.Author})
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var id string
-	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
-		id = oid.Hex()
-	}
-
 	b.ID = id
 	return b, nil
 }
@@ -110,18 +405,22 @@ func (r *MongoDBRepo) UpdateBook(id string, updatedBook *models.Book) error {
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
 	}
 
-	filter := bson.D{{"_id", objID}}
+	filter := bson.D{{Key: "_id", Value: objID}, r.tenantFilter()}
 	updatedObject := bson.M{"$set": updatedBook}
 
-	res := r.collection.FindOneAndUpdate(ctx, filter, updatedObject)
-	if err = res.Err(); err != nil {
-		return err
-	}
+	return r.inOutboxTx(ctx, func(ctx context.Context) error {
+		res := r.collection.FindOneAndUpdate(ctx, filter, updatedObject)
+		if err := res.Err(); errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+		} else if err != nil {
+			return err
+		}
 
-	return nil
+		return r.appendOutbox(ctx, events.BookUpdated, id, &models.Book{ID: id, Name: updatedBook.Name, Author: updatedBook.Author})
+	})
 }
 
 func (r *MongoDBRepo) DeleteBook(id string) error {
@@ -133,7 +432,23 @@ func (r *MongoDBRepo) DeleteBook(id string) error {
 		return err
 	}
 
-	filter := bson.D{{"_id", objID}}
+	filter := bson.D{{Key: "_id", Value: objID}, r.tenantFilter()}
+	if r.outbox {
+		// the removed book is part of the event, deletes of missing books
+		// are not recorded
+		return r.inOutboxTx(ctx, func(ctx context.Context) error {
+			var book *models.Book
+			err := r.collection.FindOneAndDelete(ctx, filter).Decode(&book)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return r.appendOutbox(ctx, events.BookDeleted, id, &models.Book{ID: id, Name: book.Name, Author: book.Author})
+		})
+	}
+
 	_, err = r.collection.DeleteOne(ctx, filter)
 	if err != nil {
 		return err
@@ -146,11 +461,11 @@ func (r *MongoDBRepo) DeleteAllBooks() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	takeAllFilter := bson.D{}
-	_, err := r.collection.DeleteMany(ctx, takeAllFilter)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	takeAllFilter := bson.D{r.tenantFilter()}
+	return r.inOutboxTx(ctx, func(ctx context.Context) error {
+		if _, err := r.collection.DeleteMany(ctx, takeAllFilter); err != nil {
+			return err
+		}
+		return r.appendOutbox(ctx, events.BooksCleared, "", nil)
+	})
 }
@@ -1,7 +1,9 @@
 package book
 
 import (
+	"context"
 	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
@@ -84,6 +86,115 @@ func Test_MongoDB_GetAllBooks(t *testing.T) {
 	})
 }
 
+func Test_MongoDB_GetBooksBatch(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should return requested batch", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		expectedBooks := []*models.Book{
+			{ID: "333333333333333333333333", Name: "Book3", Author: "Author3"},
+			{ID: "444444444444444444444444", Name: "Book4", Author: "Author4"},
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "db.books", mtest.FirstBatch,
+				createBsonForBook(t, expectedBooks[0]),
+				createBsonForBook(t, expectedBooks[1])),
+			mtest.CreateCursorResponse(0, "db.books", mtest.NextBatch),
+		)
+
+		// when
+		books, err := ts.GetBooksBatch(2, 2)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while retrieving books batch from db:", err)
+		}
+
+		if !bookArraysEquals(t, books, expectedBooks) {
+			t.Fatalf("Returned book array are not equal to expected book array: %v vs %v\n", books, expectedBooks)
+		}
+	})
+}
+
+func Test_MongoDB_GetBooksByRating(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should sort books by their ratings", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+			tenant:     repository.DefaultTenant,
+		}
+
+		expectedBooks := []*models.Book{
+			{ID: "333333333333333333333333", Name: "Book3", Author: "Author3"},
+			{ID: "111111111111111111111111", Name: "Book1", Author: "Author1"},
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.books", mtest.FirstBatch,
+				createBsonForBook(t, expectedBooks[0]),
+				createBsonForBook(t, expectedBooks[1])),
+		)
+
+		// when
+		books, err := ts.GetBooksByRating(0, 2)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while retrieving books by rating from db:", err)
+		}
+
+		if len(books) != 2 || books[0].ID != expectedBooks[0].ID || books[1].ID != expectedBooks[1].ID {
+			t.Fatalf("Books should keep the order of the query, has: %+v\n", books)
+		}
+
+		command := mt.GetStartedEvent().Command
+		sort := command.Lookup("sort").Document()
+		if keys, _ := sort.Elements(); len(keys) != 3 || keys[0].Key() != "rating_average" || sort.Lookup("rating_average").Int32() != -1 {
+			t.Fatalf("Query should sort by rating first, has: %s\n", sort)
+		}
+	})
+}
+
+func Test_MongoDB_SetRating(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should update book in collection of isolated tenant", func(mt *mtest.T) {
+		// given
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// when
+		err := SetRatingMongoDB(context.Background(), mt.DB, repository.TenancyIsolated, "acme", "333333333333333333333333", &models.Rating{Average: 4.5, Count: 2})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while setting rating of book:", err)
+		}
+
+		command := mt.GetStartedEvent().Command
+		if collection := command.Lookup("update").StringValue(); collection != "books_acme" {
+			t.Fatalf("Update should use collection %q, used: %q\n", "books_acme", collection)
+		}
+
+		set := command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+		if set.Lookup("rating_average").Double() != 4.5 || set.Lookup("rating_count").Int32() != 2 {
+			t.Fatalf("Update should set the rating, has: %s\n", set)
+		}
+	})
+}
+
 func Test_MongoDB_GetBook(t *testing.T) {
 	// setup
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
@@ -178,7 +289,7 @@ func Test_MongoDB_UpdateBook(t *testing.T) {
 
 		updatedBook := &models.Book{ID: "333333333333333333333333", Name: "Book3", Author: "Author3"}
 
-		updated := bson.D{{"ok", 1}, {"value", createBsonForBook(t, updatedBook)}}
+		updated := bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: createBsonForBook(t, updatedBook)}}
 		mt.AddMockResponses(updated)
 
 		// when
@@ -261,6 +372,89 @@ func Test_MongoDB_DeleteAllBooks_ShouldCallDeleteAllQuery(t *testing.T) {
 	})
 }
 
+func Test_MongoDB_ForTenant(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should scope deletes to tenant", func(mt *mtest.T) {
+		// given
+		ts := &MongoDBRepo{
+			db:         mt.DB,
+			collection: mt.Coll,
+			tenant:     repository.DefaultTenant,
+			tenancy:    repository.TenancyShared,
+		}
+
+		tenantRepo, err := ts.ForTenant("acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// when
+		err = tenantRepo.DeleteAllBooks()
+
+		// then
+		if err != nil {
+			t.Fatalf("Encountered error while deleting all books: %s\n", err)
+		}
+
+		command := mt.GetStartedEvent().Command
+		filter := command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+		if tenant := filter.Lookup("tenant_id").StringValue(); tenant != "acme" {
+			t.Fatalf("Delete should be scoped to tenant %q, has filter: %s\n", "acme", filter)
+		}
+	})
+
+	mt.Run("Should use separate collection of isolated tenant", func(mt *mtest.T) {
+		// given
+		ts := &MongoDBRepo{
+			db:         mt.DB,
+			collection: mt.Coll,
+			tenant:     repository.DefaultTenant,
+			tenancy:    repository.TenancyIsolated,
+		}
+
+		tenantRepo, err := ts.ForTenant("acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+".books_acme", mtest.FirstBatch))
+
+		// when
+		_, err = tenantRepo.GetAllBooks()
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while retrieving books from db:", err)
+		}
+
+		command := mt.GetStartedEvent().Command
+		if collection := command.Lookup("find").StringValue(); collection != "books_acme" {
+			t.Fatalf("Query should use collection %q, used: %q\n", "books_acme", collection)
+		}
+	})
+
+	mt.Run("Should reject invalid tenant", func(mt *mtest.T) {
+		// given
+		ts := &MongoDBRepo{
+			db:         mt.DB,
+			collection: mt.Coll,
+		}
+
+		// when
+		_, err := ts.ForTenant("../admin")
+
+		// then
+		if err == nil {
+			t.Fatal("Expected to return error but returned nil instead")
+		}
+	})
+}
+
 // utility functions
 
 func createBsonForBook(t *testing.T, b *models.Book) bson.D {
@@ -270,9 +464,9 @@ func createBsonForBook(t *testing.T, b *models.Book) bson.D {
 	}
 
 	parsedBook := bson.D{
-		{"_id", objID},
-		{"name", b.Name},
-		{"author", b.Author},
+		{Key: "_id", Value: objID},
+		{Key: "name", Value: b.Name},
+		{Key: "author", Value: b.Author},
 	}
 
 	return parsedBook
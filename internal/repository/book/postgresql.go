@@ -3,38 +3,164 @@ package book
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
 	"github.com/auwendil/crud-app/internal/models"
-	_ "github.com/jackc/pgconn"
-	_ "github.com/jackc/pgx/v5"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/database"
+	"github.com/auwendil/crud-app/internal/repository/outbox"
+	"github.com/jackc/pgx/v5"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// PostgreSQLRepo scopes every query to its tenant. Besides filtering by
+// tenant_id, the tenant is set as app.tenant_id for the transaction, so
+// row level security policies apply as well. With isolated tenancy the
+// queries run against the books table in the schema of the tenant.
+//
+// With the outbox enabled every write also stores its event in the outbox
+// table within the same transaction.
 type PostgreSQLRepo struct {
 	DB *sql.DB
+
+	tenant      string
+	tenancy     repository.Tenancy
+	provisioned *sync.Map
+	outbox      bool
 }
 
 const postgresDBTimeout = time.Second * 3
-const postgresDBDriverName = "pgx"
-const postgresDBName = "books"
 
 func NewPostgreSQLRepo(dataName string) (*PostgreSQLRepo, error) {
-	db, err := sql.Open(postgresDBDriverName, fmt.Sprintf("%s/%s", dataName, postgresDBName))
+	db, err := database.OpenPostgreSQL(dataName)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Ping()
-	if err != nil {
+	repo := &PostgreSQLRepo{
+		DB:          db,
+		tenant:      repository.DefaultTenant,
+		tenancy:     repository.TenancyShared,
+		provisioned: &sync.Map{},
+	}
+
+	return repo, nil
+}
+
+func (r *PostgreSQLRepo) SetTenancy(tenancy repository.Tenancy) {
+	r.tenancy = tenancy
+}
+
+func (r *PostgreSQLRepo) SetOutbox(enabled bool) {
+	r.outbox = enabled
+}
+
+func (r *PostgreSQLRepo) ForTenant(tenant string) (repository.BookRepo, error) {
+	if err := repository.ValidateTenant(tenant); err != nil {
 		return nil, err
 	}
 
-	repo := &PostgreSQLRepo{
-		DB: db,
+	scoped := *r
+	scoped.tenant = tenant
+
+	if r.tenancy == repository.TenancyIsolated {
+		if err := scoped.provisionSchema(); err != nil {
+			return nil, fmt.Errorf("provisioning schema of tenant %q: %w", tenant, err)
+		}
 	}
 
-	return repo, nil
+	return &scoped, nil
+}
+
+func (r *PostgreSQLRepo) schema() string {
+	return tenantSchema(r.tenant)
+}
+
+func tenantSchema(tenant string) string {
+	return pgx.Identifier{"tenant_" + tenant}.Sanitize()
+}
+
+// provisionSchema creates the schema of an isolated tenant the first time
+// the tenant is used.
+func (r *PostgreSQLRepo) provisionSchema() error {
+	if _, ok := r.provisioned.Load(r.tenant); ok {
+		return nil
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	schema := r.schema()
+	queries := []string{
+		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;`, schema),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.books (LIKE public.books INCLUDING ALL);`, schema),
+		// schemas created before books were kept with their ratings
+		fmt.Sprintf(`ALTER TABLE %s.books ADD COLUMN IF NOT EXISTS rating_average double precision NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;`, schema),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS books_rating_idx ON %s.books (tenant_id, rating_average DESC, rating_count DESC, id);`, schema),
+	}
+	for _, query := range queries {
+		if _, err := r.DB.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	r.provisioned.Store(r.tenant, true)
+	return nil
+}
+
+func (r *PostgreSQLRepo) inTenantTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `SELECT set_config('app.tenant_id', $1, true);`, r.tenant)
+	if err == nil && r.tenancy == repository.TenancyIsolated {
+		_, err = tx.ExecContext(ctx, `SELECT set_config('search_path', $1, true);`, r.schema())
+	}
+	if err == nil {
+		err = fn(tx)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CheckSchema selects no rows with every column used by the repository,
+// so a missing table or column fails the query.
+func (r *PostgreSQLRepo) CheckSchema() error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	queries := []string{`SELECT id, name, author, tenant_id, rating_average, rating_count FROM books LIMIT 0;`}
+	if r.outbox {
+		queries = append(queries, `SELECT id, tenant, event_type, book_id, book, created_at FROM public.outbox LIMIT 0;`)
+	}
+
+	return r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		for _, query := range queries {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *PostgreSQLRepo) appendOutbox(ctx context.Context, tx *sql.Tx, t events.Type, id string, book *models.Book) error {
+	if !r.outbox {
+		return nil
+	}
+
+	return outbox.AppendPostgreSQL(ctx, tx, &models.OutboxMessage{Tenant: r.tenant, Type: string(t), BookID: id, Book: book})
 }
 
 func (r *PostgreSQLRepo) GetAllBooks() ([]*models.Book, error) {
@@ -43,24 +169,207 @@ func (r *PostgreSQLRepo) GetAllBooks() ([]*models.Book, error) {
 
 	query := `
 		SELECT id, name, author
-		FROM books;
+		FROM books
+		WHERE tenant_id = $1;
 	`
 
-	rows, err := r.DB.QueryContext(ctx, query)
+	var books []*models.Book
+	err := r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, r.tenant)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		books, err = scanBooks(rows)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	books := []*models.Book{}
-	for rows.Next() {
-		var book models.Book
-		err = rows.Scan(&book.ID, &book.Name, &book.Author)
+	return books, nil
+}
+
+func (r *PostgreSQLRepo) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, name, author
+		FROM books
+		WHERE tenant_id = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3;
+	`
+
+	var books []*models.Book
+	err := r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, r.tenant, limit, offset)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		defer rows.Close()
 
-		books = append(books, &book)
+		books, err = scanBooks(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+func (r *PostgreSQLRepo) GetBooksByRating(offset, limit int) ([]*models.Book, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	// unrated books have the average of 0, rated ones at least 1
+	query := `
+		SELECT id, name, author
+		FROM books
+		WHERE tenant_id = $1
+		ORDER BY rating_average DESC, rating_count DESC, id
+		LIMIT $2 OFFSET $3;
+	`
+
+	// LIMIT NULL returns all rows
+	var limitArg any
+	if limit > 0 {
+		limitArg = limit
+	}
+
+	var books []*models.Book
+	err := r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, r.tenant, limitArg, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		books, err = scanBooks(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+func (r *PostgreSQLRepo) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	// IDs which are not numbers cannot exist and would fail the cast
+	numericIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := strconv.ParseInt(id, 10, 32); err == nil {
+			numericIDs = append(numericIDs, id)
+		}
+	}
+	if len(numericIDs) == 0 {
+		return []*models.Book{}, nil
+	}
+
+	query := `
+		SELECT id, name, author
+		FROM books
+		WHERE tenant_id = $1 AND id = ANY($2::int[]);
+	`
+
+	var books []*models.Book
+	err := r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, r.tenant, "{"+strings.Join(numericIDs, ",")+"}")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		books, err = scanBooks(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+func (r *PostgreSQLRepo) CountAuthors(ids []string) ([]*models.FacetCount, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	conditions, args := "tenant_id = $1", []any{r.tenant}
+	if ids != nil {
+		numericIDs := make([]string, 0, len(ids))
+		for _, id := range ids {
+			if _, err := strconv.ParseInt(id, 10, 32); err == nil {
+				numericIDs = append(numericIDs, id)
+			}
+		}
+		if len(numericIDs) == 0 {
+			return []*models.FacetCount{}, nil
+		}
+		conditions, args = conditions+" AND id = ANY($2::int[])", append(args, "{"+strings.Join(numericIDs, ",")+"}")
+	}
+
+	query := `SELECT author, COUNT(*) FROM books WHERE ` + conditions + ` GROUP BY author ORDER BY COUNT(*) DESC, author;`
+
+	counts := []*models.FacetCount{}
+	err := r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var c models.FacetCount
+			if err = rows.Scan(&c.Value, &c.Count); err != nil {
+				return err
+			}
+			counts = append(counts, &c)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *PostgreSQLRepo) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, name, author
+		FROM books
+		WHERE tenant_id = $1 AND (name ILIKE $2 OR author ILIKE $2)
+		ORDER BY id
+		LIMIT $3 OFFSET $4;
+	`
+
+	pattern := "%" + likeEscaper.Replace(phrase) + "%"
+
+	var books []*models.Book
+	err := r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, r.tenant, pattern, limit, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		books, err = scanBooks(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return books, nil
@@ -73,13 +382,21 @@ func (r *PostgreSQLRepo) GetBook(id string) (*models.Book, error) {
 	query := `
 		SELECT id, name, author
 		FROM books
-		WHERE id=$1;
+		WHERE id=$1 AND tenant_id=$2;
 	`
 
-	var book models.Book
-	row := r.DB.QueryRowContext(ctx, query, id)
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
 
-	err := row.Scan(&book.ID, &book.Name, &book.Author)
+	var book models.Book
+	err := r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, query, id, r.tenant)
+		return row.Scan(&book.ID, &book.Name, &book.Author)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -92,22 +409,29 @@ func (r *PostgreSQLRepo) AddBook(b *models.Book) (*models.Book, error) {
 	defer cancelFn()
 
 	query := `
-		INSERT INTO books (name, author)
-		VALUES ($1, $2)
+		INSERT INTO books (name, author, tenant_id)
+		VALUES ($1, $2, $3)
 		RETURNING id;
 	`
 
-	var newId int
-	err := r.DB.QueryRowContext(ctx, query, b.Name, b.Author).Scan(&newId)
+	var createdBook *models.Book
+	err := r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		var newId int
+		if err := tx.QueryRowContext(ctx, query, b.Name, b.Author, r.tenant).Scan(&newId); err != nil {
+			return err
+		}
+
+		createdBook = &models.Book{
+			ID:     fmt.Sprintf("%d", newId),
+			Name:   b.Name,
+			Author: b.Author,
+		}
+		return r.appendOutbox(ctx, tx, events.BookCreated, createdBook.ID, createdBook)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	createdBook := &models.Book{
-		ID:     fmt.Sprintf("%d", newId),
-		Name:   b.Name,
-		Author: b.Author,
-	}
 	return createdBook, nil
 }
 
@@ -118,11 +442,28 @@ func (r *PostgreSQLRepo) UpdateBook(id string, updatedBook *models.Book) error {
 	query := `
 		UPDATE books
 		SET name = $2, author = $3
-		WHERE id = $1;
+		WHERE id = $1 AND tenant_id = $4;
 	`
 
-	_, err := r.DB.ExecContext(ctx, query, id, updatedBook.Name, updatedBook.Author)
-	return err
+	if err := checkID(id); err != nil {
+		return err
+	}
+
+	return r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, id, updatedBook.Name, updatedBook.Author, r.tenant)
+		if err != nil {
+			return err
+		}
+
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+		}
+		return r.appendOutbox(ctx, tx, events.BookUpdated, id, &models.Book{ID: id, Name: updatedBook.Name, Author: updatedBook.Author})
+	})
 }
 
 func (r *PostgreSQLRepo) DeleteBook(id string) error {
@@ -131,11 +472,38 @@ func (r *PostgreSQLRepo) DeleteBook(id string) error {
 
 	query := `
 		DELETE FROM books
-		WHERE id = $1;
+		WHERE id = $1 AND tenant_id = $2;
 	`
 
-	_, err := r.DB.ExecContext(ctx, query, id)
-	return err
+	if err := checkID(id); err != nil {
+		return err
+	}
+
+	if r.outbox {
+		// the removed book is part of the event, deletes of missing books
+		// are not recorded
+		query = `
+			DELETE FROM books
+			WHERE id = $1 AND tenant_id = $2
+			RETURNING name, author;
+		`
+		return r.inTenantTx(ctx, func(tx *sql.Tx) error {
+			book := &models.Book{ID: id}
+			err := tx.QueryRowContext(ctx, query, id, r.tenant).Scan(&book.Name, &book.Author)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return r.appendOutbox(ctx, tx, events.BookDeleted, id, book)
+		})
+	}
+
+	return r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, id, r.tenant)
+		return err
+	})
 }
 
 func (r *PostgreSQLRepo) DeleteAllBooks() error {
@@ -143,9 +511,67 @@ func (r *PostgreSQLRepo) DeleteAllBooks() error {
 	defer cancel()
 
 	query := `
-		DELETE FROM books;
+		DELETE FROM books
+		WHERE tenant_id = $1;
 	`
 
-	_, err := r.DB.ExecContext(ctx, query)
+	return r.inTenantTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, query, r.tenant); err != nil {
+			return err
+		}
+		return r.appendOutbox(ctx, tx, events.BooksCleared, "", nil)
+	})
+}
+
+// SetRatingPostgreSQL copies the rating of the book to its record in tx,
+// so books can be sorted by rating. The review repository calls it with
+// every write of a review. Its transactions search the public schema, so
+// the books table is qualified with the schema of the tenant.
+func SetRatingPostgreSQL(ctx context.Context, tx *sql.Tx, tenancy repository.Tenancy, tenant, id string, rating *models.Rating) error {
+	if checkID(id) != nil {
+		// such books cannot exist
+		return nil
+	}
+
+	table := "public.books"
+	if tenancy == repository.TenancyIsolated {
+		table = tenantSchema(tenant) + ".books"
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('app.tenant_id', $1, true);`, tenant); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE ` + table + `
+		SET rating_average = $3, rating_count = $4
+		WHERE id = $1 AND tenant_id = $2;
+	`
+
+	_, err := tx.ExecContext(ctx, query, id, tenant, rating.Average, rating.Count)
 	return err
 }
+
+// checkID rejects IDs which are not numbers in the range of the SERIAL id
+// column, such books cannot exist and the cast would fail in Postgres.
+func checkID(id string) error {
+	if _, err := strconv.ParseInt(id, 10, 32); err != nil {
+		return fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+	return nil
+}
+
+func scanBooks(rows *sql.Rows) ([]*models.Book, error) {
+	books := []*models.Book{}
+	for rows.Next() {
+		var book models.Book
+		err := rows.Scan(&book.ID, &book.Name, &book.Author)
+		if err != nil {
+			return nil, err
+		}
+
+		books = append(books, &book)
+	}
+
+	return books, rows.Err()
+}
@@ -1,9 +1,13 @@
 package book
 
 import (
+	"context"
+	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
 	"regexp"
+	"sync"
 
 	"testing"
 )
@@ -28,7 +32,9 @@ func Test_Postgresql_GetAllBooks_ShouldReturnExpectedArray(t *testing.T) {
 		dbRows.AddRow(book.ID, book.Name, book.Author)
 	}
 
-	mock.ExpectQuery(`SELECT id, name, author FROM books;`).
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE tenant_id = $1;`)).
+		WithArgs(repository.DefaultTenant).
 		WillReturnRows(dbRows)
 	mock.ExpectCommit()
 
@@ -58,7 +64,9 @@ func Test_Postgresql_GetAllBooks_ShouldReturnEmptyArrayWhenTableIsEmpty(t *testi
 
 	// given
 	dbRows := sqlmock.NewRows(booksPostgresqlRows)
-	mock.ExpectQuery(`SELECT id, name, author FROM books;`).
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE tenant_id = $1;`)).
+		WithArgs(repository.DefaultTenant).
 		WillReturnRows(dbRows)
 	mock.ExpectCommit()
 
@@ -79,6 +87,145 @@ func Test_Postgresql_GetAllBooks_ShouldReturnEmptyArrayWhenTableIsEmpty(t *testi
 	}
 }
 
+func Test_Postgresql_GetBooksBatch_ShouldCallPagedSelectQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	offset, limit := 2, 2
+	expectedBooks := []*models.Book{
+		{ID: "3", Name: "Book3", Author: "Author3"},
+		{ID: "4", Name: "Book4", Author: "Author4"},
+	}
+
+	dbRows := sqlmock.NewRows(booksPostgresqlRows)
+	for _, book := range expectedBooks {
+		dbRows.AddRow(book.ID, book.Name, book.Author)
+	}
+
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE tenant_id = $1 ORDER BY id LIMIT $2 OFFSET $3;`)).
+		WithArgs(repository.DefaultTenant, limit, offset).
+		WillReturnRows(dbRows)
+	mock.ExpectCommit()
+
+	// when
+	resBooks, err := testServer.GetBooksBatch(offset, limit)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bookArraysEquals(t, resBooks, expectedBooks) {
+		t.Error("Result books are different than expected")
+	}
+}
+
+func Test_Postgresql_GetBooksByIDs_ShouldCallSingleSelectQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	expectedBooks := []*models.Book{
+		{ID: "1", Name: "Book1", Author: "Author1"},
+		{ID: "3", Name: "Book3", Author: "Author3"},
+	}
+
+	dbRows := sqlmock.NewRows(booksPostgresqlRows)
+	for _, book := range expectedBooks {
+		dbRows.AddRow(book.ID, book.Name, book.Author)
+	}
+
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE tenant_id = $1 AND id = ANY($2::int[]);`)).
+		WithArgs(repository.DefaultTenant, "{1,3}").
+		WillReturnRows(dbRows)
+	mock.ExpectCommit()
+
+	// when
+	resBooks, err := testServer.GetBooksByIDs([]string{"1", "not-a-number", "3"})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bookArraysEquals(t, resBooks, expectedBooks) {
+		t.Error("Result books are different than expected")
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_CountAuthors_ShouldCountOnlyGivenBooks(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT author, COUNT(*) FROM books WHERE tenant_id = $1 AND id = ANY($2::int[]) GROUP BY author ORDER BY COUNT(*) DESC, author;`)).
+		WithArgs(repository.DefaultTenant, "{1,3}").
+		WillReturnRows(sqlmock.NewRows([]string{"author", "count"}).AddRow("Author1", 2))
+	mock.ExpectCommit()
+
+	// when
+	counts, err := testServer.CountAuthors([]string{"1", "not-a-number", "3"})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts) != 1 || *counts[0] != (models.FacetCount{Value: "Author1", Count: 2}) {
+		t.Fatalf("Author counts are different than expected: %+v\n", counts)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_SearchBooks_ShouldEscapePhrase(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	offset, limit := 0, 10
+	expectedBooks := []*models.Book{
+		{ID: "2", Name: "100% Book", Author: "Author2"},
+	}
+
+	dbRows := sqlmock.NewRows(booksPostgresqlRows)
+	for _, book := range expectedBooks {
+		dbRows.AddRow(book.ID, book.Name, book.Author)
+	}
+
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE tenant_id = $1 AND (name ILIKE $2 OR author ILIKE $2) ORDER BY id LIMIT $3 OFFSET $4;`)).
+		WithArgs(repository.DefaultTenant, `%100\% b%`, limit, offset).
+		WillReturnRows(dbRows)
+	mock.ExpectCommit()
+
+	// when
+	resBooks, err := testServer.SearchBooks("100% b", offset, limit)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bookArraysEquals(t, resBooks, expectedBooks) {
+		t.Error("Result books are different than expected")
+	}
+}
+
 func Test_Postgresql_GetBook_ShouldCallSelectQuery(t *testing.T) {
 	// setup
 	testServer, mock := prepareTestDB(t)
@@ -90,8 +237,9 @@ func Test_Postgresql_GetBook_ShouldCallSelectQuery(t *testing.T) {
 
 	dbRows := sqlmock.NewRows(booksPostgresqlRows)
 	dbRows.AddRow(expectedBook.ID, expectedBook.Name, expectedBook.Author)
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE id=$1;`)).
-		WithArgs(resultBookID).
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE id=$1 AND tenant_id=$2;`)).
+		WithArgs(resultBookID, repository.DefaultTenant).
 		WillReturnRows(dbRows)
 	mock.ExpectCommit()
 
@@ -108,6 +256,72 @@ func Test_Postgresql_GetBook_ShouldCallSelectQuery(t *testing.T) {
 	}
 }
 
+func Test_Postgresql_GetBook_ShouldReturnNotFound(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE id=$1 AND tenant_id=$2;`)).
+		WithArgs("404", repository.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(booksPostgresqlRows))
+	mock.ExpectRollback()
+
+	// when
+	_, err := testServer.GetBook("404")
+
+	// then
+	if !errors.Is(err, repository.ErrBookNotFound) {
+		t.Fatalf("Expected %q error but received: %v\n", repository.ErrBookNotFound, err)
+	}
+}
+
+func Test_Postgresql_UpdateBook_ShouldReturnNotFound(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	testBook := &models.Book{Name: "Book3", Author: "Author3"}
+
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE books SET name = $2, author = $3 WHERE id = $1 AND tenant_id = $4;`)).
+		WithArgs("404", testBook.Name, testBook.Author, repository.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// when
+	err := testServer.UpdateBook("404", testBook)
+
+	// then
+	if !errors.Is(err, repository.ErrBookNotFound) {
+		t.Fatalf("Expected %q error but received: %v\n", repository.ErrBookNotFound, err)
+	}
+}
+
+func Test_Postgresql_ShouldNotFindBooksWithNonNumericIDs(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// when
+	_, getErr := testServer.GetBook("abc")
+	updateErr := testServer.UpdateBook("abc", &models.Book{Name: "Book3", Author: "Author3"})
+	deleteErr := testServer.DeleteBook("abc")
+
+	// then
+	for _, err := range []error{getErr, updateErr, deleteErr} {
+		if !errors.Is(err, repository.ErrBookNotFound) {
+			t.Fatalf("Expected %q error but received: %v\n", repository.ErrBookNotFound, err)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func Test_Postgresql_AddBook_ShouldCallInsertQuery(t *testing.T) {
 	// setup
 	testServer, mock := prepareTestDB(t)
@@ -119,8 +333,9 @@ func Test_Postgresql_AddBook_ShouldCallInsertQuery(t *testing.T) {
 	dbRows := sqlmock.NewRows([]string{"id"})
 	dbRows.AddRow(testBook.ID)
 
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO books (name, author) VALUES ($1, $2) RETURNING id;`)).
-		WithArgs(testBook.Name, testBook.Author).
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO books (name, author, tenant_id) VALUES ($1, $2, $3) RETURNING id;`)).
+		WithArgs(testBook.Name, testBook.Author, repository.DefaultTenant).
 		WillReturnRows(dbRows)
 	mock.ExpectCommit()
 
@@ -146,8 +361,9 @@ func Test_Postgresql_UpdateBook_ShouldCallUpdateQuery(t *testing.T) {
 	testBook := &models.Book{ID: "3", Name: "Book3", Author: "Author3"}
 
 	res := sqlmock.NewResult(1, 1)
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE books SET name = $2, author = $3 WHERE id = $1;`)).
-		WithArgs(testBook.ID, testBook.Name, testBook.Author).
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE books SET name = $2, author = $3 WHERE id = $1 AND tenant_id = $4;`)).
+		WithArgs(testBook.ID, testBook.Name, testBook.Author, repository.DefaultTenant).
 		WillReturnResult(res)
 	mock.ExpectCommit()
 
@@ -169,8 +385,9 @@ func Test_Postgresql_DeleteBook_ShouldCallDeleteQuery(t *testing.T) {
 	testBook := &models.Book{ID: "3", Name: "Book3", Author: "Author3"}
 
 	res := sqlmock.NewResult(1, 1)
-	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM books WHERE id = $1;`)).
-		WithArgs(testBook.ID).
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM books WHERE id = $1 AND tenant_id = $2;`)).
+		WithArgs(testBook.ID, repository.DefaultTenant).
 		WillReturnResult(res)
 	mock.ExpectCommit()
 
@@ -190,7 +407,10 @@ func Test_Postgresql_DeleteAllBooks_ShouldCallDeleteAllQuery(t *testing.T) {
 
 	// given
 	res := sqlmock.NewResult(1, 1)
-	mock.ExpectExec(`DELETE FROM books;`).WillReturnResult(res)
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM books WHERE tenant_id = $1;`)).
+		WithArgs(repository.DefaultTenant).
+		WillReturnResult(res)
 	mock.ExpectCommit()
 
 	// when
@@ -202,12 +422,316 @@ func Test_Postgresql_DeleteAllBooks_ShouldCallDeleteAllQuery(t *testing.T) {
 	}
 }
 
+func Test_Postgresql_AddBook_ShouldWriteOutboxInSameTransaction(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+	testServer.SetOutbox(true)
+
+	t.Run("Commits book with its event", func(t *testing.T) {
+		// given
+		expectTenantTx(mock, repository.DefaultTenant)
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO books (name, author, tenant_id) VALUES ($1, $2, $3) RETURNING id;`)).
+			WithArgs("Book3", "Author3", repository.DefaultTenant).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("3"))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO public.outbox (tenant, event_type, book_id, book) VALUES ($1, $2, $3, $4);`)).
+			WithArgs(repository.DefaultTenant, "book.created", "3", []byte(`{"id":"3","name":"Book3","author":"Author3"}`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		// when
+		_, err := testServer.AddBook(&models.Book{Name: "Book3", Author: "Author3"})
+
+		// then
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Rolls back book when event cannot be written", func(t *testing.T) {
+		// given
+		expectTenantTx(mock, repository.DefaultTenant)
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO books (name, author, tenant_id) VALUES ($1, $2, $3) RETURNING id;`)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("4"))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO public.outbox`)).
+			WillReturnError(errors.New("outbox unavailable"))
+		mock.ExpectRollback()
+
+		// when
+		_, err := testServer.AddBook(&models.Book{Name: "Book4", Author: "Author4"})
+
+		// then
+		if err == nil {
+			t.Fatal("Expected to return error but returned nil instead")
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func Test_Postgresql_DeleteBook_ShouldWriteOutboxOnlyForExistingBook(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+	testServer.SetOutbox(true)
+
+	// given
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM books WHERE id = $1 AND tenant_id = $2 RETURNING name, author;`)).
+		WithArgs("3", repository.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "author"}).AddRow("Book3", "Author3"))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO public.outbox (tenant, event_type, book_id, book) VALUES ($1, $2, $3, $4);`)).
+		WithArgs(repository.DefaultTenant, "book.deleted", "3", []byte(`{"id":"3","name":"Book3","author":"Author3"}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM books WHERE id = $1 AND tenant_id = $2 RETURNING name, author;`)).
+		WithArgs("9", repository.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "author"}))
+	mock.ExpectCommit()
+
+	// when
+	existingErr := testServer.DeleteBook("3")
+	missingErr := testServer.DeleteBook("9")
+
+	// then
+	if existingErr != nil || missingErr != nil {
+		t.Fatal(existingErr, missingErr)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_ForTenant_ShouldScopeQueriesToTenant(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	tenantRepo, err := testServer.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := sqlmock.NewResult(1, 1)
+	expectTenantTx(mock, "acme")
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM books WHERE tenant_id = $1;`)).
+		WithArgs("acme").
+		WillReturnResult(res)
+	mock.ExpectCommit()
+
+	// when
+	err = tenantRepo.DeleteAllBooks()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_ForTenant_ShouldUseSchemaOfIsolatedTenant(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+	testServer.SetTenancy(repository.TenancyIsolated)
+
+	// given
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE SCHEMA IF NOT EXISTS "tenant_acme";`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS "tenant_acme".books (LIKE public.books INCLUDING ALL);`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "tenant_acme".books ADD COLUMN IF NOT EXISTS rating_average`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE INDEX IF NOT EXISTS books_rating_idx ON "tenant_acme".books`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	expectTenantTx(mock, "acme")
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('search_path', $1, true);`)).
+		WithArgs(`"tenant_acme"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE tenant_id = $1;`)).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows(booksPostgresqlRows))
+	mock.ExpectCommit()
+
+	// when
+	tenantRepo, err := testServer.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tenantRepo.GetAllBooks()
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = testServer.ForTenant("acme"); err != nil {
+		t.Fatal("Schema of already provisioned tenant should not be created again:", err)
+	}
+}
+
+func Test_Postgresql_ForTenant_ShouldRejectInvalidTenant(t *testing.T) {
+	// setup
+	testServer, _ := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// when
+	_, err := testServer.ForTenant(`acme"; DROP TABLE books; --`)
+
+	// then
+	if err == nil {
+		t.Fatal("Expected to return error but returned nil instead")
+	}
+}
+
+func Test_Postgresql_CheckSchema_ShouldReturnErrorOfMissingColumn(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	expectTenantTx(mock, repository.DefaultTenant)
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT id, name, author, tenant_id, rating_average, rating_count FROM books LIMIT 0;`)).
+		WillReturnError(errors.New(`column "tenant_id" does not exist`))
+	mock.ExpectRollback()
+
+	// when
+	err := testServer.CheckSchema()
+
+	// then
+	if err == nil {
+		t.Fatal("Expected to return error but returned nil instead")
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_GetBooksByRating(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int
+		expectedLimit any
+	}{
+		{"Returns page of books", 2, 2},
+		{"Returns all books without limit", 0, nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			testServer, mock := prepareTestDB(t)
+			defer testServer.DB.Close()
+
+			// given
+			dbRows := sqlmock.NewRows(booksPostgresqlRows).
+				AddRow("3", "Book3", "Author3").
+				AddRow("1", "Book1", "Author1")
+			expectTenantTx(mock, repository.DefaultTenant)
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, author FROM books WHERE tenant_id = $1 ORDER BY rating_average DESC, rating_count DESC, id LIMIT $2 OFFSET $3;`)).
+				WithArgs(repository.DefaultTenant, tt.expectedLimit, 1).
+				WillReturnRows(dbRows)
+			mock.ExpectCommit()
+
+			// when
+			books, err := testServer.GetBooksByRating(1, tt.limit)
+
+			// then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(books) != 2 || books[0].ID != "3" || books[1].ID != "1" {
+				t.Fatalf("Books should keep the order of the query, has: %+v\n", books)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func Test_Postgresql_SetRating_ShouldUpdateBookOfTenant(t *testing.T) {
+	tests := []struct {
+		name          string
+		tenancy       repository.Tenancy
+		expectedTable string
+	}{
+		{"Shared tenancy", repository.TenancyShared, "public.books"},
+		{"Isolated tenancy", repository.TenancyIsolated, `"tenant_acme".books`},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			testServer, mock := prepareTestDB(t)
+			defer testServer.DB.Close()
+
+			// given
+			expectTenantTx(mock, "acme")
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE `+tt.expectedTable+` SET rating_average = $3, rating_count = $4 WHERE id = $1 AND tenant_id = $2;`)).
+				WithArgs("7", "acme", 4.5, 2).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			// when
+			tx, err := testServer.DB.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = SetRatingPostgreSQL(context.Background(), tx, tt.tenancy, "acme", "7", &models.Rating{Average: 4.5, Count: 2})
+			if err == nil {
+				err = tx.Commit()
+			}
+
+			// then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
 func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
 	}
 
-	testServer := &PostgreSQLRepo{DB: db}
+	testServer := &PostgreSQLRepo{
+		DB:          db,
+		tenant:      repository.DefaultTenant,
+		tenancy:     repository.TenancyShared,
+		provisioned: &sync.Map{},
+	}
 	return testServer, mock
 }
+
+func expectTenantTx(mock sqlmock.Sqlmock, tenant string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('app.tenant_id', $1, true);`)).
+		WithArgs(tenant).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
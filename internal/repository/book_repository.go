@@ -1,14 +1,54 @@
 package repository
 
 import (
+	"errors"
 	"github.com/auwendil/crud-app/internal/models"
 )
 
+// ErrBookNotFound is returned by GetBook and UpdateBook when there is no
+// book with the given ID.
+var ErrBookNotFound = errors.New("book not found")
+
+// ErrRatingSortUnsupported is returned by GetBooksByRating of repositories
+// passing it through to one which does not keep ratings of books.
+var ErrRatingSortUnsupported = errors.New("books are not kept with their ratings, they cannot be sorted by rating")
+
 type BookRepo interface {
 	GetAllBooks() ([]*models.Book, error)
+	GetBooksBatch(offset, limit int) ([]*models.Book, error)
 	GetBook(id string) (*models.Book, error)
+	// GetBooksByIDs returns existing books among ids in no particular
+	// order, missing ones are skipped.
+	GetBooksByIDs(ids []string) ([]*models.Book, error)
+	// SearchBooks returns books whose name or author contains phrase,
+	// ignoring case, ordered by ID.
+	SearchBooks(phrase string, offset, limit int) ([]*models.Book, error)
 	AddBook(b *models.Book) (*models.Book, error)
 	UpdateBook(id string, updatedBook *models.Book) error
 	DeleteBook(id string) error
 	DeleteAllBooks() error
 }
+
+// SchemaChecker is implemented by repositories able to verify that their
+// database is reachable and has every table, column and index they use.
+type SchemaChecker interface {
+	CheckSchema() error
+}
+
+// AuthorCounter is implemented by repositories able to count books by their
+// authors.
+type AuthorCounter interface {
+	// CountAuthors counts books among ids, or every book when ids is nil,
+	// by their authors, the most frequent authors first.
+	CountAuthors(ids []string) ([]*models.FacetCount, error)
+}
+
+// RatingSorter is implemented by repositories keeping ratings of books on
+// their records. The review repository of the same database updates them
+// together with every review.
+type RatingSorter interface {
+	// GetBooksByRating returns a page of books ordered from the best rated
+	// ones, books rated equally by the amount of reviews and then by ID.
+	// Unrated books come last. Limit 0 returns all books from offset.
+	GetBooksByRating(offset, limit int) ([]*models.Book, error)
+}
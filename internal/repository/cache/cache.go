@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"golang.org/x/sync/singleflight"
+	"sync/atomic"
+)
+
+const allBooksKey = "books:all"
+
+// Repo is a read-through cache in front of another BookRepo. Concurrent
+// misses for the same key are collapsed into a single backend call and
+// every write invalidates the entries it could have changed.
+type Repo struct {
+	base   repository.BookRepo
+	cache  Cache
+	prefix string
+	state  *state
+}
+
+// state is shared between the Repo and its tenant scoped copies.
+type state struct {
+	group singleflight.Group
+
+	// generation is bumped on every write, a load started before a write
+	// must not populate the cache with data read before that write
+	generation atomic.Uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+var _ repository.BookRepo = (*Repo)(nil)
+
+func New(base repository.BookRepo, cache Cache) *Repo {
+	return &Repo{
+		base:  base,
+		cache: cache,
+		state: &state{},
+	}
+}
+
+// ForTenant returns a Repo caching books of the tenant under its own keys.
+// The underlying repository has to support tenants as well.
+func (r *Repo) ForTenant(tenant string) (repository.BookRepo, error) {
+	tenantRepos, ok := r.base.(repository.TenantBookRepos)
+	if !ok {
+		return nil, fmt.Errorf("cached repository does not support tenants")
+	}
+
+	base, err := tenantRepos.ForTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repo{
+		base:   base,
+		cache:  r.cache,
+		prefix: tenant + "/",
+		state:  r.state,
+	}, nil
+}
+
+func (r *Repo) GetAllBooks() ([]*models.Book, error) {
+	var books []*models.Book
+	err := r.readThrough(r.prefix+allBooksKey, &books, func() (any, error) {
+		return r.base.GetAllBooks()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+func (r *Repo) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return r.base.GetBooksBatch(offset, limit)
+}
+
+func (r *Repo) GetBook(id string) (*models.Book, error) {
+	var book *models.Book
+	err := r.readThrough(r.bookKey(id), &book, func() (any, error) {
+		return r.base.GetBook(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// GetBooksByIDs serves cached books and loads the missing ones from the
+// base repository with a single call.
+func (r *Repo) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	books := make([]*models.Book, 0, len(ids))
+	var missing []string
+	for _, id := range ids {
+		var book *models.Book
+		if data, ok := r.cache.Get(r.bookKey(id)); ok && json.Unmarshal(data, &book) == nil {
+			books = append(books, book)
+			continue
+		}
+		missing = append(missing, id)
+	}
+
+	r.state.hits.Add(int64(len(books)))
+	r.state.misses.Add(int64(len(missing)))
+	if len(missing) == 0 {
+		return books, nil
+	}
+
+	generation := r.state.generation.Load()
+	loaded, err := r.base.GetBooksByIDs(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.state.generation.Load() == generation {
+		for _, book := range loaded {
+			if data, err := json.Marshal(book); err == nil {
+				r.cache.Set(r.bookKey(book.ID), data)
+			}
+		}
+	}
+
+	return append(books, loaded...), nil
+}
+
+func (r *Repo) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return r.base.SearchBooks(phrase, offset, limit)
+}
+
+// CountAuthors is not cached, the base repository has to count authors.
+func (r *Repo) CountAuthors(ids []string) ([]*models.FacetCount, error) {
+	counter, ok := r.base.(repository.AuthorCounter)
+	if !ok {
+		return nil, fmt.Errorf("cached repository does not count authors")
+	}
+	return counter.CountAuthors(ids)
+}
+
+// GetBooksByRating is not cached, ratings change with reviews which do not
+// invalidate cached books.
+func (r *Repo) GetBooksByRating(offset, limit int) ([]*models.Book, error) {
+	sorter, ok := r.base.(repository.RatingSorter)
+	if !ok {
+		return nil, repository.ErrRatingSortUnsupported
+	}
+	return sorter.GetBooksByRating(offset, limit)
+}
+
+func (r *Repo) AddBook(b *models.Book) (*models.Book, error) {
+	defer r.invalidate(r.prefix + allBooksKey)
+	return r.base.AddBook(b)
+}
+
+func (r *Repo) UpdateBook(id string, updatedBook *models.Book) error {
+	defer r.invalidate(r.bookKey(id), r.prefix+allBooksKey)
+	return r.base.UpdateBook(id, updatedBook)
+}
+
+func (r *Repo) DeleteBook(id string) error {
+	defer r.invalidate(r.bookKey(id), r.prefix+allBooksKey)
+	return r.base.DeleteBook(id)
+}
+
+// DeleteAllBooks purges the whole cache, including entries of other
+// tenants, as keys of a single tenant cannot be listed.
+func (r *Repo) DeleteAllBooks() error {
+	defer func() {
+		r.state.generation.Add(1)
+		r.cache.Purge()
+	}()
+	return r.base.DeleteAllBooks()
+}
+
+func (r *Repo) Stats() Stats {
+	return Stats{
+		Hits:   r.state.hits.Load(),
+		Misses: r.state.misses.Load(),
+	}
+}
+
+// readThrough decodes the cached value of key into dst, loading and
+// caching it first when missing.
+func (r *Repo) readThrough(key string, dst any, load func() (any, error)) error {
+	if data, ok := r.cache.Get(key); ok {
+		r.state.hits.Add(1)
+		return json.Unmarshal(data, dst)
+	}
+	r.state.misses.Add(1)
+
+	data, err, _ := r.state.group.Do(key, func() (any, error) {
+		generation := r.state.generation.Load()
+
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.state.generation.Load() == generation {
+			r.cache.Set(key, data)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data.([]byte), dst)
+}
+
+func (r *Repo) invalidate(keys ...string) {
+	r.state.generation.Add(1)
+	for _, key := range keys {
+		r.cache.Delete(key)
+	}
+}
+
+func (r *Repo) bookKey(id string) string {
+	return r.prefix + "book:" + id
+}
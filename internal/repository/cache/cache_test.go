@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Cache_GetBook_ShouldServeRepeatedReadsFromCache(t *testing.T) {
+	// setup
+	base := newRepoStub()
+	ts := New(base, NewLRU(10, time.Minute))
+
+	// when
+	for i := 0; i < 3; i++ {
+		book, err := ts.GetBook("1")
+		if err != nil {
+			t.Fatal("Encountered error while getting book:", err)
+		}
+		if book.Name != "Book1" {
+			t.Fatalf("Returned wrong book: %+v\n", book)
+		}
+	}
+
+	// then
+	if calls := base.getBookCalls.Load(); calls != 1 {
+		t.Fatalf("Base repository should be called once, was called %d times\n", calls)
+	}
+
+	if stats := ts.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("Unexpected cache stats: %+v\n", stats)
+	}
+}
+
+func Test_Cache_GetBooksByIDs_ShouldLoadOnlyMissingBooks(t *testing.T) {
+	// setup
+	base := newRepoStub()
+	base.books["2"] = &models.Book{ID: "2", Name: "Book2", Author: "Author2"}
+	ts := New(base, NewLRU(10, time.Minute))
+
+	// given
+	if _, err := ts.GetBook("1"); err != nil {
+		t.Fatal("Encountered error while getting book:", err)
+	}
+
+	// when
+	books, err := ts.GetBooksByIDs([]string{"1", "2", "3"})
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while getting books:", err)
+	}
+
+	if summary := booksSummary(books); summary != "1:Book1,2:Book2" {
+		t.Fatalf("Returned wrong books: %s\n", summary)
+	}
+
+	if calls := base.getBooksByIDsCalls.Load(); calls != 1 {
+		t.Fatalf("Missing books should be loaded with one call, base was called %d times\n", calls)
+	}
+
+	if _, err = ts.GetBooksByIDs([]string{"1", "2"}); err != nil {
+		t.Fatal("Encountered error while getting books:", err)
+	}
+	if calls := base.getBooksByIDsCalls.Load(); calls != 1 {
+		t.Fatalf("Loaded books should be cached, base was called %d times\n", calls)
+	}
+}
+
+func Test_Cache_ShouldInvalidateOnWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(r *Repo) error
+	}{
+		{"AddBook", func(r *Repo) error {
+			_, err := r.AddBook(&models.Book{Name: "Book2", Author: "Author2"})
+			return err
+		}},
+		{"UpdateBook", func(r *Repo) error {
+			return r.UpdateBook("1", &models.Book{Name: "Updated", Author: "Author1"})
+		}},
+		{"DeleteBook", func(r *Repo) error { return r.DeleteBook("1") }},
+		{"DeleteAllBooks", func(r *Repo) error { return r.DeleteAllBooks() }},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			base := newRepoStub()
+			ts := New(base, NewLRU(10, time.Minute))
+
+			// given
+			before, _ := ts.GetAllBooks()
+
+			// when
+			if err := tt.write(ts); err != nil {
+				t.Fatal("Encountered error while writing:", err)
+			}
+
+			// then
+			after, _ := ts.GetAllBooks()
+			if calls := base.getAllBooksCalls.Load(); calls != 2 {
+				t.Fatalf("List should be reloaded after write, base was called %d times\n", calls)
+			}
+
+			if booksSummary(before) == booksSummary(after) {
+				t.Fatalf("Returned list was not refreshed: %v\n", after)
+			}
+		})
+	}
+}
+
+func Test_Cache_ShouldDeduplicateConcurrentMisses(t *testing.T) {
+	// setup
+	base := newRepoStub()
+	base.delay = 50 * time.Millisecond
+	ts := New(base, NewLRU(10, time.Minute))
+
+	// when
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = ts.GetBook("1")
+		}()
+	}
+	wg.Wait()
+
+	// then
+	if calls := base.getBookCalls.Load(); calls != 1 {
+		t.Fatalf("Concurrent misses should call base repository once, was called %d times\n", calls)
+	}
+}
+
+func Test_Cache_ForTenant_ShouldKeepTenantsApart(t *testing.T) {
+	// setup
+	base := &tenantReposStub{repos: map[string]*repoStub{
+		"acme":   newRepoStub(),
+		"globex": {books: map[string]*models.Book{"1": {ID: "1", Name: "GlobexBook", Author: "Author"}}},
+	}}
+	ts := New(base, NewLRU(10, time.Minute))
+
+	acme, err := ts.ForTenant("acme")
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while scoping cache to tenant: %s\n", err)
+	}
+	globex, err := ts.ForTenant("globex")
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while scoping cache to tenant: %s\n", err)
+	}
+
+	// given
+	_, _ = acme.GetBook("1")
+
+	// when
+	book, err := globex.GetBook("1")
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while getting book:", err)
+	}
+
+	if book.Name != "GlobexBook" {
+		t.Fatalf("Tenant received book cached for another tenant: %+v\n", book)
+	}
+}
+
+// utils
+
+type tenantReposStub struct {
+	repoStub
+	repos map[string]*repoStub
+}
+
+func (r *tenantReposStub) ForTenant(tenant string) (repository.BookRepo, error) {
+	repo, ok := r.repos[tenant]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant %q", tenant)
+	}
+	return repo, nil
+}
+
+func booksSummary(books []*models.Book) string {
+	summary := make([]string, 0, len(books))
+	for _, b := range books {
+		summary = append(summary, b.ID+":"+b.Name)
+	}
+	sort.Strings(summary)
+	return strings.Join(summary, ",")
+}
+
+type repoStub struct {
+	mu    sync.Mutex
+	books map[string]*models.Book
+	delay time.Duration
+
+	getBookCalls       atomic.Int64
+	getAllBooksCalls   atomic.Int64
+	getBooksByIDsCalls atomic.Int64
+}
+
+func newRepoStub() *repoStub {
+	return &repoStub{books: map[string]*models.Book{
+		"1": {ID: "1", Name: "Book1", Author: "Author1"},
+	}}
+}
+
+func (r *repoStub) GetAllBooks() ([]*models.Book, error) {
+	r.getAllBooksCalls.Add(1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	books := []*models.Book{}
+	for _, b := range r.books {
+		books = append(books, &models.Book{ID: b.ID, Name: b.Name, Author: b.Author})
+	}
+	return books, nil
+}
+
+func (r *repoStub) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return r.GetAllBooks()
+}
+
+func (r *repoStub) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	r.getBooksByIDsCalls.Add(1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	books := []*models.Book{}
+	for _, id := range ids {
+		if b, ok := r.books[id]; ok {
+			books = append(books, &models.Book{ID: b.ID, Name: b.Name, Author: b.Author})
+		}
+	}
+	return books, nil
+}
+
+func (r *repoStub) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) GetBook(id string) (*models.Book, error) {
+	r.getBookCalls.Add(1)
+	time.Sleep(r.delay)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.books[id]
+	if !ok {
+		return nil, fmt.Errorf("book (id=%s) not found", id)
+	}
+	return &models.Book{ID: b.ID, Name: b.Name, Author: b.Author}, nil
+}
+
+func (r *repoStub) AddBook(b *models.Book) (*models.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	created := &models.Book{ID: fmt.Sprint(len(r.books) + 1), Name: b.Name, Author: b.Author}
+	r.books[created.ID] = created
+	return created, nil
+}
+
+func (r *repoStub) UpdateBook(id string, updatedBook *models.Book) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.books[id] = &models.Book{ID: id, Name: updatedBook.Name, Author: updatedBook.Author}
+	return nil
+}
+
+func (r *repoStub) DeleteBook(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.books, id)
+	return nil
+}
+
+func (r *repoStub) DeleteAllBooks() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.books = make(map[string]*models.Book)
+	return nil
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_LRU_ShouldEvictLeastRecentlyUsedEntry(t *testing.T) {
+	// setup
+	ts := NewLRU(2, time.Minute)
+
+	// given
+	ts.Set("a", []byte("1"))
+	ts.Set("b", []byte("2"))
+	_, _ = ts.Get("a")
+
+	// when
+	ts.Set("c", []byte("3"))
+
+	// then
+	if _, ok := ts.Get("b"); ok {
+		t.Error("Least recently used entry should be evicted")
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if _, ok := ts.Get(key); !ok {
+			t.Errorf("Entry %q should still be cached\n", key)
+		}
+	}
+}
+
+func Test_LRU_ShouldExpireEntriesAfterTTL(t *testing.T) {
+	// setup
+	now := time.Now()
+	ts := NewLRU(10, time.Minute)
+	ts.now = func() time.Time { return now }
+
+	// given
+	ts.Set("a", []byte("1"))
+
+	// when
+	now = now.Add(2 * time.Minute)
+
+	// then
+	if _, ok := ts.Get("a"); ok {
+		t.Error("Expired entry should not be returned")
+	}
+
+	if ts.Len() != 0 {
+		t.Errorf("Expired entry should be removed, cache still has %d entries\n", ts.Len())
+	}
+}
@@ -0,0 +1,877 @@
+package circulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoDBRepo marks copies on loan with the ID of their active loan in
+// loan_id field, checkouts claim the copy by setting it atomically. Copies
+// set aside for a ready hold have hold_id and hold_member_id fields, and
+// active holds have active field, which makes them unique per member and
+// book.
+type MongoDBRepo struct {
+	copies  *mongo.Collection
+	members *mongo.Collection
+	loans   *mongo.Collection
+	holds   *mongo.Collection
+	reviews *mongo.Collection
+	shelves *mongo.Collection
+}
+
+const (
+	mongoCopiesCollectionName  = "copies"
+	mongoMembersCollectionName = "members"
+	mongoLoansCollectionName   = "loans"
+	mongoHoldsCollectionName   = "holds"
+	mongoReviewsCollectionName = "reviews"
+	mongoShelvesCollectionName = "shelves"
+)
+
+// copyState is the part of copy documents telling whether it is free.
+type copyState struct {
+	BookID string `bson:"book_id"`
+	LoanID string `bson:"loan_id"`
+	HoldID string `bson:"hold_id"`
+}
+
+var releaseHold = bson.D{{Key: "$unset", Value: bson.D{{Key: "hold_id", Value: ""}, {Key: "hold_member_id", Value: ""}}}}
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		copies:  db.Collection(mongoCopiesCollectionName),
+		members: db.Collection(mongoMembersCollectionName),
+		loans:   db.Collection(mongoLoansCollectionName),
+		holds:   db.Collection(mongoHoldsCollectionName),
+		reviews: db.Collection(mongoReviewsCollectionName),
+		shelves: db.Collection(mongoShelvesCollectionName),
+	}
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.copies.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "barcode", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "book_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.members.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.loans.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "member_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "copy_id", Value: 1}}},
+		{Keys: bson.D{{Key: "returned_at", Value: 1}, {Key: "due_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.holds.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "book_id", Value: 1}, {Key: "member_id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "active", Value: true}}),
+		},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "book_id", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "member_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+	})
+	return err
+}
+
+func (r *MongoDBRepo) GetCopies(tenant, bookID string) ([]*models.Copy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.copies.Find(ctx, bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bookID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	copies := []*models.Copy{}
+	if err = cursor.All(ctx, &copies); err != nil {
+		return nil, err
+	}
+
+	return copies, nil
+}
+
+func (r *MongoDBRepo) GetCopy(tenant, id string) (*models.Copy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(tenant, id, repository.ErrCopyNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *models.Copy
+	err = r.copies.FindOne(ctx, filter).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrCopyNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (r *MongoDBRepo) AddCopy(c *models.Copy) (*models.Copy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	created := *c
+	created.ID = ""
+	created.CreatedAt = time.Now().UTC()
+
+	result, err := r.copies.InsertOne(ctx, &created)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("%w (barcode=%s)", repository.ErrBarcodeTaken, c.Barcode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		created.ID = oid.Hex()
+	}
+
+	return &created, nil
+}
+
+func (r *MongoDBRepo) UpdateCopy(c *models.Copy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(c.Tenant, c.ID, repository.ErrCopyNotFound)
+	if err != nil {
+		return err
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "barcode", Value: c.Barcode},
+		{Key: "condition", Value: c.Condition},
+		{Key: "location", Value: c.Location},
+	}}}
+
+	result, err := r.copies.UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w (barcode=%s)", repository.ErrBarcodeTaken, c.Barcode)
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCopyNotFound, c.ID)
+	}
+
+	return nil
+}
+
+// DeleteCopy removes the copy only when it is not claimed by a loan or a
+// hold.
+func (r *MongoDBRepo) DeleteCopy(tenant, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(tenant, id, repository.ErrCopyNotFound)
+	if err != nil {
+		return err
+	}
+
+	free := append(filter, bson.E{Key: "loan_id", Value: nil}, bson.E{Key: "hold_id", Value: nil})
+	result, err := r.copies.DeleteOne(ctx, free)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return r.copyUnavailable(ctx, filter, id)
+	}
+
+	_, err = r.loans.DeleteMany(ctx, bson.D{{Key: "copy_id", Value: id}})
+	return err
+}
+
+func (r *MongoDBRepo) GetAvailability(tenant, bookID string) (*models.Availability, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bookID}}
+	total, err := r.copies.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	free := append(filter, bson.E{Key: "loan_id", Value: nil}, bson.E{Key: "hold_id", Value: nil})
+	available, err := r.copies.CountDocuments(ctx, free)
+	if err != nil {
+		return nil, err
+	}
+
+	holds, err := r.holds.CountDocuments(ctx, append(filter, bson.E{Key: "status", Value: models.HoldWaiting}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Availability{Copies: int(total), Available: int(available), Holds: int(holds)}, nil
+}
+
+func (r *MongoDBRepo) GetMembers(tenant string) ([]*models.Member, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.members.Find(ctx, bson.D{{Key: "tenant", Value: tenant}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []*models.Member{}
+	if err = cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (r *MongoDBRepo) GetMember(tenant, id string) (*models.Member, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(tenant, id, repository.ErrMemberNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *models.Member
+	err = r.members.FindOne(ctx, filter).Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (r *MongoDBRepo) AddMember(m *models.Member) (*models.Member, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	created := *m
+	created.ID = ""
+	created.CreatedAt = time.Now().UTC()
+
+	result, err := r.members.InsertOne(ctx, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		created.ID = oid.Hex()
+	}
+
+	return &created, nil
+}
+
+func (r *MongoDBRepo) DeleteMember(tenant, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(tenant, id, repository.ErrMemberNotFound)
+	if err != nil {
+		return err
+	}
+
+	active, err := r.loans.CountDocuments(ctx, bson.D{
+		{Key: "tenant", Value: tenant},
+		{Key: "member_id", Value: id},
+		{Key: "returned_at", Value: nil},
+	})
+	if err != nil {
+		return err
+	}
+	if active > 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberHasLoans, id)
+	}
+
+	result, err := r.members.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+
+	if _, err = r.loans.DeleteMany(ctx, bson.D{{Key: "member_id", Value: id}}); err != nil {
+		return err
+	}
+	if _, err = r.holds.DeleteMany(ctx, bson.D{{Key: "member_id", Value: id}}); err != nil {
+		return err
+	}
+	if _, err = r.shelves.DeleteMany(ctx, bson.D{{Key: "tenant", Value: tenant}, {Key: "member_id", Value: id}}); err != nil {
+		return err
+	}
+
+	_, err = r.copies.UpdateMany(ctx, bson.D{{Key: "tenant", Value: tenant}, {Key: "hold_member_id", Value: id}}, releaseHold)
+	if err != nil {
+		return err
+	}
+
+	// reviews outlive their authors, they only lose the member
+	_, err = r.reviews.UpdateMany(ctx, bson.D{{Key: "tenant", Value: tenant}, {Key: "member_id", Value: id}},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "member_id", Value: ""}}}})
+	return err
+}
+
+func (r *MongoDBRepo) GetLoans(tenant string, filter repository.LoanFilter) ([]*models.Loan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.D{{Key: "tenant", Value: tenant}}
+	if filter.MemberID != "" {
+		query = append(query, bson.E{Key: "member_id", Value: filter.MemberID})
+	}
+	if filter.CopyID != "" {
+		query = append(query, bson.E{Key: "copy_id", Value: filter.CopyID})
+	}
+	if filter.ActiveOnly {
+		query = append(query, bson.E{Key: "returned_at", Value: nil})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := r.loans.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	loans := []*models.Loan{}
+	if err = cursor.All(ctx, &loans); err != nil {
+		return nil, err
+	}
+
+	return loans, nil
+}
+
+func (r *MongoDBRepo) GetDueLoans(before time.Time) ([]*models.Loan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.D{{Key: "returned_at", Value: nil}, {Key: "due_at", Value: bson.D{{Key: "$lt", Value: before}}}}
+	opts := options.Find().SetSort(bson.D{{Key: "due_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.loans.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	loans := []*models.Loan{}
+	if err = cursor.All(ctx, &loans); err != nil {
+		return nil, err
+	}
+
+	return loans, nil
+}
+
+func (r *MongoDBRepo) GetLoan(tenant, id string) (*models.Loan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(tenant, id, repository.ErrLoanNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var l *models.Loan
+	err = r.loans.FindOne(ctx, filter).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrLoanNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Checkout claims the copy by setting its loan_id only when it is unset
+// and the copy is not set aside for another member, so of concurrent
+// checkouts only one matches the copy. The claim is released when the loan
+// cannot be stored.
+func (r *MongoDBRepo) Checkout(l *models.Loan) (*models.Loan, error) {
+	if _, err := r.GetMember(l.Tenant, l.MemberID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(l.Tenant, l.CopyID, repository.ErrCopyNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	loanID := primitive.NewObjectID()
+	claimable := append(filter,
+		bson.E{Key: "loan_id", Value: nil},
+		bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "hold_member_id", Value: nil}},
+			bson.D{{Key: "hold_member_id", Value: l.MemberID}},
+		}},
+	)
+	claim := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "loan_id", Value: loanID.Hex()}}},
+		{Key: "$unset", Value: bson.D{{Key: "hold_id", Value: ""}, {Key: "hold_member_id", Value: ""}}},
+	}
+
+	var c copyState
+	err = r.copies.FindOneAndUpdate(ctx, claimable, claim).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.copyUnavailable(ctx, filter, l.CopyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created := *l
+	created.ID = loanID.Hex()
+	created.BookID = c.BookID
+	created.ReturnedAt = nil
+	created.Renewals = 0
+
+	_, err = r.loans.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: loanID},
+		{Key: "tenant", Value: created.Tenant},
+		{Key: "copy_id", Value: created.CopyID},
+		{Key: "book_id", Value: created.BookID},
+		{Key: "member_id", Value: created.MemberID},
+		{Key: "checked_out_at", Value: created.CheckedOutAt},
+		{Key: "due_at", Value: created.DueAt},
+		{Key: "returned_at", Value: nil},
+		{Key: "renewals", Value: 0},
+	})
+	if err != nil {
+		release := bson.D{{Key: "$unset", Value: bson.D{{Key: "loan_id", Value: ""}}}}
+		_, _ = r.copies.UpdateOne(ctx, append(filter, bson.E{Key: "loan_id", Value: loanID.Hex()}), release)
+		return nil, err
+	}
+
+	if err = r.collectHold(ctx, &created); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// collectHold closes the active hold of the borrower for the book of the
+// loan, freeing another copy set aside for it.
+func (r *MongoDBRepo) collectHold(ctx context.Context, l *models.Loan) error {
+	filter := bson.D{
+		{Key: "tenant", Value: l.Tenant},
+		{Key: "book_id", Value: l.BookID},
+		{Key: "member_id", Value: l.MemberID},
+		{Key: "active", Value: true},
+	}
+
+	var h *models.Hold
+	err := r.holds.FindOneAndUpdate(ctx, filter, closeHold(models.HoldCollected, l.CheckedOutAt)).Decode(&h)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if h.CopyID != "" && h.CopyID != l.CopyID {
+		return r.releaseCopy(ctx, h)
+	}
+	return nil
+}
+
+// Return closes the loan and then releases its copy, a copy of a loan
+// closed concurrently is released only once.
+func (r *MongoDBRepo) Return(tenant, id string, returnedAt time.Time) (*models.Loan, error) {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "returned_at", Value: returnedAt}}}}
+	l, err := r.updateActiveLoan(tenant, id, bson.D{}, update, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	copyFilter, err := tenantFilter(tenant, l.CopyID, repository.ErrCopyNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	release := bson.D{{Key: "$unset", Value: bson.D{{Key: "loan_id", Value: ""}}}}
+	if _, err = r.copies.UpdateOne(ctx, append(copyFilter, bson.E{Key: "loan_id", Value: id}), release); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (r *MongoDBRepo) Renew(tenant, id string, dueAt time.Time, maxRenewals int) (*models.Loan, error) {
+	condition := bson.D{{Key: "renewals", Value: bson.D{{Key: "$lt", Value: maxRenewals}}}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "due_at", Value: dueAt}}},
+		{Key: "$inc", Value: bson.D{{Key: "renewals", Value: 1}}},
+	}
+
+	return r.updateActiveLoan(tenant, id, condition, update, repository.ErrRenewalsReached)
+}
+
+// updateActiveLoan applies update to the loan when it is active and
+// matches condition. When the loan was not updated, it is looked up to
+// tell whether it is missing, returned, or rejected with errRejected.
+func (r *MongoDBRepo) updateActiveLoan(tenant, id string, condition, update bson.D, errRejected error) (*models.Loan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(tenant, id, repository.ErrLoanNotFound)
+	if err != nil {
+		return nil, err
+	}
+	filter = append(filter, bson.E{Key: "returned_at", Value: nil})
+	filter = append(filter, condition...)
+
+	var l *models.Loan
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.loans.FindOneAndUpdate(ctx, filter, update, opts).Decode(&l)
+	if err == nil {
+		return l, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	existing, err := r.GetLoan(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if !existing.Active() || errRejected == nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrLoanReturned, id)
+	}
+	return nil, fmt.Errorf("%w (id=%s)", errRejected, id)
+}
+
+// copyUnavailable tells why the copy matching filter was not claimed.
+func (r *MongoDBRepo) copyUnavailable(ctx context.Context, filter bson.D, id string) error {
+	var c copyState
+	err := r.copies.FindOne(ctx, filter).Decode(&c)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return fmt.Errorf("%w (id=%s)", repository.ErrCopyNotFound, id)
+	case err != nil:
+		return err
+	case c.LoanID != "":
+		return fmt.Errorf("%w (id=%s)", repository.ErrCopyOnLoan, id)
+	}
+	return fmt.Errorf("%w (id=%s)", repository.ErrCopyOnHold, id)
+}
+
+func (r *MongoDBRepo) PlaceHold(h *models.Hold) (*models.Hold, error) {
+	if _, err := r.GetMember(h.Tenant, h.MemberID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	created := *h
+	created.Status = models.HoldWaiting
+	created.CopyID = ""
+	created.ReadyAt, created.ExpiresAt, created.ClosedAt = nil, nil, nil
+
+	result, err := r.holds.InsertOne(ctx, bson.D{
+		{Key: "tenant", Value: created.Tenant},
+		{Key: "book_id", Value: created.BookID},
+		{Key: "member_id", Value: created.MemberID},
+		{Key: "status", Value: created.Status},
+		{Key: "active", Value: true},
+		{Key: "placed_at", Value: created.PlacedAt},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("%w (book_id=%s)", repository.ErrHoldExists, h.BookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		created.ID = oid.Hex()
+	}
+
+	return &created, nil
+}
+
+func (r *MongoDBRepo) GetHolds(tenant string, filter repository.HoldFilter) ([]*models.Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.D{{Key: "tenant", Value: tenant}}
+	if filter.BookID != "" {
+		query = append(query, bson.E{Key: "book_id", Value: filter.BookID})
+	}
+	if filter.MemberID != "" {
+		query = append(query, bson.E{Key: "member_id", Value: filter.MemberID})
+	}
+	if filter.ActiveOnly {
+		query = append(query, bson.E{Key: "active", Value: true})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.holds.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	holds := []*models.Hold{}
+	if err = cursor.All(ctx, &holds); err != nil {
+		return nil, err
+	}
+
+	for _, h := range holds {
+		if err = r.setPosition(ctx, h); err != nil {
+			return nil, err
+		}
+	}
+	return holds, nil
+}
+
+func (r *MongoDBRepo) GetHold(tenant, id string) (*models.Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(tenant, id, repository.ErrHoldNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var h *models.Hold
+	err = r.holds.FindOne(ctx, filter).Decode(&h)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrHoldNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return h, r.setPosition(ctx, h)
+}
+
+func (r *MongoDBRepo) CancelHold(tenant, id string, cancelledAt time.Time) (*models.Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := tenantFilter(tenant, id, repository.ErrHoldNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var h *models.Hold
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = r.holds.FindOneAndUpdate(ctx, append(filter, bson.E{Key: "active", Value: true}), closeHold(models.HoldCancelled, cancelledAt), opts).Decode(&h)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err = r.GetHold(tenant, id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrHoldClosed, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return h, r.releaseCopy(ctx, h)
+}
+
+// PromoteHolds claims a free copy for the oldest waiting hold, one at a
+// time. When the hold was closed meanwhile, its copy is released and the
+// next hold is tried.
+func (r *MongoDBRepo) PromoteHolds(tenant, bookID string, now time.Time, pickup time.Duration) ([]*models.Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	waitingFilter := bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bookID}, {Key: "status", Value: models.HoldWaiting}}
+	freeFilter := bson.D{
+		{Key: "tenant", Value: tenant},
+		{Key: "book_id", Value: bookID},
+		{Key: "loan_id", Value: nil},
+		{Key: "hold_id", Value: nil},
+	}
+	oldest := options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	promoted := []*models.Hold{}
+	for {
+		var waiting *models.Hold
+		err := r.holds.FindOne(ctx, waitingFilter, oldest).Decode(&waiting)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return promoted, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		claim := bson.D{{Key: "$set", Value: bson.D{{Key: "hold_id", Value: waiting.ID}, {Key: "hold_member_id", Value: waiting.MemberID}}}}
+		claimOpts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "_id", Value: 1}})
+		var c *models.Copy
+		err = r.copies.FindOneAndUpdate(ctx, freeFilter, claim, claimOpts).Decode(&c)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return promoted, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		holdFilter, err := tenantFilter(tenant, waiting.ID, repository.ErrHoldNotFound)
+		if err != nil {
+			return nil, err
+		}
+		ready := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: models.HoldReady},
+			{Key: "copy_id", Value: c.ID},
+			{Key: "ready_at", Value: now},
+			{Key: "expires_at", Value: now.Add(pickup)},
+		}}}
+
+		var h *models.Hold
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = r.holds.FindOneAndUpdate(ctx, append(holdFilter, bson.E{Key: "status", Value: models.HoldWaiting}), ready, opts).Decode(&h)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if err = r.releaseCopy(ctx, &models.Hold{ID: waiting.ID, Tenant: tenant, CopyID: c.ID}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, h)
+	}
+}
+
+func (r *MongoDBRepo) ExpireHolds(now time.Time) ([]*models.Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "status", Value: models.HoldReady}, {Key: "expires_at", Value: bson.D{{Key: "$lt", Value: now}}}}
+	cursor, err := r.holds.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []*models.Hold{}
+	if err = cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+
+	expired := []*models.Hold{}
+	for _, candidate := range candidates {
+		holdFilter, err := tenantFilter(candidate.Tenant, candidate.ID, repository.ErrHoldNotFound)
+		if err != nil {
+			return nil, err
+		}
+
+		var h *models.Hold
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = r.holds.FindOneAndUpdate(ctx, append(holdFilter, filter...), closeHold(models.HoldExpired, now), opts).Decode(&h)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err = r.releaseCopy(ctx, h); err != nil {
+			return nil, err
+		}
+		expired = append(expired, h)
+	}
+
+	return expired, nil
+}
+
+// setPosition counts waiting holds of the book up to the waiting hold h.
+func (r *MongoDBRepo) setPosition(ctx context.Context, h *models.Hold) error {
+	if h.Status != models.HoldWaiting {
+		return nil
+	}
+
+	objID, err := primitive.ObjectIDFromHex(h.ID)
+	if err != nil {
+		return err
+	}
+
+	count, err := r.holds.CountDocuments(ctx, bson.D{
+		{Key: "tenant", Value: h.Tenant},
+		{Key: "book_id", Value: h.BookID},
+		{Key: "status", Value: models.HoldWaiting},
+		{Key: "_id", Value: bson.D{{Key: "$lte", Value: objID}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	h.Position = int(count)
+	return nil
+}
+
+// releaseCopy frees the copy set aside for the hold h, unless it was
+// already taken by a checkout.
+func (r *MongoDBRepo) releaseCopy(ctx context.Context, h *models.Hold) error {
+	if h.CopyID == "" {
+		return nil
+	}
+
+	filter, err := tenantFilter(h.Tenant, h.CopyID, repository.ErrCopyNotFound)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.copies.UpdateOne(ctx, append(filter, bson.E{Key: "hold_id", Value: h.ID}), releaseHold)
+	return err
+}
+
+// closeHold sets the final status of an active hold.
+func closeHold(status string, at time.Time) bson.D {
+	return bson.D{
+		{Key: "$set", Value: bson.D{{Key: "status", Value: status}, {Key: "closed_at", Value: at}}},
+		{Key: "$unset", Value: bson.D{{Key: "active", Value: ""}}},
+	}
+}
+
+// tenantFilter matches the record within its tenant, IDs which are not
+// ObjectIDs cannot exist.
+func tenantFilter(tenant, id string, errNotFound error) (bson.D, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w (id=%s)", errNotFound, id)
+	}
+
+	return bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: tenant}}, nil
+}
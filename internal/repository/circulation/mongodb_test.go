@@ -0,0 +1,170 @@
+package circulation
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+	"time"
+)
+
+func Test_MongoDB_Checkout(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	memberID, copyID := primitive.NewObjectID(), primitive.NewObjectID()
+	member := mtest.CreateCursorResponse(0, "db.members", mtest.FirstBatch, bson.D{
+		{Key: "_id", Value: memberID},
+		{Key: "tenant", Value: "acme"},
+		{Key: "name", Value: "Member1"},
+	})
+
+	mt.Run("Should claim the copy and store the loan", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{copies: mt.Coll, members: mt.Coll, loans: mt.Coll, holds: mt.Coll}
+
+		mt.AddMockResponses(
+			member,
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{Key: "_id", Value: copyID},
+				{Key: "tenant", Value: "acme"},
+				{Key: "book_id", Value: "42"},
+			}}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+		)
+
+		// when
+		l, err := ts.Checkout(&models.Loan{Tenant: "acme", CopyID: copyID.Hex(), MemberID: memberID.Hex(), DueAt: time.Now()})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while checking out copy:", err)
+		}
+
+		if l.ID == "" || l.BookID != "42" || !l.Active() {
+			t.Fatalf("Created loan is different than expected: %+v\n", l)
+		}
+	})
+
+	mt.Run("Should reject copy claimed by another loan", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{copies: mt.Coll, members: mt.Coll, loans: mt.Coll, holds: mt.Coll}
+
+		mt.AddMockResponses(
+			member,
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, "db.copies", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: copyID},
+				{Key: "loan_id", Value: primitive.NewObjectID().Hex()},
+			}),
+		)
+
+		// when
+		_, err := ts.Checkout(&models.Loan{Tenant: "acme", CopyID: copyID.Hex(), MemberID: memberID.Hex()})
+
+		// then
+		if !errors.Is(err, repository.ErrCopyOnLoan) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrCopyOnLoan, err)
+		}
+	})
+
+	mt.Run("Should reject copy set aside for another member", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{copies: mt.Coll, members: mt.Coll, loans: mt.Coll, holds: mt.Coll}
+
+		mt.AddMockResponses(
+			member,
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, "db.copies", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: copyID},
+				{Key: "hold_id", Value: primitive.NewObjectID().Hex()},
+			}),
+		)
+
+		// when
+		_, err := ts.Checkout(&models.Loan{Tenant: "acme", CopyID: copyID.Hex(), MemberID: memberID.Hex()})
+
+		// then
+		if !errors.Is(err, repository.ErrCopyOnHold) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrCopyOnHold, err)
+		}
+	})
+
+	mt.Run("Should return not found for invalid copy id", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{copies: mt.Coll, members: mt.Coll, loans: mt.Coll, holds: mt.Coll}
+
+		mt.AddMockResponses(member)
+
+		// when
+		_, err := ts.Checkout(&models.Loan{Tenant: "acme", CopyID: "7", MemberID: memberID.Hex()})
+
+		// then
+		if !errors.Is(err, repository.ErrCopyNotFound) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrCopyNotFound, err)
+		}
+	})
+}
+
+func Test_MongoDB_Return_ShouldRejectReturnedLoan(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should return error of returned loan", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{copies: mt.Coll, members: mt.Coll, loans: mt.Coll, holds: mt.Coll}
+
+		loanID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, "db.loans", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: loanID},
+				{Key: "tenant", Value: "acme"},
+				{Key: "returned_at", Value: time.Now()},
+			}),
+		)
+
+		// when
+		_, err := ts.Return("acme", loanID.Hex(), time.Now())
+
+		// then
+		if !errors.Is(err, repository.ErrLoanReturned) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrLoanReturned, err)
+		}
+	})
+}
+
+func Test_MongoDB_CancelHold_ShouldRejectClosedHold(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should return error of closed hold", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{copies: mt.Coll, members: mt.Coll, loans: mt.Coll, holds: mt.Coll}
+
+		holdID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, "db.holds", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: holdID},
+				{Key: "tenant", Value: "acme"},
+				{Key: "status", Value: models.HoldCollected},
+			}),
+		)
+
+		// when
+		_, err := ts.CancelHold("acme", holdID.Hex(), time.Now())
+
+		// then
+		if !errors.Is(err, repository.ErrHoldClosed) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrHoldClosed, err)
+		}
+	})
+}
@@ -0,0 +1,836 @@
+package circulation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strings"
+	"time"
+)
+
+type PostgreSQLRepo struct {
+	DB *sql.DB
+}
+
+const (
+	postgresDBTimeout       = time.Second * 3
+	postgresUniqueViolation = "23505"
+)
+
+const loanColumns = `id, tenant, copy_id, book_id, member_id, checked_out_at, due_at, returned_at, renewals`
+
+// holdColumns are selected from holds aliased as h, holdPosition counts
+// waiting holds of the book up to the selected one.
+const (
+	holdColumns  = `h.id, h.tenant, h.book_id, h.member_id, h.status, h.copy_id, h.placed_at, h.ready_at, h.expires_at, h.closed_at`
+	holdPosition = `CASE WHEN h.status = 'waiting' THEN (SELECT count(*) FROM holds w WHERE w.tenant = h.tenant AND w.book_id = h.book_id AND w.status = 'waiting' AND w.id <= h.id) ELSE 0 END`
+)
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{
+		DB: db,
+	}
+}
+
+func (r *PostgreSQLRepo) GetCopies(tenant, bookID string) ([]*models.Copy, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, tenant, book_id, barcode, condition, location, created_at
+		FROM copies
+		WHERE tenant = $1 AND book_id = $2
+		ORDER BY id;
+	`
+
+	rows, err := r.DB.QueryContext(ctx, query, tenant, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	copies := []*models.Copy{}
+	for rows.Next() {
+		c, err := scanCopy(rows)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, c)
+	}
+
+	return copies, rows.Err()
+}
+
+func (r *PostgreSQLRepo) GetCopy(tenant, id string) (*models.Copy, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, tenant, book_id, barcode, condition, location, created_at
+		FROM copies
+		WHERE tenant = $1 AND id = $2::bigint;
+	`
+
+	copyID, err := repository.ParseID(id, repository.ErrCopyNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := scanCopy(r.DB.QueryRowContext(ctx, query, tenant, copyID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrCopyNotFound, id)
+	}
+	return c, err
+}
+
+func (r *PostgreSQLRepo) AddCopy(c *models.Copy) (*models.Copy, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO copies (tenant, book_id, barcode, condition, location, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id;
+	`
+
+	created := *c
+	created.CreatedAt = time.Now().UTC()
+
+	var newId int
+	err := r.DB.QueryRowContext(ctx, query, c.Tenant, c.BookID, c.Barcode, c.Condition, c.Location, created.CreatedAt).Scan(&newId)
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w (barcode=%s)", repository.ErrBarcodeTaken, c.Barcode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created.ID = fmt.Sprintf("%d", newId)
+	return &created, nil
+}
+
+func (r *PostgreSQLRepo) UpdateCopy(c *models.Copy) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		UPDATE copies
+		SET barcode = $3, condition = $4, location = $5
+		WHERE tenant = $1 AND id = $2::bigint;
+	`
+
+	copyID, err := repository.ParseID(c.ID, repository.ErrCopyNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, query, c.Tenant, copyID, c.Barcode, c.Condition, c.Location)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("%w (barcode=%s)", repository.ErrBarcodeTaken, c.Barcode)
+	}
+	if err != nil {
+		return err
+	}
+
+	return notFoundUnlessAffected(res, repository.ErrCopyNotFound, c.ID)
+}
+
+// DeleteCopy locks the copy, so it cannot be checked out between the
+// check of its loans and its removal.
+func (r *PostgreSQLRepo) DeleteCopy(tenant, id string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		copyID, _, err := lockCopy(ctx, tx, tenant, id)
+		if err != nil {
+			return err
+		}
+
+		var onLoan bool
+		query := `SELECT EXISTS (SELECT 1 FROM loans WHERE copy_id = $1 AND returned_at IS NULL);`
+		if err = tx.QueryRowContext(ctx, query, copyID).Scan(&onLoan); err != nil {
+			return err
+		}
+		if onLoan {
+			return fmt.Errorf("%w (id=%s)", repository.ErrCopyOnLoan, id)
+		}
+
+		var onHold bool
+		query = `SELECT EXISTS (SELECT 1 FROM holds WHERE copy_id = $1 AND status = 'ready');`
+		if err = tx.QueryRowContext(ctx, query, copyID).Scan(&onHold); err != nil {
+			return err
+		}
+		if onHold {
+			return fmt.Errorf("%w (id=%s)", repository.ErrCopyOnHold, id)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM copies WHERE id = $1;`, copyID)
+		return err
+	})
+}
+
+func (r *PostgreSQLRepo) GetAvailability(tenant, bookID string) (*models.Availability, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT count(c.id), count(c.id) FILTER (WHERE l.id IS NULL AND h.id IS NULL),
+			(SELECT count(*) FROM holds w WHERE w.tenant = $1 AND w.book_id = $2 AND w.status = 'waiting')
+		FROM copies c
+		LEFT JOIN loans l ON l.copy_id = c.id AND l.returned_at IS NULL
+		LEFT JOIN holds h ON h.copy_id = c.id AND h.status = 'ready'
+		WHERE c.tenant = $1 AND c.book_id = $2;
+	`
+
+	var a models.Availability
+	if err := r.DB.QueryRowContext(ctx, query, tenant, bookID).Scan(&a.Copies, &a.Available, &a.Holds); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (r *PostgreSQLRepo) GetMembers(tenant string) ([]*models.Member, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, tenant, name, email, created_at
+		FROM members
+		WHERE tenant = $1
+		ORDER BY id;
+	`
+
+	rows, err := r.DB.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*models.Member{}
+	for rows.Next() {
+		var m models.Member
+		if err := rows.Scan(&m.ID, &m.Tenant, &m.Name, &m.Email, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, &m)
+	}
+
+	return members, rows.Err()
+}
+
+func (r *PostgreSQLRepo) GetMember(tenant, id string) (*models.Member, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, tenant, name, email, created_at
+		FROM members
+		WHERE tenant = $1 AND id = $2::bigint;
+	`
+
+	memberID, err := repository.ParseID(id, repository.ErrMemberNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var m models.Member
+	err = r.DB.QueryRowContext(ctx, query, tenant, memberID).Scan(&m.ID, &m.Tenant, &m.Name, &m.Email, &m.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *PostgreSQLRepo) AddMember(m *models.Member) (*models.Member, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO members (tenant, name, email, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;
+	`
+
+	created := *m
+	created.CreatedAt = time.Now().UTC()
+
+	var newId int
+	if err := r.DB.QueryRowContext(ctx, query, m.Tenant, m.Name, m.Email, created.CreatedAt).Scan(&newId); err != nil {
+		return nil, err
+	}
+
+	created.ID = fmt.Sprintf("%d", newId)
+	return &created, nil
+}
+
+// DeleteMember locks the member, so nothing is checked out to it between
+// the check of its loans and its removal.
+func (r *PostgreSQLRepo) DeleteMember(tenant, id string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	parsedID, err := repository.ParseID(id, repository.ErrMemberNotFound)
+	if err != nil {
+		return err
+	}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		var memberID int
+		query := `SELECT id FROM members WHERE tenant = $1 AND id = $2::bigint FOR UPDATE;`
+		err := tx.QueryRowContext(ctx, query, tenant, parsedID).Scan(&memberID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		var hasLoans bool
+		query = `SELECT EXISTS (SELECT 1 FROM loans WHERE member_id = $1 AND returned_at IS NULL);`
+		if err = tx.QueryRowContext(ctx, query, memberID).Scan(&hasLoans); err != nil {
+			return err
+		}
+		if hasLoans {
+			return fmt.Errorf("%w (id=%s)", repository.ErrMemberHasLoans, id)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM members WHERE id = $1;`, memberID)
+		return err
+	})
+}
+
+func (r *PostgreSQLRepo) GetLoans(tenant string, filter repository.LoanFilter) ([]*models.Loan, error) {
+	conditions := []string{"tenant = $1"}
+	args := []any{tenant}
+	if filter.MemberID != "" {
+		memberID, err := repository.ParseID(filter.MemberID, repository.ErrMemberNotFound)
+		if err != nil {
+			return []*models.Loan{}, nil
+		}
+		args = append(args, memberID)
+		conditions = append(conditions, fmt.Sprintf("member_id = $%d::bigint", len(args)))
+	}
+	if filter.CopyID != "" {
+		copyID, err := repository.ParseID(filter.CopyID, repository.ErrCopyNotFound)
+		if err != nil {
+			return []*models.Loan{}, nil
+		}
+		args = append(args, copyID)
+		conditions = append(conditions, fmt.Sprintf("copy_id = $%d::bigint", len(args)))
+	}
+	if filter.ActiveOnly {
+		conditions = append(conditions, "returned_at IS NULL")
+	}
+
+	query := `SELECT ` + loanColumns + ` FROM loans WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY id DESC;`
+
+	return r.queryLoans(query, args...)
+}
+
+func (r *PostgreSQLRepo) GetDueLoans(before time.Time) ([]*models.Loan, error) {
+	query := `SELECT ` + loanColumns + ` FROM loans WHERE returned_at IS NULL AND due_at < $1 ORDER BY due_at, id;`
+
+	return r.queryLoans(query, before)
+}
+
+func (r *PostgreSQLRepo) GetLoan(tenant, id string) (*models.Loan, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `SELECT ` + loanColumns + ` FROM loans WHERE tenant = $1 AND id = $2::bigint;`
+
+	loanID, err := repository.ParseID(id, repository.ErrLoanNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := scanLoan(r.DB.QueryRowContext(ctx, query, tenant, loanID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrLoanNotFound, id)
+	}
+	return l, err
+}
+
+// Checkout locks the copy for the transaction, so concurrent checkouts of
+// it wait for each other and all but the first find it on loan. The unique
+// index of active loans rejects the loan if the lock was bypassed.
+func (r *PostgreSQLRepo) Checkout(l *models.Loan) (*models.Loan, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	created := *l
+	created.ReturnedAt = nil
+	created.Renewals = 0
+
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		copyID, bookID, err := lockCopy(ctx, tx, l.Tenant, l.CopyID)
+		if err != nil {
+			return err
+		}
+		created.BookID = bookID
+
+		parsedMemberID, err := repository.ParseID(l.MemberID, repository.ErrMemberNotFound)
+		if err != nil {
+			return err
+		}
+
+		// shared lock keeps the member until the loan is committed
+		var memberID int
+		query := `SELECT id FROM members WHERE tenant = $1 AND id = $2::bigint FOR SHARE;`
+		err = tx.QueryRowContext(ctx, query, l.Tenant, parsedMemberID).Scan(&memberID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, l.MemberID)
+		}
+		if err != nil {
+			return err
+		}
+
+		var onLoan bool
+		query = `SELECT EXISTS (SELECT 1 FROM loans WHERE copy_id = $1 AND returned_at IS NULL);`
+		if err = tx.QueryRowContext(ctx, query, copyID).Scan(&onLoan); err != nil {
+			return err
+		}
+		if onLoan {
+			return fmt.Errorf("%w (id=%s)", repository.ErrCopyOnLoan, l.CopyID)
+		}
+
+		var holder int
+		query = `SELECT member_id FROM holds WHERE copy_id = $1 AND status = 'ready';`
+		err = tx.QueryRowContext(ctx, query, copyID).Scan(&holder)
+		if err == nil && holder != memberID {
+			return fmt.Errorf("%w (id=%s)", repository.ErrCopyOnHold, l.CopyID)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		query = `
+			INSERT INTO loans (tenant, copy_id, book_id, member_id, checked_out_at, due_at, renewals)
+			VALUES ($1, $2, $3, $4, $5, $6, 0)
+			RETURNING id;
+		`
+
+		var newId int
+		err = tx.QueryRowContext(ctx, query, l.Tenant, copyID, bookID, memberID, l.CheckedOutAt, l.DueAt).Scan(&newId)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w (id=%s)", repository.ErrCopyOnLoan, l.CopyID)
+		}
+		if err != nil {
+			return err
+		}
+
+		created.ID = fmt.Sprintf("%d", newId)
+
+		query = `
+			UPDATE holds
+			SET status = 'collected', closed_at = $4
+			WHERE tenant = $1 AND book_id = $2 AND member_id = $3 AND status IN ('waiting', 'ready');
+		`
+		_, err = tx.ExecContext(ctx, query, l.Tenant, bookID, memberID, l.CheckedOutAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+func (r *PostgreSQLRepo) Return(tenant, id string, returnedAt time.Time) (*models.Loan, error) {
+	query := `
+		UPDATE loans
+		SET returned_at = $3
+		WHERE tenant = $1 AND id = $2::bigint AND returned_at IS NULL
+		RETURNING ` + loanColumns + `;
+	`
+
+	loanID, err := repository.ParseID(id, repository.ErrLoanNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.updateActiveLoan(tenant, id, nil, query, tenant, loanID, returnedAt)
+}
+
+func (r *PostgreSQLRepo) Renew(tenant, id string, dueAt time.Time, maxRenewals int) (*models.Loan, error) {
+	query := `
+		UPDATE loans
+		SET due_at = $3, renewals = renewals + 1
+		WHERE tenant = $1 AND id = $2::bigint AND returned_at IS NULL AND renewals < $4
+		RETURNING ` + loanColumns + `;
+	`
+
+	loanID, err := repository.ParseID(id, repository.ErrLoanNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.updateActiveLoan(tenant, id, repository.ErrRenewalsReached, query, tenant, loanID, dueAt, maxRenewals)
+}
+
+// updateActiveLoan runs query updating the loan and returning it. When
+// the loan was not updated, it is looked up to tell whether it is
+// missing, returned, or otherwise rejected by query with errRejected.
+func (r *PostgreSQLRepo) updateActiveLoan(tenant, id string, errRejected error, query string, args ...any) (*models.Loan, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	l, err := scanLoan(r.DB.QueryRowContext(ctx, query, args...))
+	if err == nil {
+		return l, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	existing, err := r.GetLoan(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if !existing.Active() || errRejected == nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrLoanReturned, id)
+	}
+	return nil, fmt.Errorf("%w (id=%s)", errRejected, id)
+}
+
+func (r *PostgreSQLRepo) PlaceHold(h *models.Hold) (*models.Hold, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO holds (tenant, book_id, member_id, status, placed_at)
+		SELECT $1, $2, id, 'waiting', $4
+		FROM members
+		WHERE tenant = $1 AND id = $3::bigint
+		RETURNING id;
+	`
+
+	memberID, err := repository.ParseID(h.MemberID, repository.ErrMemberNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	created := *h
+	created.Status = models.HoldWaiting
+	created.CopyID = ""
+	created.ReadyAt, created.ExpiresAt, created.ClosedAt = nil, nil, nil
+
+	var newId int
+	err = r.DB.QueryRowContext(ctx, query, h.Tenant, h.BookID, memberID, h.PlacedAt).Scan(&newId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, h.MemberID)
+	}
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w (book_id=%s)", repository.ErrHoldExists, h.BookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created.ID = fmt.Sprintf("%d", newId)
+	return &created, nil
+}
+
+func (r *PostgreSQLRepo) GetHolds(tenant string, filter repository.HoldFilter) ([]*models.Hold, error) {
+	conditions := []string{"h.tenant = $1"}
+	args := []any{tenant}
+	if filter.BookID != "" {
+		args = append(args, filter.BookID)
+		conditions = append(conditions, fmt.Sprintf("h.book_id = $%d", len(args)))
+	}
+	if filter.MemberID != "" {
+		memberID, err := repository.ParseID(filter.MemberID, repository.ErrMemberNotFound)
+		if err != nil {
+			return []*models.Hold{}, nil
+		}
+		args = append(args, memberID)
+		conditions = append(conditions, fmt.Sprintf("h.member_id = $%d::bigint", len(args)))
+	}
+	if filter.ActiveOnly {
+		conditions = append(conditions, "h.status IN ('waiting', 'ready')")
+	}
+
+	query := `SELECT ` + holdColumns + `, ` + holdPosition + ` FROM holds h WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY h.id;`
+
+	return r.queryHolds(query, args...)
+}
+
+func (r *PostgreSQLRepo) GetHold(tenant, id string) (*models.Hold, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `SELECT ` + holdColumns + `, ` + holdPosition + ` FROM holds h WHERE h.tenant = $1 AND h.id = $2::bigint;`
+
+	holdID, err := repository.ParseID(id, repository.ErrHoldNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := scanHold(r.DB.QueryRowContext(ctx, query, tenant, holdID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrHoldNotFound, id)
+	}
+	return h, err
+}
+
+// CancelHold frees the copy of a ready hold by closing it, copies are set
+// aside only for ready holds.
+func (r *PostgreSQLRepo) CancelHold(tenant, id string, cancelledAt time.Time) (*models.Hold, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		UPDATE holds h
+		SET status = 'cancelled', closed_at = $3
+		WHERE h.tenant = $1 AND h.id = $2::bigint AND h.status IN ('waiting', 'ready')
+		RETURNING ` + holdColumns + `, 0;
+	`
+
+	holdID, err := repository.ParseID(id, repository.ErrHoldNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := scanHold(r.DB.QueryRowContext(ctx, query, tenant, holdID, cancelledAt))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return h, err
+	}
+
+	if _, err = r.GetHold(tenant, id); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w (id=%s)", repository.ErrHoldClosed, id)
+}
+
+// PromoteHolds locks the free copies, skipping those locked by checkouts
+// or other promotions, and pairs them with the oldest waiting holds.
+func (r *PostgreSQLRepo) PromoteHolds(tenant, bookID string, now time.Time, pickup time.Duration) ([]*models.Hold, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	promoted := []*models.Hold{}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			SELECT c.id
+			FROM copies c
+			WHERE c.tenant = $1 AND c.book_id = $2
+				AND NOT EXISTS (SELECT 1 FROM loans l WHERE l.copy_id = c.id AND l.returned_at IS NULL)
+				AND NOT EXISTS (SELECT 1 FROM holds h WHERE h.copy_id = c.id AND h.status = 'ready')
+			ORDER BY c.id
+			FOR UPDATE OF c SKIP LOCKED;
+		`
+		copyIDs, err := queryIDs(ctx, tx, query, tenant, bookID)
+		if err != nil || len(copyIDs) == 0 {
+			return err
+		}
+
+		query = `
+			SELECT id
+			FROM holds
+			WHERE tenant = $1 AND book_id = $2 AND status = 'waiting'
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED;
+		`
+		holdIDs, err := queryIDs(ctx, tx, query, tenant, bookID, len(copyIDs))
+		if err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE holds h
+			SET status = 'ready', copy_id = $2, ready_at = $3, expires_at = $4
+			WHERE h.id = $1
+			RETURNING ` + holdColumns + `, 0;
+		`
+		for i, holdID := range holdIDs {
+			h, err := scanHold(tx.QueryRowContext(ctx, query, holdID, copyIDs[i], now, now.Add(pickup)))
+			if err != nil {
+				return err
+			}
+			promoted = append(promoted, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return promoted, nil
+}
+
+func (r *PostgreSQLRepo) ExpireHolds(now time.Time) ([]*models.Hold, error) {
+	query := `
+		UPDATE holds h
+		SET status = 'expired', closed_at = $1
+		WHERE h.status = 'ready' AND h.expires_at < $1
+		RETURNING ` + holdColumns + `, 0;
+	`
+
+	return r.queryHolds(query, now)
+}
+
+func (r *PostgreSQLRepo) queryLoans(query string, args ...any) ([]*models.Loan, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []*models.Loan{}
+	for rows.Next() {
+		l, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, l)
+	}
+
+	return loans, rows.Err()
+}
+
+func (r *PostgreSQLRepo) queryHolds(query string, args ...any) ([]*models.Hold, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []*models.Hold{}
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+
+	return holds, rows.Err()
+}
+
+func (r *PostgreSQLRepo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockCopy locks the copy of the tenant for the transaction, returning
+// its numeric ID and its book.
+func lockCopy(ctx context.Context, tx *sql.Tx, tenant, id string) (int, string, error) {
+	parsedID, err := repository.ParseID(id, repository.ErrCopyNotFound)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var copyID int
+	var bookID string
+	query := `SELECT id, book_id FROM copies WHERE tenant = $1 AND id = $2::bigint FOR UPDATE;`
+	err = tx.QueryRowContext(ctx, query, tenant, parsedID).Scan(&copyID, &bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", fmt.Errorf("%w (id=%s)", repository.ErrCopyNotFound, id)
+	}
+	return copyID, bookID, err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCopy(row rowScanner) (*models.Copy, error) {
+	var c models.Copy
+	if err := row.Scan(&c.ID, &c.Tenant, &c.BookID, &c.Barcode, &c.Condition, &c.Location, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func scanLoan(row rowScanner) (*models.Loan, error) {
+	var l models.Loan
+	var returnedAt sql.NullTime
+	err := row.Scan(&l.ID, &l.Tenant, &l.CopyID, &l.BookID, &l.MemberID, &l.CheckedOutAt, &l.DueAt, &returnedAt, &l.Renewals)
+	if err != nil {
+		return nil, err
+	}
+
+	l.ReturnedAt = nullTime(returnedAt)
+	return &l, nil
+}
+
+// scanHold scans holdColumns followed by the position.
+func scanHold(row rowScanner) (*models.Hold, error) {
+	var h models.Hold
+	var copyID sql.NullString
+	var readyAt, expiresAt, closedAt sql.NullTime
+	err := row.Scan(&h.ID, &h.Tenant, &h.BookID, &h.MemberID, &h.Status, &copyID, &h.PlacedAt, &readyAt, &expiresAt, &closedAt, &h.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	h.CopyID = copyID.String
+	h.ReadyAt, h.ExpiresAt, h.ClosedAt = nullTime(readyAt), nullTime(expiresAt), nullTime(closedAt)
+	return &h, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation
+}
+
+func notFoundUnlessAffected(res sql.Result, errNotFound error, id string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w (id=%s)", errNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package circulation
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var holdsPostgresqlRows = []string{"id", "tenant", "book_id", "member_id", "status", "copy_id", "placed_at", "ready_at", "expires_at", "closed_at", "position"}
+
+var loansPostgresqlRows = []string{"id", "tenant", "copy_id", "book_id", "member_id", "checked_out_at", "due_at", "returned_at", "renewals"}
+
+func Test_Postgresql_Checkout_ShouldInsertLoanOfLockedCopy(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	now := time.Now().UTC()
+	loan := &models.Loan{Tenant: "acme", CopyID: "3", MemberID: "5", CheckedOutAt: now, DueAt: now.Add(14 * 24 * time.Hour)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, book_id FROM copies WHERE tenant = $1 AND id = $2::bigint FOR UPDATE;`)).
+		WithArgs("acme", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id"}).AddRow(3, "42"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM members WHERE tenant = $1 AND id = $2::bigint FOR SHARE;`)).
+		WithArgs("acme", int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM loans WHERE copy_id = $1 AND returned_at IS NULL);`)).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT member_id FROM holds WHERE copy_id = $1 AND status = 'ready';`)).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"member_id"}).AddRow(5))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO loans (tenant, copy_id, book_id, member_id, checked_out_at, due_at, renewals) VALUES ($1, $2, $3, $4, $5, $6, 0) RETURNING id;`)).
+		WithArgs("acme", 3, "42", 5, loan.CheckedOutAt, loan.DueAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE holds SET status = 'collected', closed_at = $4 WHERE tenant = $1 AND book_id = $2 AND member_id = $3 AND status IN ('waiting', 'ready');`)).
+		WithArgs("acme", "42", 5, loan.CheckedOutAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// when
+	created, err := testServer.Checkout(loan)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.ID != "9" || created.BookID != "42" || !created.Active() {
+		t.Fatalf("Created loan is different than expected: %+v\n", created)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_Checkout_ShouldRejectCopyOnLoan(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, book_id FROM copies WHERE tenant = $1 AND id = $2::bigint FOR UPDATE;`)).
+		WithArgs("acme", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id"}).AddRow(3, "42"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM members WHERE tenant = $1 AND id = $2::bigint FOR SHARE;`)).
+		WithArgs("acme", int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM loans WHERE copy_id = $1 AND returned_at IS NULL);`)).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	// when
+	_, err := testServer.Checkout(&models.Loan{Tenant: "acme", CopyID: "3", MemberID: "5"})
+
+	// then
+	if !errors.Is(err, repository.ErrCopyOnLoan) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrCopyOnLoan, err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_Checkout_ShouldRejectCopySetAsideForAnotherMember(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, book_id FROM copies WHERE tenant = $1 AND id = $2::bigint FOR UPDATE;`)).
+		WithArgs("acme", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id"}).AddRow(3, "42"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM members WHERE tenant = $1 AND id = $2::bigint FOR SHARE;`)).
+		WithArgs("acme", int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM loans WHERE copy_id = $1 AND returned_at IS NULL);`)).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT member_id FROM holds WHERE copy_id = $1 AND status = 'ready';`)).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"member_id"}).AddRow(6))
+	mock.ExpectRollback()
+
+	// when
+	_, err := testServer.Checkout(&models.Loan{Tenant: "acme", CopyID: "3", MemberID: "5"})
+
+	// then
+	if !errors.Is(err, repository.ErrCopyOnHold) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrCopyOnHold, err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_Renew_ShouldTellWhyLoanWasNotRenewed(t *testing.T) {
+	tests := []struct {
+		name          string
+		returnedAt    any
+		expectedError error
+	}{
+		{"Returned loan", time.Now(), repository.ErrLoanReturned},
+		{"Active loan", nil, repository.ErrRenewalsReached},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			testServer, mock := prepareTestDB(t)
+			defer testServer.DB.Close()
+
+			// given
+			dueAt := time.Now().Add(time.Hour)
+			mock.ExpectQuery(regexp.QuoteMeta(`UPDATE loans SET due_at = $3, renewals = renewals + 1 WHERE tenant = $1 AND id = $2::bigint AND returned_at IS NULL AND renewals < $4 RETURNING`)).
+				WithArgs("acme", int64(9), dueAt, 2).
+				WillReturnRows(sqlmock.NewRows(loansPostgresqlRows))
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM loans WHERE tenant = $1 AND id = $2::bigint;`)).
+				WithArgs("acme", int64(9)).
+				WillReturnRows(sqlmock.NewRows(loansPostgresqlRows).AddRow("9", "acme", "3", "42", "5", time.Now(), time.Now(), tt.returnedAt, 2))
+
+			// when
+			_, err := testServer.Renew("acme", "9", dueAt, 2)
+
+			// then
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected %v, has: %v\n", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func Test_Postgresql_GetAvailability(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(c.id), count(c.id) FILTER (WHERE l.id IS NULL AND h.id IS NULL), (SELECT count(*) FROM holds w WHERE w.tenant = $1 AND w.book_id = $2 AND w.status = 'waiting') FROM copies c`)).
+		WithArgs("acme", "42").
+		WillReturnRows(sqlmock.NewRows([]string{"copies", "available", "holds"}).AddRow(3, 1, 2))
+
+	// when
+	a, err := testServer.GetAvailability("acme", "42")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *a != (models.Availability{Copies: 3, Available: 1, Holds: 2}) {
+		t.Fatalf("Returned availability is different than expected: %+v\n", a)
+	}
+}
+
+func Test_Postgresql_PlaceHold_ShouldTellWhyHoldWasNotPlaced(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		expectedError error
+	}{
+		{"Missing member", sql.ErrNoRows, repository.ErrMemberNotFound},
+		{"Active hold", &pgconn.PgError{Code: postgresUniqueViolation}, repository.ErrHoldExists},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			testServer, mock := prepareTestDB(t)
+			defer testServer.DB.Close()
+
+			// given
+			placedAt := time.Now()
+			mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO holds (tenant, book_id, member_id, status, placed_at) SELECT $1, $2, id, 'waiting', $4 FROM members WHERE tenant = $1 AND id = $3::bigint RETURNING id;`)).
+				WithArgs("acme", "42", int64(5), placedAt).
+				WillReturnError(tt.err)
+
+			// when
+			_, err := testServer.PlaceHold(&models.Hold{Tenant: "acme", BookID: "42", MemberID: "5", PlacedAt: placedAt})
+
+			// then
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected %v, has: %v\n", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func Test_Postgresql_PromoteHolds_ShouldPairFreeCopiesWithOldestHolds(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	now := time.Now().UTC()
+	pickup := 72 * time.Hour
+	expiresAt := now.Add(pickup)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT c.id FROM copies c WHERE c.tenant = $1 AND c.book_id = $2`)).
+		WithArgs("acme", "42").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM holds WHERE tenant = $1 AND book_id = $2 AND status = 'waiting' ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED;`)).
+		WithArgs("acme", "42", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE holds h SET status = 'ready', copy_id = $2, ready_at = $3, expires_at = $4 WHERE h.id = $1 RETURNING`)).
+		WithArgs(7, 3, now, expiresAt).
+		WillReturnRows(sqlmock.NewRows(holdsPostgresqlRows).AddRow(7, "acme", "42", 5, "ready", 3, now, now, expiresAt, nil, 0))
+	mock.ExpectCommit()
+
+	// when
+	promoted, err := testServer.PromoteHolds("acme", "42", now, pickup)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(promoted) != 1 || promoted[0].CopyID != "3" || promoted[0].Status != models.HoldReady || !promoted[0].ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Promoted holds are different than expected: %+v\n", promoted)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_ShouldNotFindNonNumericIDs(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// when
+	_, loanErr := testServer.GetLoan("acme", "abc")
+	loans, loansErr := testServer.GetLoans("acme", repository.LoanFilter{MemberID: "abc"})
+
+	// then
+	if !errors.Is(loanErr, repository.ErrLoanNotFound) {
+		t.Fatalf("Expected loan not found, has: %v\n", loanErr)
+	}
+	if loansErr != nil || len(loans) != 0 {
+		t.Fatalf("Expected no loans, has: %v, %v\n", loans, loansErr)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// utils
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
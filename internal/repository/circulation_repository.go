@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"time"
+)
+
+// Errors of circulation, NotFound ones are returned as well when the
+// record belongs to another tenant.
+var (
+	ErrCopyNotFound   = errors.New("copy not found")
+	ErrMemberNotFound = errors.New("member not found")
+	ErrLoanNotFound   = errors.New("loan not found")
+	ErrHoldNotFound   = errors.New("hold not found")
+
+	ErrBarcodeTaken    = errors.New("barcode is already used by another copy")
+	ErrCopyOnLoan      = errors.New("copy is on loan")
+	ErrCopyOnHold      = errors.New("copy is set aside for another member")
+	ErrMemberHasLoans  = errors.New("member has copies on loan")
+	ErrLoanReturned    = errors.New("loan is already returned")
+	ErrRenewalsReached = errors.New("loan reached the limit of renewals")
+	ErrHoldExists      = errors.New("member already holds the book")
+	ErrHoldClosed      = errors.New("hold is no longer active")
+)
+
+// LoanFilter selects loans, zero fields match every loan.
+type LoanFilter struct {
+	MemberID string
+	CopyID   string
+	// ActiveOnly skips returned loans.
+	ActiveOnly bool
+}
+
+// HoldFilter selects holds, zero fields match every hold.
+type HoldFilter struct {
+	BookID   string
+	MemberID string
+	// ActiveOnly skips collected, cancelled and expired holds.
+	ActiveOnly bool
+}
+
+type CirculationRepo interface {
+	GetCopies(tenant, bookID string) ([]*models.Copy, error)
+	GetCopy(tenant, id string) (*models.Copy, error)
+	AddCopy(c *models.Copy) (*models.Copy, error)
+	UpdateCopy(c *models.Copy) error
+	// DeleteCopy removes the copy together with its returned loans, it
+	// fails with ErrCopyOnLoan while the copy is checked out and with
+	// ErrCopyOnHold while it is set aside for a hold.
+	DeleteCopy(tenant, id string) error
+	// GetAvailability counts copies of the book, free ones and waiting
+	// holds.
+	GetAvailability(tenant, bookID string) (*models.Availability, error)
+
+	GetMembers(tenant string) ([]*models.Member, error)
+	GetMember(tenant, id string) (*models.Member, error)
+	AddMember(m *models.Member) (*models.Member, error)
+	// DeleteMember removes the member together with its returned loans, it
+	// fails with ErrMemberHasLoans while the member has copies on loan.
+	// Reviews of the member are kept without their author.
+	DeleteMember(tenant, id string) error
+
+	// GetLoans returns loans of the tenant matching filter, newest first.
+	GetLoans(tenant string, filter LoanFilter) ([]*models.Loan, error)
+	GetLoan(tenant, id string) (*models.Loan, error)
+	// GetDueLoans returns active loans of all tenants due before before,
+	// those due first come first.
+	GetDueLoans(before time.Time) ([]*models.Loan, error)
+	// Checkout creates an active loan of the copy, it fails with
+	// ErrCopyOnLoan when the copy already has one, even when both
+	// checkouts run at once, and with ErrCopyOnHold when the copy is set
+	// aside for another member. An active hold of the member for the book
+	// is collected.
+	Checkout(l *models.Loan) (*models.Loan, error)
+	// Return closes the active loan at returnedAt.
+	Return(tenant, id string, returnedAt time.Time) (*models.Loan, error)
+	// Renew moves the due date of the active loan to dueAt, unless it was
+	// renewed maxRenewals times already.
+	Renew(tenant, id string, dueAt time.Time, maxRenewals int) (*models.Loan, error)
+
+	// PlaceHold queues the member for the book, it fails with
+	// ErrHoldExists when the member has an active hold of the book.
+	PlaceHold(h *models.Hold) (*models.Hold, error)
+	// GetHolds returns holds of the tenant matching filter, oldest first.
+	GetHolds(tenant string, filter HoldFilter) ([]*models.Hold, error)
+	GetHold(tenant, id string) (*models.Hold, error)
+	// CancelHold closes the active hold, a copy set aside for it becomes
+	// free.
+	CancelHold(tenant, id string, cancelledAt time.Time) (*models.Hold, error)
+	// PromoteHolds sets free copies of the book aside for the oldest
+	// waiting holds, which become ready for pickup until now+pickup.
+	PromoteHolds(tenant, bookID string, now time.Time, pickup time.Duration) ([]*models.Hold, error)
+	// ExpireHolds expires ready holds of all tenants not collected before
+	// now, freeing their copies.
+	ExpireHolds(now time.Time) ([]*models.Hold, error)
+}
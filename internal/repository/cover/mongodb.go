@@ -0,0 +1,128 @@
+package cover
+
+import (
+	"context"
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoDBRepo keeps a document of covers collection for every book with a
+// cover. Covers are replaced atomically, returning the replaced document.
+type MongoDBRepo struct {
+	covers *mongo.Collection
+}
+
+const mongoCoversCollectionName = "covers"
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{covers: db.Collection(mongoCoversCollectionName)}
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.covers.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "book_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (r *MongoDBRepo) GetCovers(tenant string, bookIDs []string) (map[string]*models.Cover, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	covers := make(map[string]*models.Cover)
+	if len(bookIDs) == 0 {
+		return covers, nil
+	}
+
+	filter := bson.D{
+		{Key: "tenant", Value: tenant},
+		{Key: "book_id", Value: bson.D{{Key: "$in", Value: bookIDs}}},
+	}
+	cursor, err := r.covers.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*models.Cover
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	for _, c := range found {
+		covers[c.BookID] = c
+	}
+	return covers, nil
+}
+
+func (r *MongoDBRepo) SetCover(c *models.Cover) (*models.Cover, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "tenant", Value: c.Tenant}, {Key: "book_id", Value: c.BookID}}
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var previous *models.Cover
+	err := r.covers.FindOneAndReplace(ctx, filter, c, opts).Decode(&previous)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+func (r *MongoDBRepo) DeleteCovers(tenant, bookID string) ([]*models.Cover, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if bookID != "" {
+		filter := bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bookID}}
+
+		var deleted *models.Cover
+		err := r.covers.FindOneAndDelete(ctx, filter).Decode(&deleted)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return []*models.Cover{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*models.Cover{deleted}, nil
+	}
+
+	// only the found versions are deleted, so a cover set in the meantime
+	// is not removed without its images
+	filter := bson.D{{Key: "tenant", Value: tenant}}
+	cursor, err := r.covers.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []*models.Cover{}
+	if err = cursor.All(ctx, &deleted); err != nil {
+		return nil, err
+	}
+	if len(deleted) == 0 {
+		return deleted, nil
+	}
+
+	versions := make(bson.A, len(deleted))
+	for i, c := range deleted {
+		versions[i] = bson.D{{Key: "book_id", Value: c.BookID}, {Key: "version", Value: c.Version}}
+	}
+	_, err = r.covers.DeleteMany(ctx, append(filter, bson.E{Key: "$or", Value: versions}))
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,76 @@
+package cover
+
+import (
+	"github.com/auwendil/crud-app/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func Test_MongoDB_SetCover(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	c := &models.Cover{Tenant: "acme", BookID: "1", Version: "b2", ContentType: "image/png"}
+
+	mt.Run("Should return replaced cover", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{covers: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+			{Key: "tenant", Value: "acme"},
+			{Key: "book_id", Value: "1"},
+			{Key: "version", Value: "a1"},
+			{Key: "content_type", Value: "image/jpeg"},
+			{Key: "thumbnails", Value: bson.A{bson.D{{Key: "name", Value: "small"}, {Key: "content_type", Value: "image/jpeg"}}}},
+		}}))
+
+		// when
+		previous, err := ts.SetCover(c)
+
+		// then
+		if err != nil {
+			t.Fatalf("Expected no error, has: %v\n", err)
+		}
+		if previous == nil || previous.Version != "a1" || len(previous.Thumbnails) != 1 || previous.Thumbnails[0].Name != "small" {
+			t.Fatalf("Expected replaced cover a1, has: %+v\n", previous)
+		}
+	})
+
+	mt.Run("Should return nil for first cover", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{covers: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+
+		// when
+		previous, err := ts.SetCover(c)
+
+		// then
+		if err != nil || previous != nil {
+			t.Fatalf("Expected no replaced cover, has: %+v, %v\n", previous, err)
+		}
+	})
+}
+
+func Test_MongoDB_DeleteCovers(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should return nothing for book without cover", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{covers: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+
+		// when
+		deleted, err := ts.DeleteCovers("acme", "1")
+
+		// then
+		if err != nil || len(deleted) != 0 {
+			t.Fatalf("Expected no deleted covers, has: %+v, %v\n", deleted, err)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package cover
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"strings"
+	"time"
+)
+
+// PostgreSQLRepo keeps thumbnails of a cover as a JSON array next to the
+// rest of its metadata. Replacements of the cover of a book are serialized
+// with an advisory lock, so every replaced cover is returned exactly once.
+type PostgreSQLRepo struct {
+	DB *sql.DB
+}
+
+const (
+	postgresDBTimeout          = time.Second * 3
+	postgresCoverLockNamespace = "covers"
+)
+
+const coverColumns = `tenant, book_id, version, content_type, width, height, size, thumbnails, updated_at`
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{DB: db}
+}
+
+func (r *PostgreSQLRepo) GetCovers(tenant string, bookIDs []string) (map[string]*models.Cover, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	covers := make(map[string]*models.Cover)
+	if len(bookIDs) == 0 {
+		return covers, nil
+	}
+
+	query := `SELECT ` + coverColumns + ` FROM covers WHERE tenant = $1 AND book_id = ANY($2::text[]);`
+
+	rows, err := r.DB.QueryContext(ctx, query, tenant, textArray(bookIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCover(rows)
+		if err != nil {
+			return nil, err
+		}
+		covers[c.BookID] = c
+	}
+
+	return covers, rows.Err()
+}
+
+func (r *PostgreSQLRepo) SetCover(c *models.Cover) (*models.Cover, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	thumbnails, err := json.Marshal(c.Thumbnails)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *models.Cover
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2));`, postgresCoverLockNamespace, c.Tenant+"/"+c.BookID)
+		if err != nil {
+			return err
+		}
+
+		query := `SELECT ` + coverColumns + ` FROM covers WHERE tenant = $1 AND book_id = $2;`
+		previous, err = scanCover(tx.QueryRowContext(ctx, query, c.Tenant, c.BookID))
+		if errors.Is(err, sql.ErrNoRows) {
+			previous, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO covers (` + coverColumns + `)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (tenant, book_id) DO UPDATE
+			SET version = $3, content_type = $4, width = $5, height = $6, size = $7, thumbnails = $8, updated_at = $9;
+		`
+		_, err = tx.ExecContext(ctx, query, c.Tenant, c.BookID, c.Version, c.ContentType, c.Width, c.Height, c.Size, string(thumbnails), c.UpdatedAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+func (r *PostgreSQLRepo) DeleteCovers(tenant, bookID string) ([]*models.Cover, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query, args := `DELETE FROM covers WHERE tenant = $1`, []any{tenant}
+	if bookID != "" {
+		query, args = query+` AND book_id = $2`, append(args, bookID)
+	}
+
+	rows, err := r.DB.QueryContext(ctx, query+` RETURNING `+coverColumns+`;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	covers := []*models.Cover{}
+	for rows.Next() {
+		c, err := scanCover(rows)
+		if err != nil {
+			return nil, err
+		}
+		covers = append(covers, c)
+	}
+
+	return covers, rows.Err()
+}
+
+func (r *PostgreSQLRepo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCover(row rowScanner) (*models.Cover, error) {
+	var c models.Cover
+	var thumbnails []byte
+	err := row.Scan(&c.Tenant, &c.BookID, &c.Version, &c.ContentType, &c.Width, &c.Height, &c.Size, &thumbnails, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(thumbnails, &c.Thumbnails); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// textArray formats ids as a PostgreSQL array literal, quoting every
+// element.
+func textArray(ids []string) string {
+	quoted := make([]string, len(ids))
+	for i, id := range ids {
+		id = strings.ReplaceAll(id, `\`, `\\`)
+		quoted[i] = `"` + strings.ReplaceAll(id, `"`, `\"`) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
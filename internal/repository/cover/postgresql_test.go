@@ -0,0 +1,99 @@
+package cover
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var coverRowColumns = []string{"tenant", "book_id", "version", "content_type", "width", "height", "size", "thumbnails", "updated_at"}
+
+func Test_Postgresql_SetCover_ShouldReturnReplacedCover(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := &models.Cover{
+		Tenant:      "acme",
+		BookID:      "1",
+		Version:     "b2",
+		ContentType: "image/png",
+		Width:       60,
+		Height:      40,
+		Size:        512,
+		Thumbnails:  []*models.CoverThumbnail{{Name: "small", ContentType: "image/png", Width: 60, Height: 40}},
+		UpdatedAt:   now,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2));`)).
+		WithArgs(postgresCoverLockNamespace, "acme/1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT `+coverColumns+` FROM covers WHERE tenant = $1 AND book_id = $2;`)).
+		WithArgs("acme", "1").
+		WillReturnRows(sqlmock.NewRows(coverRowColumns).
+			AddRow("acme", "1", "a1", "image/jpeg", 40, 60, 256, []byte(`[{"name":"small","content_type":"image/jpeg","width":40,"height":60}]`), now.Add(-time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO covers (`+coverColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (tenant, book_id) DO UPDATE SET version = $3, content_type = $4, width = $5, height = $6, size = $7, thumbnails = $8, updated_at = $9;`)).
+		WithArgs("acme", "1", "b2", "image/png", 60, 40, int64(512), `[{"name":"small","content_type":"image/png","width":60,"height":40}]`, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// when
+	previous, err := testServer.SetCover(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("Expected no error, has: %v\n", err)
+	}
+	if previous == nil || previous.Version != "a1" || len(previous.Thumbnails) != 1 || previous.Thumbnails[0].ContentType != "image/jpeg" {
+		t.Fatalf("Expected replaced cover a1, has: %+v\n", previous)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_DeleteCovers_ShouldReturnCoversOfEveryBook(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM covers WHERE tenant = $1 RETURNING ` + coverColumns + `;`)).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows(coverRowColumns).
+			AddRow("acme", "1", "a1", "image/jpeg", 40, 60, 256, []byte(`[]`), now).
+			AddRow("acme", "2", "c3", "image/webp", 40, 60, 128, []byte(`[]`), now))
+
+	// when
+	deleted, err := testServer.DeleteCovers("acme", "")
+
+	// then
+	if err != nil {
+		t.Fatalf("Expected no error, has: %v\n", err)
+	}
+	if len(deleted) != 2 || deleted[0].BookID != "1" || deleted[1].Version != "c3" {
+		t.Fatalf("Expected covers of books 1 and 2, has: %+v\n", deleted)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// utils
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+)
+
+var ErrCoverNotFound = errors.New("book has no cover")
+
+// CoverRepo keeps metadata of covers of books, images themselves are kept
+// in a blob store. Removed covers are returned, so their images can be
+// removed from the store too.
+type CoverRepo interface {
+	// GetCovers returns covers of books among bookIDs by the IDs of the
+	// books, books without a cover are skipped.
+	GetCovers(tenant string, bookIDs []string) (map[string]*models.Cover, error)
+	// SetCover replaces the cover of the book, it returns the replaced
+	// cover or nil when the book had none.
+	SetCover(c *models.Cover) (*models.Cover, error)
+	// DeleteCovers removes the cover of the book, or covers of every book
+	// of the tenant when bookID is empty, and returns them.
+	DeleteCovers(tenant, bookID string) ([]*models.Cover, error)
+}
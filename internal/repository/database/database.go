@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/jackc/pgconn"
+	_ "github.com/jackc/pgx/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	postgresDriverName = "pgx"
+	postgresDBName     = "books"
+	mongoDBName        = "db"
+)
+
+func OpenPostgreSQL(dataName string) (*sql.DB, error) {
+	db, err := sql.Open(postgresDriverName, fmt.Sprintf("%s/%s", dataName, postgresDBName))
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func OpenMongoDB(connectionURI string) (*mongo.Database, error) {
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(connectionURI))
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Database(mongoDBName), nil
+}
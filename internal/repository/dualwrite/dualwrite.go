@@ -0,0 +1,375 @@
+package dualwrite
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/idmap"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	reconcileBatchSize = 100
+
+	// maxShadowReads bounds comparisons running at once, reads arriving
+	// while all of them run are not compared, so shadow reads never add
+	// more than this much load to the secondary.
+	maxShadowReads = 4
+)
+
+// Repo writes every change to both the primary and the secondary backend
+// and serves reads from the primary. The primary stays the source of truth:
+// failures on the secondary are logged and counted, but never returned.
+// IDs differ between backends, so the mapping translates primary IDs into
+// secondary ones.
+type Repo struct {
+	primary     repository.BookRepo
+	secondary   repository.BookRepo
+	mapping     idmap.Store
+	shadowReads bool
+
+	shadowSlots chan struct{}
+	shadowWG    sync.WaitGroup
+
+	secondaryWriteErrors atomic.Int64
+	shadowReadsDone      atomic.Int64
+	shadowReadsSkipped   atomic.Int64
+	divergences          atomic.Int64
+}
+
+type Stats struct {
+	SecondaryWriteErrors int64 `json:"secondary_write_errors"`
+	ShadowReads          int64 `json:"shadow_reads"`
+	ShadowReadsSkipped   int64 `json:"shadow_reads_skipped"`
+	Divergences          int64 `json:"divergences"`
+}
+
+type Difference struct {
+	PrimaryID   string       `json:"primary_id,omitempty"`
+	SecondaryID string       `json:"secondary_id,omitempty"`
+	Reason      string       `json:"reason"`
+	Primary     *models.Book `json:"primary,omitempty"`
+	Secondary   *models.Book `json:"secondary,omitempty"`
+}
+
+type Reconciliation struct {
+	PrimaryCount   int          `json:"primary_count"`
+	SecondaryCount int          `json:"secondary_count"`
+	Differences    []Difference `json:"differences"`
+}
+
+const (
+	reasonNotMapped     = "not mapped to secondary"
+	reasonMissing       = "missing in secondary"
+	reasonMismatch      = "fields differ"
+	reasonOnlySecondary = "exists only in secondary"
+)
+
+var _ repository.BookRepo = (*Repo)(nil)
+
+func New(primary, secondary repository.BookRepo, mapping idmap.Store, shadowReads bool) *Repo {
+	return &Repo{
+		primary:     primary,
+		secondary:   secondary,
+		mapping:     mapping,
+		shadowReads: shadowReads,
+		shadowSlots: make(chan struct{}, maxShadowReads),
+	}
+}
+
+func (r *Repo) GetAllBooks() ([]*models.Book, error) {
+	books, err := r.primary.GetAllBooks()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.shadowReads {
+		r.shadow(func() { r.compareAll(books) })
+	}
+	return books, nil
+}
+
+func (r *Repo) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return r.primary.GetBooksBatch(offset, limit)
+}
+
+func (r *Repo) GetBook(id string) (*models.Book, error) {
+	book, err := r.primary.GetBook(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.shadowReads {
+		r.shadow(func() { r.compareOne(book) })
+	}
+	return book, nil
+}
+
+func (r *Repo) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	return r.primary.GetBooksByIDs(ids)
+}
+
+// CountAuthors counts books of the primary, which has to count authors.
+func (r *Repo) CountAuthors(ids []string) ([]*models.FacetCount, error) {
+	counter, ok := r.primary.(repository.AuthorCounter)
+	if !ok {
+		return nil, fmt.Errorf("primary repository does not count authors")
+	}
+	return counter.CountAuthors(ids)
+}
+
+// GetBooksByRating sorts books of the primary, ratings are kept in its
+// database only.
+func (r *Repo) GetBooksByRating(offset, limit int) ([]*models.Book, error) {
+	sorter, ok := r.primary.(repository.RatingSorter)
+	if !ok {
+		return nil, repository.ErrRatingSortUnsupported
+	}
+	return sorter.GetBooksByRating(offset, limit)
+}
+
+func (r *Repo) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return r.primary.SearchBooks(phrase, offset, limit)
+}
+
+func (r *Repo) AddBook(b *models.Book) (*models.Book, error) {
+	secondaryBook := &models.Book{Name: b.Name, Author: b.Author}
+
+	created, err := r.primary.AddBook(b)
+	if err != nil {
+		return nil, err
+	}
+
+	secondaryCreated, err := r.secondary.AddBook(secondaryBook)
+	if err != nil {
+		r.secondaryWriteFailed("add", created.ID, err)
+		return created, nil
+	}
+
+	if err = r.mapping.Put(created.ID, secondaryCreated.ID); err != nil {
+		r.secondaryWriteFailed("map", created.ID, err)
+	}
+	return created, nil
+}
+
+func (r *Repo) UpdateBook(id string, updatedBook *models.Book) error {
+	secondaryBook := &models.Book{Name: updatedBook.Name, Author: updatedBook.Author}
+
+	if err := r.primary.UpdateBook(id, updatedBook); err != nil {
+		return err
+	}
+
+	secondaryID, ok := r.mapping.Get(id)
+	if !ok {
+		r.secondaryWriteFailed("update", id, fmt.Errorf("book is %s", reasonNotMapped))
+		return nil
+	}
+
+	if err := r.secondary.UpdateBook(secondaryID, secondaryBook); err != nil {
+		r.secondaryWriteFailed("update", id, err)
+	}
+	return nil
+}
+
+func (r *Repo) DeleteBook(id string) error {
+	if err := r.primary.DeleteBook(id); err != nil {
+		return err
+	}
+
+	secondaryID, ok := r.mapping.Get(id)
+	if !ok {
+		r.secondaryWriteFailed("delete", id, fmt.Errorf("book is %s", reasonNotMapped))
+		return nil
+	}
+
+	if err := r.secondary.DeleteBook(secondaryID); err != nil {
+		r.secondaryWriteFailed("delete", id, err)
+		return nil
+	}
+
+	if err := r.mapping.Delete(id); err != nil {
+		r.secondaryWriteFailed("unmap", id, err)
+	}
+	return nil
+}
+
+func (r *Repo) DeleteAllBooks() error {
+	if err := r.primary.DeleteAllBooks(); err != nil {
+		return err
+	}
+
+	if err := r.secondary.DeleteAllBooks(); err != nil {
+		r.secondaryWriteFailed("delete all", "", err)
+		return nil
+	}
+
+	if err := r.mapping.Clear(); err != nil {
+		r.secondaryWriteFailed("unmap all", "", err)
+	}
+	return nil
+}
+
+func (r *Repo) Stats() Stats {
+	return Stats{
+		SecondaryWriteErrors: r.secondaryWriteErrors.Load(),
+		ShadowReads:          r.shadowReadsDone.Load(),
+		ShadowReadsSkipped:   r.shadowReadsSkipped.Load(),
+		Divergences:          r.divergences.Load(),
+	}
+}
+
+// Reconcile walks through all primary books and reports every one that
+// is missing or differs in the secondary, followed by secondary books
+// which are not mapped to any primary book.
+func (r *Repo) Reconcile() (*Reconciliation, error) {
+	report := &Reconciliation{Differences: []Difference{}}
+
+	mappedSecondaryIDs := make(map[string]bool)
+	r.mapping.Range(func(_, secondaryID string) bool {
+		mappedSecondaryIDs[secondaryID] = true
+		return true
+	})
+
+	err := forEachBatch(r.primary, func(books []*models.Book) error {
+		report.PrimaryCount += len(books)
+		for _, b := range books {
+			if diff := r.diff(b); diff != nil {
+				report.Differences = append(report.Differences, *diff)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading primary: %w", err)
+	}
+
+	err = forEachBatch(r.secondary, func(books []*models.Book) error {
+		report.SecondaryCount += len(books)
+		for _, b := range books {
+			if !mappedSecondaryIDs[b.ID] {
+				report.Differences = append(report.Differences, Difference{
+					SecondaryID: b.ID,
+					Reason:      reasonOnlySecondary,
+					Secondary:   b,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading secondary: %w", err)
+	}
+
+	return report, nil
+}
+
+func (r *Repo) diff(primaryBook *models.Book) *Difference {
+	return r.diffWith(primaryBook, r.secondary.GetBook)
+}
+
+// diffWith compares the primary book with the secondary one returned by
+// getSecondary for its mapped ID.
+func (r *Repo) diffWith(primaryBook *models.Book, getSecondary func(id string) (*models.Book, error)) *Difference {
+	secondaryID, ok := r.mapping.Get(primaryBook.ID)
+	if !ok {
+		return &Difference{PrimaryID: primaryBook.ID, Reason: reasonNotMapped, Primary: primaryBook}
+	}
+
+	secondaryBook, err := getSecondary(secondaryID)
+	if err != nil || secondaryBook == nil {
+		return &Difference{PrimaryID: primaryBook.ID, SecondaryID: secondaryID, Reason: reasonMissing, Primary: primaryBook}
+	}
+
+	if secondaryBook.Name != primaryBook.Name || secondaryBook.Author != primaryBook.Author {
+		return &Difference{
+			PrimaryID:   primaryBook.ID,
+			SecondaryID: secondaryID,
+			Reason:      reasonMismatch,
+			Primary:     primaryBook,
+			Secondary:   secondaryBook,
+		}
+	}
+
+	return nil
+}
+
+// shadow runs compare in the background when a shadow slot is free and
+// skips it otherwise.
+func (r *Repo) shadow(compare func()) {
+	select {
+	case r.shadowSlots <- struct{}{}:
+	default:
+		r.shadowReadsSkipped.Add(1)
+		return
+	}
+
+	r.shadowWG.Add(1)
+	go func() {
+		defer r.shadowWG.Done()
+		defer func() { <-r.shadowSlots }()
+		r.shadowReadsDone.Add(1)
+		compare()
+	}()
+}
+
+func (r *Repo) compareOne(primaryBook *models.Book) {
+	if diff := r.diff(primaryBook); diff != nil {
+		r.diverged(diff)
+	}
+}
+
+// compareAll compares a list of primary books with a single list of
+// secondary books instead of reading them one by one.
+func (r *Repo) compareAll(primaryBooks []*models.Book) {
+	secondaryBooks, err := r.secondary.GetAllBooks()
+	if err != nil {
+		log.Printf("dual-write: shadow read of secondary books failed: %s\n", err)
+		return
+	}
+
+	byID := make(map[string]*models.Book, len(secondaryBooks))
+	for _, b := range secondaryBooks {
+		byID[b.ID] = b
+	}
+	getSecondary := func(id string) (*models.Book, error) {
+		if b, ok := byID[id]; ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+
+	for _, b := range primaryBooks {
+		if diff := r.diffWith(b, getSecondary); diff != nil {
+			r.diverged(diff)
+		}
+	}
+}
+
+func (r *Repo) diverged(diff *Difference) {
+	r.divergences.Add(1)
+	log.Printf("dual-write: book (primary_id=%s, secondary_id=%s) diverged: %s\n", diff.PrimaryID, diff.SecondaryID, diff.Reason)
+}
+
+func (r *Repo) secondaryWriteFailed(op, id string, err error) {
+	r.secondaryWriteErrors.Add(1)
+	log.Printf("dual-write: secondary %s of book (id=%s) failed: %s\n", op, id, err)
+}
+
+func forEachBatch(repo repository.BookRepo, fn func([]*models.Book) error) error {
+	for offset := 0; ; offset += reconcileBatchSize {
+		books, err := repo.GetBooksBatch(offset, reconcileBatchSize)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(books); err != nil {
+			return err
+		}
+
+		if len(books) < reconcileBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package dualwrite
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/idmap"
+	"github.com/auwendil/crud-app/internal/models"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_DualWrite_AddBook_ShouldWriteToBothBackends(t *testing.T) {
+	// setup
+	primary, secondary := newRepoStub("p"), newRepoStub("s")
+	ts := New(primary, secondary, prepareMapping(t), false)
+
+	// when
+	created, err := ts.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while adding book:", err)
+	}
+
+	secondaryID, ok := ts.mapping.Get(created.ID)
+	if !ok {
+		t.Fatalf("Created book (id=%s) is not mapped to secondary\n", created.ID)
+	}
+
+	secondaryBook, err := secondary.GetBook(secondaryID)
+	if err != nil {
+		t.Fatal("Book was not written to secondary:", err)
+	}
+
+	if secondaryBook.Name != "Book1" || secondaryBook.Author != "Author1" {
+		t.Fatalf("Secondary book differs from created one: %+v\n", secondaryBook)
+	}
+}
+
+func Test_DualWrite_UpdateBook_ShouldNotFailWhenSecondaryFails(t *testing.T) {
+	// setup
+	primary, secondary := newRepoStub("p"), newRepoStub("s")
+	ts := New(primary, secondary, prepareMapping(t), false)
+
+	created, err := ts.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while adding book: %s\n", err)
+	}
+
+	// given
+	secondary.failWrites = true
+
+	// when
+	err = ts.UpdateBook(created.ID, &models.Book{Name: "Updated", Author: "Author1"})
+
+	// then
+	if err != nil {
+		t.Fatal("Secondary failure should not be returned:", err)
+	}
+
+	if book, _ := primary.GetBook(created.ID); book.Name != "Updated" {
+		t.Fatalf("Primary book was not updated: %+v\n", book)
+	}
+
+	if ts.Stats().SecondaryWriteErrors != 1 {
+		t.Fatalf("Secondary write errors should be counted, stats: %+v\n", ts.Stats())
+	}
+}
+
+func Test_DualWrite_GetBook_ShouldCountShadowReadDivergence(t *testing.T) {
+	// setup
+	primary, secondary := newRepoStub("p"), newRepoStub("s")
+	ts := New(primary, secondary, prepareMapping(t), true)
+
+	created, err := ts.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while adding book: %s\n", err)
+	}
+
+	// given
+	secondaryID, _ := ts.mapping.Get(created.ID)
+	secondary.books[secondaryID].Name = "Diverged"
+
+	// when
+	book, err := ts.GetBook(created.ID)
+	ts.shadowWG.Wait()
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while getting book:", err)
+	}
+
+	if book.Name != "Book1" {
+		t.Fatalf("Book should be served from primary, has: %+v\n", book)
+	}
+
+	stats := ts.Stats()
+	if stats.ShadowReads != 1 || stats.Divergences != 1 {
+		t.Fatalf("Shadow read divergence should be counted, stats: %+v\n", stats)
+	}
+}
+
+func Test_DualWrite_GetAllBooks_ShouldCompareWithSingleSecondaryRead(t *testing.T) {
+	// setup
+	primary, secondary := newRepoStub("p"), newRepoStub("s")
+	ts := New(primary, secondary, prepareMapping(t), true)
+
+	var created []*models.Book
+	for i := 0; i < 3; i++ {
+		b, err := ts.AddBook(&models.Book{Name: fmt.Sprintf("Book%d", i), Author: "Author"})
+		if err != nil {
+			t.Fatalf("[SETUP] Encountered error while adding book: %s\n", err)
+		}
+		created = append(created, b)
+	}
+
+	// given
+	secondaryID, _ := ts.mapping.Get(created[1].ID)
+	delete(secondary.books, secondaryID)
+
+	// when
+	_, err := ts.GetAllBooks()
+	ts.shadowWG.Wait()
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while getting books:", err)
+	}
+
+	if secondary.getAllBooksCalls.Load() != 1 || secondary.getBookCalls.Load() != 0 {
+		t.Fatalf("Expected one list read of secondary, has %d list and %d single reads\n", secondary.getAllBooksCalls.Load(), secondary.getBookCalls.Load())
+	}
+
+	if stats := ts.Stats(); stats.ShadowReads != 1 || stats.Divergences != 1 {
+		t.Fatalf("Missing secondary book should be counted, stats: %+v\n", stats)
+	}
+}
+
+func Test_DualWrite_GetBook_ShouldSkipShadowReadsWhenAllSlotsAreBusy(t *testing.T) {
+	// setup
+	primary, secondary := newRepoStub("p"), newRepoStub("s")
+	ts := New(primary, secondary, prepareMapping(t), true)
+
+	created, err := ts.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while adding book: %s\n", err)
+	}
+
+	// given
+	for i := 0; i < maxShadowReads; i++ {
+		ts.shadowSlots <- struct{}{}
+	}
+
+	// when
+	_, err = ts.GetBook(created.ID)
+	ts.shadowWG.Wait()
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while getting book:", err)
+	}
+
+	if stats := ts.Stats(); stats.ShadowReads != 0 || stats.ShadowReadsSkipped != 1 || secondary.getBookCalls.Load() != 0 {
+		t.Fatalf("Shadow read should be skipped, stats: %+v\n", stats)
+	}
+}
+
+func Test_DualWrite_Reconcile_ShouldReportDifferences(t *testing.T) {
+	// setup
+	primary, secondary := newRepoStub("p"), newRepoStub("s")
+	ts := New(primary, secondary, prepareMapping(t), false)
+
+	var created []*models.Book
+	for i := 0; i < 3; i++ {
+		b, err := ts.AddBook(&models.Book{Name: fmt.Sprintf("Book%d", i), Author: "Author"})
+		if err != nil {
+			t.Fatalf("[SETUP] Encountered error while adding book: %s\n", err)
+		}
+		created = append(created, b)
+	}
+
+	// given
+	secondaryID, _ := ts.mapping.Get(created[0].ID)
+	secondary.books[secondaryID].Author = "Changed"
+	_, _ = primary.AddBook(&models.Book{Name: "OnlyPrimary", Author: "Author"})
+	_, _ = secondary.AddBook(&models.Book{Name: "OnlySecondary", Author: "Author"})
+
+	// when
+	report, err := ts.Reconcile()
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while reconciling:", err)
+	}
+
+	if report.PrimaryCount != 4 || report.SecondaryCount != 4 {
+		t.Fatalf("Unexpected counts in report: %+v\n", report)
+	}
+
+	reasons := map[string]int{}
+	for _, d := range report.Differences {
+		reasons[d.Reason]++
+	}
+
+	expected := map[string]int{reasonMismatch: 1, reasonNotMapped: 1, reasonOnlySecondary: 1}
+	for reason, count := range expected {
+		if reasons[reason] != count {
+			t.Errorf("Expected %d differences with reason %q, has %d\n", count, reason, reasons[reason])
+		}
+	}
+}
+
+// utils
+
+type repoStub struct {
+	prefix     string
+	books      map[string]*models.Book
+	nextID     int
+	failWrites bool
+
+	getBookCalls, getAllBooksCalls atomic.Int64
+}
+
+func newRepoStub(prefix string) *repoStub {
+	return &repoStub{prefix: prefix, books: make(map[string]*models.Book), nextID: 1}
+}
+
+func prepareMapping(t *testing.T) *idmap.FileStore {
+	mapping, err := idmap.OpenFileStore(filepath.Join(t.TempDir(), "mapping.csv"))
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while opening mapping file: %s\n", err)
+	}
+	t.Cleanup(func() { _ = mapping.Close() })
+	return mapping
+}
+
+func (r *repoStub) GetAllBooks() ([]*models.Book, error) {
+	r.getAllBooksCalls.Add(1)
+	books := []*models.Book{}
+	for _, b := range r.books {
+		books = append(books, b)
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+	return books, nil
+}
+
+func (r *repoStub) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	books, _ := r.GetAllBooks()
+	if offset >= len(books) {
+		return []*models.Book{}, nil
+	}
+	if offset+limit > len(books) {
+		limit = len(books) - offset
+	}
+	return books[offset : offset+limit], nil
+}
+
+func (r *repoStub) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	books := []*models.Book{}
+	for _, id := range ids {
+		if b, ok := r.books[id]; ok {
+			books = append(books, b)
+		}
+	}
+	return books, nil
+}
+
+func (r *repoStub) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) GetBook(id string) (*models.Book, error) {
+	r.getBookCalls.Add(1)
+	b, ok := r.books[id]
+	if !ok {
+		return nil, fmt.Errorf("book (id=%s) not found", id)
+	}
+	return b, nil
+}
+
+func (r *repoStub) AddBook(b *models.Book) (*models.Book, error) {
+	if r.failWrites {
+		return nil, fmt.Errorf("write failed")
+	}
+
+	created := &models.Book{ID: fmt.Sprintf("%s%03d", r.prefix, r.nextID), Name: b.Name, Author: b.Author}
+	r.nextID++
+	r.books[created.ID] = created
+	return created, nil
+}
+
+func (r *repoStub) UpdateBook(id string, updatedBook *models.Book) error {
+	if r.failWrites {
+		return fmt.Errorf("write failed")
+	}
+	if _, ok := r.books[id]; !ok {
+		return fmt.Errorf("book does not exist")
+	}
+	r.books[id] = &models.Book{ID: id, Name: updatedBook.Name, Author: updatedBook.Author}
+	return nil
+}
+
+func (r *repoStub) DeleteBook(id string) error {
+	if r.failWrites {
+		return fmt.Errorf("write failed")
+	}
+	delete(r.books, id)
+	return nil
+}
+
+func (r *repoStub) DeleteAllBooks() error {
+	if r.failWrites {
+		return fmt.Errorf("write failed")
+	}
+	r.books = make(map[string]*models.Book)
+	return nil
+}
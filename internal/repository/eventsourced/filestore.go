@@ -0,0 +1,206 @@
+package eventsourced
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	eventsFileName    = "events.jsonl"
+	snapshotsFileName = "snapshots.jsonl"
+)
+
+// FileStore keeps events and snapshots as JSON lines in two files of a
+// directory. Events are held in memory after opening, snapshots are read
+// from the file when needed. Only one process may use the directory.
+type FileStore struct {
+	mu        sync.Mutex
+	events    *os.File
+	snapshots *os.File
+	log       []Event
+	index     []snapshotEntry
+}
+
+// snapshotEntry locates a snapshot line in the snapshots file.
+type snapshotEntry struct {
+	seq    uint64
+	time   time.Time
+	offset int64
+	size   int
+}
+
+var _ Store = (*FileStore)(nil)
+
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	events, err := os.OpenFile(filepath.Join(dir, eventsFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := os.OpenFile(filepath.Join(dir, snapshotsFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		_ = events.Close()
+		return nil, err
+	}
+
+	s := &FileStore{events: events, snapshots: snapshots}
+	if err = s.readEvents(); err == nil {
+		err = s.readSnapshots()
+	}
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) readEvents() error {
+	scanner := bufio.NewScanner(s.events)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("decoding event %d: %w", len(s.log)+1, err)
+		}
+		if e.Seq != uint64(len(s.log))+1 {
+			return fmt.Errorf("event %d found in place of %d", e.Seq, len(s.log)+1)
+		}
+		s.log = append(s.log, e)
+	}
+	return scanner.Err()
+}
+
+func (s *FileStore) readSnapshots() error {
+	reader := bufio.NewReader(s.snapshots)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+
+		// books are not needed to index the snapshot
+		var header struct {
+			Seq  uint64    `json:"seq"`
+			Time time.Time `json:"time"`
+		}
+		if err := json.Unmarshal(line, &header); err != nil {
+			return fmt.Errorf("decoding snapshot at offset %d: %w", offset, err)
+		}
+
+		s.index = append(s.index, snapshotEntry{seq: header.Seq, time: header.Time, offset: offset, size: len(line)})
+		offset += int64(len(line))
+	}
+}
+
+func (s *FileStore) Append(expectedSeq uint64, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expectedSeq != uint64(len(s.log)) {
+		return ErrConflict
+	}
+
+	var data []byte
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	if err := s.write(s.events, data); err != nil {
+		return err
+	}
+	s.log = append(s.log, events...)
+	return nil
+}
+
+func (s *FileStore) Load(afterSeq uint64) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if afterSeq >= uint64(len(s.log)) {
+		return nil, nil
+	}
+
+	events := make([]Event, len(s.log)-int(afterSeq))
+	copy(events, s.log[afterSeq:])
+	return events, nil
+}
+
+func (s *FileStore) SaveSnapshot(snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	info, err := s.snapshots.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err = s.write(s.snapshots, line); err != nil {
+		return err
+	}
+	s.index = append(s.index, snapshotEntry{seq: snapshot.Seq, time: snapshot.Time, offset: info.Size(), size: len(line)})
+	return nil
+}
+
+func (s *FileStore) LatestSnapshot(at time.Time) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// snapshots are appended in order of events, so their times grow too
+	i := sort.Search(len(s.index), func(i int) bool { return s.index[i].time.After(at) })
+	if i == 0 {
+		return nil, nil
+	}
+
+	entry := s.index[i-1]
+	line := make([]byte, entry.size)
+	if _, err := s.snapshots.ReadAt(line, entry.offset); err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(line, &snapshot); err != nil {
+		return nil, fmt.Errorf("decoding snapshot at %d: %w", entry.seq, err)
+	}
+	return &snapshot, nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return errors.Join(s.events.Close(), s.snapshots.Close())
+}
+
+// write appends data and syncs it to disk, a stored event is never lost.
+func (s *FileStore) write(file *os.File, data []byte) error {
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,142 @@
+package eventsourced
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+const (
+	postgresDBTimeout = time.Second * 3
+	// postgresUniqueViolation is the SQLSTATE of inserting a taken key.
+	postgresUniqueViolation = "23505"
+)
+
+// PostgreSQLStore keeps events in book_events, whose primary key is the
+// sequence number, so one of two concurrent writers appending after the
+// same event always fails with ErrConflict.
+type PostgreSQLStore struct {
+	DB *sql.DB
+}
+
+var _ Store = (*PostgreSQLStore)(nil)
+
+func NewPostgreSQLStore(db *sql.DB) *PostgreSQLStore {
+	return &PostgreSQLStore{
+		DB: db,
+	}
+}
+
+func (s *PostgreSQLStore) Append(expectedSeq uint64, events []Event) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO public.book_events (seq, event_type, tenant, book_id, name, author, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);
+	`
+
+	for i, e := range events {
+		if e.Seq != expectedSeq+uint64(i)+1 {
+			return fmt.Errorf("event %d appended in place of %d", e.Seq, expectedSeq+uint64(i)+1)
+		}
+
+		_, err = tx.ExecContext(ctx, query, e.Seq, e.Type, e.Tenant, e.BookID, e.Name, e.Author, e.Time)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgreSQLStore) Load(afterSeq uint64) ([]Event, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT seq, event_type, tenant, book_id, name, author, created_at
+		FROM public.book_events
+		WHERE seq > $1
+		ORDER BY seq;
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, afterSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err = rows.Scan(&e.Seq, &e.Type, &e.Tenant, &e.BookID, &e.Name, &e.Author, &e.Time); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *PostgreSQLStore) SaveSnapshot(snapshot *Snapshot) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	books, err := json.Marshal(snapshot.Books)
+	if err != nil {
+		return err
+	}
+
+	// another process may have saved the same state already
+	query := `
+		INSERT INTO public.book_snapshots (seq, created_at, books)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (seq) DO NOTHING;
+	`
+
+	_, err = s.DB.ExecContext(ctx, query, snapshot.Seq, snapshot.Time, books)
+	return err
+}
+
+func (s *PostgreSQLStore) LatestSnapshot(at time.Time) (*Snapshot, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT seq, created_at, books
+		FROM public.book_snapshots
+		WHERE created_at <= $1
+		ORDER BY seq DESC
+		LIMIT 1;
+	`
+
+	var snapshot Snapshot
+	var books []byte
+	err := s.DB.QueryRowContext(ctx, query, at).Scan(&snapshot.Seq, &snapshot.Time, &books)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(books, &snapshot.Books); err != nil {
+		return nil, fmt.Errorf("decoding snapshot at %d: %w", snapshot.Seq, err)
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,91 @@
+package eventsourced
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_Postgresql_Append_ShouldReturnConflictOnTakenSeq(t *testing.T) {
+	// setup
+	store, mock := prepareTestDB(t)
+	defer store.DB.Close()
+
+	// given
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO public.book_events (seq, event_type, tenant, book_id, name, author, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7);`)).
+		WithArgs(3, BookCreated, "default", "3", "Book3", "Author3", now).
+		WillReturnError(&pgconn.PgError{Code: postgresUniqueViolation})
+	mock.ExpectRollback()
+
+	// when
+	err := store.Append(2, []Event{{Seq: 3, Type: BookCreated, Tenant: "default", BookID: "3", Name: "Book3", Author: "Author3", Time: now}})
+
+	// then
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict, has: %v\n", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_Load_ShouldReturnEventsAfterSeq(t *testing.T) {
+	// setup
+	store, mock := prepareTestDB(t)
+	defer store.DB.Close()
+
+	// given
+	dbRows := sqlmock.NewRows([]string{"seq", "event_type", "tenant", "book_id", "name", "author", "created_at"})
+	dbRows.AddRow(3, "BookRenamed", "default", "1", "Book2", "", time.Now())
+	dbRows.AddRow(4, "BookDeleted", "default", "1", "", "", time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT seq, event_type, tenant, book_id, name, author, created_at FROM public.book_events WHERE seq > $1 ORDER BY seq;`)).
+		WithArgs(2).
+		WillReturnRows(dbRows)
+
+	// when
+	events, err := store.Load(2)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != BookRenamed || events[1].Seq != 4 {
+		t.Fatalf("Returned events are different than expected: %+v\n", events)
+	}
+}
+
+func Test_Postgresql_LatestSnapshot_ShouldReturnNilWithoutSnapshots(t *testing.T) {
+	// setup
+	store, mock := prepareTestDB(t)
+	defer store.DB.Close()
+
+	// given
+	at := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT seq, created_at, books FROM public.book_snapshots WHERE created_at <= $1 ORDER BY seq DESC LIMIT 1;`)).
+		WithArgs(at).
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "created_at", "books"}))
+
+	// when
+	snapshot, err := store.LatestSnapshot(at)
+
+	// then
+	if err != nil || snapshot != nil {
+		t.Fatalf("Expected no snapshot, has: %v %v\n", snapshot, err)
+	}
+}
+
+// utils
+
+func prepareTestDB(t *testing.T) (*PostgreSQLStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLStore(db), mock
+}
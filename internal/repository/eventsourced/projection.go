@@ -0,0 +1,148 @@
+package eventsourced
+
+import (
+	"github.com/auwendil/crud-app/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// projection is the catalog after applying events up to seq.
+type projection struct {
+	seq   uint64
+	time  time.Time
+	books map[string]map[string]*models.Book
+}
+
+func newProjection() *projection {
+	return &projection{books: make(map[string]map[string]*models.Book)}
+}
+
+func projectionFromSnapshot(s *Snapshot) *projection {
+	p := newProjection()
+	p.seq, p.time = s.Seq, s.Time
+	for _, b := range s.Books {
+		p.tenantBooks(b.Tenant)[b.ID] = &models.Book{ID: b.ID, Name: b.Name, Author: b.Author}
+	}
+	return p
+}
+
+func (p *projection) snapshot() *Snapshot {
+	s := &Snapshot{Seq: p.seq, Time: p.time, Books: []SnapshotBook{}}
+	for tenant, books := range p.books {
+		for _, b := range books {
+			s.Books = append(s.Books, SnapshotBook{Tenant: tenant, ID: b.ID, Name: b.Name, Author: b.Author})
+		}
+	}
+	return s
+}
+
+func (p *projection) tenantBooks(tenant string) map[string]*models.Book {
+	books, ok := p.books[tenant]
+	if !ok {
+		books = make(map[string]*models.Book)
+		p.books[tenant] = books
+	}
+	return books
+}
+
+func (p *projection) apply(e Event) {
+	books := p.tenantBooks(e.Tenant)
+	switch e.Type {
+	case BookCreated:
+		books[e.BookID] = &models.Book{ID: e.BookID, Name: e.Name, Author: e.Author}
+	case BookRenamed:
+		if b, ok := books[e.BookID]; ok {
+			b.Name = e.Name
+		}
+	case AuthorChanged:
+		if b, ok := books[e.BookID]; ok {
+			b.Author = e.Author
+		}
+	case BookDeleted:
+		delete(books, e.BookID)
+	}
+
+	p.seq, p.time = e.Seq, e.Time
+}
+
+// get returns a copy of the book, reads never share books of the state.
+func (p *projection) get(tenant, id string) (*models.Book, bool) {
+	b, ok := p.books[tenant][id]
+	if !ok {
+		return nil, false
+	}
+	return &models.Book{ID: b.ID, Name: b.Name, Author: b.Author}, true
+}
+
+// list returns copies of books of tenant accepted by match, ordered by
+// ID.
+func (p *projection) list(tenant string, match func(b *models.Book) bool) []*models.Book {
+	books := []*models.Book{}
+	for _, b := range p.books[tenant] {
+		if match == nil || match(b) {
+			books = append(books, &models.Book{ID: b.ID, Name: b.Name, Author: b.Author})
+		}
+	}
+
+	sort.Slice(books, func(i, j int) bool { return idLess(books[i].ID, books[j].ID) })
+	return books
+}
+
+func (p *projection) search(tenant, phrase string) []*models.Book {
+	phrase = strings.ToLower(phrase)
+	return p.list(tenant, func(b *models.Book) bool {
+		return strings.Contains(strings.ToLower(b.Name), phrase) || strings.Contains(strings.ToLower(b.Author), phrase)
+	})
+}
+
+// countAuthors counts books of tenant among ids, or all of them when ids
+// is nil, by their authors, the most frequent authors first.
+func (p *projection) countAuthors(tenant string, ids []string) []*models.FacetCount {
+	var books []*models.Book
+	if ids == nil {
+		books = p.list(tenant, nil)
+	} else {
+		for _, id := range ids {
+			if b, ok := p.get(tenant, id); ok {
+				books = append(books, b)
+			}
+		}
+	}
+
+	byAuthor := make(map[string]int)
+	for _, b := range books {
+		byAuthor[b.Author]++
+	}
+
+	counts := make([]*models.FacetCount, 0, len(byAuthor))
+	for author, count := range byAuthor {
+		counts = append(counts, &models.FacetCount{Value: author, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+	return counts
+}
+
+func idLess(a, b string) bool {
+	x, _ := strconv.ParseUint(a, 10, 64)
+	y, _ := strconv.ParseUint(b, 10, 64)
+	return x < y
+}
+
+func page(books []*models.Book, offset, limit int) []*models.Book {
+	if offset >= len(books) {
+		return []*models.Book{}
+	}
+
+	books = books[offset:]
+	if len(books) > limit {
+		books = books[:limit]
+	}
+	return books
+}
@@ -0,0 +1,352 @@
+package eventsourced
+
+import (
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultSnapshotEvery is the amount of events between snapshots.
+const DefaultSnapshotEvery = 1000
+
+// maxAppendAttempts limits retries of writes conflicting with other
+// writers to the same store.
+const maxAppendAttempts = 3
+
+// ErrReadOnly is returned by writes to the catalog of the past.
+var ErrReadOnly = errors.New("past state of the catalog cannot be changed")
+
+// Repo keeps the catalog as a stream of events. Reads are served from a
+// projection of the stream, which catches up with events appended by
+// other processes before every operation. Every SnapshotEvery events the
+// projection is saved as a snapshot, so rebuilding it needs only the
+// events after the latest one.
+type Repo struct {
+	core   *core
+	tenant string
+}
+
+type core struct {
+	mu            sync.Mutex
+	store         Store
+	state         *projection
+	snapshotEvery int
+	sinceSnapshot int
+}
+
+var _ repository.BookRepo = (*Repo)(nil)
+
+// New rebuilds the current state from store. snapshotEvery of 0 disables
+// snapshots.
+func New(store Store, snapshotEvery int) (*Repo, error) {
+	c := &core{
+		store:         store,
+		state:         newProjection(),
+		snapshotEvery: snapshotEvery,
+	}
+
+	snapshot, err := store.LatestSnapshot(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("loading snapshot: %w", err)
+	}
+	if snapshot != nil {
+		c.state = projectionFromSnapshot(snapshot)
+	}
+
+	if err = c.catchUp(); err != nil {
+		return nil, err
+	}
+
+	return &Repo{core: c, tenant: repository.DefaultTenant}, nil
+}
+
+func (r *Repo) ForTenant(tenant string) (repository.BookRepo, error) {
+	return r.Tenant(tenant)
+}
+
+// Tenant returns the Repo of tenant sharing the event stream.
+func (r *Repo) Tenant(tenant string) (*Repo, error) {
+	if err := repository.ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	return &Repo{core: r.core, tenant: tenant}, nil
+}
+
+// AsOf replays events up to t, starting from the latest snapshot taken
+// before it, and returns the catalog of the tenant as it was then. Writes
+// to the returned repository fail with ErrReadOnly.
+func (r *Repo) AsOf(t time.Time) (repository.BookRepo, error) {
+	state := newProjection()
+
+	snapshot, err := r.core.store.LatestSnapshot(t)
+	if err != nil {
+		return nil, fmt.Errorf("loading snapshot: %w", err)
+	}
+	if snapshot != nil {
+		state = projectionFromSnapshot(snapshot)
+	}
+
+	events, err := r.core.store.Load(state.seq)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		if e.Time.After(t) {
+			break
+		}
+		state.apply(e)
+	}
+
+	return &pastRepo{state: state, tenant: r.tenant}, nil
+}
+
+func (c *core) catchUp() error {
+	events, err := c.store.Load(c.state.seq)
+	if err != nil {
+		return fmt.Errorf("loading events: %w", err)
+	}
+
+	for _, e := range events {
+		c.state.apply(e)
+	}
+	c.snapshotAfter(len(events))
+	return nil
+}
+
+// snapshotAfter saves a snapshot once enough events were applied. The
+// events are already stored, so failures are only logged.
+func (c *core) snapshotAfter(applied int) {
+	c.sinceSnapshot += applied
+	if c.snapshotEvery <= 0 || c.sinceSnapshot < c.snapshotEvery {
+		return
+	}
+
+	if err := c.store.SaveSnapshot(c.state.snapshot()); err != nil {
+		log.Printf("eventsourced: saving snapshot at %d failed: %s\n", c.state.seq, err)
+		return
+	}
+	c.sinceSnapshot = 0
+}
+
+// read runs fn on the current state.
+func (c *core) read(fn func(p *projection)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.catchUp(); err != nil {
+		return err
+	}
+	fn(c.state)
+	return nil
+}
+
+// write appends events decided by decide on the current state. Events of
+// other writers appended in the meantime are applied and decide is asked
+// again. BookCreated events without BookID get their Seq as the ID.
+func (c *core) write(decide func(p *projection) ([]Event, error)) ([]Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		if err := c.catchUp(); err != nil {
+			return nil, err
+		}
+
+		events, err := decide(c.state)
+		if err != nil || len(events) == 0 {
+			return nil, err
+		}
+
+		now := time.Now().UTC()
+		for i := range events {
+			events[i].Seq = c.state.seq + uint64(i) + 1
+			events[i].Time = now
+			if events[i].Type == BookCreated && events[i].BookID == "" {
+				events[i].BookID = strconv.FormatUint(events[i].Seq, 10)
+			}
+		}
+
+		err = c.store.Append(c.state.seq, events)
+		if errors.Is(err, ErrConflict) && attempt < maxAppendAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range events {
+			c.state.apply(e)
+		}
+		c.snapshotAfter(len(events))
+		return events, nil
+	}
+}
+
+func (r *Repo) GetAllBooks() ([]*models.Book, error) {
+	var books []*models.Book
+	err := r.core.read(func(p *projection) {
+		books = p.list(r.tenant, nil)
+	})
+	return books, err
+}
+
+func (r *Repo) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	var books []*models.Book
+	err := r.core.read(func(p *projection) {
+		books = page(p.list(r.tenant, nil), offset, limit)
+	})
+	return books, err
+}
+
+func (r *Repo) GetBook(id string) (*models.Book, error) {
+	var book *models.Book
+	err := r.core.read(func(p *projection) {
+		book, _ = p.get(r.tenant, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if book == nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+	return book, nil
+}
+
+func (r *Repo) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	books := []*models.Book{}
+	err := r.core.read(func(p *projection) {
+		for _, id := range ids {
+			if b, ok := p.get(r.tenant, id); ok {
+				books = append(books, b)
+			}
+		}
+	})
+	return books, err
+}
+
+func (r *Repo) CountAuthors(ids []string) ([]*models.FacetCount, error) {
+	var counts []*models.FacetCount
+	err := r.core.read(func(p *projection) {
+		counts = p.countAuthors(r.tenant, ids)
+	})
+	return counts, err
+}
+
+func (r *Repo) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	var books []*models.Book
+	err := r.core.read(func(p *projection) {
+		books = page(p.search(r.tenant, phrase), offset, limit)
+	})
+	return books, err
+}
+
+func (r *Repo) AddBook(b *models.Book) (*models.Book, error) {
+	events, err := r.core.write(func(p *projection) ([]Event, error) {
+		return []Event{{Type: BookCreated, Tenant: r.tenant, Name: b.Name, Author: b.Author}}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Book{ID: events[0].BookID, Name: b.Name, Author: b.Author}, nil
+}
+
+// UpdateBook records only fields which changed, an update without
+// changes appends nothing.
+func (r *Repo) UpdateBook(id string, updatedBook *models.Book) error {
+	_, err := r.core.write(func(p *projection) ([]Event, error) {
+		current, ok := p.get(r.tenant, id)
+		if !ok {
+			return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+		}
+
+		var events []Event
+		if current.Name != updatedBook.Name {
+			events = append(events, Event{Type: BookRenamed, Tenant: r.tenant, BookID: id, Name: updatedBook.Name})
+		}
+		if current.Author != updatedBook.Author {
+			events = append(events, Event{Type: AuthorChanged, Tenant: r.tenant, BookID: id, Author: updatedBook.Author})
+		}
+		return events, nil
+	})
+	return err
+}
+
+func (r *Repo) DeleteBook(id string) error {
+	_, err := r.core.write(func(p *projection) ([]Event, error) {
+		if _, ok := p.get(r.tenant, id); !ok {
+			return nil, nil
+		}
+		return []Event{{Type: BookDeleted, Tenant: r.tenant, BookID: id}}, nil
+	})
+	return err
+}
+
+func (r *Repo) DeleteAllBooks() error {
+	_, err := r.core.write(func(p *projection) ([]Event, error) {
+		var events []Event
+		for _, b := range p.list(r.tenant, nil) {
+			events = append(events, Event{Type: BookDeleted, Tenant: r.tenant, BookID: b.ID})
+		}
+		return events, nil
+	})
+	return err
+}
+
+// pastRepo serves a replayed state which never changes.
+type pastRepo struct {
+	state  *projection
+	tenant string
+}
+
+func (r *pastRepo) GetAllBooks() ([]*models.Book, error) {
+	return r.state.list(r.tenant, nil), nil
+}
+
+func (r *pastRepo) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return page(r.state.list(r.tenant, nil), offset, limit), nil
+}
+
+func (r *pastRepo) GetBook(id string) (*models.Book, error) {
+	book, ok := r.state.get(r.tenant, id)
+	if !ok {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+	return book, nil
+}
+
+func (r *pastRepo) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	books := []*models.Book{}
+	for _, id := range ids {
+		if b, ok := r.state.get(r.tenant, id); ok {
+			books = append(books, b)
+		}
+	}
+	return books, nil
+}
+
+func (r *pastRepo) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return page(r.state.search(r.tenant, phrase), offset, limit), nil
+}
+
+func (r *pastRepo) AddBook(b *models.Book) (*models.Book, error) {
+	return nil, ErrReadOnly
+}
+
+func (r *pastRepo) UpdateBook(id string, updatedBook *models.Book) error {
+	return ErrReadOnly
+}
+
+func (r *pastRepo) DeleteBook(id string) error {
+	return ErrReadOnly
+}
+
+func (r *pastRepo) DeleteAllBooks() error {
+	return ErrReadOnly
+}
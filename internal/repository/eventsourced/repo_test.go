@@ -0,0 +1,231 @@
+package eventsourced
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Repo_Writes_ShouldAppendEvents(t *testing.T) {
+	// setup
+	store := prepareFileStore(t, t.TempDir())
+	repo, err := New(store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// given
+	book, err := repo.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	if err = repo.UpdateBook(book.ID, &models.Book{Name: "Book2", Author: "Author2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateBook(book.ID, &models.Book{Name: "Book2", Author: "Author2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteBook(book.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.DeleteBook(book.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	events, err := store.Load(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	expectedTypes := []EventType{BookCreated, BookRenamed, AuthorChanged, BookDeleted}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Fatalf("Stored events are %v, should be %v\n", types, expectedTypes)
+	}
+
+	if _, err = repo.GetBook(book.ID); !errors.Is(err, repository.ErrBookNotFound) {
+		t.Fatalf("Deleted book should not be found, has: %v\n", err)
+	}
+}
+
+func Test_Repo_UpdateBook_ShouldReturnNotFound(t *testing.T) {
+	// setup
+	repo, err := New(prepareFileStore(t, t.TempDir()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	err = repo.UpdateBook("1", &models.Book{Name: "Book1", Author: "Author1"})
+
+	// then
+	if !errors.Is(err, repository.ErrBookNotFound) {
+		t.Fatalf("Expected ErrBookNotFound, has: %v\n", err)
+	}
+}
+
+func Test_Repo_Tenants_ShouldSeeOnlyOwnBooks(t *testing.T) {
+	// setup
+	repo, err := New(prepareFileStore(t, t.TempDir()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, _ := repo.Tenant("acme")
+	globex, _ := repo.Tenant("globex")
+
+	// given
+	if _, err = acme.AddBook(&models.Book{Name: "Book1", Author: "Author1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = globex.AddBook(&models.Book{Name: "Book2", Author: "Author2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	if err = globex.DeleteAllBooks(); err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	acmeBooks, _ := acme.GetAllBooks()
+	globexBooks, _ := globex.GetAllBooks()
+	if len(acmeBooks) != 1 || acmeBooks[0].Name != "Book1" || len(globexBooks) != 0 {
+		t.Fatalf("Tenants see books different than expected: %v %v\n", acmeBooks, globexBooks)
+	}
+}
+
+func Test_Repo_New_ShouldRestoreStateFromSnapshotAndEvents(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	store := prepareFileStore(t, dir)
+	repo, err := New(store, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// given
+	for _, name := range []string{"Book1", "Book2", "Book3"} {
+		if _, err = repo.AddBook(&models.Book{Name: name, Author: "Author"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.Close()
+
+	// when
+	reopened := prepareFileStore(t, dir)
+	restored, err := New(reopened, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// then
+	snapshot, _ := reopened.LatestSnapshot(time.Now())
+	if snapshot == nil || snapshot.Seq != 2 {
+		t.Fatalf("Snapshot should be taken after 2 events, has: %+v\n", snapshot)
+	}
+
+	books, _ := restored.GetAllBooks()
+	expectedBooks := []*models.Book{
+		{ID: "1", Name: "Book1", Author: "Author"},
+		{ID: "2", Name: "Book2", Author: "Author"},
+		{ID: "3", Name: "Book3", Author: "Author"},
+	}
+	if !reflect.DeepEqual(books, expectedBooks) {
+		t.Fatalf("Restored books are %v, should be %v\n", books, expectedBooks)
+	}
+}
+
+func Test_Repo_AsOf_ShouldReplayEventsUpToTime(t *testing.T) {
+	// setup
+	store := prepareFileStore(t, t.TempDir())
+	repo, err := New(store, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// given
+	book, _ := repo.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+	_ = repo.UpdateBook(book.ID, &models.Book{Name: "Book2", Author: "Author1"})
+	events, _ := store.Load(0)
+	renamedAt := events[1].Time
+	time.Sleep(time.Millisecond)
+	_ = repo.DeleteBook(book.ID)
+
+	tests := []struct {
+		name         string
+		at           time.Time
+		expectedName string
+	}{
+		{"Before creation", events[0].Time.Add(-time.Millisecond), ""},
+		{"After creation", events[0].Time, "Book1"},
+		{"After rename", renamedAt, "Book2"},
+		{"After deletion", time.Now(), ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// when
+			past, err := repo.AsOf(tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			books, _ := past.GetAllBooks()
+
+			// then
+			if tt.expectedName == "" && len(books) != 0 {
+				t.Fatalf("No books expected, has: %v\n", books)
+			}
+			if tt.expectedName != "" && (len(books) != 1 || books[0].Name != tt.expectedName) {
+				t.Fatalf("Expected book %s, has: %v\n", tt.expectedName, books)
+			}
+
+			if err = past.DeleteAllBooks(); !errors.Is(err, ErrReadOnly) {
+				t.Fatalf("Past state should be read only, has: %v\n", err)
+			}
+		})
+	}
+}
+
+func Test_Repo_Write_ShouldCatchUpWithOtherWriters(t *testing.T) {
+	// setup
+	store := prepareFileStore(t, t.TempDir())
+	first, _ := New(store, 0)
+	second, _ := New(store, 0)
+
+	// given
+	if _, err := first.AddBook(&models.Book{Name: "Book1", Author: "Author1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// when
+	book, err := second.AddBook(&models.Book{Name: "Book2", Author: "Author2"})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.ID != "2" {
+		t.Fatalf("Book should be created by event 2, has ID %s\n", book.ID)
+	}
+}
+
+// utils
+
+func prepareFileStore(t *testing.T, dir string) *FileStore {
+	store, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while opening event store: %s\n", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
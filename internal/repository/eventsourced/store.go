@@ -0,0 +1,60 @@
+package eventsourced
+
+import (
+	"errors"
+	"time"
+)
+
+type EventType string
+
+const (
+	BookCreated   EventType = "BookCreated"
+	BookRenamed   EventType = "BookRenamed"
+	AuthorChanged EventType = "AuthorChanged"
+	BookDeleted   EventType = "BookDeleted"
+)
+
+// ErrConflict is returned by Store.Append when another writer appended
+// events first.
+var ErrConflict = errors.New("events were appended concurrently")
+
+// Event is an immutable fact about one book. Seq numbers events of the
+// whole store from 1 without gaps, the ID of a book is the Seq of its
+// BookCreated event.
+type Event struct {
+	Seq    uint64    `json:"seq"`
+	Type   EventType `json:"type"`
+	Tenant string    `json:"tenant"`
+	BookID string    `json:"book_id"`
+	Name   string    `json:"name,omitempty"`
+	Author string    `json:"author,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Snapshot is the state after the event Seq, published at Time.
+type Snapshot struct {
+	Seq   uint64         `json:"seq"`
+	Time  time.Time      `json:"time"`
+	Books []SnapshotBook `json:"books"`
+}
+
+type SnapshotBook struct {
+	Tenant string `json:"tenant"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Author string `json:"author"`
+}
+
+// Store keeps events append-only, together with snapshots of the state
+// which shorten rebuilding it.
+type Store interface {
+	// Append stores events numbered from expectedSeq+1, or returns
+	// ErrConflict when the store already has events after expectedSeq.
+	Append(expectedSeq uint64, events []Event) error
+	// Load returns events after afterSeq ordered by Seq.
+	Load(afterSeq uint64) ([]Event, error)
+	SaveSnapshot(s *Snapshot) error
+	// LatestSnapshot returns the newest snapshot whose Time is not after
+	// at, or nil when there is none.
+	LatestSnapshot(at time.Time) (*Snapshot, error)
+}
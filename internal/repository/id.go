@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ParseID parses a numeric ID, so SQL repositories compare it with the
+// indexed column as a number. IDs which are not numbers match no row and
+// are reported with errNotFound.
+func ParseID(id string, errNotFound error) (int64, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w (id=%s)", errNotFound, id)
+	}
+	return n, nil
+}
@@ -0,0 +1,116 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/auwendil/crud-app/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type MongoDBRepo struct {
+	collection *mongo.Collection
+}
+
+const mongoCollectionName = "idempotency_keys"
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		collection: db.Collection(mongoCollectionName),
+	}
+}
+
+// CreateIndexes adds a TTL index, so MongoDB removes expired keys
+// on its own.
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (r *MongoDBRepo) ReserveIdempotencyKey(rec *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// TTL monitor runs only once a minute, expired keys may still be there
+	expired := bson.D{{Key: "_id", Value: rec.Key}, {Key: "expires_at", Value: bson.D{{Key: "$lte", Value: rec.CreatedAt}}}}
+	if _, err := r.collection.DeleteOne(ctx, expired); err != nil {
+		return nil, err
+	}
+
+	reserved := &models.IdempotencyRecord{
+		Key:         rec.Key,
+		Fingerprint: rec.Fingerprint,
+		LeaseID:     rec.LeaseID,
+		CreatedAt:   rec.CreatedAt,
+		ExpiresAt:   rec.ExpiresAt,
+	}
+
+	_, err := r.collection.InsertOne(ctx, reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var existing *models.IdempotencyRecord
+	if err = r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: rec.Key}}).Decode(&existing); err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+func (r *MongoDBRepo) ExtendIdempotencyKey(key, leaseID string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: key}, {Key: "lease_id", Value: leaseID}, {Key: "status_code", Value: 0}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: expiresAt}}}}
+
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *MongoDBRepo) CompleteIdempotencyKey(rec *models.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: rec.Key}, {Key: "lease_id", Value: rec.LeaseID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status_code", Value: rec.StatusCode},
+		{Key: "content_type", Value: rec.ContentType},
+		{Key: "response_body", Value: rec.ResponseBody},
+		{Key: "expires_at", Value: rec.ExpiresAt},
+	}}}
+
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *MongoDBRepo) DeleteIdempotencyKey(key, leaseID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: key}, {Key: "lease_id", Value: leaseID}})
+	return err
+}
+
+func (r *MongoDBRepo) DeleteExpiredIdempotencyKeys(now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lte", Value: now}}}}
+	res, err := r.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,155 @@
+package idempotency
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+	"time"
+)
+
+func Test_MongoDB_ReserveIdempotencyKey(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should reserve new key", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}},
+			mtest.CreateSuccessResponse(),
+		)
+
+		// when
+		existing, err := ts.ReserveIdempotencyKey(prepareRecord())
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while reserving key:", err)
+		}
+
+		if existing != nil {
+			t.Fatalf("New key should be reserved, returned existing record: %+v\n", existing)
+		}
+	})
+
+	mt.Run("Should return existing record for duplicated key", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		rec := prepareRecord()
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}},
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key error"}),
+			mtest.CreateCursorResponse(1, "db.idempotency_keys", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: rec.Key},
+				{Key: "fingerprint", Value: rec.Fingerprint},
+				{Key: "status_code", Value: 201},
+			}),
+		)
+
+		// when
+		existing, err := ts.ReserveIdempotencyKey(rec)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while reserving key:", err)
+		}
+
+		if existing == nil || existing.StatusCode != 201 || existing.Fingerprint != rec.Fingerprint {
+			t.Fatalf("Existing record differs from stored one: %+v\n", existing)
+		}
+	})
+
+	mt.Run("Should return error when insert fails", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}},
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "insert failed"}),
+		)
+
+		// when
+		_, err := ts.ReserveIdempotencyKey(prepareRecord())
+
+		// then
+		if err == nil || mongo.IsDuplicateKeyError(err) {
+			t.Fatal("Expected insert error but received:", err)
+		}
+	})
+}
+
+func Test_MongoDB_CompleteIdempotencyKey(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should store response of the same request and extend expiry", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		rec := prepareRecord()
+		rec.StatusCode = 201
+		rec.ExpiresAt = rec.CreatedAt.Add(24 * time.Hour)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		// when
+		err := ts.CompleteIdempotencyKey(rec)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while completing key:", err)
+		}
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if leaseID := update.Lookup("q", "lease_id").StringValue(); leaseID != rec.LeaseID {
+			t.Fatalf("Only record leased by the request should be completed, has filter: %s\n", update.Lookup("q"))
+		}
+		if expiresAt := update.Lookup("u", "$set", "expires_at").Time(); !expiresAt.Equal(rec.ExpiresAt.Truncate(time.Millisecond)) {
+			t.Fatalf("Expiry should move to %s, has: %s\n", rec.ExpiresAt, expiresAt)
+		}
+	})
+}
+
+func Test_MongoDB_ExtendIdempotencyKey(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should extend own in-progress lease only", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			collection: mt.Coll,
+		}
+
+		rec := prepareRecord()
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		// when
+		err := ts.ExtendIdempotencyKey(rec.Key, rec.LeaseID, rec.ExpiresAt)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while extending key:", err)
+		}
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if leaseID := update.Lookup("q", "lease_id").StringValue(); leaseID != rec.LeaseID {
+			t.Fatalf("Only record leased by the request should be extended, has filter: %s\n", update.Lookup("q"))
+		}
+		if _, err = update.LookupErr("q", "status_code"); err != nil {
+			t.Fatalf("Only in-progress record should be extended, has filter: %s\n", update.Lookup("q"))
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"github.com/auwendil/crud-app/internal/models"
+	"time"
+)
+
+type PostgreSQLRepo struct {
+	DB *sql.DB
+}
+
+const postgresDBTimeout = time.Second * 3
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{
+		DB: db,
+	}
+}
+
+func (r *PostgreSQLRepo) ReserveIdempotencyKey(rec *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	deleteExpired := `
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND expires_at <= $2;
+	`
+	if _, err := r.DB.ExecContext(ctx, deleteExpired, rec.Key, rec.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	insert := `
+		INSERT INTO idempotency_keys (key, fingerprint, lease_id, status_code, created_at, expires_at)
+		VALUES ($1, $2, $3, 0, $4, $5)
+		ON CONFLICT (key) DO NOTHING;
+	`
+	res, err := r.DB.ExecContext(ctx, insert, rec.Key, rec.Fingerprint, rec.LeaseID, rec.CreatedAt, rec.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if inserted == 1 {
+		return nil, nil
+	}
+
+	query := `
+		SELECT key, fingerprint, lease_id, status_code, content_type, response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE key = $1;
+	`
+
+	var existing models.IdempotencyRecord
+	row := r.DB.QueryRowContext(ctx, query, rec.Key)
+
+	err = row.Scan(&existing.Key, &existing.Fingerprint, &existing.LeaseID, &existing.StatusCode, &existing.ContentType,
+		&existing.ResponseBody, &existing.CreatedAt, &existing.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+func (r *PostgreSQLRepo) ExtendIdempotencyKey(key, leaseID string, expiresAt time.Time) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		UPDATE idempotency_keys
+		SET expires_at = $3
+		WHERE key = $1 AND lease_id = $2 AND status_code = 0;
+	`
+
+	_, err := r.DB.ExecContext(ctx, query, key, leaseID, expiresAt)
+	return err
+}
+
+func (r *PostgreSQLRepo) CompleteIdempotencyKey(rec *models.IdempotencyRecord) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $3, content_type = $4, response_body = $5, expires_at = $6
+		WHERE key = $1 AND lease_id = $2;
+	`
+
+	_, err := r.DB.ExecContext(ctx, query, rec.Key, rec.LeaseID, rec.StatusCode, rec.ContentType, rec.ResponseBody, rec.ExpiresAt)
+	return err
+}
+
+func (r *PostgreSQLRepo) DeleteIdempotencyKey(key, leaseID string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND lease_id = $2;
+	`
+
+	_, err := r.DB.ExecContext(ctx, query, key, leaseID)
+	return err
+}
+
+func (r *PostgreSQLRepo) DeleteExpiredIdempotencyKeys(now time.Time) (int64, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at <= $1;
+	`
+
+	res, err := r.DB.ExecContext(ctx, query, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,198 @@
+package idempotency
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var idempotencyPostgresqlRows = []string{"key", "fingerprint", "lease_id", "status_code", "content_type", "response_body", "created_at", "expires_at"}
+
+func Test_Postgresql_ReserveIdempotencyKey_ShouldReserveNewKey(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	rec := prepareRecord()
+
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= $2;`)).
+		WithArgs(rec.Key, rec.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO idempotency_keys (key, fingerprint, lease_id, status_code, created_at, expires_at) VALUES ($1, $2, $3, 0, $4, $5) ON CONFLICT (key) DO NOTHING;`)).
+		WithArgs(rec.Key, rec.Fingerprint, rec.LeaseID, rec.CreatedAt, rec.ExpiresAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// when
+	existing, err := testServer.ReserveIdempotencyKey(rec)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if existing != nil {
+		t.Fatalf("New key should be reserved, returned existing record: %+v\n", existing)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_ReserveIdempotencyKey_ShouldReturnExistingRecord(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	rec := prepareRecord()
+	body := []byte(`{"error":false}`)
+
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= $2;`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO idempotency_keys`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	dbRows := sqlmock.NewRows(idempotencyPostgresqlRows)
+	dbRows.AddRow(rec.Key, rec.Fingerprint, "other-lease", 201, "application/json", body, rec.CreatedAt, rec.ExpiresAt)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT key, fingerprint, lease_id, status_code, content_type, response_body, created_at, expires_at FROM idempotency_keys WHERE key = $1;`)).
+		WithArgs(rec.Key).
+		WillReturnRows(dbRows)
+
+	// when
+	existing, err := testServer.ReserveIdempotencyKey(rec)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if existing == nil || existing.StatusCode != 201 || string(existing.ResponseBody) != string(body) {
+		t.Fatalf("Existing record differs from stored one: %+v\n", existing)
+	}
+}
+
+func Test_Postgresql_ExtendIdempotencyKey_ShouldExtendOwnInProgressLease(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	rec := prepareRecord()
+	expiresAt := rec.ExpiresAt.Add(time.Minute)
+
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE idempotency_keys SET expires_at = $3 WHERE key = $1 AND lease_id = $2 AND status_code = 0;`)).
+		WithArgs(rec.Key, rec.LeaseID, expiresAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// when
+	err := testServer.ExtendIdempotencyKey(rec.Key, rec.LeaseID, expiresAt)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_DeleteIdempotencyKey_ShouldReleaseOwnLeaseOnly(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	rec := prepareRecord()
+
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM idempotency_keys WHERE key = $1 AND lease_id = $2;`)).
+		WithArgs(rec.Key, rec.LeaseID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// when
+	err := testServer.DeleteIdempotencyKey(rec.Key, rec.LeaseID)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_CompleteIdempotencyKey_ShouldStoreResponseOfSameRequest(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	rec := prepareRecord()
+	rec.StatusCode, rec.ContentType, rec.ResponseBody = 201, "application/json", []byte(`{"error":false}`)
+	rec.ExpiresAt = rec.CreatedAt.Add(24 * time.Hour)
+
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5, expires_at = $6 WHERE key = $1 AND lease_id = $2;`)).
+		WithArgs(rec.Key, rec.LeaseID, 201, "application/json", rec.ResponseBody, rec.ExpiresAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// when
+	err := testServer.CompleteIdempotencyKey(rec)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_DeleteExpiredIdempotencyKeys_ShouldCallDeleteQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	now := time.Now()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM idempotency_keys WHERE expires_at <= $1;`)).
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	// when
+	deleted, err := testServer.DeleteExpiredIdempotencyKeys(now)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 4 {
+		t.Fatalf("Returned amount of deleted keys (%d) is different than expected: %d\n", deleted, 4)
+	}
+}
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
+
+func prepareRecord() *models.IdempotencyRecord {
+	now := time.Now().UTC()
+	return &models.IdempotencyRecord{
+		Key:         "default/api_key:1/retry-1",
+		Fingerprint: "abc",
+		LeaseID:     "0123456789abcdef0123456789abcdef",
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+}
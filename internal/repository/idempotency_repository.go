@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"github.com/auwendil/crud-app/internal/models"
+	"time"
+)
+
+type IdempotencyRepo interface {
+	// ReserveIdempotencyKey stores rec unless an unexpired record with the
+	// same key exists, in which case the existing record is returned.
+	// Expired records are replaced, reservations of requests which never
+	// completed as well. A nil record means the key was reserved for the
+	// caller.
+	ReserveIdempotencyKey(rec *models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	// ExtendIdempotencyKey moves the expiry of the in-progress reservation
+	// of key held by leaseID to expiresAt. Keys taken over by another
+	// request, after the reservation expired, are left intact.
+	ExtendIdempotencyKey(key, leaseID string, expiresAt time.Time) error
+	// CompleteIdempotencyKey stores the response of rec and moves its
+	// expiry to rec.ExpiresAt, if the key is still held by rec.LeaseID.
+	CompleteIdempotencyKey(rec *models.IdempotencyRecord) error
+	// DeleteIdempotencyKey releases key, if it is still held by leaseID.
+	DeleteIdempotencyKey(key, leaseID string) error
+	DeleteExpiredIdempotencyKeys(now time.Time) (int64, error)
+}
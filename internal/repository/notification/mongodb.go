@@ -0,0 +1,219 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoDBRepo elects leaders with lease documents named by jobs, a lease
+// not renewed before it expires may be taken by another holder.
+type MongoDBRepo struct {
+	members       *mongo.Collection
+	loans         *mongo.Collection
+	preferences   *mongo.Collection
+	notifications *mongo.Collection
+	leases        *mongo.Collection
+}
+
+const (
+	mongoMembersCollectionName       = "members"
+	mongoLoansCollectionName         = "loans"
+	mongoPreferencesCollectionName   = "notification_preferences"
+	mongoNotificationsCollectionName = "notifications"
+	mongoLeasesCollectionName        = "leases"
+)
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		members:       db.Collection(mongoMembersCollectionName),
+		loans:         db.Collection(mongoLoansCollectionName),
+		preferences:   db.Collection(mongoPreferencesCollectionName),
+		notifications: db.Collection(mongoNotificationsCollectionName),
+		leases:        db.Collection(mongoLeasesCollectionName),
+	}
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.preferences.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "member_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.notifications.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "loan_id", Value: 1}, {Key: "kind", Value: 1}, {Key: "channel", Value: 1}, {Key: "due_at", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "member_id", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
+
+func (r *MongoDBRepo) GetPreferences(tenant, memberID string) (*models.NotificationPreferences, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var p *models.NotificationPreferences
+	err := r.preferences.FindOne(ctx, bson.D{{Key: "tenant", Value: tenant}, {Key: "member_id", Value: memberID}}).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (member_id=%s)", repository.ErrPreferencesNotFound, memberID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Channels == nil {
+		p.Channels = []string{}
+	}
+	return p, nil
+}
+
+func (r *MongoDBRepo) SetPreferences(p *models.NotificationPreferences) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.memberExists(ctx, p.Tenant, p.MemberID); err != nil {
+		return err
+	}
+
+	filter := bson.D{{Key: "tenant", Value: p.Tenant}, {Key: "member_id", Value: p.MemberID}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "channels", Value: p.Channels},
+		{Key: "due_soon", Value: p.DueSoon},
+		{Key: "overdue", Value: p.Overdue},
+	}}}
+
+	_, err := r.preferences.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *MongoDBRepo) GetNotifications(tenant string, filter repository.NotificationFilter) ([]*models.Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.D{{Key: "tenant", Value: tenant}}
+	if filter.MemberID != "" {
+		query = append(query, bson.E{Key: "member_id", Value: filter.MemberID})
+	}
+	if filter.LoanID != "" {
+		query = append(query, bson.E{Key: "loan_id", Value: filter.LoanID})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := r.notifications.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := []*models.Notification{}
+	if err = cursor.All(ctx, &notifications); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// RecordNotification takes the book and the member from the loan, the
+// unique index of notifications rejects repeated ones.
+func (r *MongoDBRepo) RecordNotification(n *models.Notification) (*models.Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(n.LoanID)
+	if err != nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrLoanNotFound, n.LoanID)
+	}
+
+	var l *models.Loan
+	err = r.loans.FindOne(ctx, bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: n.Tenant}}).Decode(&l)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrLoanNotFound, n.LoanID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created := *n
+	created.ID = ""
+	created.BookID = l.BookID
+	created.MemberID = l.MemberID
+
+	result, err := r.notifications.InsertOne(ctx, &created)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("%w (loan_id=%s, kind=%s, channel=%s)", repository.ErrNotificationSent, n.LoanID, n.Kind, n.Channel)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		created.ID = oid.Hex()
+	}
+
+	return &created, nil
+}
+
+// TryLead renews the lease of job when holder has it or takes it over
+// when it expired. Otherwise the upsert collides with the lease of another
+// holder on _id.
+func (r *MongoDBRepo) TryLead(job, holder string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	filter := bson.D{
+		{Key: "_id", Value: job},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "holder", Value: holder}},
+			bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lt", Value: now}}}},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "holder", Value: holder}, {Key: "expires_at", Value: now.Add(ttl)}}}}
+
+	_, err := r.leases.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *MongoDBRepo) Resign(job, holder string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.leases.DeleteOne(ctx, bson.D{{Key: "_id", Value: job}, {Key: "holder", Value: holder}})
+	return err
+}
+
+func (r *MongoDBRepo) memberExists(ctx context.Context, tenant, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+
+	count, err := r.members.CountDocuments(ctx, bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: tenant}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+	return nil
+}
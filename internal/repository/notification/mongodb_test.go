@@ -0,0 +1,55 @@
+package notification
+
+import (
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+	"time"
+)
+
+func Test_MongoDB_TryLead(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should lead with renewed lease", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{leases: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// when
+		leading, err := ts.TryLead("job", "replica-1", time.Minute)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while leading:", err)
+		}
+
+		if !leading {
+			t.Fatal("Replica should lead")
+		}
+	})
+
+	mt.Run("Should not lead while another holder has the lease", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{leases: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "duplicate key error",
+		}))
+
+		// when
+		leading, err := ts.TryLead("job", "replica-2", time.Minute)
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while leading:", err)
+		}
+
+		if leading {
+			t.Fatal("Replica should not lead")
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PostgreSQLRepo elects leaders with session advisory locks, each held
+// on a connection taken out of the pool for the job.
+type PostgreSQLRepo struct {
+	DB *sql.DB
+
+	mu     sync.Mutex
+	leases map[string]*sql.Conn
+}
+
+const (
+	postgresDBTimeout       = time.Second * 3
+	postgresUniqueViolation = "23505"
+)
+
+const notificationColumns = `id, tenant, kind, channel, loan_id, book_id, member_id, recipient, due_at, sent_at`
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{
+		DB:     db,
+		leases: make(map[string]*sql.Conn),
+	}
+}
+
+func (r *PostgreSQLRepo) GetPreferences(tenant, memberID string) (*models.NotificationPreferences, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT member_id, channels, due_soon, overdue
+		FROM notification_preferences
+		WHERE tenant = $1 AND member_id = $2::bigint;
+	`
+
+	id, err := repository.ParseID(memberID, repository.ErrPreferencesNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	p := models.NotificationPreferences{Tenant: tenant}
+	var channels string
+	err = r.DB.QueryRowContext(ctx, query, tenant, id).Scan(&p.MemberID, &channels, &p.DueSoon, &p.Overdue)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (member_id=%s)", repository.ErrPreferencesNotFound, memberID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.Channels = []string{}
+	if channels != "" {
+		p.Channels = strings.Split(channels, ",")
+	}
+	return &p, nil
+}
+
+// SetPreferences stores channels as a comma separated list.
+func (r *PostgreSQLRepo) SetPreferences(p *models.NotificationPreferences) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO notification_preferences (tenant, member_id, channels, due_soon, overdue)
+		SELECT $1, id, $3, $4, $5
+		FROM members
+		WHERE tenant = $1 AND id = $2::bigint
+		ON CONFLICT (member_id) DO UPDATE
+		SET channels = EXCLUDED.channels, due_soon = EXCLUDED.due_soon, overdue = EXCLUDED.overdue;
+	`
+
+	memberID, err := repository.ParseID(p.MemberID, repository.ErrMemberNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, query, p.Tenant, memberID, strings.Join(p.Channels, ","), p.DueSoon, p.Overdue)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, p.MemberID)
+	}
+	return nil
+}
+
+func (r *PostgreSQLRepo) GetNotifications(tenant string, filter repository.NotificationFilter) ([]*models.Notification, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	conditions := []string{"tenant = $1"}
+	args := []any{tenant}
+	if filter.MemberID != "" {
+		memberID, err := repository.ParseID(filter.MemberID, repository.ErrMemberNotFound)
+		if err != nil {
+			return []*models.Notification{}, nil
+		}
+		args = append(args, memberID)
+		conditions = append(conditions, fmt.Sprintf("member_id = $%d::bigint", len(args)))
+	}
+	if filter.LoanID != "" {
+		loanID, err := repository.ParseID(filter.LoanID, repository.ErrLoanNotFound)
+		if err != nil {
+			return []*models.Notification{}, nil
+		}
+		args = append(args, loanID)
+		conditions = append(conditions, fmt.Sprintf("loan_id = $%d::bigint", len(args)))
+	}
+
+	query := `SELECT ` + notificationColumns + ` FROM notifications WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY id DESC;`
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		err = rows.Scan(&n.ID, &n.Tenant, &n.Kind, &n.Channel, &n.LoanID, &n.BookID, &n.MemberID, &n.Recipient, &n.DueAt, &n.SentAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// RecordNotification takes the book and the member from the loan, the
+// unique index of notifications rejects repeated ones.
+func (r *PostgreSQLRepo) RecordNotification(n *models.Notification) (*models.Notification, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO notifications (tenant, kind, channel, loan_id, book_id, member_id, recipient, due_at, sent_at)
+		SELECT $1, $2, $3, id, book_id, member_id, $5, $6, $7
+		FROM loans
+		WHERE tenant = $1 AND id = $4::bigint
+		RETURNING id;
+	`
+
+	loanID, err := repository.ParseID(n.LoanID, repository.ErrLoanNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var newId int
+	err = r.DB.QueryRowContext(ctx, query, n.Tenant, n.Kind, n.Channel, loanID, n.Recipient, n.DueAt, n.SentAt).Scan(&newId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrLoanNotFound, n.LoanID)
+	}
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w (loan_id=%s, kind=%s, channel=%s)", repository.ErrNotificationSent, n.LoanID, n.Kind, n.Channel)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created := *n
+	created.ID = fmt.Sprintf("%d", newId)
+	return &created, nil
+}
+
+// TryLead keeps leading while the connection holding the advisory lock of
+// job is alive, the lock is released by the server when it is lost. Holder
+// and ttl are not needed, the session identifies the leader.
+func (r *PostgreSQLRepo) TryLead(job, holder string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	if conn, ok := r.leases[job]; ok {
+		if err := conn.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		_ = conn.Close()
+		delete(r.leases, job)
+	}
+
+	conn, err := r.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1));`, job).Scan(&locked)
+	if err != nil || !locked {
+		_ = conn.Close()
+		return false, err
+	}
+
+	r.leases[job] = conn
+	return true, nil
+}
+
+func (r *PostgreSQLRepo) Resign(job, holder string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, ok := r.leases[job]
+	if !ok {
+		return nil
+	}
+	delete(r.leases, job)
+	defer conn.Close()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1));`, job)
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation
+}
@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_Postgresql_GetPreferences_ShouldSplitChannels(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT member_id, channels, due_soon, overdue FROM notification_preferences WHERE tenant = $1 AND member_id = $2::bigint;`)).
+		WithArgs("acme", int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"member_id", "channels", "due_soon", "overdue"}).AddRow("5", "email,webhook", false, true))
+
+	// when
+	p, err := testServer.GetPreferences("acme", "5")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Channels) != 2 || p.Channels[1] != "webhook" || p.Wants(models.NotificationDueSoon) || !p.Wants(models.NotificationOverdue) {
+		t.Fatalf("Returned preferences are different than expected: %+v\n", p)
+	}
+}
+
+func Test_Postgresql_RecordNotification_ShouldRejectRepeatedNotification(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	dueAt, sentAt := time.Now(), time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO notifications (tenant, kind, channel, loan_id, book_id, member_id, recipient, due_at, sent_at) SELECT $1, $2, $3, id, book_id, member_id, $5, $6, $7 FROM loans WHERE tenant = $1 AND id = $4::bigint RETURNING id;`)).
+		WithArgs("acme", models.NotificationOverdue, "email", int64(9), "member5@example.com", dueAt, sentAt).
+		WillReturnError(&pgconn.PgError{Code: postgresUniqueViolation})
+
+	// when
+	_, err := testServer.RecordNotification(&models.Notification{
+		Tenant:    "acme",
+		Kind:      models.NotificationOverdue,
+		Channel:   "email",
+		LoanID:    "9",
+		Recipient: "member5@example.com",
+		DueAt:     dueAt,
+		SentAt:    sentAt,
+	})
+
+	// then
+	if !errors.Is(err, repository.ErrNotificationSent) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrNotificationSent, err)
+	}
+}
+
+func Test_Postgresql_TryLead_ShouldKeepLockedConnection(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT pg_try_advisory_lock(hashtext($1));`)).
+		WithArgs("job").
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectPing()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock(hashtext($1));`)).
+		WithArgs("job").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// when
+	first, err := testServer.TryLead("job", "replica-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := testServer.TryLead("job", "replica-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testServer.Resign("job", "replica-1")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !first || !second {
+		t.Fatalf("Replica should lead while it keeps the lock, has: %t, %t\n", first, second)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// utils
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"time"
+)
+
+var (
+	ErrPreferencesNotFound = errors.New("notification preferences not found")
+	ErrNotificationSent    = errors.New("notification was already sent")
+)
+
+// NotificationFilter selects sent notifications, zero fields match every
+// notification.
+type NotificationFilter struct {
+	MemberID string
+	LoanID   string
+}
+
+// NotificationRepo keeps preferences of members and the log of sent
+// notifications.
+type NotificationRepo interface {
+	// GetPreferences returns ErrPreferencesNotFound when the member kept
+	// the defaults.
+	GetPreferences(tenant, memberID string) (*models.NotificationPreferences, error)
+	// SetPreferences fails with ErrMemberNotFound when the member does not
+	// exist.
+	SetPreferences(p *models.NotificationPreferences) error
+
+	// GetNotifications returns sent notifications matching filter, newest
+	// first.
+	GetNotifications(tenant string, filter NotificationFilter) ([]*models.Notification, error)
+	// RecordNotification logs the sent notification, it fails with
+	// ErrNotificationSent when one of the same kind was sent through the
+	// channel for the due date already.
+	RecordNotification(n *models.Notification) (*models.Notification, error)
+}
+
+// LeaderElector lets only one of replicas run a background job.
+type LeaderElector interface {
+	// TryLead acquires or keeps leadership of job for holder, it reports
+	// false while another holder leads. Leadership not kept for ttl may be
+	// taken over.
+	TryLead(job, holder string, ttl time.Duration) (bool, error)
+	// Resign gives up leadership of job held by holder.
+	Resign(job, holder string) error
+}
@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoCollectionName is the collection of outbox messages, shared by all
+// tenants.
+const MongoCollectionName = "outbox"
+
+type MongoDBRepo struct {
+	collection *mongo.Collection
+}
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		collection: db.Collection(MongoCollectionName),
+	}
+}
+
+// AppendMongoDB stores m in db. Called with the context of a session
+// transaction, it is written only when the change it describes is
+// committed.
+func AppendMongoDB(ctx context.Context, db *mongo.Database, m *models.OutboxMessage) error {
+	stored := *m
+	stored.ID = ""
+	stored.CreatedAt = time.Now().UTC()
+
+	_, err := db.Collection(MongoCollectionName).InsertOne(ctx, &stored)
+	return err
+}
+
+// GetOutboxMessages orders messages by their ObjectIDs, which grow within
+// one writing process. Messages written by several processes in the same
+// second may be returned out of order.
+func (r *MongoDBRepo) GetOutboxMessages(afterID string, limit int) ([]*models.OutboxMessage, error) {
+	filter := bson.D{}
+	if afterID != "" {
+		objID, err := primitive.ObjectIDFromHex(afterID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outbox message id %q", afterID)
+		}
+		filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: objID}}}}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []*models.OutboxMessage{}
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (r *MongoDBRepo) DeleteOutboxMessages(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objIDs := bson.A{}
+	for _, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return fmt.Errorf("invalid outbox message id %q", id)
+		}
+		objIDs = append(objIDs, objID)
+	}
+
+	_, err := r.collection.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: objIDs}}}})
+	return err
+}
@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PostgreSQLRepo struct {
+	DB *sql.DB
+}
+
+const postgresDBTimeout = time.Second * 3
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{
+		DB: db,
+	}
+}
+
+// AppendPostgreSQL stores m in tx, so it is written only when the change
+// it describes is committed. The table is qualified with its schema, as
+// transactions of isolated tenants search only the schema of the tenant.
+func AppendPostgreSQL(ctx context.Context, tx *sql.Tx, m *models.OutboxMessage) error {
+	query := `
+		INSERT INTO public.outbox (tenant, event_type, book_id, book)
+		VALUES ($1, $2, $3, $4);
+	`
+
+	var book []byte
+	if m.Book != nil {
+		var err error
+		if book, err = json.Marshal(m.Book); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, query, m.Tenant, m.Type, m.BookID, book)
+	return err
+}
+
+func (r *PostgreSQLRepo) GetOutboxMessages(afterID string, limit int) ([]*models.OutboxMessage, error) {
+	var after int64
+	if afterID != "" {
+		var err error
+		if after, err = strconv.ParseInt(afterID, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid outbox message id %q", afterID)
+		}
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, tenant, event_type, book_id, book, created_at
+		FROM public.outbox
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2;
+	`
+
+	rows, err := r.DB.QueryContext(ctx, query, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*models.OutboxMessage{}
+	for rows.Next() {
+		var m models.OutboxMessage
+		var book []byte
+		if err = rows.Scan(&m.ID, &m.Tenant, &m.Type, &m.BookID, &book, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		if book != nil {
+			if err = json.Unmarshal(book, &m.Book); err != nil {
+				return nil, fmt.Errorf("decoding book of outbox message %s: %w", m.ID, err)
+			}
+		}
+		messages = append(messages, &m)
+	}
+
+	return messages, rows.Err()
+}
+
+func (r *PostgreSQLRepo) DeleteOutboxMessages(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	for _, id := range ids {
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			return fmt.Errorf("invalid outbox message id %q", id)
+		}
+	}
+
+	query := `
+		DELETE FROM public.outbox
+		WHERE id = ANY($1::bigint[]);
+	`
+
+	_, err := r.DB.ExecContext(ctx, query, "{"+strings.Join(ids, ",")+"}")
+	return err
+}
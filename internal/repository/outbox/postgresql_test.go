@@ -0,0 +1,87 @@
+package outbox
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_Postgresql_GetOutboxMessages_ShouldDecodeBooks(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	dbRows := sqlmock.NewRows([]string{"id", "tenant", "event_type", "book_id", "book", "created_at"})
+	dbRows.AddRow("1", "acme", "book.created", "3", []byte(`{"id":"3","name":"Book3","author":"Author3"}`), time.Now())
+	dbRows.AddRow("2", "acme", "books.cleared", "", nil, time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, tenant, event_type, book_id, book, created_at FROM public.outbox WHERE id > $1 ORDER BY id LIMIT $2;`)).
+		WithArgs(int64(0), 10).
+		WillReturnRows(dbRows)
+
+	// when
+	messages, err := testServer.GetOutboxMessages("", 10)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || messages[0].Book == nil || messages[0].Book.Name != "Book3" || messages[1].Book != nil {
+		t.Fatalf("Returned messages are different than expected: %+v %+v\n", messages[0], messages[1])
+	}
+}
+
+func Test_Postgresql_GetOutboxMessages_ShouldReadAfterGivenID(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	dbRows := sqlmock.NewRows([]string{"id", "tenant", "event_type", "book_id", "book", "created_at"})
+	dbRows.AddRow("8", "acme", "books.cleared", "", nil, time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, tenant, event_type, book_id, book, created_at FROM public.outbox WHERE id > $1 ORDER BY id LIMIT $2;`)).
+		WithArgs(int64(7), 10).
+		WillReturnRows(dbRows)
+
+	// when
+	messages, err := testServer.GetOutboxMessages("7", 10)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 1 || messages[0].ID != "8" {
+		t.Fatalf("Returned messages are different than expected: %+v\n", messages)
+	}
+}
+
+func Test_Postgresql_DeleteOutboxMessages_ShouldCallSingleDeleteQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM public.outbox WHERE id = ANY($1::bigint[]);`)).
+		WithArgs("{1,3}").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// when
+	err := testServer.DeleteOutboxMessages([]string{"1", "3"})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
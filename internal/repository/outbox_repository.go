@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"github.com/auwendil/crud-app/internal/models"
+)
+
+// OutboxRepo is read by the outbox relay, messages are written by book
+// repositories together with the changes.
+type OutboxRepo interface {
+	// GetOutboxMessages returns the oldest undelivered messages written
+	// after the message with ID afterID, all of them when it is empty, in
+	// the order they were written.
+	GetOutboxMessages(afterID string, limit int) ([]*models.OutboxMessage, error)
+	DeleteOutboxMessages(ids []string) error
+}
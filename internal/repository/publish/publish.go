@@ -0,0 +1,147 @@
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+)
+
+// Publisher receives events of successful writes.
+type Publisher interface {
+	Publish(e events.Event) events.Event
+}
+
+// Repo publishes an event after every successful write to another
+// BookRepo. Reads are passed through.
+type Repo struct {
+	base      repository.BookRepo
+	publisher Publisher
+	tenant    string
+}
+
+var _ repository.BookRepo = (*Repo)(nil)
+
+func New(base repository.BookRepo, publisher Publisher) *Repo {
+	return &Repo{
+		base:      base,
+		publisher: publisher,
+		tenant:    repository.DefaultTenant,
+	}
+}
+
+// ForTenant returns a Repo publishing events of the tenant. The underlying
+// repository has to support tenants as well.
+func (r *Repo) ForTenant(tenant string) (repository.BookRepo, error) {
+	tenantRepos, ok := r.base.(repository.TenantBookRepos)
+	if !ok {
+		return nil, fmt.Errorf("publishing repository does not support tenants")
+	}
+
+	base, err := tenantRepos.ForTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repo{
+		base:      base,
+		publisher: r.publisher,
+		tenant:    tenant,
+	}, nil
+}
+
+func (r *Repo) GetAllBooks() ([]*models.Book, error) {
+	return r.base.GetAllBooks()
+}
+
+func (r *Repo) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return r.base.GetBooksBatch(offset, limit)
+}
+
+func (r *Repo) GetBook(id string) (*models.Book, error) {
+	return r.base.GetBook(id)
+}
+
+func (r *Repo) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	return r.base.GetBooksByIDs(ids)
+}
+
+// CountAuthors passes counting through, the underlying repository has to
+// count authors as well.
+func (r *Repo) CountAuthors(ids []string) ([]*models.FacetCount, error) {
+	counter, ok := r.base.(repository.AuthorCounter)
+	if !ok {
+		return nil, fmt.Errorf("publishing repository does not count authors")
+	}
+	return counter.CountAuthors(ids)
+}
+
+// GetBooksByRating passes sorting through, the underlying repository has
+// to keep ratings of books.
+func (r *Repo) GetBooksByRating(offset, limit int) ([]*models.Book, error) {
+	sorter, ok := r.base.(repository.RatingSorter)
+	if !ok {
+		return nil, repository.ErrRatingSortUnsupported
+	}
+	return sorter.GetBooksByRating(offset, limit)
+}
+
+func (r *Repo) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return r.base.SearchBooks(phrase, offset, limit)
+}
+
+func (r *Repo) AddBook(b *models.Book) (*models.Book, error) {
+	book, err := r.base.AddBook(b)
+	if err != nil {
+		return nil, err
+	}
+
+	r.publish(events.BookCreated, book.ID, book)
+	return book, nil
+}
+
+func (r *Repo) UpdateBook(id string, updatedBook *models.Book) error {
+	if err := r.base.UpdateBook(id, updatedBook); err != nil {
+		return err
+	}
+
+	r.publish(events.BookUpdated, id, &models.Book{ID: id, Name: updatedBook.Name, Author: updatedBook.Author})
+	return nil
+}
+
+// DeleteBook reads the book first, so subscribers filtering by author
+// learn about its removal and deletes of missing books are not published.
+func (r *Repo) DeleteBook(id string) error {
+	book, err := r.base.GetBook(id)
+	if err != nil && !errors.Is(err, repository.ErrBookNotFound) {
+		return err
+	}
+
+	if err = r.base.DeleteBook(id); err != nil {
+		return err
+	}
+
+	if book != nil {
+		r.publish(events.BookDeleted, id, book)
+	}
+	return nil
+}
+
+func (r *Repo) DeleteAllBooks() error {
+	if err := r.base.DeleteAllBooks(); err != nil {
+		return err
+	}
+
+	r.publish(events.BooksCleared, "", nil)
+	return nil
+}
+
+func (r *Repo) publish(t events.Type, id string, book *models.Book) {
+	r.publisher.Publish(events.Event{
+		Type:   t,
+		Tenant: r.tenant,
+		BookID: id,
+		Book:   book,
+	})
+}
@@ -0,0 +1,143 @@
+package publish
+
+import (
+	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"testing"
+)
+
+func Test_Publish_ShouldPublishSuccessfulWrites(t *testing.T) {
+	// setup
+	base := &repoStub{books: map[string]*models.Book{}}
+	publisher := &publisherStub{}
+	ts := New(base, publisher)
+
+	// when
+	created, err := ts.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+	if err != nil {
+		t.Fatal("Encountered error while adding book:", err)
+	}
+	if err = ts.UpdateBook(created.ID, &models.Book{Name: "Updated", Author: "Author1"}); err != nil {
+		t.Fatal("Encountered error while updating book:", err)
+	}
+	if err = ts.DeleteBook(created.ID); err != nil {
+		t.Fatal("Encountered error while deleting book:", err)
+	}
+	if err = ts.DeleteBook("missing"); err != nil {
+		t.Fatal("Encountered error while deleting missing book:", err)
+	}
+	if err = ts.DeleteAllBooks(); err != nil {
+		t.Fatal("Encountered error while deleting all books:", err)
+	}
+
+	// then
+	expected := []events.Type{events.BookCreated, events.BookUpdated, events.BookDeleted, events.BooksCleared}
+	if len(publisher.events) != len(expected) {
+		t.Fatalf("Published %d events, should be %d: %+v\n", len(publisher.events), len(expected), publisher.events)
+	}
+
+	for i, e := range publisher.events {
+		if e.Type != expected[i] || e.Tenant != repository.DefaultTenant {
+			t.Fatalf("Event %d should be %s of default tenant, has: %+v\n", i, expected[i], e)
+		}
+	}
+
+	if deleted := publisher.events[2].Book; deleted == nil || deleted.Name != "Updated" {
+		t.Fatalf("Deleted event should carry the removed book, has: %+v\n", deleted)
+	}
+}
+
+func Test_Publish_ShouldNotPublishFailedWrites(t *testing.T) {
+	// setup
+	base := &repoStub{books: map[string]*models.Book{}, failWrites: true}
+	publisher := &publisherStub{}
+	ts := New(base, publisher)
+
+	// when
+	_, addErr := ts.AddBook(&models.Book{Name: "Book1", Author: "Author1"})
+	updateErr := ts.UpdateBook("1", &models.Book{Name: "Book1", Author: "Author1"})
+
+	// then
+	if addErr == nil || updateErr == nil {
+		t.Fatal("Expected to return errors but returned nil instead")
+	}
+
+	if len(publisher.events) != 0 {
+		t.Fatalf("Failed writes should not be published: %+v\n", publisher.events)
+	}
+}
+
+// utils
+
+type publisherStub struct {
+	events []events.Event
+}
+
+func (p *publisherStub) Publish(e events.Event) events.Event {
+	p.events = append(p.events, e)
+	return e
+}
+
+type repoStub struct {
+	books      map[string]*models.Book
+	failWrites bool
+}
+
+func (r *repoStub) GetAllBooks() ([]*models.Book, error) {
+	books := []*models.Book{}
+	for _, b := range r.books {
+		books = append(books, b)
+	}
+	return books, nil
+}
+
+func (r *repoStub) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return r.GetAllBooks()
+}
+
+func (r *repoStub) GetBook(id string) (*models.Book, error) {
+	b, ok := r.books[id]
+	if !ok {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+	return b, nil
+}
+
+func (r *repoStub) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) AddBook(b *models.Book) (*models.Book, error) {
+	if r.failWrites {
+		return nil, fmt.Errorf("write failed")
+	}
+
+	created := &models.Book{ID: fmt.Sprint(len(r.books) + 1), Name: b.Name, Author: b.Author}
+	r.books[created.ID] = created
+	return created, nil
+}
+
+func (r *repoStub) UpdateBook(id string, updatedBook *models.Book) error {
+	if r.failWrites {
+		return fmt.Errorf("write failed")
+	}
+
+	r.books[id] = &models.Book{ID: id, Name: updatedBook.Name, Author: updatedBook.Author}
+	return nil
+}
+
+func (r *repoStub) DeleteBook(id string) error {
+	delete(r.books, id)
+	return nil
+}
+
+func (r *repoStub) DeleteAllBooks() error {
+	r.books = make(map[string]*models.Book)
+	return nil
+}
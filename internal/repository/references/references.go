@@ -0,0 +1,135 @@
+package references
+
+import (
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+)
+
+// Repo removes records referring to books, e.g. their tags, shelf entries
+// or covers, whenever books of another BookRepo are deleted. Removal is
+// done before the delete returns, so callers never see references to
+// deleted books. Reads and other writes are passed through.
+type Repo struct {
+	base   repository.BookRepo
+	refs   []repository.BookReferences
+	tenant string
+}
+
+var _ repository.BookRepo = (*Repo)(nil)
+
+func New(base repository.BookRepo, refs []repository.BookReferences) *Repo {
+	return &Repo{
+		base:   base,
+		refs:   refs,
+		tenant: repository.DefaultTenant,
+	}
+}
+
+// ForTenant returns a Repo removing references of books of the tenant. The
+// underlying repository has to support tenants as well.
+func (r *Repo) ForTenant(tenant string) (repository.BookRepo, error) {
+	tenantRepos, ok := r.base.(repository.TenantBookRepos)
+	if !ok {
+		return nil, fmt.Errorf("references repository does not support tenants")
+	}
+
+	base, err := tenantRepos.ForTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repo{
+		base:   base,
+		refs:   r.refs,
+		tenant: tenant,
+	}, nil
+}
+
+func (r *Repo) GetAllBooks() ([]*models.Book, error) {
+	return r.base.GetAllBooks()
+}
+
+func (r *Repo) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return r.base.GetBooksBatch(offset, limit)
+}
+
+func (r *Repo) GetBook(id string) (*models.Book, error) {
+	return r.base.GetBook(id)
+}
+
+func (r *Repo) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	return r.base.GetBooksByIDs(ids)
+}
+
+// CountAuthors passes counting through, the underlying repository has to
+// count authors as well.
+func (r *Repo) CountAuthors(ids []string) ([]*models.FacetCount, error) {
+	counter, ok := r.base.(repository.AuthorCounter)
+	if !ok {
+		return nil, fmt.Errorf("references repository does not count authors")
+	}
+	return counter.CountAuthors(ids)
+}
+
+// GetBooksByRating passes sorting through, the underlying repository has
+// to keep ratings of books.
+func (r *Repo) GetBooksByRating(offset, limit int) ([]*models.Book, error) {
+	sorter, ok := r.base.(repository.RatingSorter)
+	if !ok {
+		return nil, repository.ErrRatingSortUnsupported
+	}
+	return sorter.GetBooksByRating(offset, limit)
+}
+
+func (r *Repo) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return r.base.SearchBooks(phrase, offset, limit)
+}
+
+func (r *Repo) AddBook(b *models.Book) (*models.Book, error) {
+	return r.base.AddBook(b)
+}
+
+func (r *Repo) UpdateBook(id string, updatedBook *models.Book) error {
+	return r.base.UpdateBook(id, updatedBook)
+}
+
+// DeleteBook removes references after the book is deleted. They are
+// removed for missing books too, so deleting the book again finishes
+// a removal that failed before.
+func (r *Repo) DeleteBook(id string) error {
+	err := r.base.DeleteBook(id)
+	if err != nil && !errors.Is(err, repository.ErrBookNotFound) {
+		return err
+	}
+
+	if refErr := r.deleteReferences(id); refErr != nil {
+		return refErr
+	}
+	return err
+}
+
+func (r *Repo) DeleteAllBooks() error {
+	if err := r.base.DeleteAllBooks(); err != nil {
+		return err
+	}
+	return r.deleteReferences("")
+}
+
+// deleteReferences removes references to the book, or to every book of
+// the tenant when id is empty, from all repositories, even when some of
+// them fail.
+func (r *Repo) deleteReferences(id string) error {
+	var errs []error
+	for _, ref := range r.refs {
+		if err := ref.DeleteBookReferences(r.tenant, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("removing references to book %q: %w", id, err)
+	}
+	return nil
+}
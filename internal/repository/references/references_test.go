@@ -0,0 +1,160 @@
+package references
+
+import (
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"testing"
+)
+
+func Test_References_ShouldRemoveReferencesOfDeletedBooks(t *testing.T) {
+	// setup
+	base := &repoStub{books: map[string]*models.Book{"1": {ID: "1"}, "2": {ID: "2"}}}
+	tags, shelves := &referencesStub{}, &referencesStub{}
+	ts := New(base, []repository.BookReferences{tags, shelves})
+
+	// when
+	if err := ts.DeleteBook("1"); err != nil {
+		t.Fatal("Encountered error while deleting book:", err)
+	}
+	if err := ts.DeleteAllBooks(); err != nil {
+		t.Fatal("Encountered error while deleting all books:", err)
+	}
+
+	// then
+	expected := "[default/1 default/]"
+	if fmt.Sprint(tags.deleted) != expected || fmt.Sprint(shelves.deleted) != expected {
+		t.Fatalf("Expected references %s to be removed, has: %v and %v\n", expected, tags.deleted, shelves.deleted)
+	}
+}
+
+func Test_References_ShouldRemoveReferencesOfMissingBooks(t *testing.T) {
+	// setup
+	base := &repoStub{books: map[string]*models.Book{}}
+	tags := &referencesStub{}
+	ts := New(base, []repository.BookReferences{tags})
+
+	// when
+	err := ts.DeleteBook("1")
+
+	// then
+	if !errors.Is(err, repository.ErrBookNotFound) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrBookNotFound, err)
+	}
+	if fmt.Sprint(tags.deleted) != "[default/1]" {
+		t.Fatalf("Expected references of book 1 to be removed, has: %v\n", tags.deleted)
+	}
+}
+
+func Test_References_ShouldReportFailedRemovals(t *testing.T) {
+	// setup
+	base := &repoStub{books: map[string]*models.Book{"1": {ID: "1"}}}
+	failing, tags := &referencesStub{fail: true}, &referencesStub{}
+	ts := New(base, []repository.BookReferences{failing, tags})
+
+	// when
+	err := ts.DeleteBook("1")
+
+	// then
+	if err == nil {
+		t.Fatal("Expected to return error but returned nil instead")
+	}
+	if fmt.Sprint(tags.deleted) != "[default/1]" {
+		t.Fatalf("Expected other references to be removed despite the failure, has: %v\n", tags.deleted)
+	}
+}
+
+func Test_References_ShouldKeepReferencesWhenDeleteFails(t *testing.T) {
+	// setup
+	base := &repoStub{books: map[string]*models.Book{"1": {ID: "1"}}, failWrites: true}
+	tags := &referencesStub{}
+	ts := New(base, []repository.BookReferences{tags})
+
+	// when
+	deleteErr := ts.DeleteBook("1")
+	deleteAllErr := ts.DeleteAllBooks()
+
+	// then
+	if deleteErr == nil || deleteAllErr == nil {
+		t.Fatal("Expected to return errors but returned nil instead")
+	}
+	if len(tags.deleted) != 0 {
+		t.Fatalf("References of books which were not deleted should stay, removed: %v\n", tags.deleted)
+	}
+}
+
+// utils
+
+type referencesStub struct {
+	deleted []string
+	fail    bool
+}
+
+func (r *referencesStub) DeleteBookReferences(tenant, bookID string) error {
+	if r.fail {
+		return fmt.Errorf("removal failed")
+	}
+	r.deleted = append(r.deleted, tenant+"/"+bookID)
+	return nil
+}
+
+type repoStub struct {
+	books      map[string]*models.Book
+	failWrites bool
+}
+
+func (r *repoStub) GetAllBooks() ([]*models.Book, error) {
+	books := []*models.Book{}
+	for _, b := range r.books {
+		books = append(books, b)
+	}
+	return books, nil
+}
+
+func (r *repoStub) GetBooksBatch(offset, limit int) ([]*models.Book, error) {
+	return r.GetAllBooks()
+}
+
+func (r *repoStub) GetBook(id string) (*models.Book, error) {
+	b, ok := r.books[id]
+	if !ok {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+	return b, nil
+}
+
+func (r *repoStub) GetBooksByIDs(ids []string) ([]*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) SearchBooks(phrase string, offset, limit int) ([]*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) AddBook(b *models.Book) (*models.Book, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) UpdateBook(id string, updatedBook *models.Book) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) DeleteBook(id string) error {
+	if r.failWrites {
+		return fmt.Errorf("write failed")
+	}
+	if _, ok := r.books[id]; !ok {
+		return fmt.Errorf("%w (id=%s)", repository.ErrBookNotFound, id)
+	}
+	delete(r.books, id)
+	return nil
+}
+
+func (r *repoStub) DeleteAllBooks() error {
+	if r.failWrites {
+		return fmt.Errorf("write failed")
+	}
+	r.books = make(map[string]*models.Book)
+	return nil
+}
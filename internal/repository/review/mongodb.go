@@ -0,0 +1,333 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/book"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoDBRepo keeps ratings in book_ratings collection together with the
+// sum of approved ratings. Every write of a review adds the difference it
+// made to the sum and the count atomically, so writes running at once do
+// not overwrite each other. The resulting rating is copied to the book in
+// the same session transaction, so writes of reviews require a replica set.
+// Reviews of deleted members have no member_id field.
+type MongoDBRepo struct {
+	db      *mongo.Database
+	tenancy repository.Tenancy
+	members *mongo.Collection
+	reviews *mongo.Collection
+	ratings *mongo.Collection
+}
+
+const (
+	mongoMembersCollectionName = "members"
+	mongoReviewsCollectionName = "reviews"
+	mongoRatingsCollectionName = "book_ratings"
+)
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		db:      db,
+		tenancy: repository.TenancyShared,
+		members: db.Collection(mongoMembersCollectionName),
+		reviews: db.Collection(mongoReviewsCollectionName),
+		ratings: db.Collection(mongoRatingsCollectionName),
+	}
+}
+
+// SetTenancy tells in which collection books of a tenant are kept, so their
+// ratings are copied to the right documents.
+func (r *MongoDBRepo) SetTenancy(tenancy repository.Tenancy) {
+	r.tenancy = tenancy
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.reviews.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "book_id", Value: 1}, {Key: "member_id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "member_id", Value: bson.D{{Key: "$exists", Value: true}}}}),
+		},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "book_id", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.ratings.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "book_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (r *MongoDBRepo) GetReviews(tenant, bookID string, filter repository.ReviewFilter) ([]*models.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bookID}}
+	if filter.MemberID != "" {
+		query = append(query, bson.E{Key: "member_id", Value: filter.MemberID})
+	}
+	if filter.Status != "" {
+		query = append(query, bson.E{Key: "status", Value: filter.Status})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := r.reviews.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	reviews := []*models.Review{}
+	if err = cursor.All(ctx, &reviews); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+func (r *MongoDBRepo) GetReview(tenant, id string) (*models.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := reviewFilter(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv *models.Review
+	err = r.reviews.FindOne(ctx, filter).Decode(&rv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+func (r *MongoDBRepo) AddReview(rv *models.Review) (*models.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.memberExists(ctx, rv.Tenant, rv.MemberID); err != nil {
+		return nil, err
+	}
+
+	var created models.Review
+	err := r.inTx(ctx, func(ctx context.Context) error {
+		created = *rv
+		created.ID = ""
+		created.UpdatedAt = created.CreatedAt
+
+		result, err := r.reviews.InsertOne(ctx, &created)
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w (book_id=%s, member_id=%s)", repository.ErrReviewExists, rv.BookID, rv.MemberID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+			created.ID = oid.Hex()
+		}
+
+		return r.adjustRating(ctx, created.Tenant, created.BookID, nil, &created)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+func (r *MongoDBRepo) UpdateReview(rv *models.Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := reviewFilter(rv.Tenant, rv.ID)
+	if err != nil {
+		return err
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "rating", Value: rv.Rating},
+		{Key: "text", Value: rv.Text},
+		{Key: "status", Value: rv.Status},
+		{Key: "updated_at", Value: rv.UpdatedAt},
+	}}}
+
+	return r.inTx(ctx, func(ctx context.Context) error {
+		var before *models.Review
+		err := r.reviews.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, rv.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		return r.adjustRating(ctx, before.Tenant, before.BookID, before, rv)
+	})
+}
+
+func (r *MongoDBRepo) DeleteReview(tenant, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := reviewFilter(tenant, id)
+	if err != nil {
+		return err
+	}
+
+	return r.inTx(ctx, func(ctx context.Context) error {
+		var deleted *models.Review
+		err := r.reviews.FindOneAndDelete(ctx, filter).Decode(&deleted)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		return r.adjustRating(ctx, tenant, deleted.BookID, deleted, nil)
+	})
+}
+
+func (r *MongoDBRepo) GetRatings(tenant string, bookIDs []string) (map[string]*models.Rating, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ratings := make(map[string]*models.Rating)
+	if len(bookIDs) == 0 {
+		return ratings, nil
+	}
+
+	filter := bson.D{
+		{Key: "tenant", Value: tenant},
+		{Key: "book_id", Value: bson.D{{Key: "$in", Value: bookIDs}}},
+		{Key: "count", Value: bson.D{{Key: "$gt", Value: 0}}},
+	}
+	cursor, err := r.ratings.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*models.Rating
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	for _, rating := range found {
+		ratings[rating.BookID] = rating
+	}
+	return ratings, nil
+}
+
+func (r *MongoDBRepo) DeleteBookReferences(tenant, bookID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "tenant", Value: tenant}}
+	if bookID != "" {
+		filter = append(filter, bson.E{Key: "book_id", Value: bookID})
+	}
+
+	if _, err := r.reviews.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	_, err := r.ratings.DeleteMany(ctx, filter)
+	return err
+}
+
+// inTx runs fn in a session transaction, fn may be retried on transient
+// transaction errors.
+func (r *MongoDBRepo) inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := r.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// adjustRating applies the change of the review from before to after, nil
+// when it did not exist, to the rating of the book and copies the result to
+// the book. Only approved reviews count.
+func (r *MongoDBRepo) adjustRating(ctx context.Context, tenant, bookID string, before, after *models.Review) error {
+	sum, count := 0, 0
+	if before != nil && before.Status == models.ReviewApproved {
+		sum, count = sum-before.Rating, count-1
+	}
+	if after != nil && after.Status == models.ReviewApproved {
+		sum, count = sum+after.Rating, count+1
+	}
+	if count == 0 && sum == 0 {
+		return nil
+	}
+
+	filter := bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bookID}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "sum", Value: bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$sum", 0}}}, sum}}}},
+			{Key: "count", Value: bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$count", 0}}}, count}}}},
+		}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "average", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$gt", Value: bson.A{"$count", 0}}},
+				bson.D{{Key: "$divide", Value: bson.A{"$sum", "$count"}}},
+				0,
+			}}}},
+		}}},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var rating models.Rating
+	if err := r.ratings.FindOneAndUpdate(ctx, filter, update, opts).Decode(&rating); err != nil {
+		return err
+	}
+
+	return book.SetRatingMongoDB(ctx, r.db, r.tenancy, tenant, bookID, &rating)
+}
+
+func (r *MongoDBRepo) memberExists(ctx context.Context, tenant, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+
+	count, err := r.members.CountDocuments(ctx, bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: tenant}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+	return nil
+}
+
+func reviewFilter(tenant, id string) (bson.D, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, id)
+	}
+
+	return bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: tenant}}, nil
+}
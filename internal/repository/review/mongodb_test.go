@@ -0,0 +1,189 @@
+package review
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+	"time"
+)
+
+func Test_MongoDB_UpdateReview(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	reviewID := primitive.NewObjectID()
+	bookID := primitive.NewObjectID()
+	approved := mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+		{Key: "_id", Value: reviewID},
+		{Key: "tenant", Value: "acme"},
+		{Key: "book_id", Value: bookID.Hex()},
+		{Key: "rating", Value: 3},
+		{Key: "status", Value: models.ReviewApproved},
+	}})
+
+	mt.Run("Should add change of approved rating to the rating of the book", func(mt *mtest.T) {
+		// given
+		ts := prepareTestRepo(mt)
+
+		mt.AddMockResponses(
+			approved,
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{Key: "tenant", Value: "acme"},
+				{Key: "book_id", Value: bookID.Hex()},
+				{Key: "sum", Value: 10},
+				{Key: "count", Value: 2},
+				{Key: "average", Value: 5.0},
+			}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		// when
+		err := ts.UpdateReview(&models.Review{ID: reviewID.Hex(), Tenant: "acme", Rating: 5, Status: models.ReviewApproved, UpdatedAt: time.Now()})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while updating review:", err)
+		}
+
+		_ = mt.GetStartedEvent()
+		command := mt.GetStartedEvent().Command
+		if id := command.Lookup("query", "book_id").StringValue(); id != bookID.Hex() {
+			t.Fatalf("Rating of book %q should be updated, has filter: %s\n", bookID.Hex(), command.Lookup("query"))
+		}
+		set := command.Lookup("update").Array().Index(0).Value().Document().Lookup("$set").Document()
+		sum := set.Lookup("sum", "$add").Array().Index(1).Value().AsInt64()
+		count := set.Lookup("count", "$add").Array().Index(1).Value().AsInt64()
+		if sum != 2 || count != 0 {
+			t.Fatalf("Rating should change by sum=2 and count=0, changes by sum=%d and count=%d\n", sum, count)
+		}
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if id := update.Lookup("q", "_id").ObjectID(); id != bookID {
+			t.Fatalf("Rating should be copied to book %s, has filter: %s\n", bookID.Hex(), update.Lookup("q"))
+		}
+		copied := update.Lookup("u", "$set").Document()
+		if copied.Lookup("rating_average").Double() != 5 || copied.Lookup("rating_count").AsInt64() != 2 {
+			t.Fatalf("Book should get rating average=5 and count=2, has: %s\n", copied)
+		}
+
+		if name := mt.GetStartedEvent().CommandName; name != "commitTransaction" {
+			t.Fatalf("Review and ratings should be written in one transaction, sent %s instead of commit\n", name)
+		}
+	})
+
+	mt.Run("Should leave the rating when review is not approved", func(mt *mtest.T) {
+		// given
+		ts := prepareTestRepo(mt)
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+				{Key: "_id", Value: reviewID},
+				{Key: "tenant", Value: "acme"},
+				{Key: "book_id", Value: bookID.Hex()},
+				{Key: "rating", Value: 3},
+				{Key: "status", Value: models.ReviewPending},
+			}}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		// when
+		err := ts.UpdateReview(&models.Review{ID: reviewID.Hex(), Tenant: "acme", Rating: 1, Status: models.ReviewHidden})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while updating review:", err)
+		}
+
+		_ = mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event.CommandName != "commitTransaction" {
+			t.Fatalf("Rating should not be updated, sent: %s\n", event.Command)
+		}
+	})
+
+	mt.Run("Should return error of missing review", func(mt *mtest.T) {
+		// given
+		ts := prepareTestRepo(mt)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}), mtest.CreateSuccessResponse())
+
+		// when
+		err := ts.UpdateReview(&models.Review{ID: reviewID.Hex(), Tenant: "acme", Rating: 1})
+
+		// then
+		if !errors.Is(err, repository.ErrReviewNotFound) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrReviewNotFound, err)
+		}
+	})
+}
+
+func Test_MongoDB_AddReview_ShouldRejectSecondReviewOfMember(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should return error of existing review", func(mt *mtest.T) {
+		// given
+		ts := prepareTestRepo(mt)
+
+		memberID := primitive.NewObjectID()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.members", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key error"}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		// when
+		_, err := ts.AddReview(&models.Review{Tenant: "acme", BookID: "42", MemberID: memberID.Hex(), Rating: 4, Status: models.ReviewApproved})
+
+		// then
+		if !errors.Is(err, repository.ErrReviewExists) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrReviewExists, err)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			t.Fatal("Duplicate key error should not leak out of the repository")
+		}
+	})
+}
+
+func Test_MongoDB_DeleteBookReferences(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should remove reviews and rating of the book", func(mt *mtest.T) {
+		// given
+		ts := prepareTestRepo(mt)
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		// when
+		err := ts.DeleteBookReferences("acme", "42")
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while removing references:", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			filter := mt.GetStartedEvent().Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+			if filter.Lookup("tenant").StringValue() != "acme" || filter.Lookup("book_id").StringValue() != "42" {
+				t.Fatalf("Delete %d should remove documents of book 42 of acme, has filter: %s\n", i+1, filter)
+			}
+		}
+	})
+}
+
+// utils
+func prepareTestRepo(mt *mtest.T) *MongoDBRepo {
+	return &MongoDBRepo{db: mt.DB, tenancy: repository.TenancyShared, members: mt.Coll, reviews: mt.Coll, ratings: mt.Coll}
+}
@@ -0,0 +1,363 @@
+package review
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/auwendil/crud-app/internal/repository/book"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strings"
+	"time"
+)
+
+// PostgreSQLRepo keeps ratings in book_ratings table. Writes of reviews
+// lock the rating of the book first and recompute it from approved reviews
+// before committing, so writes to reviews of one book are serialized. The
+// rating is copied to the book in the same transaction, tenancy tells in
+// which schema books of the tenant are kept.
+type PostgreSQLRepo struct {
+	DB *sql.DB
+
+	tenancy repository.Tenancy
+}
+
+const (
+	postgresDBTimeout       = time.Second * 3
+	postgresUniqueViolation = "23505"
+)
+
+const reviewColumns = `id, tenant, book_id, member_id, rating, text, status, created_at, updated_at`
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{DB: db, tenancy: repository.TenancyShared}
+}
+
+func (r *PostgreSQLRepo) SetTenancy(tenancy repository.Tenancy) {
+	r.tenancy = tenancy
+}
+
+func (r *PostgreSQLRepo) GetReviews(tenant, bookID string, filter repository.ReviewFilter) ([]*models.Review, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	conditions := []string{"tenant = $1", "book_id = $2"}
+	args := []any{tenant, bookID}
+	if filter.MemberID != "" {
+		memberID, err := repository.ParseID(filter.MemberID, repository.ErrMemberNotFound)
+		if err != nil {
+			return []*models.Review{}, nil
+		}
+		args = append(args, memberID)
+		conditions = append(conditions, fmt.Sprintf("member_id = $%d::bigint", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	query := `SELECT ` + reviewColumns + ` FROM reviews WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY id DESC;`
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*models.Review{}
+	for rows.Next() {
+		rv, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, rv)
+	}
+
+	return reviews, rows.Err()
+}
+
+func (r *PostgreSQLRepo) GetReview(tenant, id string) (*models.Review, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `SELECT ` + reviewColumns + ` FROM reviews WHERE tenant = $1 AND id = $2::bigint;`
+
+	reviewID, err := repository.ParseID(id, repository.ErrReviewNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	rv, err := scanReview(r.DB.QueryRowContext(ctx, query, tenant, reviewID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, id)
+	}
+	return rv, err
+}
+
+func (r *PostgreSQLRepo) AddReview(rv *models.Review) (*models.Review, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO reviews (tenant, book_id, member_id, rating, text, status, created_at, updated_at)
+		SELECT $1, $2, id, $4, $5, $6, $7, $7
+		FROM members
+		WHERE tenant = $1 AND id = $3::bigint
+		RETURNING id;
+	`
+
+	memberID, err := repository.ParseID(rv.MemberID, repository.ErrMemberNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var newId int
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := lockRating(ctx, tx, rv.Tenant, rv.BookID); err != nil {
+			return err
+		}
+
+		err := tx.QueryRowContext(ctx, query, rv.Tenant, rv.BookID, memberID, rv.Rating, rv.Text, rv.Status, rv.CreatedAt).Scan(&newId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, rv.MemberID)
+		}
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w (book_id=%s, member_id=%s)", repository.ErrReviewExists, rv.BookID, rv.MemberID)
+		}
+		if err != nil {
+			return err
+		}
+
+		return r.refreshRating(ctx, tx, rv.Tenant, rv.BookID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	created := *rv
+	created.ID = fmt.Sprintf("%d", newId)
+	created.UpdatedAt = created.CreatedAt
+	return &created, nil
+}
+
+func (r *PostgreSQLRepo) UpdateReview(rv *models.Review) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		UPDATE reviews
+		SET rating = $3, text = $4, status = $5, updated_at = $6
+		WHERE tenant = $1 AND id = $2::bigint;
+	`
+
+	reviewID, err := repository.ParseID(rv.ID, repository.ErrReviewNotFound)
+	if err != nil {
+		return err
+	}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		bookID, err := lockReviewedBook(ctx, tx, rv.Tenant, reviewID)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, query, rv.Tenant, reviewID, rv.Rating, rv.Text, rv.Status, rv.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if err = notFoundUnlessAffected(res, rv.ID); err != nil {
+			return err
+		}
+
+		return r.refreshRating(ctx, tx, rv.Tenant, bookID)
+	})
+}
+
+func (r *PostgreSQLRepo) DeleteReview(tenant, id string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	reviewID, err := repository.ParseID(id, repository.ErrReviewNotFound)
+	if err != nil {
+		return err
+	}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		bookID, err := lockReviewedBook(ctx, tx, tenant, reviewID)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE tenant = $1 AND id = $2::bigint;`, tenant, reviewID)
+		if err != nil {
+			return err
+		}
+		if err = notFoundUnlessAffected(res, id); err != nil {
+			return err
+		}
+
+		return r.refreshRating(ctx, tx, tenant, bookID)
+	})
+}
+
+func (r *PostgreSQLRepo) GetRatings(tenant string, bookIDs []string) (map[string]*models.Rating, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	ratings := make(map[string]*models.Rating)
+	if len(bookIDs) == 0 {
+		return ratings, nil
+	}
+
+	query := `
+		SELECT book_id, average, count
+		FROM book_ratings
+		WHERE tenant = $1 AND book_id = ANY($2::text[]) AND count > 0;
+	`
+
+	rows, err := r.DB.QueryContext(ctx, query, tenant, textArray(bookIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rating models.Rating
+		if err = rows.Scan(&rating.BookID, &rating.Average, &rating.Count); err != nil {
+			return nil, err
+		}
+		ratings[rating.BookID] = &rating
+	}
+
+	return ratings, rows.Err()
+}
+
+// DeleteBookReferences removes the rating first, so writes of reviews of
+// the book wait for the removal, then the reviews.
+func (r *PostgreSQLRepo) DeleteBookReferences(tenant, bookID string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	condition, args := `tenant = $1`, []any{tenant}
+	if bookID != "" {
+		condition, args = `tenant = $1 AND book_id = $2`, append(args, bookID)
+	}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM book_ratings WHERE `+condition+`;`, args...); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE `+condition+`;`, args...)
+		return err
+	})
+}
+
+func (r *PostgreSQLRepo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockReviewedBook locks the rating of the book the review is about,
+// returning the book.
+func lockReviewedBook(ctx context.Context, tx *sql.Tx, tenant string, id int64) (string, error) {
+	var bookID string
+	err := tx.QueryRowContext(ctx, `SELECT book_id FROM reviews WHERE tenant = $1 AND id = $2::bigint;`, tenant, id).Scan(&bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w (id=%d)", repository.ErrReviewNotFound, id)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return bookID, lockRating(ctx, tx, tenant, bookID)
+}
+
+// lockRating creates the rating of the book when missing and locks it for
+// the transaction. Statements following it see reviews committed by
+// transactions which held the lock before.
+func lockRating(ctx context.Context, tx *sql.Tx, tenant, bookID string) error {
+	query := `
+		INSERT INTO book_ratings (tenant, book_id)
+		VALUES ($1, $2)
+		ON CONFLICT (tenant, book_id) DO UPDATE SET count = book_ratings.count;
+	`
+
+	_, err := tx.ExecContext(ctx, query, tenant, bookID)
+	return err
+}
+
+// refreshRating recomputes the locked rating of the book from its approved
+// reviews and copies it to the book.
+func (r *PostgreSQLRepo) refreshRating(ctx context.Context, tx *sql.Tx, tenant, bookID string) error {
+	query := `
+		UPDATE book_ratings
+		SET (average, count) = (
+			SELECT COALESCE(AVG(rating), 0), COUNT(*)
+			FROM reviews
+			WHERE tenant = $1 AND book_id = $2 AND status = 'approved'
+		)
+		WHERE tenant = $1 AND book_id = $2
+		RETURNING average, count;
+	`
+
+	rating := models.Rating{BookID: bookID}
+	if err := tx.QueryRowContext(ctx, query, tenant, bookID).Scan(&rating.Average, &rating.Count); err != nil {
+		return err
+	}
+
+	return book.SetRatingPostgreSQL(ctx, tx, r.tenancy, tenant, bookID, &rating)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanReview(row rowScanner) (*models.Review, error) {
+	var rv models.Review
+	var memberID sql.NullString
+	err := row.Scan(&rv.ID, &rv.Tenant, &rv.BookID, &memberID, &rv.Rating, &rv.Text, &rv.Status, &rv.CreatedAt, &rv.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rv.MemberID = memberID.String
+	return &rv, nil
+}
+
+// textArray formats ids as a PostgreSQL array literal, quoting every
+// element.
+func textArray(ids []string) string {
+	quoted := make([]string, len(ids))
+	for i, id := range ids {
+		id = strings.ReplaceAll(id, `\`, `\\`)
+		quoted[i] = `"` + strings.ReplaceAll(id, `"`, `\"`) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation
+}
+
+func notFoundUnlessAffected(res sql.Result, id string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrReviewNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package review
+
+import (
+	"database/sql/driver"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"regexp"
+	"testing"
+	"time"
+)
+
+const (
+	lockRatingQuery    = `INSERT INTO book_ratings (tenant, book_id) VALUES ($1, $2) ON CONFLICT (tenant, book_id) DO UPDATE SET count = book_ratings.count;`
+	refreshRatingQuery = `UPDATE book_ratings SET (average, count) = ( SELECT COALESCE(AVG(rating), 0), COUNT(*) FROM reviews WHERE tenant = $1 AND book_id = $2 AND status = 'approved' ) WHERE tenant = $1 AND book_id = $2 RETURNING average, count;`
+	insertReviewQuery  = `INSERT INTO reviews (tenant, book_id, member_id, rating, text, status, created_at, updated_at) SELECT $1, $2, id, $4, $5, $6, $7, $7 FROM members WHERE tenant = $1 AND id = $3::bigint RETURNING id;`
+)
+
+func Test_Postgresql_AddReview_ShouldRefreshLockedRating(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	now := time.Now().UTC()
+	rv := &models.Review{Tenant: "acme", BookID: "42", MemberID: "5", Rating: 4, Text: "Good", Status: models.ReviewApproved, CreatedAt: now}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(lockRatingQuery)).
+		WithArgs("acme", "42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(insertReviewQuery)).
+		WithArgs("acme", "42", int64(5), 4, "Good", models.ReviewApproved, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	expectRefreshRating(mock, "public.books", 4, 1)
+	mock.ExpectCommit()
+
+	// when
+	created, err := testServer.AddReview(rv)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.ID != "7" || !created.UpdatedAt.Equal(now) {
+		t.Fatalf("Created review is different than expected: %+v\n", created)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_AddReview_ShouldTellWhyReviewWasNotAdded(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{name: "missing member", err: nil, expected: repository.ErrMemberNotFound},
+		{name: "second review of member", err: &pgconn.PgError{Code: postgresUniqueViolation}, expected: repository.ErrReviewExists},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			testServer, mock := prepareTestDB(t)
+			defer testServer.DB.Close()
+
+			// given
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(lockRatingQuery)).
+				WithArgs("acme", "42").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			insert := mock.ExpectQuery(regexp.QuoteMeta(insertReviewQuery))
+			if tt.err != nil {
+				insert.WillReturnError(tt.err)
+			} else {
+				insert.WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			}
+			mock.ExpectRollback()
+
+			// when
+			_, err := testServer.AddReview(&models.Review{Tenant: "acme", BookID: "42", MemberID: "5", Rating: 4})
+
+			// then
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("Expected %v, has: %v\n", tt.expected, err)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func Test_Postgresql_ShouldNotFindReviewsWithNonNumericIDs(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// when
+	_, getErr := testServer.GetReview("acme", "abc")
+	deleteErr := testServer.DeleteReview("acme", "abc")
+	reviews, listErr := testServer.GetReviews("acme", "42", repository.ReviewFilter{MemberID: "abc"})
+
+	// then
+	if !errors.Is(getErr, repository.ErrReviewNotFound) || !errors.Is(deleteErr, repository.ErrReviewNotFound) {
+		t.Fatalf("Expected %v, has: %v and %v\n", repository.ErrReviewNotFound, getErr, deleteErr)
+	}
+	if listErr != nil || len(reviews) != 0 {
+		t.Fatalf("Expected no reviews, has: %v, %v\n", reviews, listErr)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_DeleteReview_ShouldRefreshRatingOfReviewedBook(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+	testServer.SetTenancy(repository.TenancyIsolated)
+
+	// given
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT book_id FROM reviews WHERE tenant = $1 AND id = $2::bigint;`)).
+		WithArgs("acme", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id"}).AddRow("42"))
+	mock.ExpectExec(regexp.QuoteMeta(lockRatingQuery)).
+		WithArgs("acme", "42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM reviews WHERE tenant = $1 AND id = $2::bigint;`)).
+		WithArgs("acme", int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRefreshRating(mock, `"tenant_acme".books`, 0, 0)
+	mock.ExpectCommit()
+
+	// when
+	err := testServer.DeleteReview("acme", "7")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_DeleteBookReferences(t *testing.T) {
+	tests := []struct {
+		name      string
+		bookID    string
+		condition string
+		args      []driver.Value
+	}{
+		{"Should remove reviews and rating of the book", "42", `tenant = $1 AND book_id = $2`, []driver.Value{"acme", "42"}},
+		{"Should remove reviews and ratings of every book", "", `tenant = $1`, []driver.Value{"acme"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			testServer, mock := prepareTestDB(t)
+			defer testServer.DB.Close()
+
+			// given
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM book_ratings WHERE ` + tt.condition + `;`)).
+				WithArgs(tt.args...).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM reviews WHERE ` + tt.condition + `;`)).
+				WithArgs(tt.args...).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectCommit()
+
+			// when
+			err := testServer.DeleteBookReferences("acme", tt.bookID)
+
+			// then
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func Test_Postgresql_GetRatings(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT book_id, average, count FROM book_ratings WHERE tenant = $1 AND book_id = ANY($2::text[]) AND count > 0;`)).
+		WithArgs("acme", `{"1","2","a\"b"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "average", "count"}).AddRow("2", 4.5, 2))
+
+	// when
+	ratings, err := testServer.GetRatings("acme", []string{"1", "2", `a"b`})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ratings) != 1 || ratings["2"].Average != 4.5 || ratings["2"].Count != 2 {
+		t.Fatalf("Ratings are different than expected: %+v\n", ratings)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// utils
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
+
+// expectRefreshRating expects the rating of book 42 of tenant acme to be
+// recomputed and copied to the book in table.
+func expectRefreshRating(mock sqlmock.Sqlmock, table string, average float64, count int) {
+	mock.ExpectQuery(regexp.QuoteMeta(refreshRatingQuery)).
+		WithArgs("acme", "42").
+		WillReturnRows(sqlmock.NewRows([]string{"average", "count"}).AddRow(average, count))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT set_config('app.tenant_id', $1, true);`)).
+		WithArgs("acme").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE `+table+` SET rating_average = $3, rating_count = $4 WHERE id = $1 AND tenant_id = $2;`)).
+		WithArgs("42", "acme", average, count).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
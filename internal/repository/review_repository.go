@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+)
+
+var (
+	ErrReviewNotFound = errors.New("review not found")
+	ErrReviewExists   = errors.New("member already reviewed the book")
+)
+
+// ReviewFilter selects reviews of a book, zero fields match every review.
+type ReviewFilter struct {
+	MemberID string
+	Status   string
+}
+
+// ReviewRepo keeps reviews of books together with ratings aggregating
+// them. Every write of a review updates the rating of its book, so both
+// stay consistent even when reviews of the book are written at once. The
+// rating is copied to the book in the same transaction, RatingSorter of
+// the book repository sorts by it.
+type ReviewRepo interface {
+	// GetReviews returns reviews of the book matching filter, newest
+	// first.
+	GetReviews(tenant, bookID string, filter ReviewFilter) ([]*models.Review, error)
+	GetReview(tenant, id string) (*models.Review, error)
+	// AddReview fails with ErrMemberNotFound when the member does not
+	// exist and with ErrReviewExists when the member already reviewed the
+	// book.
+	AddReview(rv *models.Review) (*models.Review, error)
+	// UpdateReview replaces rating, text, status and the update time of
+	// the review.
+	UpdateReview(rv *models.Review) error
+	DeleteReview(tenant, id string) error
+
+	// GetRatings returns ratings of books among bookIDs by their IDs,
+	// books without approved reviews are skipped.
+	GetRatings(tenant string, bookIDs []string) (map[string]*models.Rating, error)
+
+	// BookReferences removes reviews of deleted books together with their
+	// ratings.
+	BookReferences
+}
@@ -0,0 +1,380 @@
+package shelf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoDBRepo keeps entries embedded in documents of their shelves in the
+// order of their positions. Every write of entries is a single update of
+// the shelf document, moves and reorders compute the new order on the
+// server, so writes running at once do not overwrite each other.
+type MongoDBRepo struct {
+	members *mongo.Collection
+	shelves *mongo.Collection
+}
+
+const (
+	mongoMembersCollectionName = "members"
+	mongoShelvesCollectionName = "shelves"
+)
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		members: db.Collection(mongoMembersCollectionName),
+		shelves: db.Collection(mongoShelvesCollectionName),
+	}
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.shelves.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "member_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "share_token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "entries.book_id", Value: 1}}},
+	})
+	return err
+}
+
+func (r *MongoDBRepo) GetShelves(tenant, memberID string) ([]*models.Shelf, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := bson.D{{Key: "tenant", Value: tenant}, {Key: "member_id", Value: memberID}}
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "entries", Value: 0}}).
+		SetSort(bson.D{{Key: "default", Value: -1}, {Key: "_id", Value: 1}})
+	cursor, err := r.shelves.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	shelves := []*models.Shelf{}
+	if err = cursor.All(ctx, &shelves); err != nil {
+		return nil, err
+	}
+
+	return shelves, nil
+}
+
+func (r *MongoDBRepo) GetShelf(tenant, id string) (*models.Shelf, error) {
+	filter, err := shelfFilter(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.getShelf(filter, id)
+}
+
+func (r *MongoDBRepo) GetSharedShelf(tenant, token string) (*models.Shelf, error) {
+	filter := bson.D{
+		{Key: "tenant", Value: tenant},
+		{Key: "share_token", Value: token},
+		{Key: "visibility", Value: models.ShelfPublic},
+	}
+	return r.getShelf(filter, token)
+}
+
+func (r *MongoDBRepo) AddShelf(sh *models.Shelf) (*models.Shelf, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.memberExists(ctx, sh.Tenant, sh.MemberID); err != nil {
+		return nil, err
+	}
+
+	created := *sh
+	created.ID = ""
+	created.UpdatedAt = created.CreatedAt
+	created.Entries = []*models.ShelfEntry{}
+
+	result, err := r.shelves.InsertOne(ctx, &created)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("%w (member_id=%s, name=%s)", repository.ErrShelfExists, sh.MemberID, sh.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		created.ID = oid.Hex()
+	}
+
+	created.Entries = nil
+	return &created, nil
+}
+
+func (r *MongoDBRepo) UpdateShelf(sh *models.Shelf) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := shelfFilter(sh.Tenant, sh.ID)
+	if err != nil {
+		return err
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: sh.Name},
+		{Key: "visibility", Value: sh.Visibility},
+		{Key: "share_token", Value: sh.ShareToken},
+		{Key: "updated_at", Value: sh.UpdatedAt},
+	}}}
+
+	result, err := r.shelves.UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w (member_id=%s, name=%s)", repository.ErrShelfExists, sh.MemberID, sh.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, sh.ID)
+	}
+	return nil
+}
+
+func (r *MongoDBRepo) DeleteShelf(tenant, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := shelfFilter(tenant, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.shelves.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, id)
+	}
+	return nil
+}
+
+func (r *MongoDBRepo) AddShelfEntry(tenant, shelfID string, e *models.ShelfEntry) (*models.ShelfEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := shelfFilter(tenant, shelfID)
+	if err != nil {
+		return nil, err
+	}
+
+	created := *e
+	created.UpdatedAt = created.AddedAt
+	update := bson.D{{Key: "$push", Value: bson.D{{Key: "entries", Value: &created}}}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.D{{Key: "entries.book_id", Value: 1}})
+
+	var updated models.Shelf
+	err = r.shelves.FindOneAndUpdate(ctx, append(filter, bson.E{Key: "entries.book_id", Value: bson.D{{Key: "$ne", Value: e.BookID}}}), update, opts).
+		Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.missingEntry(ctx, filter, shelfID, repository.ErrShelfEntryExists, e.BookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created.Position = len(updated.Entries)
+	return &created, nil
+}
+
+func (r *MongoDBRepo) UpdateShelfEntry(tenant, shelfID string, e *models.ShelfEntry) error {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "entries.$.note", Value: e.Note},
+		{Key: "entries.$.progress", Value: e.Progress},
+		{Key: "entries.$.updated_at", Value: e.UpdatedAt},
+	}}}
+	return r.updateEntry(tenant, shelfID, e.BookID, update)
+}
+
+func (r *MongoDBRepo) DeleteShelfEntry(tenant, shelfID, bookID string) error {
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "entries", Value: bson.D{{Key: "book_id", Value: bookID}}}}}}
+	return r.updateEntry(tenant, shelfID, bookID, update)
+}
+
+func (r *MongoDBRepo) MoveShelfEntry(tenant, shelfID, bookID string, position int) error {
+	if position < 1 {
+		position = 1
+	}
+
+	// others are entries without the moved one, which is put in front of
+	// the entry at position
+	others := bson.D{{Key: "$filter", Value: bson.D{
+		{Key: "input", Value: "$entries"},
+		{Key: "cond", Value: bson.D{{Key: "$ne", Value: bson.A{"$$this.book_id", bookID}}}},
+	}}}
+	moved := bson.D{{Key: "$filter", Value: bson.D{
+		{Key: "input", Value: "$entries"},
+		{Key: "cond", Value: bson.D{{Key: "$eq", Value: bson.A{"$$this.book_id", bookID}}}},
+	}}}
+	parts := bson.A{"$$moved", bson.D{{Key: "$slice", Value: bson.A{
+		"$$others", position - 1, bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$size", Value: "$$others"}}, 1}}},
+	}}}}
+	if position > 1 {
+		parts = append(bson.A{bson.D{{Key: "$slice", Value: bson.A{"$$others", position - 1}}}}, parts...)
+	}
+
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "entries", Value: bson.D{{Key: "$let", Value: bson.D{
+		{Key: "vars", Value: bson.D{{Key: "others", Value: others}, {Key: "moved", Value: moved}}},
+		{Key: "in", Value: bson.D{{Key: "$concatArrays", Value: parts}}},
+	}}}}}}}}
+	return r.updateEntry(tenant, shelfID, bookID, update)
+}
+
+func (r *MongoDBRepo) ReorderShelf(tenant, shelfID string, bookIDs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := shelfFilter(tenant, shelfID)
+	if err != nil {
+		return err
+	}
+
+	unique := make(map[string]bool, len(bookIDs))
+	for _, id := range bookIDs {
+		unique[id] = true
+	}
+	if len(unique) != len(bookIDs) {
+		return fmt.Errorf("%w (shelf_id=%s)", repository.ErrShelfOrderMismatch, shelfID)
+	}
+
+	// the shelf matches only when it has every book of bookIDs and no other
+	matching := append(filter,
+		bson.E{Key: "entries", Value: bson.D{{Key: "$size", Value: len(bookIDs)}}},
+		bson.E{Key: "entries.book_id", Value: bson.D{{Key: "$all", Value: bookIDs}}},
+	)
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "entries", Value: bson.D{{Key: "$map", Value: bson.D{
+		{Key: "input", Value: bookIDs},
+		{Key: "in", Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{
+			"$entries",
+			bson.D{{Key: "$indexOfArray", Value: bson.A{"$entries.book_id", "$$this"}}},
+		}}}},
+	}}}}}}}}
+
+	result, err := r.shelves.UpdateOne(ctx, matching, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.missingEntry(ctx, filter, shelfID, repository.ErrShelfOrderMismatch, "")
+	}
+	return nil
+}
+
+func (r *MongoDBRepo) DeleteBookReferences(tenant, bookID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "tenant", Value: tenant}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "entries", Value: bson.A{}}}}}
+	if bookID != "" {
+		filter = append(filter, bson.E{Key: "entries.book_id", Value: bookID})
+		update = bson.D{{Key: "$pull", Value: bson.D{{Key: "entries", Value: bson.D{{Key: "book_id", Value: bookID}}}}}}
+	}
+
+	_, err := r.shelves.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// getShelf returns the shelf matching filter, numbering its entries.
+func (r *MongoDBRepo) getShelf(filter bson.D, key string) (*models.Shelf, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var sh *models.Shelf
+	err := r.shelves.FindOne(ctx, filter).Decode(&sh)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sh.Entries == nil {
+		sh.Entries = []*models.ShelfEntry{}
+	}
+	for i, e := range sh.Entries {
+		e.Position = i + 1
+	}
+	return sh, nil
+}
+
+// updateEntry applies update to the shelf when the book is on it.
+func (r *MongoDBRepo) updateEntry(tenant, shelfID, bookID string, update any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := shelfFilter(tenant, shelfID)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.shelves.UpdateOne(ctx, append(filter, bson.E{Key: "entries.book_id", Value: bookID}), update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.missingEntry(ctx, filter, shelfID, repository.ErrShelfEntryNotFound, bookID)
+	}
+	return nil
+}
+
+// missingEntry tells why an update of entries matched no shelf, it is
+// ErrShelfNotFound when the shelf does not exist and errEntry otherwise.
+func (r *MongoDBRepo) missingEntry(ctx context.Context, filter bson.D, shelfID string, errEntry error, bookID string) error {
+	count, err := r.shelves.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, shelfID)
+	}
+	if bookID == "" {
+		return fmt.Errorf("%w (shelf_id=%s)", errEntry, shelfID)
+	}
+	return fmt.Errorf("%w (shelf_id=%s, book_id=%s)", errEntry, shelfID, bookID)
+}
+
+func (r *MongoDBRepo) memberExists(ctx context.Context, tenant, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+
+	count, err := r.members.CountDocuments(ctx, bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: tenant}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, id)
+	}
+	return nil
+}
+
+func shelfFilter(tenant, id string) (bson.D, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, id)
+	}
+
+	return bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: tenant}}, nil
+}
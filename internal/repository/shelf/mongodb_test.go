@@ -0,0 +1,101 @@
+package shelf
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func Test_MongoDB_ReorderShelf(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	shelfID := primitive.NewObjectID().Hex()
+
+	mt.Run("Should reorder entries only of shelf with the same books", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{members: mt.Coll, shelves: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		// when
+		err := ts.ReorderShelf("acme", shelfID, []string{"2", "1"})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while reordering shelf:", err)
+		}
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if size := update.Lookup("q", "entries", "$size").Int32(); size != 2 {
+			t.Fatalf("Shelf should have exactly 2 entries, has filter: %s\n", update.Lookup("q"))
+		}
+		books, _ := update.Lookup("q", "entries.book_id", "$all").Array().Values()
+		if len(books) != 2 || books[0].StringValue() != "2" || books[1].StringValue() != "1" {
+			t.Fatalf("Shelf should have books [2 1], has filter: %s\n", update.Lookup("q"))
+		}
+	})
+
+	mt.Run("Should return error of order with other books", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{members: mt.Coll, shelves: mt.Coll}
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateCursorResponse(0, "db.shelves", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+		)
+
+		// when
+		err := ts.ReorderShelf("acme", shelfID, []string{"1", "3"})
+
+		// then
+		if !errors.Is(err, repository.ErrShelfOrderMismatch) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrShelfOrderMismatch, err)
+		}
+	})
+
+	mt.Run("Should return error of repeated book without updating shelf", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{members: mt.Coll, shelves: mt.Coll}
+
+		// when
+		err := ts.ReorderShelf("acme", shelfID, []string{"1", "1"})
+
+		// then
+		if !errors.Is(err, repository.ErrShelfOrderMismatch) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrShelfOrderMismatch, err)
+		}
+
+		if event := mt.GetStartedEvent(); event != nil {
+			t.Fatalf("Shelf should not be updated, sent: %s\n", event.Command)
+		}
+	})
+}
+
+func Test_MongoDB_DeleteShelfEntry_ShouldReturnNotFoundOfMissingShelf(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should tell missing shelf from missing entry", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{members: mt.Coll, shelves: mt.Coll}
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateCursorResponse(0, "db.shelves", mtest.FirstBatch, bson.D{{Key: "n", Value: 0}}),
+		)
+
+		// when
+		err := ts.DeleteShelfEntry("acme", primitive.NewObjectID().Hex(), "1")
+
+		// then
+		if !errors.Is(err, repository.ErrShelfNotFound) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrShelfNotFound, err)
+		}
+	})
+}
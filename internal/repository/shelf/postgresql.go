@@ -0,0 +1,451 @@
+package shelf
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strings"
+	"time"
+)
+
+// PostgreSQLRepo keeps entries of shelves in shelf_entries table ordered
+// by their position column, which may have gaps left by removed entries.
+// Writes changing the order lock the row of the shelf first.
+type PostgreSQLRepo struct {
+	DB *sql.DB
+}
+
+const (
+	postgresDBTimeout       = time.Second * 3
+	postgresUniqueViolation = "23505"
+)
+
+const shelfColumns = `id, tenant, member_id, name, is_default, visibility, share_token, created_at, updated_at`
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{DB: db}
+}
+
+func (r *PostgreSQLRepo) GetShelves(tenant, memberID string) ([]*models.Shelf, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `SELECT ` + shelfColumns + ` FROM shelves WHERE tenant = $1 AND member_id = $2::bigint ORDER BY is_default DESC, id;`
+
+	id, err := repository.ParseID(memberID, repository.ErrMemberNotFound)
+	if err != nil {
+		return []*models.Shelf{}, nil
+	}
+
+	rows, err := r.DB.QueryContext(ctx, query, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shelves := []*models.Shelf{}
+	for rows.Next() {
+		sh, err := scanShelf(rows)
+		if err != nil {
+			return nil, err
+		}
+		shelves = append(shelves, sh)
+	}
+
+	return shelves, rows.Err()
+}
+
+func (r *PostgreSQLRepo) GetShelf(tenant, id string) (*models.Shelf, error) {
+	shelfID, err := repository.ParseID(id, repository.ErrShelfNotFound)
+	if err != nil {
+		return nil, err
+	}
+	return r.getShelf(`SELECT `+shelfColumns+` FROM shelves WHERE tenant = $1 AND id = $2::bigint;`, tenant, shelfID)
+}
+
+func (r *PostgreSQLRepo) GetSharedShelf(tenant, token string) (*models.Shelf, error) {
+	return r.getShelf(`SELECT `+shelfColumns+` FROM shelves WHERE tenant = $1 AND share_token = $2 AND visibility = 'public';`, tenant, token)
+}
+
+func (r *PostgreSQLRepo) AddShelf(sh *models.Shelf) (*models.Shelf, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO shelves (tenant, member_id, name, is_default, visibility, share_token, created_at, updated_at)
+		SELECT $1, id, $3, $4, $5, $6, $7, $7
+		FROM members
+		WHERE tenant = $1 AND id = $2::bigint
+		RETURNING id;
+	`
+
+	memberID, err := repository.ParseID(sh.MemberID, repository.ErrMemberNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	var newId int
+	err = r.DB.QueryRowContext(ctx, query, sh.Tenant, memberID, sh.Name, sh.Default, sh.Visibility, sh.ShareToken, sh.CreatedAt).Scan(&newId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrMemberNotFound, sh.MemberID)
+	}
+	if isUniqueViolation(err) {
+		return nil, fmt.Errorf("%w (member_id=%s, name=%s)", repository.ErrShelfExists, sh.MemberID, sh.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created := *sh
+	created.ID = fmt.Sprintf("%d", newId)
+	created.UpdatedAt = created.CreatedAt
+	created.Entries = nil
+	return &created, nil
+}
+
+func (r *PostgreSQLRepo) UpdateShelf(sh *models.Shelf) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		UPDATE shelves
+		SET name = $3, visibility = $4, share_token = $5, updated_at = $6
+		WHERE tenant = $1 AND id = $2::bigint;
+	`
+
+	shelfID, err := repository.ParseID(sh.ID, repository.ErrShelfNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, query, sh.Tenant, shelfID, sh.Name, sh.Visibility, sh.ShareToken, sh.UpdatedAt)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("%w (member_id=%s, name=%s)", repository.ErrShelfExists, sh.MemberID, sh.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	return notFoundUnlessAffected(res, repository.ErrShelfNotFound, sh.ID)
+}
+
+func (r *PostgreSQLRepo) DeleteShelf(tenant, id string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	shelfID, err := repository.ParseID(id, repository.ErrShelfNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM shelves WHERE tenant = $1 AND id = $2::bigint;`, tenant, shelfID)
+	if err != nil {
+		return err
+	}
+
+	return notFoundUnlessAffected(res, repository.ErrShelfNotFound, id)
+}
+
+func (r *PostgreSQLRepo) AddShelfEntry(tenant, shelfID string, e *models.ShelfEntry) (*models.ShelfEntry, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO shelf_entries (tenant, shelf_id, book_id, position, note, progress, added_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7);
+	`
+
+	created := *e
+	err := r.inShelfTx(ctx, tenant, shelfID, func(tx *sql.Tx, id int) error {
+		var last int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(MAX(position), 0) FROM shelf_entries WHERE shelf_id = $1;`, id).
+			Scan(&created.Position, &last)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, query, tenant, id, e.BookID, last+1, e.Note, e.Progress, e.AddedAt)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w (shelf_id=%s, book_id=%s)", repository.ErrShelfEntryExists, shelfID, e.BookID)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	created.Position++
+	created.UpdatedAt = created.AddedAt
+	return &created, nil
+}
+
+func (r *PostgreSQLRepo) UpdateShelfEntry(tenant, shelfID string, e *models.ShelfEntry) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		UPDATE shelf_entries
+		SET note = $4, progress = $5, updated_at = $6
+		WHERE tenant = $1 AND shelf_id = $2::bigint AND book_id = $3;
+	`
+
+	id, err := repository.ParseID(shelfID, repository.ErrShelfNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, query, tenant, id, e.BookID, e.Note, e.Progress, e.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return notFoundUnlessAffected(res, repository.ErrShelfEntryNotFound, e.BookID)
+}
+
+func (r *PostgreSQLRepo) DeleteShelfEntry(tenant, shelfID, bookID string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `DELETE FROM shelf_entries WHERE tenant = $1 AND shelf_id = $2::bigint AND book_id = $3;`
+
+	id, err := repository.ParseID(shelfID, repository.ErrShelfNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, query, tenant, id, bookID)
+	if err != nil {
+		return err
+	}
+
+	return notFoundUnlessAffected(res, repository.ErrShelfEntryNotFound, bookID)
+}
+
+func (r *PostgreSQLRepo) MoveShelfEntry(tenant, shelfID, bookID string, position int) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	return r.inShelfTx(ctx, tenant, shelfID, func(tx *sql.Tx, id int) error {
+		bookIDs, err := shelfOrder(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		others := make([]string, 0, len(bookIDs))
+		for _, other := range bookIDs {
+			if other != bookID {
+				others = append(others, other)
+			}
+		}
+		if len(others) == len(bookIDs) {
+			return fmt.Errorf("%w (shelf_id=%s, book_id=%s)", repository.ErrShelfEntryNotFound, shelfID, bookID)
+		}
+
+		switch {
+		case position < 1:
+			position = 1
+		case position > len(others)+1:
+			position = len(others) + 1
+		}
+		order := append(append(append([]string{}, others[:position-1]...), bookID), others[position-1:]...)
+		return writeOrder(ctx, tx, id, order)
+	})
+}
+
+func (r *PostgreSQLRepo) ReorderShelf(tenant, shelfID string, bookIDs []string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	return r.inShelfTx(ctx, tenant, shelfID, func(tx *sql.Tx, id int) error {
+		current, err := shelfOrder(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !sameBooks(current, bookIDs) {
+			return fmt.Errorf("%w (shelf_id=%s)", repository.ErrShelfOrderMismatch, shelfID)
+		}
+
+		return writeOrder(ctx, tx, id, bookIDs)
+	})
+}
+
+func (r *PostgreSQLRepo) DeleteBookReferences(tenant, bookID string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query, args := `DELETE FROM shelf_entries WHERE tenant = $1;`, []any{tenant}
+	if bookID != "" {
+		query, args = `DELETE FROM shelf_entries WHERE tenant = $1 AND book_id = $2;`, append(args, bookID)
+	}
+
+	_, err := r.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// getShelf returns the shelf selected by query together with its entries.
+func (r *PostgreSQLRepo) getShelf(query string, tenant string, key any) (*models.Shelf, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	sh, err := scanShelf(r.DB.QueryRowContext(ctx, query, tenant, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%v)", repository.ErrShelfNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	shelfID, err := repository.ParseID(sh.ID, repository.ErrShelfNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	entriesQuery := `
+		SELECT book_id, note, progress, added_at, updated_at
+		FROM shelf_entries
+		WHERE shelf_id = $1::bigint
+		ORDER BY position, book_id;
+	`
+
+	rows, err := r.DB.QueryContext(ctx, entriesQuery, shelfID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sh.Entries = []*models.ShelfEntry{}
+	for rows.Next() {
+		e := models.ShelfEntry{Position: len(sh.Entries) + 1}
+		if err = rows.Scan(&e.BookID, &e.Note, &e.Progress, &e.AddedAt, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		sh.Entries = append(sh.Entries, &e)
+	}
+
+	return sh, rows.Err()
+}
+
+// inShelfTx runs fn in a transaction holding the lock of the shelf, fn
+// gets the numeric ID of the shelf.
+func (r *PostgreSQLRepo) inShelfTx(ctx context.Context, tenant, shelfID string, fn func(tx *sql.Tx, id int) error) error {
+	parsedID, err := repository.ParseID(shelfID, repository.ErrShelfNotFound)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM shelves WHERE tenant = $1 AND id = $2::bigint FOR UPDATE;`, tenant, parsedID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w (id=%s)", repository.ErrShelfNotFound, shelfID)
+	}
+	if err == nil {
+		err = fn(tx, id)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// shelfOrder returns books of the shelf ordered by their position.
+func shelfOrder(ctx context.Context, tx *sql.Tx, id int) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT book_id FROM shelf_entries WHERE shelf_id = $1 ORDER BY position, book_id;`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookIDs := []string{}
+	for rows.Next() {
+		var bookID string
+		if err = rows.Scan(&bookID); err != nil {
+			return nil, err
+		}
+		bookIDs = append(bookIDs, bookID)
+	}
+
+	return bookIDs, rows.Err()
+}
+
+// writeOrder numbers entries of the shelf in the order of bookIDs.
+func writeOrder(ctx context.Context, tx *sql.Tx, id int, bookIDs []string) error {
+	query := `
+		UPDATE shelf_entries e
+		SET position = o.position
+		FROM unnest($2::text[]) WITH ORDINALITY AS o(book_id, position)
+		WHERE e.shelf_id = $1 AND e.book_id = o.book_id;
+	`
+
+	_, err := tx.ExecContext(ctx, query, id, textArray(bookIDs))
+	return err
+}
+
+// sameBooks reports whether order lists every book of current exactly
+// once.
+func sameBooks(current, order []string) bool {
+	if len(current) != len(order) {
+		return false
+	}
+
+	listed := make(map[string]bool, len(order))
+	for _, id := range order {
+		listed[id] = true
+	}
+	for _, id := range current {
+		if !listed[id] {
+			return false
+		}
+	}
+	return len(listed) == len(current)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanShelf(row rowScanner) (*models.Shelf, error) {
+	var sh models.Shelf
+	err := row.Scan(&sh.ID, &sh.Tenant, &sh.MemberID, &sh.Name, &sh.Default, &sh.Visibility, &sh.ShareToken, &sh.CreatedAt, &sh.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &sh, nil
+}
+
+// textArray formats ids as a PostgreSQL array literal, quoting every
+// element.
+func textArray(ids []string) string {
+	quoted := make([]string, len(ids))
+	for i, id := range ids {
+		id = strings.ReplaceAll(id, `\`, `\\`)
+		quoted[i] = `"` + strings.ReplaceAll(id, `"`, `\"`) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation
+}
+
+func notFoundUnlessAffected(res sql.Result, notFound error, id string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w (id=%s)", notFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package shelf
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"regexp"
+	"testing"
+	"time"
+)
+
+const (
+	lockShelfQuery  = `SELECT id FROM shelves WHERE tenant = $1 AND id = $2::bigint FOR UPDATE;`
+	shelfOrderQuery = `SELECT book_id FROM shelf_entries WHERE shelf_id = $1 ORDER BY position, book_id;`
+	writeOrderQuery = `UPDATE shelf_entries e SET position = o.position FROM unnest($2::text[]) WITH ORDINALITY AS o(book_id, position) WHERE e.shelf_id = $1 AND e.book_id = o.book_id;`
+)
+
+func Test_Postgresql_AddShelfEntry_ShouldPutBookLast(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	addedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(lockShelfQuery)).
+		WithArgs("acme", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*), COALESCE(MAX(position), 0) FROM shelf_entries WHERE shelf_id = $1;`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(2, 5))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO shelf_entries (tenant, shelf_id, book_id, position, note, progress, added_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $7);`)).
+		WithArgs("acme", 7, "42", 6, "Recommended", 0, addedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// when
+	e, err := testServer.AddShelfEntry("acme", "7", &models.ShelfEntry{BookID: "42", Note: "Recommended", AddedAt: addedAt})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Position != 3 || !e.UpdatedAt.Equal(addedAt) {
+		t.Fatalf("Expected third entry added at %v, has: %+v\n", addedAt, e)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_MoveShelfEntry_ShouldShiftFollowingEntries(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(lockShelfQuery)).
+		WithArgs("acme", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(regexp.QuoteMeta(shelfOrderQuery)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id"}).AddRow("1").AddRow("2").AddRow("3"))
+	mock.ExpectExec(regexp.QuoteMeta(writeOrderQuery)).
+		WithArgs(7, `{"1","3","2"}`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	// when
+	err := testServer.MoveShelfEntry("acme", "7", "3", 2)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_ReorderShelf_ShouldRejectOrderOfOtherBooks(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	tests := []struct {
+		name    string
+		bookIDs []string
+	}{
+		{"Missing book", []string{"2"}},
+		{"Repeated book", []string{"1", "1"}},
+		{"Other book", []string{"1", "3"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(lockShelfQuery)).
+				WithArgs("acme", int64(7)).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			mock.ExpectQuery(regexp.QuoteMeta(shelfOrderQuery)).
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"book_id"}).AddRow("1").AddRow("2"))
+			mock.ExpectRollback()
+
+			// when
+			err := testServer.ReorderShelf("acme", "7", tt.bookIDs)
+
+			// then
+			if !errors.Is(err, repository.ErrShelfOrderMismatch) {
+				t.Fatalf("Expected %v, has: %v\n", repository.ErrShelfOrderMismatch, err)
+			}
+
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func Test_Postgresql_ShouldNotFindShelvesWithNonNumericIDs(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// when
+	_, getErr := testServer.GetShelf("acme", "abc")
+	reorderErr := testServer.ReorderShelf("acme", "abc", []string{"1"})
+	shelves, listErr := testServer.GetShelves("acme", "abc")
+
+	// then
+	if !errors.Is(getErr, repository.ErrShelfNotFound) || !errors.Is(reorderErr, repository.ErrShelfNotFound) {
+		t.Fatalf("Expected %v, has: %v and %v\n", repository.ErrShelfNotFound, getErr, reorderErr)
+	}
+	if listErr != nil || len(shelves) != 0 {
+		t.Fatalf("Expected no shelves, has: %v, %v\n", shelves, listErr)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// utils
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+)
+
+var (
+	ErrShelfNotFound      = errors.New("shelf not found")
+	ErrShelfExists        = errors.New("member already has a shelf of the name")
+	ErrShelfDefault       = errors.New("default shelves cannot be renamed or deleted")
+	ErrShelfEntryNotFound = errors.New("book is not on the shelf")
+	ErrShelfEntryExists   = errors.New("book is already on the shelf")
+	ErrShelfOrderMismatch = errors.New("order must list every book of the shelf once")
+)
+
+// ShelfRepo keeps shelves of members and books on them. Writes of entries
+// of one shelf are serialized, so positions of entries stay consistent.
+type ShelfRepo interface {
+	// GetShelves returns shelves of the member without their entries,
+	// default shelves first, then in the order they were added.
+	GetShelves(tenant, memberID string) ([]*models.Shelf, error)
+	GetShelf(tenant, id string) (*models.Shelf, error)
+	// GetSharedShelf returns the public shelf of the share token, private
+	// shelves are not found.
+	GetSharedShelf(tenant, token string) (*models.Shelf, error)
+	// AddShelf fails with ErrMemberNotFound when the member does not exist
+	// and with ErrShelfExists when the member has a shelf of the same name.
+	AddShelf(sh *models.Shelf) (*models.Shelf, error)
+	// UpdateShelf replaces name, visibility, share token and the update
+	// time of the shelf.
+	UpdateShelf(sh *models.Shelf) error
+	DeleteShelf(tenant, id string) error
+
+	// AddShelfEntry puts the book last on the shelf, it fails with
+	// ErrShelfEntryExists when the book is already there.
+	AddShelfEntry(tenant, shelfID string, e *models.ShelfEntry) (*models.ShelfEntry, error)
+	// UpdateShelfEntry replaces note, progress and the update time of the
+	// entry.
+	UpdateShelfEntry(tenant, shelfID string, e *models.ShelfEntry) error
+	DeleteShelfEntry(tenant, shelfID, bookID string) error
+	// MoveShelfEntry moves the book to position, counted from 1, shifting
+	// the following entries. Positions past the last entry move it last.
+	MoveShelfEntry(tenant, shelfID, bookID string, position int) error
+	// ReorderShelf orders entries of the shelf as bookIDs, it fails with
+	// ErrShelfOrderMismatch unless bookIDs are exactly the books of the
+	// shelf.
+	ReorderShelf(tenant, shelfID string, bookIDs []string) error
+
+	BookReferences
+}
@@ -0,0 +1,562 @@
+package taxonomy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+// MongoDBRepo stores with every category IDs of its ancestors, root first,
+// so descendants of a category are found with a single query. Tags,
+// categories and the publication of a book are kept in one document of
+// book_taxonomy collection. Unlike in PostgreSQL, moves of categories are
+// not serialized.
+type MongoDBRepo struct {
+	categories *mongo.Collection
+	books      *mongo.Collection
+}
+
+const (
+	mongoCategoriesCollectionName = "categories"
+	mongoTaxonomyCollectionName   = "book_taxonomy"
+)
+
+type categoryDocument struct {
+	models.Category `bson:",inline"`
+	Ancestors       []string `bson:"ancestors"`
+}
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		categories: db.Collection(mongoCategoriesCollectionName),
+		books:      db.Collection(mongoTaxonomyCollectionName),
+	}
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.categories.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "parent_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "ancestors", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.books.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "book_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "categories", Value: 1}}},
+	})
+	return err
+}
+
+func (r *MongoDBRepo) GetCategories(tenant string) ([]*models.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.categories.Find(ctx, bson.D{{Key: "tenant", Value: tenant}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	categories := []*models.Category{}
+	if err = cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+func (r *MongoDBRepo) GetCategory(tenant, id string) (*models.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	doc, err := r.category(ctx, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &doc.Category, nil
+}
+
+func (r *MongoDBRepo) AddCategory(c *models.Category) (*models.Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ancestors, err := r.ancestorsUnder(ctx, c.Tenant, c.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := categoryDocument{Category: *c, Ancestors: ancestors}
+	doc.ID = ""
+
+	result, err := r.categories.InsertOne(ctx, &doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("%w (name=%s)", repository.ErrCategoryExists, c.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created := doc.Category
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		created.ID = oid.Hex()
+	}
+	return &created, nil
+}
+
+// UpdateCategory rewrites ancestors of the category and of its descendants
+// when the category moves, keeping the part of the path below it.
+func (r *MongoDBRepo) UpdateCategory(c *models.Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	current, err := r.category(ctx, c.Tenant, c.ID)
+	if err != nil {
+		return err
+	}
+
+	ancestors := current.Ancestors
+	if c.ParentID != current.ParentID {
+		if ancestors, err = r.ancestorsUnder(ctx, c.Tenant, c.ParentID); err != nil {
+			return err
+		}
+		for _, id := range ancestors {
+			if id == c.ID {
+				return fmt.Errorf("%w (id=%s, parent_id=%s)", repository.ErrCategoryCycle, c.ID, c.ParentID)
+			}
+		}
+	}
+
+	filter, _ := categoryFilter(c.Tenant, c.ID)
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "name", Value: c.Name},
+		{Key: "parent_id", Value: c.ParentID},
+		{Key: "ancestors", Value: ancestors},
+	}}}
+
+	result, err := r.categories.UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w (name=%s)", repository.ErrCategoryExists, c.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, c.ID)
+	}
+	if c.ParentID == current.ParentID {
+		return nil
+	}
+
+	path := append(append([]string{}, ancestors...), c.ID)
+	descendants := bson.D{{Key: "tenant", Value: c.Tenant}, {Key: "ancestors", Value: c.ID}}
+	below := bson.D{{Key: "$slice", Value: bson.A{
+		"$ancestors",
+		bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$indexOfArray", Value: bson.A{"$ancestors", c.ID}}}, 1}}},
+		bson.D{{Key: "$size", Value: "$ancestors"}},
+	}}}
+	move := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "ancestors", Value: bson.D{{Key: "$concatArrays", Value: bson.A{path, below}}}},
+		}}},
+	}
+
+	_, err = r.categories.UpdateMany(ctx, descendants, move)
+	return err
+}
+
+func (r *MongoDBRepo) DeleteCategory(tenant, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := categoryFilter(tenant, id)
+	if err != nil {
+		return err
+	}
+
+	children, err := r.categories.CountDocuments(ctx, bson.D{{Key: "tenant", Value: tenant}, {Key: "parent_id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if children > 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCategoryHasChildren, id)
+	}
+
+	result, err := r.categories.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+	}
+
+	_, err = r.books.UpdateMany(ctx,
+		bson.D{{Key: "tenant", Value: tenant}, {Key: "categories", Value: id}},
+		bson.D{{Key: "$pull", Value: bson.D{{Key: "categories", Value: id}}}},
+	)
+	return err
+}
+
+func (r *MongoDBRepo) GetBookTaxonomies(tenant string, bookIDs []string) (map[string]*models.BookTaxonomy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	taxonomies := make(map[string]*models.BookTaxonomy)
+	if len(bookIDs) == 0 {
+		return taxonomies, nil
+	}
+
+	filter := bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bson.D{{Key: "$in", Value: bookIDs}}}}
+	cursor, err := r.books.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*models.BookTaxonomy
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	for _, t := range found {
+		if len(t.Tags) == 0 && len(t.Categories) == 0 && t.Language == "" && t.PublishedYear == 0 {
+			continue
+		}
+		if t.Tags == nil {
+			t.Tags = []string{}
+		}
+		if t.Categories == nil {
+			t.Categories = []string{}
+		}
+		taxonomies[t.BookID] = t
+	}
+	return taxonomies, nil
+}
+
+func (r *MongoDBRepo) SetBookTags(tenant, bookID string, tags []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return r.setBookField(ctx, tenant, bookID, "tags", uniqueSorted(tags))
+}
+
+func (r *MongoDBRepo) SetBookCategories(tenant, bookID string, categoryIDs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ids := uniqueSorted(categoryIDs)
+	objIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+		}
+		objIDs = append(objIDs, objID)
+	}
+
+	if len(objIDs) > 0 {
+		filter := bson.D{{Key: "tenant", Value: tenant}, {Key: "_id", Value: bson.D{{Key: "$in", Value: objIDs}}}}
+		found, err := r.categoryIDs(ctx, filter)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]bool, len(found))
+		for _, id := range found {
+			existing[id] = true
+		}
+		for _, id := range ids {
+			if !existing[id] {
+				return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+			}
+		}
+	}
+
+	return r.setBookField(ctx, tenant, bookID, "categories", ids)
+}
+
+func (r *MongoDBRepo) SetBookPublication(tenant, bookID, language string, year int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	set, unset := bson.D{}, bson.D{}
+	if language != "" {
+		set = append(set, bson.E{Key: "language", Value: language})
+	} else {
+		unset = append(unset, bson.E{Key: "language", Value: ""})
+	}
+	if year != 0 {
+		set = append(set, bson.E{Key: "published_year", Value: year})
+	} else {
+		unset = append(unset, bson.E{Key: "published_year", Value: ""})
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	filter := bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bookID}}
+	_, err := r.books.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *MongoDBRepo) FindBooks(tenant string, filter repository.TaxonomyFilter) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query, err := r.matchingBooks(ctx, tenant, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := r.books.Distinct(ctx, "book_id", query)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (r *MongoDBRepo) GetTagCounts(tenant string, filter repository.TaxonomyFilter) ([]*models.TagCount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query, err := r.matchingBooks(ctx, tenant, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$unwind", Value: "$tags"}},
+		countBy("$tags"),
+		byCount,
+	}
+	cursor, err := r.books.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []*models.TagCount{}
+	if err = cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// GetFacets counts all facets in one aggregation, each of them in its own
+// pipeline of $facet stage.
+func (r *MongoDBRepo) GetFacets(tenant string, filter repository.TaxonomyFilter) (*models.TaxonomyFacets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query, err := r.matchingBooks(ctx, tenant, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	decade := bson.D{{Key: "$concat", Value: bson.A{
+		bson.D{{Key: "$toString", Value: bson.D{{Key: "$subtract", Value: bson.A{
+			"$published_year",
+			bson.D{{Key: "$mod", Value: bson.A{"$published_year", 10}}},
+		}}}}},
+		"s",
+	}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$facet", Value: bson.D{
+			{Key: "tags", Value: bson.A{
+				bson.D{{Key: "$unwind", Value: "$tags"}},
+				countBy("$tags"),
+				byCount,
+			}},
+			{Key: "languages", Value: bson.A{
+				bson.D{{Key: "$match", Value: bson.D{{Key: "language", Value: bson.D{{Key: "$exists", Value: true}}}}}},
+				countBy("$language"),
+				byCount,
+			}},
+			{Key: "decades", Value: bson.A{
+				bson.D{{Key: "$match", Value: bson.D{{Key: "published_year", Value: bson.D{{Key: "$exists", Value: true}}}}}},
+				countBy(decade),
+				byCount,
+			}},
+		}}},
+	}
+	cursor, err := r.books.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*models.TaxonomyFacets
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	facets := &models.TaxonomyFacets{}
+	if len(found) > 0 {
+		facets = found[0]
+	}
+	for _, counts := range []*[]*models.FacetCount{&facets.Tags, &facets.Languages, &facets.Decades} {
+		if *counts == nil {
+			*counts = []*models.FacetCount{}
+		}
+	}
+	return facets, nil
+}
+
+func (r *MongoDBRepo) DeleteBookReferences(tenant, bookID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "tenant", Value: tenant}}
+	if bookID != "" {
+		filter = append(filter, bson.E{Key: "book_id", Value: bookID})
+	}
+
+	_, err := r.books.DeleteMany(ctx, filter)
+	return err
+}
+
+func (r *MongoDBRepo) category(ctx context.Context, tenant, id string) (*categoryDocument, error) {
+	filter, err := categoryFilter(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc *categoryDocument
+	err = r.categories.FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// ancestorsUnder returns ancestors of a category placed under parentID.
+func (r *MongoDBRepo) ancestorsUnder(ctx context.Context, tenant, parentID string) ([]string, error) {
+	if parentID == "" {
+		return []string{}, nil
+	}
+
+	parent, err := r.category(ctx, tenant, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]string{}, parent.Ancestors...), parent.ID), nil
+}
+
+func (r *MongoDBRepo) categoryIDs(ctx context.Context, filter bson.D) ([]string, error) {
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.categories.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*models.Category
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(found))
+	for _, c := range found {
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
+}
+
+// matchingBooks returns a query of book_taxonomy matching filter, a
+// category is replaced by itself and all of its descendants.
+func (r *MongoDBRepo) matchingBooks(ctx context.Context, tenant string, filter repository.TaxonomyFilter) (bson.D, error) {
+	query := bson.D{{Key: "tenant", Value: tenant}}
+	if filter.Tag != "" {
+		query = append(query, bson.E{Key: "tags", Value: filter.Tag})
+	}
+	if filter.CategoryID != "" {
+		ids := []string{}
+		if objID, err := primitive.ObjectIDFromHex(filter.CategoryID); err == nil {
+			tree := bson.D{
+				{Key: "tenant", Value: tenant},
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "_id", Value: objID}},
+					bson.D{{Key: "ancestors", Value: filter.CategoryID}},
+				}},
+			}
+			if ids, err = r.categoryIDs(ctx, tree); err != nil {
+				return nil, err
+			}
+		}
+		query = append(query, bson.E{Key: "categories", Value: bson.D{{Key: "$in", Value: ids}}})
+	}
+	return query, nil
+}
+
+func (r *MongoDBRepo) setBookField(ctx context.Context, tenant, bookID, field string, values []string) error {
+	filter := bson.D{{Key: "tenant", Value: tenant}, {Key: "book_id", Value: bookID}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: values}}}}
+
+	_, err := r.books.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// byCount sorts groups of countBy, the largest ones first.
+var byCount = bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}}
+
+// countBy groups documents by the value of expression, counting them.
+func countBy(expression any) bson.D {
+	return bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: expression}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}}
+}
+
+func categoryFilter(tenant, id string) (bson.D, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+	}
+
+	return bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: tenant}}, nil
+}
+
+func uniqueSorted(values []string) []string {
+	unique := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !unique[v] {
+			unique[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,152 @@
+package taxonomy
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func Test_MongoDB_UpdateCategory(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	rootID, categoryID, childID, otherID := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	category := mtest.CreateCursorResponse(0, "db.categories", mtest.FirstBatch, bson.D{
+		{Key: "_id", Value: categoryID},
+		{Key: "tenant", Value: "acme"},
+		{Key: "parent_id", Value: rootID.Hex()},
+		{Key: "name", Value: "Fantasy"},
+		{Key: "ancestors", Value: bson.A{rootID.Hex()}},
+	})
+
+	mt.Run("Should not move category under its descendant", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{categories: mt.Coll, books: mt.Coll}
+
+		mt.AddMockResponses(category, mtest.CreateCursorResponse(0, "db.categories", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: childID},
+			{Key: "tenant", Value: "acme"},
+			{Key: "parent_id", Value: categoryID.Hex()},
+			{Key: "name", Value: "Epic"},
+			{Key: "ancestors", Value: bson.A{rootID.Hex(), categoryID.Hex()}},
+		}))
+
+		// when
+		err := ts.UpdateCategory(&models.Category{ID: categoryID.Hex(), Tenant: "acme", ParentID: childID.Hex(), Name: "Fantasy"})
+
+		// then
+		if !errors.Is(err, repository.ErrCategoryCycle) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrCategoryCycle, err)
+		}
+	})
+
+	mt.Run("Should move descendants together with the category", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{categories: mt.Coll, books: mt.Coll}
+
+		mt.AddMockResponses(
+			category,
+			mtest.CreateCursorResponse(0, "db.categories", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: otherID},
+				{Key: "tenant", Value: "acme"},
+				{Key: "parent_id", Value: ""},
+				{Key: "name", Value: "Fiction"},
+				{Key: "ancestors", Value: bson.A{}},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}),
+		)
+
+		// when
+		err := ts.UpdateCategory(&models.Category{ID: categoryID.Hex(), Tenant: "acme", ParentID: otherID.Hex(), Name: "Fantasy"})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while updating category:", err)
+		}
+
+		_ = mt.GetStartedEvent()
+		_ = mt.GetStartedEvent()
+		_ = mt.GetStartedEvent()
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if ancestor := update.Lookup("q", "ancestors").StringValue(); ancestor != categoryID.Hex() {
+			t.Fatalf("Descendants of %s should be updated, has filter: %s\n", categoryID.Hex(), update.Lookup("q"))
+		}
+		set := update.Lookup("u").Array().Index(0).Value().Document().Lookup("$set").Document()
+		path := set.Lookup("ancestors", "$concatArrays").Array().Index(0).Value().Array()
+		if values, _ := path.Values(); len(values) != 2 || values[0].StringValue() != otherID.Hex() || values[1].StringValue() != categoryID.Hex() {
+			t.Fatalf("Descendants should be moved under [%s %s], are moved under: %s\n", otherID.Hex(), categoryID.Hex(), path)
+		}
+	})
+}
+
+func Test_MongoDB_DeleteCategory_ShouldKeepCategoryWithChildren(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should return error of category with children", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{categories: mt.Coll, books: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.categories", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}))
+
+		// when
+		err := ts.DeleteCategory("acme", primitive.NewObjectID().Hex())
+
+		// then
+		if !errors.Is(err, repository.ErrCategoryHasChildren) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrCategoryHasChildren, err)
+		}
+
+		_ = mt.GetStartedEvent()
+		if event := mt.GetStartedEvent(); event != nil {
+			t.Fatalf("Category should not be deleted, sent: %s\n", event.Command)
+		}
+	})
+}
+
+func Test_MongoDB_GetFacets_ShouldDecodeFacetCounts(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should decode counts of every facet", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{categories: mt.Coll, books: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.book_taxonomy", mtest.FirstBatch, bson.D{
+			{Key: "tags", Value: bson.A{
+				bson.D{{Key: "_id", Value: "classic"}, {Key: "count", Value: 2}},
+				bson.D{{Key: "_id", Value: "dystopia"}, {Key: "count", Value: 1}},
+			}},
+			{Key: "languages", Value: bson.A{}},
+			{Key: "decades", Value: bson.A{bson.D{{Key: "_id", Value: "1940s"}, {Key: "count", Value: 1}}}},
+		}))
+
+		// when
+		facets, err := ts.GetFacets("acme", repository.TaxonomyFilter{})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while counting facets:", err)
+		}
+
+		if len(facets.Tags) != 2 || *facets.Tags[0] != (models.FacetCount{Value: "classic", Count: 2}) {
+			t.Fatalf("Tags are different than expected: %+v\n", facets.Tags)
+		}
+		if facets.Languages == nil || len(facets.Languages) != 0 || len(facets.Decades) != 1 || facets.Decades[0].Value != "1940s" {
+			t.Fatalf("Languages and decades are different than expected: %+v %+v\n", facets.Languages, facets.Decades)
+		}
+
+		pipeline := mt.GetStartedEvent().Command.Lookup("pipeline").Array()
+		if tenant := pipeline.Index(0).Value().Document().Lookup("$match", "tenant").StringValue(); tenant != "acme" {
+			t.Fatalf("Facets should be counted for tenant acme, are counted for: %s\n", tenant)
+		}
+	})
+}
@@ -0,0 +1,617 @@
+package taxonomy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PostgreSQLRepo keeps the category tree as an adjacency list, descendants
+// of a category are found with a recursive query. Moves of categories of a
+// tenant are serialized with an advisory lock, so concurrent moves cannot
+// form a cycle.
+type PostgreSQLRepo struct {
+	DB *sql.DB
+}
+
+const (
+	postgresDBTimeout             = time.Second * 3
+	postgresUniqueViolation       = "23505"
+	postgresForeignKeyViolation   = "23503"
+	postgresCategoryLockNamespace = "categories"
+)
+
+const categoryColumns = `id, tenant, parent_id, name, created_at`
+
+// categoryTree selects IDs of a category and of its descendants, the verb
+// is replaced by the number of the parameter holding the category.
+const categoryTree = `
+	WITH RECURSIVE tree AS (
+		SELECT id FROM categories WHERE tenant = $1 AND id = $%d::bigint
+		UNION ALL
+		SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+	)
+	SELECT id FROM tree`
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{DB: db}
+}
+
+func (r *PostgreSQLRepo) GetCategories(tenant string) ([]*models.Category, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `SELECT ` + categoryColumns + ` FROM categories WHERE tenant = $1 ORDER BY name, id;`
+
+	rows, err := r.DB.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*models.Category{}
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+
+	return categories, rows.Err()
+}
+
+func (r *PostgreSQLRepo) GetCategory(tenant, id string) (*models.Category, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `SELECT ` + categoryColumns + ` FROM categories WHERE tenant = $1 AND id = $2::bigint;`
+
+	categoryID, err := repository.ParseID(id, repository.ErrCategoryNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := scanCategory(r.DB.QueryRowContext(ctx, query, tenant, categoryID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+	}
+	return c, err
+}
+
+func (r *PostgreSQLRepo) AddCategory(c *models.Category) (*models.Category, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	var row *sql.Row
+	if c.ParentID == "" {
+		query := `INSERT INTO categories (tenant, name, created_at) VALUES ($1, $2, $3) RETURNING id;`
+		row = r.DB.QueryRowContext(ctx, query, c.Tenant, c.Name, c.CreatedAt)
+	} else {
+		query := `
+			INSERT INTO categories (tenant, parent_id, name, created_at)
+			SELECT $1, id, $3, $4
+			FROM categories
+			WHERE tenant = $1 AND id = $2::bigint
+			RETURNING id;
+		`
+		parentID, err := repository.ParseID(c.ParentID, repository.ErrCategoryNotFound)
+		if err != nil {
+			return nil, err
+		}
+		row = r.DB.QueryRowContext(ctx, query, c.Tenant, parentID, c.Name, c.CreatedAt)
+	}
+
+	var newId int
+	err := row.Scan(&newId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, c.ParentID)
+	}
+	if isViolation(err, postgresUniqueViolation) {
+		return nil, fmt.Errorf("%w (name=%s)", repository.ErrCategoryExists, c.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created := *c
+	created.ID = fmt.Sprintf("%d", newId)
+	return &created, nil
+}
+
+// UpdateCategory walks up from the new parent to the root, the category
+// must not be among the visited ones.
+func (r *PostgreSQLRepo) UpdateCategory(c *models.Category) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	categoryID, err := repository.ParseID(c.ID, repository.ErrCategoryNotFound)
+	if err != nil {
+		return err
+	}
+
+	var parentID sql.NullInt64
+	if c.ParentID != "" {
+		id, err := repository.ParseID(c.ParentID, repository.ErrCategoryNotFound)
+		if err != nil {
+			return err
+		}
+		parentID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	ancestorsQuery := `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM categories WHERE tenant = $1 AND id = $2
+			UNION ALL
+			SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT COUNT(*), COALESCE(bool_or(id = $3::bigint), false) FROM ancestors;
+	`
+	updateQuery := `UPDATE categories SET name = $3, parent_id = $4 WHERE tenant = $1 AND id = $2::bigint;`
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2));`, postgresCategoryLockNamespace, c.Tenant)
+		if err != nil {
+			return err
+		}
+
+		if parentID.Valid {
+			var ancestors int
+			var cycle bool
+			if err = tx.QueryRowContext(ctx, ancestorsQuery, c.Tenant, parentID.Int64, categoryID).Scan(&ancestors, &cycle); err != nil {
+				return err
+			}
+			if ancestors == 0 {
+				return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, c.ParentID)
+			}
+			if cycle {
+				return fmt.Errorf("%w (id=%s, parent_id=%s)", repository.ErrCategoryCycle, c.ID, c.ParentID)
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, updateQuery, c.Tenant, categoryID, c.Name, parentID)
+		if isViolation(err, postgresUniqueViolation) {
+			return fmt.Errorf("%w (name=%s)", repository.ErrCategoryExists, c.Name)
+		}
+		if err != nil {
+			return err
+		}
+		return notFoundUnlessAffected(res, c.ID)
+	})
+}
+
+// DeleteCategory relies on the foreign key of subcategories, which keeps
+// categories with children, even those added meanwhile.
+func (r *PostgreSQLRepo) DeleteCategory(tenant, id string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	categoryID, err := repository.ParseID(id, repository.ErrCategoryNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM categories WHERE tenant = $1 AND id = $2::bigint;`, tenant, categoryID)
+	if isViolation(err, postgresForeignKeyViolation) {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCategoryHasChildren, id)
+	}
+	if err != nil {
+		return err
+	}
+	return notFoundUnlessAffected(res, id)
+}
+
+func (r *PostgreSQLRepo) GetBookTaxonomies(tenant string, bookIDs []string) (map[string]*models.BookTaxonomy, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	taxonomies := make(map[string]*models.BookTaxonomy)
+	if len(bookIDs) == 0 {
+		return taxonomies, nil
+	}
+
+	taxonomy := func(bookID string) *models.BookTaxonomy {
+		t, ok := taxonomies[bookID]
+		if !ok {
+			t = &models.BookTaxonomy{Tenant: tenant, BookID: bookID, Tags: []string{}, Categories: []string{}}
+			taxonomies[bookID] = t
+		}
+		return t
+	}
+
+	ids := textArray(bookIDs)
+	err := queryPairs(ctx, r.DB, func(bookID, tag string) {
+		t := taxonomy(bookID)
+		t.Tags = append(t.Tags, tag)
+	}, `SELECT book_id, tag FROM book_tags WHERE tenant = $1 AND book_id = ANY($2::text[]) ORDER BY tag;`, tenant, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryPairs(ctx, r.DB, func(bookID, categoryID string) {
+		t := taxonomy(bookID)
+		t.Categories = append(t.Categories, categoryID)
+	}, `SELECT book_id, category_id::text FROM book_categories WHERE tenant = $1 AND book_id = ANY($2::text[]) ORDER BY category_id;`, tenant, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT book_id, COALESCE(language, ''), COALESCE(published_year, 0)
+		FROM book_publications
+		WHERE tenant = $1 AND book_id = ANY($2::text[]);
+	`
+	rows, err := r.DB.QueryContext(ctx, query, tenant, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookID, language string
+		var year int
+		if err = rows.Scan(&bookID, &language, &year); err != nil {
+			return nil, err
+		}
+		if language != "" || year != 0 {
+			t := taxonomy(bookID)
+			t.Language, t.PublishedYear = language, year
+		}
+	}
+
+	return taxonomies, rows.Err()
+}
+
+func (r *PostgreSQLRepo) SetBookTags(tenant, bookID string, tags []string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	return r.inBookTx(ctx, tenant, bookID, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM book_tags WHERE tenant = $1 AND book_id = $2;`, tenant, bookID)
+		if err != nil || len(tags) == 0 {
+			return err
+		}
+
+		query := `INSERT INTO book_tags (tenant, book_id, tag) SELECT DISTINCT $1, $2, unnest($3::text[]);`
+		_, err = tx.ExecContext(ctx, query, tenant, bookID, textArray(tags))
+		return err
+	})
+}
+
+func (r *PostgreSQLRepo) SetBookCategories(tenant, bookID string, categoryIDs []string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	parsedIDs := make([]int64, len(categoryIDs))
+	for i, id := range categoryIDs {
+		parsedID, err := repository.ParseID(id, repository.ErrCategoryNotFound)
+		if err != nil {
+			return err
+		}
+		parsedIDs[i] = parsedID
+	}
+	ids := bigintArray(parsedIDs)
+
+	return r.inBookTx(ctx, tenant, bookID, func(tx *sql.Tx) error {
+		found := make(map[int64]bool)
+		rows, err := tx.QueryContext(ctx, `SELECT id FROM categories WHERE tenant = $1 AND id = ANY($2::bigint[]);`, tenant, ids)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				return err
+			}
+			found[id] = true
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		for i, id := range parsedIDs {
+			if !found[id] {
+				return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, categoryIDs[i])
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM book_categories WHERE tenant = $1 AND book_id = $2;`, tenant, bookID)
+		if err != nil || len(categoryIDs) == 0 {
+			return err
+		}
+
+		query := `
+			INSERT INTO book_categories (tenant, book_id, category_id)
+			SELECT $1, $2, id
+			FROM categories
+			WHERE tenant = $1 AND id = ANY($3::bigint[]);
+		`
+		_, err = tx.ExecContext(ctx, query, tenant, bookID, ids)
+		return err
+	})
+}
+
+func (r *PostgreSQLRepo) SetBookPublication(tenant, bookID, language string, year int) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO book_publications (tenant, book_id, language, published_year)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, 0))
+		ON CONFLICT (tenant, book_id) DO UPDATE SET language = EXCLUDED.language, published_year = EXCLUDED.published_year;
+	`
+	if language == "" && year == 0 {
+		query = `DELETE FROM book_publications WHERE tenant = $1 AND book_id = $2;`
+		_, err := r.DB.ExecContext(ctx, query, tenant, bookID)
+		return err
+	}
+
+	_, err := r.DB.ExecContext(ctx, query, tenant, bookID, language, year)
+	return err
+}
+
+func (r *PostgreSQLRepo) FindBooks(tenant string, filter repository.TaxonomyFilter) ([]string, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	matching, args := matchingBooks(tenant, filter)
+	rows, err := r.DB.QueryContext(ctx, `SELECT DISTINCT book_id FROM (`+matching+`) AS matching;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (r *PostgreSQLRepo) GetTagCounts(tenant string, filter repository.TaxonomyFilter) ([]*models.TagCount, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	conditions, args := matchingConditions(tenant, filter)
+	query := `SELECT tag, COUNT(*) FROM book_tags WHERE ` + conditions + ` GROUP BY tag ORDER BY COUNT(*) DESC, tag;`
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []*models.TagCount{}
+	for rows.Next() {
+		var c models.TagCount
+		if err = rows.Scan(&c.Tag, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, &c)
+	}
+
+	return counts, rows.Err()
+}
+
+// GetFacets counts all facets with a single query, every row is labeled
+// with its facet.
+func (r *PostgreSQLRepo) GetFacets(tenant string, filter repository.TaxonomyFilter) (*models.TaxonomyFacets, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	conditions, args := matchingConditions(tenant, filter)
+	query := `
+		SELECT 'tag', tag, COUNT(*) FROM book_tags
+		WHERE ` + conditions + `
+		GROUP BY tag
+		UNION ALL
+		SELECT 'language', language, COUNT(*) FROM book_publications
+		WHERE ` + conditions + ` AND language IS NOT NULL
+		GROUP BY language
+		UNION ALL
+		SELECT 'decade', (published_year / 10 * 10)::text || 's', COUNT(*) FROM book_publications
+		WHERE ` + conditions + ` AND published_year IS NOT NULL
+		GROUP BY published_year / 10 * 10
+		ORDER BY 3 DESC, 2;
+	`
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := &models.TaxonomyFacets{Tags: []*models.FacetCount{}, Languages: []*models.FacetCount{}, Decades: []*models.FacetCount{}}
+	for rows.Next() {
+		var facet string
+		var c models.FacetCount
+		if err = rows.Scan(&facet, &c.Value, &c.Count); err != nil {
+			return nil, err
+		}
+		switch facet {
+		case "tag":
+			facets.Tags = append(facets.Tags, &c)
+		case "language":
+			facets.Languages = append(facets.Languages, &c)
+		case "decade":
+			facets.Decades = append(facets.Decades, &c)
+		}
+	}
+
+	return facets, rows.Err()
+}
+
+func (r *PostgreSQLRepo) DeleteBookReferences(tenant, bookID string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	condition, args := "tenant = $1", []any{tenant}
+	if bookID != "" {
+		condition, args = "tenant = $1 AND book_id = $2", append(args, bookID)
+	}
+
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		for _, table := range []string{"book_tags", "book_categories", "book_publications"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+condition+`;`, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *PostgreSQLRepo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// inBookTx runs fn holding an advisory lock of the book, so replacements of
+// its tags or categories do not interleave.
+func (r *PostgreSQLRepo) inBookTx(ctx context.Context, tenant, bookID string, fn func(tx *sql.Tx) error) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2));`, tenant, bookID); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// matchingBooks returns a query selecting IDs of books matching filter,
+// the tenant is its first parameter.
+func matchingBooks(tenant string, filter repository.TaxonomyFilter) (string, []any) {
+	var queries []string
+	args := []any{tenant}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		queries = append(queries, fmt.Sprintf(`SELECT book_id FROM book_tags WHERE tenant = $1 AND tag = $%d`, len(args)))
+	}
+	if filter.CategoryID != "" {
+		// IDs which are not numbers match no category, as NULL does
+		var categoryID any
+		if id, err := repository.ParseID(filter.CategoryID, repository.ErrCategoryNotFound); err == nil {
+			categoryID = id
+		}
+		args = append(args, categoryID)
+		queries = append(queries, `SELECT book_id FROM book_categories WHERE tenant = $1 AND category_id IN (`+
+			fmt.Sprintf(categoryTree, len(args))+`)`)
+	}
+	return strings.Join(queries, " INTERSECT "), args
+}
+
+// matchingConditions returns conditions of rows of books matching filter,
+// every book of the tenant matches a zero one.
+func matchingConditions(tenant string, filter repository.TaxonomyFilter) (string, []any) {
+	if filter.IsZero() {
+		return "tenant = $1", []any{tenant}
+	}
+
+	matching, args := matchingBooks(tenant, filter)
+	return "tenant = $1 AND book_id IN (" + matching + ")", args
+}
+
+func queryPairs(ctx context.Context, db *sql.DB, fn func(a, b string), query string, args ...any) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a, b string
+		if err = rows.Scan(&a, &b); err != nil {
+			return err
+		}
+		fn(a, b)
+	}
+
+	return rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCategory(row rowScanner) (*models.Category, error) {
+	var c models.Category
+	var parentID sql.NullString
+	if err := row.Scan(&c.ID, &c.Tenant, &parentID, &c.Name, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	c.ParentID = parentID.String
+	return &c, nil
+}
+
+// textArray formats values as a PostgreSQL array literal, quoting every
+// element. Duplicates are skipped.
+func textArray(values []string) string {
+	unique := make(map[string]bool, len(values))
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		if unique[v] {
+			continue
+		}
+		unique[v] = true
+		v = strings.ReplaceAll(v, `\`, `\\`)
+		quoted = append(quoted, `"`+strings.ReplaceAll(v, `"`, `\"`)+`"`)
+	}
+	sort.Strings(quoted)
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+// bigintArray formats ids as a PostgreSQL array literal, like textArray.
+func bigintArray(ids []int64) string {
+	unique := make(map[int64]bool, len(ids))
+	sorted := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !unique[id] {
+			unique[id] = true
+			sorted = append(sorted, id)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	formatted := make([]string, len(sorted))
+	for i, id := range sorted {
+		formatted[i] = strconv.FormatInt(id, 10)
+	}
+	return "{" + strings.Join(formatted, ",") + "}"
+}
+
+func isViolation(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+func notFoundUnlessAffected(res sql.Result, id string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrCategoryNotFound, id)
+	}
+	return nil
+}
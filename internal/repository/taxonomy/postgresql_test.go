@@ -0,0 +1,196 @@
+package taxonomy
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"github.com/jackc/pgx/v5/pgconn"
+	"regexp"
+	"testing"
+)
+
+const (
+	lockCategoriesQuery = `SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2));`
+	ancestorsQuery      = `WITH RECURSIVE ancestors AS ( SELECT id, parent_id FROM categories WHERE tenant = $1 AND id = $2 UNION ALL SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id ) SELECT COUNT(*), COALESCE(bool_or(id = $3::bigint), false) FROM ancestors;`
+)
+
+func Test_Postgresql_UpdateCategory_ShouldNotMoveCategoryUnderItsDescendant(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(lockCategoriesQuery)).
+		WithArgs(postgresCategoryLockNamespace, "acme").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(ancestorsQuery)).
+		WithArgs("acme", int64(3), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "bool_or"}).AddRow(3, true))
+	mock.ExpectRollback()
+
+	// when
+	err := testServer.UpdateCategory(&models.Category{ID: "1", Tenant: "acme", ParentID: "3", Name: "Fiction"})
+
+	// then
+	if !errors.Is(err, repository.ErrCategoryCycle) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrCategoryCycle, err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_DeleteCategory_ShouldKeepCategoryWithChildren(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM categories WHERE tenant = $1 AND id = $2::bigint;`)).
+		WithArgs("acme", int64(1)).
+		WillReturnError(&pgconn.PgError{Code: postgresForeignKeyViolation})
+
+	// when
+	err := testServer.DeleteCategory("acme", "1")
+
+	// then
+	if !errors.Is(err, repository.ErrCategoryHasChildren) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrCategoryHasChildren, err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_SetBookCategories_ShouldFailWhenCategoryIsMissing(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(lockCategoriesQuery)).
+		WithArgs("acme", "42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM categories WHERE tenant = $1 AND id = ANY($2::bigint[]);`)).
+		WithArgs("acme", `{1,2}`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	// when
+	err := testServer.SetBookCategories("acme", "42", []string{"2", "1"})
+
+	// then
+	if !errors.Is(err, repository.ErrCategoryNotFound) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrCategoryNotFound, err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_ShouldNotFindCategoriesWithNonNumericIDs(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// when
+	_, getErr := testServer.GetCategory("acme", "abc")
+	setErr := testServer.SetBookCategories("acme", "42", []string{"1", "abc"})
+
+	// then
+	if !errors.Is(getErr, repository.ErrCategoryNotFound) || !errors.Is(setErr, repository.ErrCategoryNotFound) {
+		t.Fatalf("Expected %v, has: %v and %v\n", repository.ErrCategoryNotFound, getErr, setErr)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_FindBooks_ShouldMatchTagAndCategoryTree(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	query := `SELECT DISTINCT book_id FROM (` +
+		`SELECT book_id FROM book_tags WHERE tenant = $1 AND tag = $2 INTERSECT ` +
+		`SELECT book_id FROM book_categories WHERE tenant = $1 AND category_id IN ( ` +
+		`WITH RECURSIVE tree AS ( SELECT id FROM categories WHERE tenant = $1 AND id = $3::bigint ` +
+		`UNION ALL SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id ) SELECT id FROM tree)` +
+		`) AS matching;`
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs("acme", "classic", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id"}).AddRow("1").AddRow("3"))
+
+	// when
+	ids, err := testServer.FindBooks("acme", repository.TaxonomyFilter{Tag: "classic", CategoryID: "7"})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "3" {
+		t.Fatalf("Found books are different than expected: %v\n", ids)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_GetFacets_ShouldSplitCountsByFacet(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	query := `SELECT 'tag', tag, COUNT(*) FROM book_tags WHERE tenant = $1 GROUP BY tag UNION ALL ` +
+		`SELECT 'language', language, COUNT(*) FROM book_publications WHERE tenant = $1 AND language IS NOT NULL GROUP BY language UNION ALL ` +
+		`SELECT 'decade', (published_year / 10 * 10)::text || 's', COUNT(*) FROM book_publications WHERE tenant = $1 AND published_year IS NOT NULL ` +
+		`GROUP BY published_year / 10 * 10 ORDER BY 3 DESC, 2;`
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"facet", "value", "count"}).
+			AddRow("language", "en", 2).
+			AddRow("tag", "classic", 2).
+			AddRow("decade", "1940s", 1).
+			AddRow("tag", "dystopia", 1))
+
+	// when
+	facets, err := testServer.GetFacets("acme", repository.TaxonomyFilter{})
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(facets.Tags) != 2 || *facets.Tags[0] != (models.FacetCount{Value: "classic", Count: 2}) || facets.Tags[1].Value != "dystopia" {
+		t.Fatalf("Tags are different than expected: %+v\n", facets.Tags)
+	}
+	if len(facets.Languages) != 1 || len(facets.Decades) != 1 || facets.Decades[0].Value != "1940s" {
+		t.Fatalf("Languages and decades are different than expected: %+v %+v\n", facets.Languages, facets.Decades)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// utils
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+)
+
+var (
+	ErrCategoryNotFound    = errors.New("category not found")
+	ErrCategoryExists      = errors.New("category with the name already exists under the parent")
+	ErrCategoryHasChildren = errors.New("category has subcategories")
+	ErrCategoryCycle       = errors.New("category cannot be moved under itself")
+)
+
+// TaxonomyFilter selects books by their tags and categories, zero fields
+// match every book.
+type TaxonomyFilter struct {
+	Tag string
+	// CategoryID matches books of the category and of its descendants.
+	CategoryID string
+}
+
+// IsZero reports whether the filter matches every book.
+func (f TaxonomyFilter) IsZero() bool {
+	return f.Tag == "" && f.CategoryID == ""
+}
+
+// TaxonomyRepo keeps the category tree and tags and categories of books.
+type TaxonomyRepo interface {
+	// GetCategories returns all categories of the tenant ordered by name.
+	GetCategories(tenant string) ([]*models.Category, error)
+	GetCategory(tenant, id string) (*models.Category, error)
+	// AddCategory fails with ErrCategoryNotFound when the parent does not
+	// exist and with ErrCategoryExists when the parent has a category of
+	// the same name.
+	AddCategory(c *models.Category) (*models.Category, error)
+	// UpdateCategory renames the category and moves it under ParentID
+	// together with its descendants, it fails with ErrCategoryCycle when
+	// the parent is the category or one of its descendants.
+	UpdateCategory(c *models.Category) error
+	// DeleteCategory removes the category from the tree and from books, it
+	// fails with ErrCategoryHasChildren unless the category is a leaf.
+	DeleteCategory(tenant, id string) error
+
+	// GetBookTaxonomies returns tags and categories of books among bookIDs
+	// by their IDs, books without any are skipped.
+	GetBookTaxonomies(tenant string, bookIDs []string) (map[string]*models.BookTaxonomy, error)
+	// SetBookTags replaces tags of the book.
+	SetBookTags(tenant, bookID string, tags []string) error
+	// SetBookCategories replaces categories of the book, it fails with
+	// ErrCategoryNotFound when one of them does not exist.
+	SetBookCategories(tenant, bookID string, categoryIDs []string) error
+	// SetBookPublication replaces the language and the publication year of
+	// the book, empty language and zero year are unknown.
+	SetBookPublication(tenant, bookID, language string, year int) error
+	// FindBooks returns IDs of books matching filter which is not zero, in
+	// no particular order.
+	FindBooks(tenant string, filter TaxonomyFilter) ([]string, error)
+	// GetTagCounts counts books matching filter by their tags, the most
+	// used tags first.
+	GetTagCounts(tenant string, filter TaxonomyFilter) ([]*models.TagCount, error)
+	// GetFacets counts books matching filter by their tags, languages and
+	// publication decades, books of unknown language or year are not
+	// counted in those facets.
+	GetFacets(tenant string, filter TaxonomyFilter) (*models.TaxonomyFacets, error)
+
+	BookReferences
+}
+
+// BookReferences is implemented by repositories keeping records which
+// refer to books, they are removed when the books are deleted.
+type BookReferences interface {
+	// DeleteBookReferences removes records referring to the book, or to
+	// every book of the tenant when bookID is empty.
+	DeleteBookReferences(tenant, bookID string) error
+}
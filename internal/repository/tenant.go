@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultTenant owns all data when the application runs without tenancy.
+const DefaultTenant = "default"
+
+type Tenancy string
+
+const (
+	// TenancyShared keeps books of all tenants in one table/collection,
+	// every row is tagged with its tenant.
+	TenancyShared Tenancy = "shared"
+	// TenancyIsolated keeps books of every tenant in a separate
+	// Postgres schema or Mongo collection.
+	TenancyIsolated Tenancy = "isolated"
+)
+
+func ParseTenancy(s string) (Tenancy, error) {
+	switch t := Tenancy(s); t {
+	case TenancyShared, TenancyIsolated:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown tenancy strategy %q, available: [shared, isolated]", s)
+}
+
+// TenantBookRepos is implemented by repositories able to scope all their
+// operations to a single tenant.
+type TenantBookRepos interface {
+	ForTenant(tenant string) (BookRepo, error)
+}
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidateTenant makes sure tenant IDs are safe to use in schema and
+// collection names.
+func ValidateTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant %q", tenant)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type MongoDBRepo struct {
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+}
+
+const (
+	mongoWebhooksCollectionName   = "webhooks"
+	mongoDeliveriesCollectionName = "webhook_deliveries"
+)
+
+func NewMongoDBRepo(db *mongo.Database) *MongoDBRepo {
+	return &MongoDBRepo{
+		webhooks:   db.Collection(mongoWebhooksCollectionName),
+		deliveries: db.Collection(mongoDeliveriesCollectionName),
+	}
+}
+
+func (r *MongoDBRepo) CreateIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.webhooks.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "tenant", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(bson.D{{Key: "dead_letter", Value: true}}),
+		},
+	})
+	return err
+}
+
+func (r *MongoDBRepo) GetWebhooks(tenant string) ([]*models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.webhooks.Find(ctx, bson.D{{Key: "tenant", Value: tenant}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := []*models.Webhook{}
+	if err = cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *MongoDBRepo) GetWebhook(tenant, id string) (*models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := webhookFilter(tenant, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var w *models.Webhook
+	err = r.webhooks.FindOne(ctx, filter).Decode(&w)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (r *MongoDBRepo) AddWebhook(w *models.Webhook) (*models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	created := *w
+	created.ID = ""
+	created.CreatedAt = time.Now().UTC()
+
+	result, err := r.webhooks.InsertOne(ctx, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		created.ID = oid.Hex()
+	}
+
+	return &created, nil
+}
+
+func (r *MongoDBRepo) UpdateWebhook(w *models.Webhook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := webhookFilter(w.Tenant, w.ID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "url", Value: w.URL},
+		{Key: "events", Value: w.Events},
+		{Key: "active", Value: w.Active},
+	}}}
+
+	result, err := r.webhooks.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, w.ID)
+	}
+
+	return nil
+}
+
+func (r *MongoDBRepo) DeleteWebhook(tenant, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := webhookFilter(tenant, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.webhooks.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, id)
+	}
+
+	_, err = r.deliveries.DeleteMany(ctx, bson.D{{Key: "webhook_id", Value: id}})
+	return err
+}
+
+func (r *MongoDBRepo) AddWebhookDelivery(d *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	created := *d
+	created.ID = ""
+	created.CreatedAt = time.Now().UTC()
+
+	result, err := r.deliveries.InsertOne(ctx, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		created.ID = oid.Hex()
+	}
+
+	return &created, nil
+}
+
+func (r *MongoDBRepo) GetWebhookDeliveries(webhookID string, limit int) ([]*models.WebhookDelivery, error) {
+	return r.findDeliveries(bson.D{{Key: "webhook_id", Value: webhookID}}, limit)
+}
+
+func (r *MongoDBRepo) GetDeadLetters(tenant string, limit int) ([]*models.WebhookDelivery, error) {
+	return r.findDeliveries(bson.D{{Key: "tenant", Value: tenant}, {Key: "dead_letter", Value: true}}, limit)
+}
+
+func (r *MongoDBRepo) findDeliveries(filter bson.D, limit int) ([]*models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.deliveries.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []*models.WebhookDelivery{}
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// webhookFilter matches the webhook within its tenant, IDs which are not
+// ObjectIDs cannot exist.
+func webhookFilter(tenant, id string) (bson.D, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, id)
+	}
+
+	return bson.D{{Key: "_id", Value: objID}, {Key: "tenant", Value: tenant}}, nil
+}
@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func Test_MongoDB_GetWebhook(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should return webhook of tenant", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			webhooks:   mt.Coll,
+			deliveries: mt.Coll,
+		}
+
+		objID := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "db.webhooks", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: objID},
+			{Key: "tenant", Value: "acme"},
+			{Key: "url", Value: "https://example.com/hook"},
+			{Key: "events", Value: bson.A{"book.created"}},
+			{Key: "active", Value: true},
+		}))
+
+		// when
+		w, err := ts.GetWebhook("acme", objID.Hex())
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while retrieving webhook:", err)
+		}
+
+		if w.ID != objID.Hex() || w.URL != "https://example.com/hook" || len(w.Events) != 1 {
+			t.Fatalf("Returned webhook is different than expected: %+v\n", w)
+		}
+	})
+
+	mt.Run("Should return not found for invalid id", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			webhooks:   mt.Coll,
+			deliveries: mt.Coll,
+		}
+
+		// when
+		_, err := ts.GetWebhook("acme", "7")
+
+		// then
+		if !errors.Is(err, repository.ErrWebhookNotFound) {
+			t.Fatalf("Expected %v, has: %v\n", repository.ErrWebhookNotFound, err)
+		}
+	})
+}
+
+func Test_MongoDB_AddWebhookDelivery(t *testing.T) {
+	// setup
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("Should record delivery", func(mt *mtest.T) {
+		// given
+		ts := MongoDBRepo{
+			webhooks:   mt.Coll,
+			deliveries: mt.Coll,
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		// when
+		d, err := ts.AddWebhookDelivery(&models.WebhookDelivery{WebhookID: "1", Tenant: "acme", EventID: "42", Attempt: 1})
+
+		// then
+		if err != nil {
+			t.Fatal("Encountered error while recording delivery:", err)
+		}
+
+		if d.ID == "" || d.CreatedAt.IsZero() {
+			t.Fatalf("Recorded delivery should have id and creation time: %+v\n", d)
+		}
+	})
+}
@@ -0,0 +1,251 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"strings"
+	"time"
+)
+
+type PostgreSQLRepo struct {
+	DB *sql.DB
+}
+
+const postgresDBTimeout = time.Second * 3
+
+func NewPostgreSQLRepo(db *sql.DB) *PostgreSQLRepo {
+	return &PostgreSQLRepo{
+		DB: db,
+	}
+}
+
+func (r *PostgreSQLRepo) GetWebhooks(tenant string) ([]*models.Webhook, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, tenant, url, secret, events, active, created_at
+		FROM webhooks
+		WHERE tenant = $1
+		ORDER BY id;
+	`
+
+	rows, err := r.DB.QueryContext(ctx, query, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (r *PostgreSQLRepo) GetWebhook(tenant, id string) (*models.Webhook, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		SELECT id, tenant, url, secret, events, active, created_at
+		FROM webhooks
+		WHERE tenant = $1 AND id = $2::bigint;
+	`
+
+	webhookID, err := repository.ParseID(id, repository.ErrWebhookNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := scanWebhook(r.DB.QueryRowContext(ctx, query, tenant, webhookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, id)
+	}
+	return w, err
+}
+
+func (r *PostgreSQLRepo) AddWebhook(w *models.Webhook) (*models.Webhook, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO webhooks (tenant, url, secret, events, active, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id;
+	`
+
+	created := *w
+	created.CreatedAt = time.Now().UTC()
+
+	var newId int
+	err := r.DB.QueryRowContext(ctx, query, w.Tenant, w.URL, w.Secret, strings.Join(w.Events, ","), w.Active, created.CreatedAt).Scan(&newId)
+	if err != nil {
+		return nil, err
+	}
+
+	created.ID = fmt.Sprintf("%d", newId)
+	return &created, nil
+}
+
+func (r *PostgreSQLRepo) UpdateWebhook(w *models.Webhook) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		UPDATE webhooks
+		SET url = $3, events = $4, active = $5
+		WHERE tenant = $1 AND id = $2::bigint;
+	`
+
+	webhookID, err := repository.ParseID(w.ID, repository.ErrWebhookNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, query, w.Tenant, webhookID, w.URL, strings.Join(w.Events, ","), w.Active)
+	if err != nil {
+		return err
+	}
+
+	return notFoundUnlessAffected(res, w.ID)
+}
+
+func (r *PostgreSQLRepo) DeleteWebhook(tenant, id string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		DELETE FROM webhooks
+		WHERE tenant = $1 AND id = $2::bigint;
+	`
+
+	webhookID, err := repository.ParseID(id, repository.ErrWebhookNotFound)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.DB.ExecContext(ctx, query, tenant, webhookID)
+	if err != nil {
+		return err
+	}
+
+	return notFoundUnlessAffected(res, id)
+}
+
+func (r *PostgreSQLRepo) AddWebhookDelivery(d *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, tenant, event_id, event_type, payload, attempt, status_code, error, succeeded, dead_letter, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id;
+	`
+
+	created := *d
+	created.CreatedAt = time.Now().UTC()
+
+	var newId int64
+	err := r.DB.QueryRowContext(ctx, query, d.WebhookID, d.Tenant, d.EventID, d.EventType, []byte(d.Payload),
+		d.Attempt, d.StatusCode, d.Error, d.Succeeded, d.DeadLetter, created.CreatedAt).Scan(&newId)
+	if err != nil {
+		return nil, err
+	}
+
+	created.ID = fmt.Sprintf("%d", newId)
+	return &created, nil
+}
+
+func (r *PostgreSQLRepo) GetWebhookDeliveries(webhookID string, limit int) ([]*models.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, tenant, event_id, event_type, payload, attempt, status_code, error, succeeded, dead_letter, created_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1::bigint
+		ORDER BY id DESC
+		LIMIT $2;
+	`
+
+	id, err := repository.ParseID(webhookID, repository.ErrWebhookNotFound)
+	if err != nil {
+		return []*models.WebhookDelivery{}, nil
+	}
+
+	return r.queryDeliveries(query, id, limit)
+}
+
+func (r *PostgreSQLRepo) GetDeadLetters(tenant string, limit int) ([]*models.WebhookDelivery, error) {
+	query := `
+		SELECT id, webhook_id, tenant, event_id, event_type, payload, attempt, status_code, error, succeeded, dead_letter, created_at
+		FROM webhook_deliveries
+		WHERE tenant = $1 AND dead_letter
+		ORDER BY id DESC
+		LIMIT $2;
+	`
+
+	return r.queryDeliveries(query, tenant, limit)
+}
+
+func (r *PostgreSQLRepo) queryDeliveries(query string, args ...any) ([]*models.WebhookDelivery, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), postgresDBTimeout)
+	defer cancelFn()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var payload []byte
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Tenant, &d.EventID, &d.EventType, &payload,
+			&d.Attempt, &d.StatusCode, &d.Error, &d.Succeeded, &d.DeadLetter, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	var w models.Webhook
+	var events string
+	if err := row.Scan(&w.ID, &w.Tenant, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	w.Events = []string{}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return &w, nil
+}
+
+func notFoundUnlessAffected(res sql.Result, id string) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w (id=%s)", repository.ErrWebhookNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var webhooksPostgresqlRows = []string{"id", "tenant", "url", "secret", "events", "active", "created_at"}
+
+func Test_Postgresql_GetWebhooks_ShouldSplitEvents(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	dbRows := sqlmock.NewRows(webhooksPostgresqlRows)
+	dbRows.AddRow("1", "acme", "https://example.com/hook", "secret", "book.created,book.deleted", true, time.Now())
+	dbRows.AddRow("2", "acme", "https://example.com/all", "secret", "", false, time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, tenant, url, secret, events, active, created_at FROM webhooks WHERE tenant = $1 ORDER BY id;`)).
+		WithArgs("acme").
+		WillReturnRows(dbRows)
+
+	// when
+	webhooks, err := testServer.GetWebhooks("acme")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(webhooks) != 2 || len(webhooks[0].Events) != 2 || webhooks[0].Events[1] != "book.deleted" || len(webhooks[1].Events) != 0 {
+		t.Fatalf("Returned webhooks are different than expected: %+v %+v\n", webhooks[0], webhooks[1])
+	}
+}
+
+func Test_Postgresql_AddWebhook_ShouldCallInsertQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	testWebhook := &models.Webhook{Tenant: "acme", URL: "https://example.com/hook", Secret: "secret", Events: []string{"book.created", "book.updated"}, Active: true}
+
+	dbRows := sqlmock.NewRows([]string{"id"})
+	dbRows.AddRow("7")
+
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO webhooks (tenant, url, secret, events, active, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`)).
+		WithArgs(testWebhook.Tenant, testWebhook.URL, testWebhook.Secret, "book.created,book.updated", true, sqlmock.AnyArg()).
+		WillReturnRows(dbRows)
+
+	// when
+	created, err := testServer.AddWebhook(testWebhook)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created.ID != "7" || created.CreatedAt.IsZero() {
+		t.Fatalf("Created webhook should have id and creation time: %+v\n", created)
+	}
+}
+
+func Test_Postgresql_DeleteWebhook_ShouldReturnNotFound(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM webhooks WHERE tenant = $1 AND id = $2::bigint;`)).
+		WithArgs("acme", int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// when
+	err := testServer.DeleteWebhook("acme", "7")
+
+	// then
+	if !errors.Is(err, repository.ErrWebhookNotFound) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrWebhookNotFound, err)
+	}
+}
+
+func Test_Postgresql_GetWebhook_ShouldNotFindNonNumericID(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// when
+	_, err := testServer.GetWebhook("acme", "abc")
+
+	// then
+	if !errors.Is(err, repository.ErrWebhookNotFound) {
+		t.Fatalf("Expected %v, has: %v\n", repository.ErrWebhookNotFound, err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Postgresql_GetDeadLetters_ShouldCallSelectQuery(t *testing.T) {
+	// setup
+	testServer, mock := prepareTestDB(t)
+	defer testServer.DB.Close()
+
+	// given
+	dbRows := sqlmock.NewRows([]string{"id", "webhook_id", "tenant", "event_id", "event_type", "payload", "attempt", "status_code", "error", "succeeded", "dead_letter", "created_at"})
+	dbRows.AddRow("3", "7", "acme", "42", "book.created", []byte(`{"id":"42"}`), 5, 500, "receiver responded with 500", false, true, time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, webhook_id, tenant, event_id, event_type, payload, attempt, status_code, error, succeeded, dead_letter, created_at FROM webhook_deliveries WHERE tenant = $1 AND dead_letter ORDER BY id DESC LIMIT $2;`)).
+		WithArgs("acme", 10).
+		WillReturnRows(dbRows)
+
+	// when
+	deliveries, err := testServer.GetDeadLetters("acme", 10)
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 1 || !deliveries[0].DeadLetter || string(deliveries[0].Payload) != `{"id":"42"}` {
+		t.Fatalf("Returned dead letters are different than expected: %+v\n", deliveries)
+	}
+}
+
+func prepareTestDB(t *testing.T) (*PostgreSQLRepo, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while preparing test db mock: %s\n", err)
+	}
+
+	return NewPostgreSQLRepo(db), mock
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"errors"
+	"github.com/auwendil/crud-app/internal/models"
+)
+
+// ErrWebhookNotFound is returned when the webhook does not exist or
+// belongs to another tenant.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+type WebhookRepo interface {
+	GetWebhooks(tenant string) ([]*models.Webhook, error)
+	GetWebhook(tenant, id string) (*models.Webhook, error)
+	AddWebhook(w *models.Webhook) (*models.Webhook, error)
+	UpdateWebhook(w *models.Webhook) error
+	// DeleteWebhook removes the webhook together with its deliveries.
+	DeleteWebhook(tenant, id string) error
+
+	AddWebhookDelivery(d *models.WebhookDelivery) (*models.WebhookDelivery, error)
+	// GetWebhookDeliveries returns the latest attempts of the webhook,
+	// newest first.
+	GetWebhookDeliveries(webhookID string, limit int) ([]*models.WebhookDelivery, error)
+	// GetDeadLetters returns the latest deliveries of the tenant which
+	// failed every attempt, newest first.
+	GetDeadLetters(tenant string, limit int) ([]*models.WebhookDelivery, error)
+}
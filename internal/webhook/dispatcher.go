@@ -0,0 +1,299 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/models"
+	"github.com/auwendil/crud-app/internal/repository"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TestEventType is the type of events sent by SendTest.
+const TestEventType = "webhook.test"
+
+// maxResponseBytes is read from receivers, so their connections can be
+// reused, the rest of a response is ignored.
+const maxResponseBytes = 64 << 10
+
+type Config struct {
+	// MaxAttempts is the amount of attempts to deliver an event before it
+	// is moved to dead letters.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles with every
+	// next one up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout limits a single attempt.
+	Timeout time.Duration
+	// QueueSize is the amount of events waiting for delivery, events
+	// published while the queue is full are dropped.
+	QueueSize int
+	Workers   int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  5 * time.Minute,
+		Timeout:     10 * time.Second,
+		QueueSize:   1000,
+		Workers:     4,
+	}
+}
+
+// Payload is the JSON body of every delivery.
+type Payload struct {
+	ID     string       `json:"id"`
+	Type   string       `json:"type"`
+	BookID string       `json:"book_id,omitempty"`
+	Book   *models.Book `json:"book,omitempty"`
+	Time   time.Time    `json:"time"`
+}
+
+type Stats struct {
+	Queued  int    `json:"queued"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// Dispatcher delivers book events to webhooks subscribed to them. Every
+// attempt is recorded in the delivery log of its webhook, failed attempts
+// are retried with exponential backoff and the last one is marked as a
+// dead letter.
+type Dispatcher struct {
+	repo   repository.WebhookRepo
+	client *http.Client
+	cfg    Config
+
+	events     chan events.Event
+	deliveries chan *delivery
+	done       chan struct{}
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+	dropped    atomic.Uint64
+}
+
+type delivery struct {
+	webhook   *models.Webhook
+	eventID   string
+	eventType string
+	payload   []byte
+	attempt   int
+}
+
+// NewDispatcher starts workers delivering events until Close is called.
+// Requests are sent with client, http.DefaultClient when nil.
+func NewDispatcher(repo repository.WebhookRepo, client *http.Client, cfg Config) *Dispatcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	d := &Dispatcher{
+		repo:       repo,
+		client:     client,
+		cfg:        cfg,
+		events:     make(chan events.Event, cfg.QueueSize),
+		deliveries: make(chan *delivery, cfg.Workers),
+		done:       make(chan struct{}),
+	}
+
+	d.wg.Add(1 + cfg.Workers)
+	go d.fanOut()
+	for i := 0; i < cfg.Workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Handle queues delivery of e to webhooks of its tenant. It never blocks,
+// so it can be registered with events.Bus.OnPublish.
+func (d *Dispatcher) Handle(e events.Event) {
+	select {
+	case d.events <- e:
+	default:
+		d.dropped.Add(1)
+		log.Printf("webhooks: queue is full, dropping event %d\n", e.ID)
+	}
+}
+
+// SendTest makes a single attempt to deliver a test event to w and
+// returns its record. Test deliveries are not retried.
+func (d *Dispatcher) SendTest(w *models.Webhook) (*models.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	id := "test-" + strconv.FormatInt(now.UnixNano(), 10)
+	payload, err := json.Marshal(Payload{ID: id, Type: TestEventType, Time: now})
+	if err != nil {
+		return nil, err
+	}
+
+	record, _ := d.send(&delivery{webhook: w, eventID: id, eventType: TestEventType, payload: payload, attempt: 1})
+	return d.repo.AddWebhookDelivery(record)
+}
+
+func (d *Dispatcher) Stats() Stats {
+	return Stats{Queued: len(d.events), Dropped: d.dropped.Load()}
+}
+
+// Close stops the workers after their current attempts, queued events
+// and pending retries are abandoned.
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(func() { close(d.done) })
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) fanOut() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case e := <-d.events:
+			d.dispatch(e)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(e events.Event) {
+	webhooks, err := d.repo.GetWebhooks(e.Tenant)
+	if err != nil {
+		log.Printf("webhooks: loading webhooks of event %d failed: %s\n", e.ID, err)
+		return
+	}
+
+	id := strconv.FormatUint(e.ID, 10)
+	payload, err := json.Marshal(Payload{ID: id, Type: string(e.Type), BookID: e.BookID, Book: e.Book, Time: e.Time})
+	if err != nil {
+		log.Printf("webhooks: encoding event %d failed: %s\n", e.ID, err)
+		return
+	}
+
+	for _, w := range webhooks {
+		if w.Active && Subscribed(w, string(e.Type)) {
+			d.enqueue(&delivery{webhook: w, eventID: id, eventType: string(e.Type), payload: payload, attempt: 1})
+		}
+	}
+}
+
+// Subscribed reports whether w receives events of eventType.
+func Subscribed(w *models.Webhook, eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, t := range w.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) enqueue(dl *delivery) {
+	select {
+	case d.deliveries <- dl:
+	case <-d.done:
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case dl := <-d.deliveries:
+			d.attempt(dl)
+		}
+	}
+}
+
+func (d *Dispatcher) attempt(dl *delivery) {
+	record, retry := d.send(dl)
+
+	switch {
+	case record.Succeeded:
+	case retry && dl.attempt < d.cfg.MaxAttempts:
+		next := *dl
+		next.attempt++
+		time.AfterFunc(d.backoff(dl.attempt), func() { d.enqueue(&next) })
+	default:
+		record.DeadLetter = true
+	}
+
+	if _, err := d.repo.AddWebhookDelivery(record); err != nil {
+		log.Printf("webhooks: recording delivery of event %s to webhook %s failed: %s\n", dl.eventID, dl.webhook.ID, err)
+	}
+}
+
+// send makes one attempt of dl and reports whether a failed one may
+// succeed when retried.
+func (d *Dispatcher) send(dl *delivery) (*models.WebhookDelivery, bool) {
+	record := &models.WebhookDelivery{
+		WebhookID: dl.webhook.ID,
+		Tenant:    dl.webhook.Tenant,
+		EventID:   dl.eventID,
+		EventType: dl.eventType,
+		Payload:   dl.payload,
+		Attempt:   dl.attempt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.webhook.URL, bytes.NewReader(dl.payload))
+	if err != nil {
+		record.Error = err.Error()
+		return record, false
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "crud-app-webhooks/1")
+	req.Header.Set(EventHeader, dl.eventType)
+	req.Header.Set(EventIDHeader, dl.eventID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(dl.webhook.Secret, timestamp, dl.payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		record.Error = err.Error()
+		return record, true
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, maxResponseBytes))
+
+	record.StatusCode = res.StatusCode
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		record.Succeeded = true
+		return record, false
+	}
+
+	record.Error = "receiver responded with " + res.Status
+	return record, retryableStatus(res.StatusCode)
+}
+
+// retryableStatus reports whether the receiver may accept the delivery
+// later, other client errors are permanent.
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.Backoff
+	for i := 1; i < attempt && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if d.cfg.MaxBackoff > 0 && delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,240 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/auwendil/crud-app/internal/events"
+	"github.com/auwendil/crud-app/internal/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Dispatcher_ShouldDeliverSignedPayloads(t *testing.T) {
+	// setup
+	received := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	repo := &repoStub{webhooks: []*models.Webhook{
+		{ID: "1", Tenant: "acme", URL: receiver.URL, Secret: "secret", Events: []string{string(events.BookDeleted)}, Active: true},
+		{ID: "2", Tenant: "acme", URL: receiver.URL, Secret: "secret", Active: false},
+		{ID: "3", Tenant: "other", URL: receiver.URL, Secret: "secret", Active: true},
+	}}
+	ts := NewDispatcher(repo, nil, testConfig())
+	defer ts.Close()
+
+	// when
+	ts.Handle(events.Event{ID: 7, Type: events.BookCreated, Tenant: "acme", BookID: "1"})
+	ts.Handle(events.Event{ID: 8, Type: events.BookDeleted, Tenant: "acme", BookID: "1", Book: &models.Book{ID: "1", Name: "Book1"}})
+
+	// then
+	r, body := waitFor(t, received), <-bodies
+	if !Verify("secret", r.Header, body, time.Minute) {
+		t.Fatalf("Signature %q does not match the payload\n", r.Header.Get(SignatureHeader))
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal("Encountered error while decoding payload:", err)
+	}
+	if payload.ID != "8" || payload.Type != string(events.BookDeleted) || payload.Book == nil || payload.Book.Name != "Book1" {
+		t.Fatalf("Expected deletion of Book1, received: %+v\n", payload)
+	}
+	if r.Header.Get(EventHeader) != string(events.BookDeleted) || r.Header.Get(EventIDHeader) != "8" {
+		t.Fatalf("Event headers do not describe the payload: %v\n", r.Header)
+	}
+
+	select {
+	case r := <-received:
+		t.Fatalf("Only subscribed active webhooks of the tenant should receive events, received: %s\n", r.Header.Get(EventIDHeader))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_Dispatcher_ShouldRetryFailedDeliveries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		expectedLogs int
+		deadLetter   bool
+	}{
+		{"Succeeds after retry", []int{http.StatusServiceUnavailable, http.StatusOK}, 2, false},
+		{"Dead letter after last attempt", []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusTooManyRequests}, 3, true},
+		{"Dead letter without retries on client error", []int{http.StatusBadRequest}, 1, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			var mu sync.Mutex
+			attempts := 0
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				w.WriteHeader(tt.statuses[attempts%len(tt.statuses)])
+				attempts++
+			}))
+			defer receiver.Close()
+
+			repo := &repoStub{webhooks: []*models.Webhook{{ID: "1", Tenant: "acme", URL: receiver.URL, Active: true}}}
+			ts := NewDispatcher(repo, nil, testConfig())
+			defer ts.Close()
+
+			// when
+			ts.Handle(events.Event{ID: 1, Type: events.BookCreated, Tenant: "acme"})
+
+			// then
+			logs := repo.waitForDeliveries(t, tt.expectedLogs)
+			last := logs[len(logs)-1]
+			if last.Attempt != tt.expectedLogs || last.DeadLetter != tt.deadLetter || last.Succeeded == tt.deadLetter {
+				t.Fatalf("Last attempt should be %d with dead letter %t, has: %+v\n", tt.expectedLogs, tt.deadLetter, last)
+			}
+
+			for _, l := range logs[:len(logs)-1] {
+				if l.Succeeded || l.DeadLetter || l.StatusCode == 0 {
+					t.Fatalf("Earlier attempts should be failed retries, has: %+v\n", l)
+				}
+			}
+		})
+	}
+}
+
+func Test_Dispatcher_SendTest_ShouldRecordSingleAttempt(t *testing.T) {
+	// setup
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	repo := &repoStub{}
+	ts := NewDispatcher(repo, nil, testConfig())
+	defer ts.Close()
+
+	// when
+	record, err := ts.SendTest(&models.Webhook{ID: "1", Tenant: "acme", URL: receiver.URL, Secret: "secret"})
+
+	// then
+	if err != nil {
+		t.Fatal("Encountered error while sending test event:", err)
+	}
+
+	if record.EventType != TestEventType || record.StatusCode != http.StatusInternalServerError || record.Succeeded || record.DeadLetter {
+		t.Fatalf("Expected failed test attempt, has: %+v\n", record)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if logs := repo.recorded(); len(logs) != 1 {
+		t.Fatalf("Test events should not be retried, recorded %d attempts\n", len(logs))
+	}
+}
+
+func Test_Dispatcher_Backoff_ShouldDoubleUpToMax(t *testing.T) {
+	// setup
+	ts := &Dispatcher{cfg: Config{Backoff: time.Second, MaxBackoff: 5 * time.Second}}
+
+	// when
+	delays := []time.Duration{ts.backoff(1), ts.backoff(2), ts.backoff(3), ts.backoff(10)}
+
+	// then
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Fatalf("Delays %v, should be %v\n", delays, expected)
+		}
+	}
+}
+
+// utils
+
+func testConfig() Config {
+	return Config{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Timeout: time.Second, QueueSize: 10, Workers: 2}
+}
+
+func waitFor(t *testing.T, received chan *http.Request) *http.Request {
+	select {
+	case r := <-received:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("No delivery received")
+		return nil
+	}
+}
+
+type repoStub struct {
+	mu         sync.Mutex
+	webhooks   []*models.Webhook
+	deliveries []*models.WebhookDelivery
+}
+
+func (r *repoStub) GetWebhooks(tenant string) ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+	for _, w := range r.webhooks {
+		if w.Tenant == tenant {
+			webhooks = append(webhooks, w)
+		}
+	}
+	return webhooks, nil
+}
+
+func (r *repoStub) GetWebhook(tenant, id string) (*models.Webhook, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) AddWebhook(w *models.Webhook) (*models.Webhook, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) UpdateWebhook(w *models.Webhook) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) DeleteWebhook(tenant, id string) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) AddWebhookDelivery(d *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries = append(r.deliveries, d)
+	return d, nil
+}
+
+func (r *repoStub) GetWebhookDeliveries(webhookID string, limit int) ([]*models.WebhookDelivery, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) GetDeadLetters(tenant string, limit int) ([]*models.WebhookDelivery, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (r *repoStub) recorded() []*models.WebhookDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*models.WebhookDelivery{}, r.deliveries...)
+}
+
+func (r *repoStub) waitForDeliveries(t *testing.T, n int) []*models.WebhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if logs := r.recorded(); len(logs) >= n {
+			time.Sleep(20 * time.Millisecond)
+			if logs = r.recorded(); len(logs) != n {
+				t.Fatalf("Recorded %d attempts, should be %d\n", len(logs), n)
+			}
+			return logs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Recorded %d attempts, should be %d\n", len(r.recorded()), n)
+	return nil
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const booksPath = "/v2/books"
+
+type Book struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Author string `json:"author"`
+}
+
+// BookPage is a page of books, NextOffset is nil on the last page.
+type BookPage struct {
+	Items      []*Book `json:"items"`
+	Offset     int     `json:"offset"`
+	Limit      int     `json:"limit"`
+	NextOffset *int    `json:"next_offset,omitempty"`
+}
+
+// ListOptions select a page of books. Zero Limit uses the server default,
+// non-zero AsOf lists the catalog as it was then, which requires the
+// server to keep books in an event store.
+type ListOptions struct {
+	Offset int
+	Limit  int
+	AsOf   time.Time
+}
+
+func (o ListOptions) query() url.Values {
+	query := url.Values{}
+	if o.Offset > 0 {
+		query.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if o.Limit > 0 {
+		query.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if !o.AsOf.IsZero() {
+		query.Set("as_of", o.AsOf.UTC().Format(time.RFC3339Nano))
+	}
+	return query
+}
+
+func bookPath(id string) string {
+	return booksPath + "/" + url.PathEscape(id)
+}
+
+func (c *Client) ListBooks(ctx context.Context, opts ListOptions) (*BookPage, error) {
+	var page BookPage
+	err := c.do(ctx, request{method: http.MethodGet, path: booksPath, query: opts.query(), idempotent: true}, &page)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (c *Client) GetBook(ctx context.Context, id string) (*Book, error) {
+	var book Book
+	err := c.do(ctx, request{method: http.MethodGet, path: bookPath(id), idempotent: true}, &book)
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// AddBook creates a book. Without an idempotency key the request is
+// retried only when the server rejected it before processing, use
+// AddBookIdempotent to retry it after network errors as well.
+func (c *Client) AddBook(ctx context.Context, b *Book) (*Book, error) {
+	return c.addBook(ctx, b, "")
+}
+
+// AddBookIdempotent creates a book with an Idempotency-Key, retries and
+// later calls with the same key return the first created book instead of
+// creating another one. The server must have idempotency keys enabled.
+func (c *Client) AddBookIdempotent(ctx context.Context, b *Book, key string) (*Book, error) {
+	return c.addBook(ctx, b, key)
+}
+
+func (c *Client) addBook(ctx context.Context, b *Book, key string) (*Book, error) {
+	req := request{method: http.MethodPost, path: booksPath, body: Book{Name: b.Name, Author: b.Author}}
+	if key != "" {
+		req.header = http.Header{idempotencyKeyHeader: {key}}
+		req.idempotent = true
+	}
+
+	var created Book
+	if err := c.do(ctx, req, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) UpdateBook(ctx context.Context, id string, b *Book) (*Book, error) {
+	var updated Book
+	req := request{method: http.MethodPut, path: bookPath(id), body: Book{Name: b.Name, Author: b.Author}, idempotent: true}
+	if err := c.do(ctx, req, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (c *Client) DeleteBook(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: bookPath(id), idempotent: true}, nil)
+}
+
+func (c *Client) DeleteAllBooks(ctx context.Context) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: booksPath, idempotent: true}, nil)
+}
+
+// BookIterator walks all books page by page, fetching the next page only
+// when the current one was read.
+type BookIterator struct {
+	ctx    context.Context
+	client *Client
+	opts   ListOptions
+	page   []*Book
+	book   *Book
+	done   bool
+	err    error
+}
+
+// Books iterates over all books in pages of pageSize, the server default
+// when 0.
+func (c *Client) Books(ctx context.Context, pageSize int) *BookIterator {
+	return &BookIterator{ctx: ctx, client: c, opts: ListOptions{Limit: pageSize}}
+}
+
+// Next advances to the next book, it returns false after the last book
+// or an error.
+func (it *BookIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		page, err := it.client.ListBooks(it.ctx, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.page = page.Items
+		if page.NextOffset == nil {
+			it.done = true
+		} else {
+			it.opts.Offset = *page.NextOffset
+		}
+	}
+
+	it.book, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+func (it *BookIterator) Book() *Book {
+	return it.book
+}
+
+func (it *BookIterator) Err() error {
+	return it.err
+}
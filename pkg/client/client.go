@@ -0,0 +1,237 @@
+// Package client is a typed client of the books API. It talks to v2
+// routes and webhook routes, whose errors are RFC 7807 problem details
+// mapped to *Error.
+//
+//	c, err := client.New("http://localhost:3000", client.DefaultConfig())
+//	book, err := c.AddBook(ctx, &client.Book{Name: "Solaris", Author: "Stanislaw Lem"})
+//
+//	it := c.Books(ctx, 100)
+//	for it.Next() {
+//		fmt.Println(it.Book().Name)
+//	}
+//	if err := it.Err(); err != nil { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyHeader         = "X-API-Key"
+	tenantHeader         = "X-Tenant-ID"
+	idempotencyKeyHeader = "Idempotency-Key"
+	problemContentType   = "application/problem+json"
+)
+
+// Config configures authentication and retries of a Client.
+type Config struct {
+	// HTTPClient sends requests, http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+	// APIKey is sent in X-API-Key header, BearerToken as a bearer token in
+	// Authorization header. Both are optional.
+	APIKey      string
+	BearerToken string
+	// Tenant is sent in X-Tenant-ID header of every request when set.
+	Tenant string
+	// MaxAttempts limits attempts of a retried call, 1 disables retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled with every next
+	// one up to MaxBackoff. Retry-After of the response takes precedence.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts: 3,
+		Backoff:     200 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+	}
+}
+
+// Client is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	cfg     Config
+}
+
+func New(baseURL string, cfg Config) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("base URL must be an absolute http or https URL, has: %q", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{baseURL: u, http: httpClient, cfg: cfg}, nil
+}
+
+// request describes a call, so it can be sent again by retries.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   any
+	header http.Header
+	// idempotent calls are retried after network errors and responses of
+	// failed gateways too, others only when the server rejected them
+	// without processing.
+	idempotent bool
+}
+
+// do sends req, retrying it when allowed, and decodes a successful JSON
+// response into out unless it is nil.
+func (c *Client) do(ctx context.Context, req request, out any) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := c.send(ctx, req, body)
+		if err == nil && res.StatusCode < http.StatusBadRequest {
+			defer res.Body.Close()
+			if out == nil {
+				return nil
+			}
+			return json.NewDecoder(res.Body).Decode(out)
+		}
+
+		var retryAfter time.Duration
+		retry := false
+		if err != nil {
+			retry = req.idempotent && ctx.Err() == nil && isTemporary(err)
+		} else {
+			apiErr := readError(res)
+			err, retryAfter = apiErr, apiErr.RetryAfter
+			retry = retryable(res.StatusCode, req.idempotent)
+		}
+
+		if !retry || attempt >= c.cfg.MaxAttempts {
+			return err
+		}
+
+		if err := sleep(ctx, c.backoff(attempt, retryAfter)); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	u := *c.baseURL
+	u.Path += req.path
+	u.RawQuery = req.query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range req.header {
+		httpReq.Header[key] = values
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.APIKey != "" {
+		httpReq.Header.Set(apiKeyHeader, c.cfg.APIKey)
+	}
+	if c.cfg.BearerToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.cfg.BearerToken)
+	}
+	if c.cfg.Tenant != "" {
+		httpReq.Header.Set(tenantHeader, c.cfg.Tenant)
+	}
+
+	return c.http.Do(httpReq)
+}
+
+// retryable tells whether a response with status may succeed when sent
+// again. 429 and 503 are returned by limits before the request is
+// processed, so even non-idempotent calls are safe to retry.
+func retryable(status int, idempotent bool) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+func isTemporary(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// backoff returns the delay before the retry following attempt, with
+// jitter so clients rejected at once do not retry at once.
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	delay := c.cfg.Backoff << (attempt - 1)
+	if delay <= 0 || (c.cfg.MaxBackoff > 0 && delay > c.cfg.MaxBackoff) {
+		delay = c.cfg.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
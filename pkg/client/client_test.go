@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Client_Retries(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		call             func(c *Client) error
+		expectedAttempts int32
+		expectedError    error
+	}{
+		{"Idempotent call after unavailable server", http.StatusServiceUnavailable, getBook, 3, ErrUnavailable},
+		{"Idempotent call after bad gateway", http.StatusBadGateway, getBook, 3, ErrServerError},
+		{"Create after rate limit", http.StatusTooManyRequests, addBook, 3, ErrRateLimited},
+		{"Create after bad gateway", http.StatusBadGateway, addBook, 1, ErrServerError},
+		{"Not found", http.StatusNotFound, getBook, 1, ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// setup
+			var attempts atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			c := prepareClient(t, ts.URL)
+
+			// when
+			err := tt.call(c)
+
+			// then
+			if !errors.Is(err, tt.expectedError) {
+				t.Fatalf("Expected %v, has: %v\n", tt.expectedError, err)
+			}
+			if attempts.Load() != tt.expectedAttempts {
+				t.Fatalf("Server received %d attempts, should be %d\n", attempts.Load(), tt.expectedAttempts)
+			}
+		})
+	}
+}
+
+func Test_Client_ShouldSucceedAfterRetry(t *testing.T) {
+	// setup
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"1","name":"Book1","author":"Author1"}`))
+	}))
+	defer ts.Close()
+
+	c := prepareClient(t, ts.URL)
+
+	// when
+	start := time.Now()
+	book, err := c.GetBook(context.Background(), "1")
+
+	// then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Name != "Book1" {
+		t.Fatalf("Expected Book1, has: %+v\n", book)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Fatalf("Retry should wait for Retry-After, waited %s\n", waited)
+	}
+}
+
+func Test_Client_ShouldDecodeProblemDetails(t *testing.T) {
+	// setup
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"type":"urn:crud-app:problem:validation-failed","title":"Bad Request","status":400,` +
+			`"detail":"book is invalid","request_id":"abc","errors":[{"field":"name","message":"is required"}]}`))
+	}))
+	defer ts.Close()
+
+	c := prepareClient(t, ts.URL)
+
+	// when
+	_, err := c.AddBook(context.Background(), &Book{Author: "Author1"})
+
+	// then
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("Expected bad request *Error, has: %v\n", err)
+	}
+	if apiErr.RequestID != "abc" || len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "name" {
+		t.Fatalf("Problem details were not decoded: %+v\n", apiErr)
+	}
+}
+
+// utils
+
+func prepareClient(t *testing.T, url string) *Client {
+	cfg := DefaultConfig()
+	cfg.Backoff, cfg.MaxBackoff = time.Millisecond, 2*time.Millisecond
+
+	c, err := New(url, cfg)
+	if err != nil {
+		t.Fatalf("[SETUP] Encountered error while creating client: %s\n", err)
+	}
+	return c
+}
+
+func getBook(c *Client) error {
+	_, err := c.GetBook(context.Background(), "1")
+	return err
+}
+
+func addBook(c *Client) error {
+	_, err := c.AddBook(context.Background(), &Book{Name: "Book1", Author: "Author1"})
+	return err
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// Errors matched by errors.Is against *Error of the response status.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrGone            = errors.New("gone")
+	ErrTooLarge        = errors.New("request too large")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrServerError     = errors.New("server error")
+	ErrUnavailable     = errors.New("service unavailable")
+	ErrUnexpectedReply = errors.New("unexpected response")
+)
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:            ErrBadRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusGone:                  ErrGone,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+}
+
+// Error is a failed response, decoded from its problem details when the
+// server sent them.
+type Error struct {
+	StatusCode int
+	// Type is a stable URN of the problem, e.g.
+	// urn:crud-app:problem:not-found.
+	Type      string
+	Title     string
+	Detail    string
+	RequestID string
+	// Fields lists invalid fields of validation problems.
+	Fields []FieldError
+	// RetryAfter is the delay requested by Retry-After header, 0 when
+	// missing.
+	RetryAfter time.Duration
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = e.Title
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+
+	if e.RequestID != "" {
+		return fmt.Sprintf("books api: %d %s (request %s)", e.StatusCode, msg, e.RequestID)
+	}
+	return fmt.Sprintf("books api: %d %s", e.StatusCode, msg)
+}
+
+func (e *Error) Is(target error) bool {
+	if err, ok := statusErrors[e.StatusCode]; ok {
+		return err == target
+	}
+	if e.StatusCode >= http.StatusInternalServerError {
+		return target == ErrServerError
+	}
+	return target == ErrUnexpectedReply
+}
+
+// readError decodes the error of res and closes its body.
+func readError(res *http.Response) *Error {
+	defer res.Body.Close()
+
+	apiErr := &Error{
+		StatusCode: res.StatusCode,
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != problemContentType {
+		_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+		return apiErr
+	}
+
+	var p struct {
+		Type      string       `json:"type"`
+		Title     string       `json:"title"`
+		Detail    string       `json:"detail"`
+		RequestID string       `json:"request_id"`
+		Errors    []FieldError `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&p); err == nil {
+		apiErr.Type, apiErr.Title, apiErr.Detail = p.Type, p.Title, p.Detail
+		apiErr.RequestID, apiErr.Fields = p.RequestID, p.Errors
+	}
+	return apiErr
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Types of book events.
+const (
+	BookCreated  = "book.created"
+	BookUpdated  = "book.updated"
+	BookDeleted  = "book.deleted"
+	BooksCleared = "books.cleared"
+)
+
+// Event is a change of the catalog, Book is the book after the change or
+// before it when it was deleted.
+type Event struct {
+	ID     uint64    `json:"id"`
+	Type   string    `json:"type"`
+	BookID string    `json:"book_id,omitempty"`
+	Book   *Book     `json:"book,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// EventFilter selects events of the subscription. LastEventID resumes a
+// subscription after the event with that ID.
+type EventFilter struct {
+	BookIDs     []string
+	Author      string
+	LastEventID uint64
+}
+
+// EventStream reads Server-Sent Events of a subscription. It is not
+// safe for concurrent use.
+type EventStream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	lastID uint64
+}
+
+// Subscribe opens a stream of book changes. When the stream breaks, call
+// Subscribe again with LastEventID of the broken stream to get the missed
+// events first. Subscribing to expired events fails with ErrGone.
+func (c *Client) Subscribe(ctx context.Context, filter EventFilter) (*EventStream, error) {
+	query := url.Values{}
+	for _, id := range filter.BookIDs {
+		query.Add("book_id", id)
+	}
+	if filter.Author != "" {
+		query.Set("author", filter.Author)
+	}
+
+	req := request{method: http.MethodGet, path: booksPath + "/events", query: query, header: http.Header{}}
+	if filter.LastEventID > 0 {
+		req.header.Set("Last-Event-ID", fmt.Sprint(filter.LastEventID))
+	}
+
+	res, err := c.send(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, readError(res)
+	}
+
+	return &EventStream{body: res.Body, reader: bufio.NewReader(res.Body), lastID: filter.LastEventID}, nil
+}
+
+// Next blocks until the next event. It returns io.EOF when the server
+// closed the stream, e.g. because the client did not keep up.
+func (s *EventStream) Next() (*Event, error) {
+	var name string
+	var data strings.Builder
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			if name == "error" {
+				var msg struct {
+					Message string `json:"message"`
+				}
+				_ = json.Unmarshal([]byte(data.String()), &msg)
+				return nil, errors.New("event stream: " + msg.Message)
+			}
+
+			var e Event
+			if err = json.Unmarshal([]byte(data.String()), &e); err != nil {
+				return nil, fmt.Errorf("decoding event: %w", err)
+			}
+			s.lastID = e.ID
+			return &e, nil
+		case strings.HasPrefix(line, ":"):
+			// heartbeat
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+// LastEventID is the ID of the last received event, to resume the
+// subscription with.
+func (s *EventStream) LastEventID() uint64 {
+	return s.lastID
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
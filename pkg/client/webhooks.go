@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const webhooksPath = "/webhook"
+
+// Webhook subscribes URL to book events, all of them when Events is empty.
+// Secret signs every delivered payload, it is returned only by AddWebhook.
+type Webhook struct {
+	ID        string    `json:"id,omitempty"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery records one attempt to deliver an event to a webhook,
+// the last failed attempt is marked as DeadLetter.
+type WebhookDelivery struct {
+	ID         string          `json:"id,omitempty"`
+	WebhookID  string          `json:"webhook_id"`
+	EventID    string          `json:"event_id"`
+	EventType  string          `json:"event_type"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	Attempt    int             `json:"attempt"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Succeeded  bool            `json:"succeeded"`
+	DeadLetter bool            `json:"dead_letter"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active bool     `json:"active"`
+}
+
+func webhookPath(id string) string {
+	return webhooksPath + "/" + url.PathEscape(id)
+}
+
+// limitQuery limits listed deliveries, the server default is used when
+// limit is 0.
+func limitQuery(limit int) url.Values {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	return query
+}
+
+// ListWebhooks returns webhooks of the tenant, webhook routes require the
+// admin role.
+func (c *Client) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	var webhooks []*Webhook
+	err := c.do(ctx, request{method: http.MethodGet, path: webhooksPath, idempotent: true}, &webhooks)
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (c *Client) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	var wh Webhook
+	err := c.do(ctx, request{method: http.MethodGet, path: webhookPath(id), idempotent: true}, &wh)
+	if err != nil {
+		return nil, err
+	}
+	return &wh, nil
+}
+
+// AddWebhook creates a webhook, it receives events only when Active is
+// set. The returned webhook carries the generated Secret, keep it to
+// verify deliveries.
+func (c *Client) AddWebhook(ctx context.Context, wh *Webhook) (*Webhook, error) {
+	var created Webhook
+	req := request{method: http.MethodPost, path: webhooksPath, body: webhookRequest{URL: wh.URL, Events: wh.Events, Active: wh.Active}}
+	if err := c.do(ctx, req, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateWebhook replaces URL, events and activity of a webhook, its secret
+// is kept.
+func (c *Client) UpdateWebhook(ctx context.Context, id string, wh *Webhook) (*Webhook, error) {
+	var updated Webhook
+	req := request{method: http.MethodPut, path: webhookPath(id), body: webhookRequest{URL: wh.URL, Events: wh.Events, Active: wh.Active}, idempotent: true}
+	if err := c.do(ctx, req, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: webhookPath(id), idempotent: true}, nil)
+}
+
+// WebhookDeliveries returns the latest delivery attempts of a webhook, at
+// most limit of them or the server default when 0.
+func (c *Client) WebhookDeliveries(ctx context.Context, id string, limit int) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	req := request{method: http.MethodGet, path: webhookPath(id) + "/deliveries", query: limitQuery(limit), idempotent: true}
+	if err := c.do(ctx, req, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// DeadLetters returns the latest deliveries of the tenant which failed all
+// their attempts, at most limit of them or the server default when 0.
+func (c *Client) DeadLetters(ctx context.Context, limit int) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	req := request{method: http.MethodGet, path: webhooksPath + "/dead-letters", query: limitQuery(limit), idempotent: true}
+	if err := c.do(ctx, req, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// TestWebhook sends a test event to a webhook, even an inactive one, and
+// returns the recorded attempt. Like AddBook, it is retried only when the
+// server rejected it before processing.
+func (c *Client) TestWebhook(ctx context.Context, id string) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := c.do(ctx, request{method: http.MethodPost, path: webhookPath(id) + "/test"}, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
@@ -1,5 +1,308 @@
-CREATE TABLE IF NOT EXISTS public.books (
-                                            id SERIAL PRIMARY KEY,
-                                            name varchar(40) NOT NULL,
-                                            author varchar(40) NOT NULL
-);
\ No newline at end of file
+CREATE TABLE IF NOT EXISTS public.books (
+                                            id SERIAL PRIMARY KEY,
+                                            name varchar(40) NOT NULL,
+                                            author varchar(40) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.api_keys (
+                                               id SERIAL PRIMARY KEY,
+                                               name varchar(40) NOT NULL,
+                                               hash char(64) NOT NULL UNIQUE,
+                                               role varchar(16) NOT NULL,
+                                               created_at timestamptz NOT NULL DEFAULT now()
+);
+
+ALTER TABLE public.api_keys ADD COLUMN IF NOT EXISTS tenant varchar(64);
+
+
+-- Tenancy: books of every tenant are tagged with tenant_id, the application
+-- sets app.tenant_id for each transaction, so the policy below hides books of
+-- other tenants from every role without BYPASSRLS.
+ALTER TABLE public.books ADD COLUMN IF NOT EXISTS tenant_id varchar(64) NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS books_tenant_id_idx ON public.books (tenant_id, id);
+
+ALTER TABLE public.books ENABLE ROW LEVEL SECURITY;
+ALTER TABLE public.books FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS books_tenant_isolation ON public.books;
+CREATE POLICY books_tenant_isolation ON public.books
+    USING (tenant_id = current_setting('app.tenant_id', true))
+    WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
+
+-- Idempotency keys of POST requests with the stored response, status_code
+-- stays 0 while the original request is in progress.
+CREATE TABLE IF NOT EXISTS public.idempotency_keys (
+                                                       key varchar(512) PRIMARY KEY,
+                                                       fingerprint char(64) NOT NULL,
+                                                       status_code integer NOT NULL DEFAULT 0,
+                                                       content_type varchar(128) NOT NULL DEFAULT '',
+                                                       response_body bytea,
+                                                       created_at timestamptz NOT NULL DEFAULT now(),
+                                                       expires_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON public.idempotency_keys (expires_at);
+
+-- lease_id identifies the request holding an in-progress key.
+ALTER TABLE public.idempotency_keys ADD COLUMN IF NOT EXISTS lease_id char(32) NOT NULL DEFAULT '';
+
+-- Webhooks receive book events of their tenant, events is a comma separated
+-- list of event types, empty for all of them.
+CREATE TABLE IF NOT EXISTS public.webhooks (
+                                               id serial PRIMARY KEY,
+                                               tenant varchar(64) NOT NULL,
+                                               url varchar(2048) NOT NULL,
+                                               secret varchar(128) NOT NULL,
+                                               events varchar(512) NOT NULL DEFAULT '',
+                                               active boolean NOT NULL DEFAULT true,
+                                               created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS webhooks_tenant_idx ON public.webhooks (tenant);
+
+-- Every delivery attempt, the last failed one is marked as dead letter.
+CREATE TABLE IF NOT EXISTS public.webhook_deliveries (
+                                                         id bigserial PRIMARY KEY,
+                                                         webhook_id integer NOT NULL REFERENCES public.webhooks (id) ON DELETE CASCADE,
+                                                         tenant varchar(64) NOT NULL,
+                                                         event_id varchar(32) NOT NULL,
+                                                         event_type varchar(64) NOT NULL,
+                                                         payload bytea,
+                                                         attempt integer NOT NULL,
+                                                         status_code integer NOT NULL DEFAULT 0,
+                                                         error text NOT NULL DEFAULT '',
+                                                         succeeded boolean NOT NULL,
+                                                         dead_letter boolean NOT NULL DEFAULT false,
+                                                         created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON public.webhook_deliveries (webhook_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_dead_letter_idx ON public.webhook_deliveries (tenant, id) WHERE dead_letter;
+
+-- Book events written in the transaction of the change they describe,
+-- deleted by the outbox relay once every sink received them.
+CREATE TABLE IF NOT EXISTS public.outbox (
+                                            id bigserial PRIMARY KEY,
+                                            tenant varchar(64) NOT NULL,
+                                            event_type varchar(64) NOT NULL,
+                                            book_id varchar(64) NOT NULL DEFAULT '',
+                                            book jsonb,
+                                            created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Append-only log of the event-sourced book repository, seq numbers all
+-- events without gaps and is the ID of books created by them.
+CREATE TABLE IF NOT EXISTS public.book_events (
+                                           seq bigint PRIMARY KEY,
+                                           event_type varchar(32) NOT NULL,
+                                           tenant varchar(64) NOT NULL,
+                                           book_id varchar(64) NOT NULL,
+                                           name varchar(255) NOT NULL DEFAULT '',
+                                           author varchar(255) NOT NULL DEFAULT '',
+                                           created_at timestamptz NOT NULL
+);
+
+-- State of the catalog after the event seq, created_at is the time of
+-- that event.
+CREATE TABLE IF NOT EXISTS public.book_snapshots (
+                                              seq bigint PRIMARY KEY,
+                                              created_at timestamptz NOT NULL,
+                                              books jsonb NOT NULL
+);
+CREATE INDEX IF NOT EXISTS book_snapshots_created_at_idx ON public.book_snapshots (created_at);
+
+-- Physical copies of books, barcodes are unique within a tenant.
+CREATE TABLE IF NOT EXISTS public.copies (
+                                             id serial PRIMARY KEY,
+                                             tenant varchar(64) NOT NULL,
+                                             book_id varchar(64) NOT NULL,
+                                             barcode varchar(64) NOT NULL,
+                                             condition varchar(16) NOT NULL,
+                                             location varchar(255) NOT NULL DEFAULT '',
+                                             created_at timestamptz NOT NULL DEFAULT now(),
+                                             UNIQUE (tenant, barcode)
+);
+CREATE INDEX IF NOT EXISTS copies_book_id_idx ON public.copies (tenant, book_id);
+
+CREATE TABLE IF NOT EXISTS public.members (
+                                              id serial PRIMARY KEY,
+                                              tenant varchar(64) NOT NULL,
+                                              name varchar(255) NOT NULL,
+                                              email varchar(255) NOT NULL,
+                                              created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS members_tenant_idx ON public.members (tenant);
+
+-- Checkouts of copies, a loan is active until it is returned and every
+-- copy has at most one active loan.
+CREATE TABLE IF NOT EXISTS public.loans (
+                                            id serial PRIMARY KEY,
+                                            tenant varchar(64) NOT NULL,
+                                            copy_id integer NOT NULL REFERENCES public.copies (id) ON DELETE CASCADE,
+                                            book_id varchar(64) NOT NULL,
+                                            member_id integer NOT NULL REFERENCES public.members (id) ON DELETE CASCADE,
+                                            checked_out_at timestamptz NOT NULL,
+                                            due_at timestamptz NOT NULL,
+                                            returned_at timestamptz,
+                                            renewals integer NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS loans_active_copy_id_idx ON public.loans (copy_id) WHERE returned_at IS NULL;
+CREATE INDEX IF NOT EXISTS loans_member_id_idx ON public.loans (tenant, member_id, id);
+CREATE INDEX IF NOT EXISTS loans_due_at_idx ON public.loans (due_at) WHERE returned_at IS NULL;
+
+-- Queue of members waiting for a copy of a book, a ready hold has a copy
+-- set aside for the member until it expires.
+CREATE TABLE IF NOT EXISTS public.holds (
+                                            id serial PRIMARY KEY,
+                                            tenant varchar(64) NOT NULL,
+                                            book_id varchar(64) NOT NULL,
+                                            member_id integer NOT NULL REFERENCES public.members (id) ON DELETE CASCADE,
+                                            status varchar(16) NOT NULL DEFAULT 'waiting',
+                                            copy_id integer REFERENCES public.copies (id) ON DELETE SET NULL,
+                                            placed_at timestamptz NOT NULL,
+                                            ready_at timestamptz,
+                                            expires_at timestamptz,
+                                            closed_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_member_idx ON public.holds (tenant, book_id, member_id) WHERE status IN ('waiting', 'ready');
+CREATE UNIQUE INDEX IF NOT EXISTS holds_ready_copy_id_idx ON public.holds (copy_id) WHERE status = 'ready';
+CREATE INDEX IF NOT EXISTS holds_queue_idx ON public.holds (tenant, book_id, status, id);
+CREATE INDEX IF NOT EXISTS holds_expires_at_idx ON public.holds (expires_at) WHERE status = 'ready';
+
+-- Preferences of members receiving loan notifications, channels are a
+-- comma separated list. Members without preferences get the defaults.
+CREATE TABLE IF NOT EXISTS public.notification_preferences (
+                                                               member_id integer PRIMARY KEY REFERENCES public.members (id) ON DELETE CASCADE,
+                                                               tenant varchar(64) NOT NULL,
+                                                               channels varchar(255) NOT NULL DEFAULT '',
+                                                               due_soon boolean NOT NULL DEFAULT true,
+                                                               overdue boolean NOT NULL DEFAULT true
+);
+
+-- Log of sent loan notifications, each kind is sent through a channel once
+-- per due date of the loan.
+CREATE TABLE IF NOT EXISTS public.notifications (
+                                                    id serial PRIMARY KEY,
+                                                    tenant varchar(64) NOT NULL,
+                                                    kind varchar(16) NOT NULL,
+                                                    channel varchar(16) NOT NULL,
+                                                    loan_id integer NOT NULL REFERENCES public.loans (id) ON DELETE CASCADE,
+                                                    book_id varchar(64) NOT NULL,
+                                                    member_id integer NOT NULL,
+                                                    recipient varchar(255) NOT NULL,
+                                                    due_at timestamptz NOT NULL,
+                                                    sent_at timestamptz NOT NULL,
+                                                    UNIQUE (loan_id, kind, channel, due_at)
+);
+CREATE INDEX IF NOT EXISTS notifications_member_id_idx ON public.notifications (tenant, member_id, id);
+
+-- Reviews of books by members, a member reviews a book once. Reviews are
+-- kept without their author when the member is deleted.
+CREATE TABLE IF NOT EXISTS public.reviews (
+                                              id serial PRIMARY KEY,
+                                              tenant varchar(64) NOT NULL,
+                                              book_id varchar(64) NOT NULL,
+                                              member_id integer REFERENCES public.members (id) ON DELETE SET NULL,
+                                              rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+                                              text text NOT NULL DEFAULT '',
+                                              status varchar(16) NOT NULL DEFAULT 'pending',
+                                              created_at timestamptz NOT NULL,
+                                              updated_at timestamptz NOT NULL,
+                                              UNIQUE (tenant, book_id, member_id)
+);
+CREATE INDEX IF NOT EXISTS reviews_book_id_idx ON public.reviews (tenant, book_id, id);
+
+-- Ratings of books aggregating their approved reviews, kept up to date by
+-- every write of a review.
+CREATE TABLE IF NOT EXISTS public.book_ratings (
+                                                   tenant varchar(64) NOT NULL,
+                                                   book_id varchar(64) NOT NULL,
+                                                   average double precision NOT NULL DEFAULT 0,
+                                                   count integer NOT NULL DEFAULT 0,
+                                                   PRIMARY KEY (tenant, book_id)
+);
+
+-- Ratings are copied to books in the same transaction, so lists of books are
+-- sorted by rating in the database. Books rated before are copied on the next
+-- write of their reviews.
+ALTER TABLE public.books ADD COLUMN IF NOT EXISTS rating_average double precision NOT NULL DEFAULT 0,
+                         ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS books_rating_idx ON public.books (tenant_id, rating_average DESC, rating_count DESC, id);
+
+-- Tree of categories of books, names are unique among siblings. Categories
+-- with subcategories cannot be deleted.
+CREATE TABLE IF NOT EXISTS public.categories (
+                                                 id serial PRIMARY KEY,
+                                                 tenant varchar(64) NOT NULL,
+                                                 parent_id integer REFERENCES public.categories (id),
+                                                 name varchar(255) NOT NULL,
+                                                 created_at timestamptz NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS categories_name_idx ON public.categories (tenant, COALESCE(parent_id, 0), name);
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON public.categories (parent_id);
+
+CREATE TABLE IF NOT EXISTS public.book_tags (
+                                                tenant varchar(64) NOT NULL,
+                                                book_id varchar(64) NOT NULL,
+                                                tag varchar(64) NOT NULL,
+                                                PRIMARY KEY (tenant, book_id, tag)
+);
+CREATE INDEX IF NOT EXISTS book_tags_tag_idx ON public.book_tags (tenant, tag);
+
+CREATE TABLE IF NOT EXISTS public.book_categories (
+                                                      tenant varchar(64) NOT NULL,
+                                                      book_id varchar(64) NOT NULL,
+                                                      category_id integer NOT NULL REFERENCES public.categories (id) ON DELETE CASCADE,
+                                                      PRIMARY KEY (tenant, book_id, category_id)
+);
+CREATE INDEX IF NOT EXISTS book_categories_category_id_idx ON public.book_categories (category_id);
+
+CREATE TABLE IF NOT EXISTS public.book_publications (
+                                                        tenant varchar(64) NOT NULL,
+                                                        book_id varchar(64) NOT NULL,
+                                                        language varchar(3),
+                                                        published_year integer,
+                                                        PRIMARY KEY (tenant, book_id)
+);
+CREATE INDEX IF NOT EXISTS book_publications_language_idx ON public.book_publications (tenant, language);
+
+-- Shelves of members, names are unique among shelves of a member. Public
+-- shelves are readable by anyone knowing their share token.
+CREATE TABLE IF NOT EXISTS public.shelves (
+                                              id serial PRIMARY KEY,
+                                              tenant varchar(64) NOT NULL,
+                                              member_id integer NOT NULL REFERENCES public.members (id) ON DELETE CASCADE,
+                                              name varchar(255) NOT NULL,
+                                              is_default boolean NOT NULL DEFAULT false,
+                                              visibility varchar(16) NOT NULL DEFAULT 'private',
+                                              share_token varchar(64) NOT NULL UNIQUE,
+                                              created_at timestamptz NOT NULL,
+                                              updated_at timestamptz NOT NULL,
+                                              UNIQUE (tenant, member_id, name)
+);
+
+-- Books on shelves ordered by position, positions of removed books are
+-- left as gaps.
+CREATE TABLE IF NOT EXISTS public.shelf_entries (
+                                                    tenant varchar(64) NOT NULL,
+                                                    shelf_id integer NOT NULL REFERENCES public.shelves (id) ON DELETE CASCADE,
+                                                    book_id varchar(64) NOT NULL,
+                                                    position integer NOT NULL,
+                                                    note text NOT NULL DEFAULT '',
+                                                    progress smallint NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+                                                    added_at timestamptz NOT NULL,
+                                                    updated_at timestamptz NOT NULL,
+                                                    PRIMARY KEY (shelf_id, book_id)
+);
+CREATE INDEX IF NOT EXISTS shelf_entries_book_id_idx ON public.shelf_entries (tenant, book_id);
+
+-- Metadata of cover images of books, the images are kept in a blob store
+-- under keys containing the version.
+CREATE TABLE IF NOT EXISTS public.covers (
+                                             tenant varchar(64) NOT NULL,
+                                             book_id varchar(64) NOT NULL,
+                                             version varchar(64) NOT NULL,
+                                             content_type varchar(32) NOT NULL,
+                                             width integer NOT NULL,
+                                             height integer NOT NULL,
+                                             size bigint NOT NULL,
+                                             thumbnails jsonb NOT NULL DEFAULT '[]',
+                                             updated_at timestamptz NOT NULL,
+                                             PRIMARY KEY (tenant, book_id)
+);
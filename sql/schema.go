@@ -0,0 +1,11 @@
+// Package sql embeds the script creating the PostgreSQL schema, so the
+// migrate subcommand can apply it without the sources.
+package sql
+
+import _ "embed"
+
+// PostgreSQLSchema creates tables, indexes and policies used by the
+// application, running it again changes nothing.
+//
+//go:embed postgresql_create_table.sql
+var PostgreSQLSchema string